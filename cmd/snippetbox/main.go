@@ -0,0 +1,13 @@
+// Command snippetbox is the command line client for a snippetbox server. See
+// "snippetbox help" for its commands.
+package main
+
+import (
+  "os"
+
+  "mateuszurbanski/snippetbox/pkg/cli"
+)
+
+func main() {
+  os.Exit(cli.New().Run(os.Args[1:]))
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+  "archive/zip"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "net/http"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The accountExport type is the archive of a user's data which they can
+// download from their account page.
+type accountExport struct {
+  Exported    time.Time          `json:"exported"`
+  Profile     exportProfile      `json:"profile"`
+  Snippets    []exportSnippet    `json:"snippets"`
+  Sessions    []exportSession    `json:"sessions"`
+  AuditEvents []exportAuditEvent `json:"audit_events"`
+}
+
+type exportProfile struct {
+  ID      int       `json:"id"`
+  Name    string    `json:"name"`
+  Email   string    `json:"email"`
+  Created time.Time `json:"created"`
+  Role    string    `json:"role"`
+}
+
+type exportSnippet struct {
+  ID         int          `json:"id"`
+  Slug       string       `json:"slug"`
+  Title      string       `json:"title"`
+  Content    string       `json:"content"`
+  Created    time.Time    `json:"created"`
+  Expires    *time.Time   `json:"expires"`
+  Visibility string       `json:"visibility"`
+  Language   string       `json:"language"`
+  Format     string       `json:"format"`
+  Files      []exportFile `json:"files,omitempty"`
+}
+
+// Snippets with more than one file, or a named file, list their files.
+type exportFile struct {
+  Filename string `json:"filename"`
+  Language string `json:"language"`
+  Content  string `json:"content"`
+}
+
+type exportSession struct {
+  IPAddress string    `json:"ip_address"`
+  UserAgent string    `json:"user_agent"`
+  Created   time.Time `json:"created"`
+  LastSeen  time.Time `json:"last_seen"`
+  Expires   time.Time `json:"expires"`
+}
+
+type exportAuditEvent struct {
+  Time      time.Time `json:"time"`
+  Action    string    `json:"action"`
+  Subject   string    `json:"subject"`
+  IPAddress string    `json:"ip_address"`
+  UserAgent string    `json:"user_agent"`
+}
+
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+  app.render(w, r, "account.page.tmpl", &templateData{
+    Form: forms.New(nil),
+  })
+}
+
+// The exportAccount handler sends the user everything we hold about them,
+// either as a single JSON document or, with ?format=zip, as a ZIP archive
+// containing the JSON document and each snippet as a text file.
+func (app *application) exportAccount(w http.ResponseWriter, r *http.Request) {
+  format := r.URL.Query().Get("format")
+
+  if format != "" && format != "json" && format != "zip" {
+    app.clientError(w, http.StatusBadRequest)
+
+    return
+  }
+
+  export, err := app.buildExport(app.authenticatedUser(r))
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.audit(r, models.AuditAccountExport, fmt.Sprintf("user:%d", export.Profile.ID))
+
+  name := fmt.Sprintf("snippetbox-%d-%s", export.Profile.ID, export.Exported.Format("2006-01-02"))
+
+  if format == "zip" {
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+
+    err = writeExportZip(w, export)
+  } else {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+
+    err = writeExportJSON(w, export)
+  }
+
+  // The headers have been sent by now, so all we can do is log the error.
+  if err != nil {
+    app.errorLog.Output(2, err.Error())
+  }
+}
+
+// The buildExport helper collects a user's profile, snippets, sessions and
+// the audit events they performed.
+func (app *application) buildExport(user *models.User) (*accountExport, error) {
+  export := &accountExport{
+    Exported: time.Now().UTC(),
+    Profile: exportProfile{
+      ID:      user.ID,
+      Name:    user.Name,
+      Email:   user.Email,
+      Created: user.Created,
+      Role:    user.Role,
+    },
+    Snippets:    []exportSnippet{},
+    Sessions:    []exportSession{},
+    AuditEvents: []exportAuditEvent{},
+  }
+
+  snippets, err := app.snippets.ListForUser(user.ID)
+  if err != nil {
+    return nil, err
+  }
+
+  for _, s := range snippets {
+    es := exportSnippet{s.ID, s.Slug, s.Title, s.Content, s.Created, expiryJSON(s), s.Visibility, s.Language, s.Format, nil}
+
+    if files := s.AllFiles(); len(files) > 1 || files[0].Filename != "" {
+      for _, f := range files {
+        es.Files = append(es.Files, exportFile{f.Filename, f.Language, f.Content})
+      }
+    }
+
+    export.Snippets = append(export.Snippets, es)
+  }
+
+  sessions, err := app.sessions.ListForUser(user.ID)
+  if err != nil {
+    return nil, err
+  }
+
+  for _, s := range sessions {
+    export.Sessions = append(export.Sessions, exportSession{s.IPAddress, s.UserAgent, s.Created, s.LastSeen, s.Expires})
+  }
+
+  // Page through the events by ID rather than offset, so that events the
+  // user causes while the export runs can't shift the batches.
+  const batchSize = 500
+
+  filter := models.AuditFilter{ActorID: user.ID}
+
+  for {
+    events, _, err := app.auditLog.List(filter, 0, batchSize)
+    if err != nil {
+      return nil, err
+    }
+
+    for _, e := range events {
+      export.AuditEvents = append(export.AuditEvents, exportAuditEvent{e.Created, e.Action, e.Subject, e.IPAddress, e.UserAgent})
+    }
+
+    if len(events) < batchSize {
+      break
+    }
+
+    filter.BeforeID = events[len(events)-1].ID
+  }
+
+  return export, nil
+}
+
+func writeExportJSON(w io.Writer, export *accountExport) error {
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+
+  return enc.Encode(export)
+}
+
+func writeExportZip(w io.Writer, export *accountExport) error {
+  zw := zip.NewWriter(w)
+
+  f, err := zw.Create("snippetbox.json")
+  if err != nil {
+    return err
+  }
+
+  if err = writeExportJSON(f, export); err != nil {
+    return err
+  }
+
+  for _, s := range export.Snippets {
+    // Snippets with named files get a directory of them.
+    if len(s.Files) > 0 {
+      for _, file := range s.Files {
+        f, err := zw.Create(fmt.Sprintf("snippets/%d/%s", s.ID, file.Filename))
+        if err != nil {
+          return err
+        }
+
+        if _, err = io.WriteString(f, file.Content); err != nil {
+          return err
+        }
+      }
+
+      continue
+    }
+
+    ext := "txt"
+    if s.Format == models.FormatMarkdown {
+      ext = "md"
+    }
+
+    f, err := zw.Create(fmt.Sprintf("snippets/%d.%s", s.ID, ext))
+    if err != nil {
+      return err
+    }
+
+    _, err = fmt.Fprintf(f, "%s\n\n%s\n", s.Title, s.Content)
+    if err != nil {
+      return err
+    }
+  }
+
+  return zw.Close()
+}
+
+// The deleteAccount handler schedules the user's account for deletion once the
+// grace period is over. The user has to choose whether their snippets are
+// deleted too or kept without an owner, and confirm with their password or,
+// if single sign-on is enabled, by logging in with their identity provider.
+func (app *application) deleteAccount(w http.ResponseWriter, r *http.Request) {
+  err := r.ParseForm()
+
+  if err != nil {
+    app.clientError(w, http.StatusBadRequest)
+
+    return
+  }
+
+  form := forms.New(r.PostForm)
+  form.Required("snippets")
+  form.PermittedValues("snippets", "delete", "keep")
+
+  user := app.authenticatedUser(r)
+
+  // Accounts created by single sign-on have a random password which nobody
+  // knows, so their owners confirm with the identity provider instead. The
+  // choice about snippets waits in the session until they come back.
+  if form.Get("confirm") == "sso" && app.oidc != nil {
+    if !form.Valid() {
+      app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+
+      return
+    }
+
+    app.session.Put(r, "deleteSnippets", form.Get("snippets"))
+    app.redirectToProvider(w, r, "delete")
+
+    return
+  }
+
+  form.Required("password")
+
+  if form.Valid() {
+    id, err := app.authenticator.Authenticate(user.Email, form.Get("password"))
+
+    if err != nil && !errors.Is(err, models.ErrInvalidCredentials) {
+      app.serverError(w, err)
+
+      return
+    }
+
+    if err != nil || id != user.ID {
+      form.Errors.Add("password", "Password is incorrect")
+    }
+  }
+
+  if !form.Valid() {
+    app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+
+    return
+  }
+
+  app.scheduleDeletion(w, r, user, form.Get("snippets") == "keep")
+}
+
+// The scheduleDeletion helper schedules a user's account for deletion, once
+// they have confirmed it, and sends them back to their account page.
+func (app *application) scheduleDeletion(w http.ResponseWriter, r *http.Request, user *models.User, keepSnippets bool) {
+  after := time.Now().Add(app.deletionGrace)
+
+  err := app.users.ScheduleDeletion(user.ID, after, keepSnippets)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.audit(r, models.AuditDeletionSchedule, fmt.Sprintf("user:%d", user.ID))
+
+  app.session.Put(r, "flash", fmt.Sprintf("Your account will be deleted on %s. You can cancel until then.", humanDate(after)))
+
+  http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+func (app *application) cancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+  user := app.authenticatedUser(r)
+
+  err := app.users.CancelDeletion(user.ID)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.audit(r, models.AuditDeletionCancel, fmt.Sprintf("user:%d", user.ID))
+
+  app.session.Put(r, "flash", "Your account will no longer be deleted.")
+
+  http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// The deleteScheduledAccounts method deletes the accounts whose grace period
+// is over. It is run periodically from main.
+func (app *application) deleteScheduledAccounts() {
+  ids, err := app.users.DeleteScheduled(time.Now())
+
+  // Record the accounts which were deleted, even if we then hit an error.
+  for _, id := range ids {
+    app.infoLog.Printf("Deleted account %d", id)
+
+    err := app.auditLog.Insert(&models.AuditEvent{
+      Action:  models.AuditAccountDelete,
+      Subject: fmt.Sprintf("user:%d", id),
+    })
+    if err != nil {
+      app.errorLog.Output(2, err.Error())
+    }
+  }
+
+  if err != nil {
+    app.errorLog.Output(2, err.Error())
+  }
+}
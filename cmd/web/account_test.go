@@ -0,0 +1,177 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/url"
+    "testing"
+    "time"
+
+    "mateuszurbanski/snippetbox/pkg/models"
+)
+
+func TestExportAccount(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    ts.login(t, "mallory@example.com")
+
+    t.Run("JSON", func(t *testing.T) {
+        code, header, body := ts.get(t, "/user/export?format=json")
+        if code != http.StatusOK {
+            t.Fatalf("want %d; got %d", http.StatusOK, code)
+        }
+
+        if ct := header.Get("Content-Type"); ct != "application/json" {
+            t.Errorf("want Content-Type application/json; got %q", ct)
+        }
+
+        var export accountExport
+        if err := json.Unmarshal(body, &export); err != nil {
+            t.Fatal(err)
+        }
+
+        if export.Profile.Email != "mallory@example.com" {
+            t.Errorf("want profile for mallory@example.com; got %q", export.Profile.Email)
+        }
+
+        if len(export.Snippets) != 1 || export.Snippets[0].Title != "An old silent pond" {
+            t.Errorf("want mallory's snippet; got %+v", export.Snippets)
+        }
+
+        if len(export.Sessions) != 1 {
+            t.Errorf("want 1 session; got %d", len(export.Sessions))
+        }
+    })
+
+    t.Run("ZIP", func(t *testing.T) {
+        code, _, body := ts.get(t, "/user/export?format=zip")
+        if code != http.StatusOK {
+            t.Fatalf("want %d; got %d", http.StatusOK, code)
+        }
+
+        zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        files := map[string]string{}
+        for _, f := range zr.File {
+            rc, err := f.Open()
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            b, _ := io.ReadAll(rc)
+            rc.Close()
+            files[f.Name] = string(b)
+        }
+
+        if _, ok := files["snippetbox.json"]; !ok {
+            t.Error("want snippetbox.json in the archive")
+        }
+
+        if files["snippets/1.txt"] != "An old silent pond\n\nAn old silent pond...\n" {
+            t.Errorf("want snippets/1.txt in the archive; got %q", files["snippets/1.txt"])
+        }
+    })
+
+    t.Run("Bad format", func(t *testing.T) {
+        code, _, _ := ts.get(t, "/user/export?format=xml")
+        if code != http.StatusBadRequest {
+            t.Errorf("want %d; got %d", http.StatusBadRequest, code)
+        }
+    })
+}
+
+func TestDeleteAccount(t *testing.T) {
+    app := newTestApplication(t)
+    app.deletionGrace = time.Hour
+
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    ts.login(t, "alice@example.com")
+
+    tests := []struct {
+        name     string
+        password string
+        snippets string
+        wantCode int
+        wantBody []byte
+    }{
+        {"Wrong password", "wrong", "delete", http.StatusOK, []byte("Password is incorrect")},
+        {"Missing password", "", "delete", http.StatusOK, []byte("This field cannot be blank")},
+        {"Invalid choice", "pa$$word", "archive", http.StatusOK, []byte("This field is invalid")},
+        {"Valid", "pa$$word", "keep", http.StatusSeeOther, nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, _, body := ts.get(t, "/user/account")
+
+            form := url.Values{}
+            form.Add("password", tt.password)
+            form.Add("snippets", tt.snippets)
+            form.Add("csrf_token", extractCSRFToken(t, body))
+
+            code, _, body := ts.postForm(t, "/user/delete", form)
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+        })
+    }
+
+    // The account is only scheduled for deletion, so the user can still log
+    // in and change their mind.
+    user, err := app.users.Get(1)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !user.DeletionScheduled() || !user.KeepSnippets {
+        t.Fatalf("want deletion scheduled, keeping snippets; got %+v", user)
+    }
+
+    _, _, body := ts.get(t, "/user/account")
+    if !bytes.Contains(body, []byte("Your account will be deleted on")) {
+        t.Error("want account page to show the scheduled deletion")
+    }
+
+    form := url.Values{}
+    form.Add("csrf_token", extractCSRFToken(t, body))
+
+    if code, _, _ := ts.postForm(t, "/user/delete/cancel", form); code != http.StatusSeeOther {
+        t.Fatalf("cancel: want %d; got %d", http.StatusSeeOther, code)
+    }
+
+    if user, _ := app.users.Get(1); user.DeletionScheduled() {
+        t.Error("want deletion cancelled")
+    }
+
+    // Once the grace period is over, the account is deleted.
+    app.users.ScheduleDeletion(1, time.Now().Add(-time.Minute), false)
+    app.deleteScheduledAccounts()
+
+    if _, err := app.users.Get(1); err != models.ErrNoRecord {
+        t.Errorf("want account deleted; got %v", err)
+    }
+
+    events, _, _ := app.auditLog.List(models.AuditFilter{Action: models.AuditAccountDelete}, 0, 10)
+    if len(events) != 1 {
+        t.Errorf("want 1 %s audit event; got %d", models.AuditAccountDelete, len(events))
+    }
+
+    code, _, _ := ts.get(t, "/user/account")
+    if code != http.StatusSeeOther {
+        t.Errorf("after deletion: want %d; got %d", http.StatusSeeOther, code)
+    }
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strconv"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The number of users or snippets shown on each page of the admin lists.
+const adminPageSize = 20
+
+// The shortest time audit events are kept for. The audit_events_no_delete
+// trigger refuses to delete anything younger (see migration 018).
+const minAuditRetention = 90 * 24 * time.Hour
+
+// The adminStats type holds the summary numbers shown on the admin dashboard.
+type adminStats struct {
+  Users        int
+  ActiveUsers  int
+  Snippets     int
+  LiveSnippets int
+}
+
+// The pagination type describes where a page sits in a list of results, so
+// that templates can link to the previous and next pages.
+type pagination struct {
+  Path   string
+  Page   int
+  Total  int
+  Query  string
+  params url.Values
+}
+
+func (p *pagination) HasPrev() bool { return p.Page > 1 }
+func (p *pagination) HasNext() bool { return p.Page*adminPageSize < p.Total }
+func (p *pagination) Prev() int     { return p.Page - 1 }
+func (p *pagination) Next() int     { return p.Page + 1 }
+
+// The URLFor method returns a link to path carrying the same search query and
+// filters as the current page.
+func (p *pagination) URLFor(path string) string {
+  if len(p.params) == 0 {
+    return path
+  }
+
+  return path + "?" + p.params.Encode()
+}
+
+// The PageURL method returns the link to another page of the same results,
+// keeping the search query and any filters.
+func (p *pagination) PageURL(page int) string {
+  q := url.Values{}
+  for k, v := range p.params {
+    q[k] = v
+  }
+
+  q.Set("page", strconv.Itoa(page))
+
+  return p.Path + "?" + q.Encode()
+}
+
+// The offset method returns the number of results before the current page.
+func (p *pagination) offset() int {
+  return (p.Page - 1) * adminPageSize
+}
+
+// The paginate helper reads the search query and page number from the query
+// string. Missing or invalid page numbers are treated as the first page.
+func paginate(r *http.Request) *pagination {
+  page, err := strconv.Atoi(r.URL.Query().Get("page"))
+  if err != nil || page < 1 {
+    page = 1
+  }
+
+  params := r.URL.Query()
+  params.Del("page")
+
+  return &pagination{Path: r.URL.Path, Page: page, Query: params.Get("q"), params: params}
+}
+
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+  var stats adminStats
+  var err error
+
+  stats.Users, stats.ActiveUsers, err = app.users.Count()
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  stats.Snippets, stats.LiveSnippets, err = app.snippets.Count()
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.render(w, r, "admin.page.tmpl", &templateData{AdminStats: &stats})
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+  p := paginate(r)
+
+  users, total, err := app.users.List(p.Query, p.offset(), adminPageSize)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  p.Total = total
+
+  app.render(w, r, "admin-users.page.tmpl", &templateData{
+    Pagination: p,
+    Users:      users,
+  })
+}
+
+func (app *application) adminSetUserActive(active bool) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+
+    if err != nil || id < 1 {
+      app.notFound(w)
+
+      return
+    }
+
+    // Don't let admins lock themselves out.
+    if !active && id == app.authenticatedUser(r).ID {
+      app.session.Put(r, "flash", "You can't deactivate your own account.")
+      http.Redirect(w, r, app.adminReturnPath(r, "/admin/users"), http.StatusSeeOther)
+
+      return
+    }
+
+    err = app.users.SetActive(id, active)
+
+    if err != nil {
+      if errors.Is(err, models.ErrNoRecord) {
+        app.notFound(w)
+      } else {
+        app.serverError(w, err)
+      }
+
+      return
+    }
+
+    // Deactivated users are logged out everywhere straight away.
+    if !active {
+      err = app.sessions.DeleteAllForUser(id, 0)
+
+      if err != nil {
+        app.serverError(w, err)
+
+        return
+      }
+
+      err = app.rememberTokens.DeleteAllForUser(id)
+
+      if err != nil {
+        app.serverError(w, err)
+
+        return
+      }
+    }
+
+    if active {
+      app.audit(r, models.AuditAdminActivate, fmt.Sprintf("user:%d", id))
+      app.session.Put(r, "flash", fmt.Sprintf("User #%d activated.", id))
+    } else {
+      app.audit(r, models.AuditAdminDeactivate, fmt.Sprintf("user:%d", id))
+      app.session.Put(r, "flash", fmt.Sprintf("User #%d deactivated.", id))
+    }
+
+    http.Redirect(w, r, app.adminReturnPath(r, "/admin/users"), http.StatusSeeOther)
+  }
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+  p := paginate(r)
+
+  snippets, total, err := app.snippets.List(p.Query, p.offset(), adminPageSize)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  p.Total = total
+
+  app.render(w, r, "admin-snippets.page.tmpl", &templateData{
+    Pagination: p,
+    Snippets:   snippets,
+  })
+}
+
+func (app *application) adminDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+  id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+
+  if err != nil || id < 1 {
+    app.notFound(w)
+
+    return
+  }
+
+  err = app.snippets.Delete(id)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  app.audit(r, models.AuditAdminSnippetDelete, fmt.Sprintf("snippet:%d", id))
+
+  app.session.Put(r, "flash", fmt.Sprintf("Snippet #%d deleted.", id))
+
+  http.Redirect(w, r, app.adminReturnPath(r, "/admin/snippets"), http.StatusSeeOther)
+}
+
+func (app *application) adminExtendSnippet(w http.ResponseWriter, r *http.Request) {
+  id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+
+  if err != nil || id < 1 {
+    app.notFound(w)
+
+    return
+  }
+
+  err = r.ParseForm()
+
+  if err != nil {
+    app.clientError(w, http.StatusBadRequest)
+
+    return
+  }
+
+  // Snippets can be extended by the same periods they can be created with.
+  form := forms.New(r.PostForm)
+  form.Required("days")
+  form.PermittedValues("days", "365", "7", "1")
+
+  if !form.Valid() {
+    app.clientError(w, http.StatusBadRequest)
+
+    return
+  }
+
+  days, _ := strconv.Atoi(form.Get("days"))
+
+  // Snippets can't be kept any longer by extending them than they can be
+  // when they're created.
+  err = app.snippets.Extend(id, days, time.Now().Add(maxExpiry))
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else if errors.Is(err, models.ErrNeverExpires) {
+      app.session.Put(r, "flash", fmt.Sprintf("Snippet #%d never expires, so it can't be extended.", id))
+      http.Redirect(w, r, app.adminReturnPath(r, "/admin/snippets"), http.StatusSeeOther)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  app.audit(r, models.AuditAdminSnippetExtend, fmt.Sprintf("snippet:%d", id))
+
+  // Say when it expires now, rather than how much it was extended by, since
+  // it may have reached the limit.
+  s, err := app.snippets.Get(id)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.session.Put(r, "flash", fmt.Sprintf("Snippet #%d now expires on %s.", id, humanDate(s.Expires)))
+
+  http.Redirect(w, r, app.adminReturnPath(r, "/admin/snippets"), http.StatusSeeOther)
+}
+
+// The auditFilter helper builds an audit log filter from the query string.
+// The since and until dates are inclusive, and anything which doesn't parse is
+// reported as a form error and otherwise ignored.
+func auditFilter(form *forms.Form) models.AuditFilter {
+  var f models.AuditFilter
+
+  form.PermittedValues("action", models.AuditActions...)
+  if form.Errors.Get("action") == "" {
+    f.Action = form.Get("action")
+  }
+
+  if actor := form.Get("actor"); actor != "" {
+    id, err := strconv.Atoi(actor)
+    if err != nil || id < 1 {
+      form.Errors.Add("actor", "This field is invalid")
+    } else {
+      f.ActorID = id
+    }
+  }
+
+  if since := form.Get("since"); since != "" {
+    t, err := time.Parse("2006-01-02", since)
+    if err != nil {
+      form.Errors.Add("since", "This field is invalid")
+    } else {
+      f.Since = t
+    }
+  }
+
+  if until := form.Get("until"); until != "" {
+    t, err := time.Parse("2006-01-02", until)
+    if err != nil {
+      form.Errors.Add("until", "This field is invalid")
+    } else {
+      f.Until = t.AddDate(0, 0, 1)
+    }
+  }
+
+  return f
+}
+
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+  form := forms.New(r.URL.Query())
+  filter := auditFilter(form)
+  p := paginate(r)
+
+  events, total, err := app.auditLog.List(filter, p.offset(), adminPageSize)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  p.Total = total
+
+  app.render(w, r, "admin-audit.page.tmpl", &templateData{
+    AuditActions: models.AuditActions,
+    AuditEvents:  events,
+    Form:         form,
+    Pagination:   p,
+  })
+}
+
+// The adminAuditExport handler writes every audit event matching the filters
+// as JSON lines: one JSON object per line, which is easy to feed into log
+// processing tools.
+func (app *application) adminAuditExport(w http.ResponseWriter, r *http.Request) {
+  form := forms.New(r.URL.Query())
+  filter := auditFilter(form)
+
+  if !form.Valid() {
+    app.clientError(w, http.StatusBadRequest)
+
+    return
+  }
+
+  enc := json.NewEncoder(w)
+
+  // Fetch the events a batch at a time, so we never hold the whole log in
+  // memory. Each batch starts below the last event of the one before, so
+  // events written while the export runs can't shift the batches and repeat
+  // lines.
+  const batchSize = 500
+
+  for {
+    events, _, err := app.auditLog.List(filter, 0, batchSize)
+
+    // Once the first batch has been written the headers have been sent, so
+    // after that all we can do is log the error.
+    if err != nil {
+      if filter.BeforeID == 0 {
+        app.serverError(w, err)
+      } else {
+        app.errorLog.Output(2, err.Error())
+      }
+
+      return
+    }
+
+    if filter.BeforeID == 0 {
+      w.Header().Set("Content-Type", "application/x-ndjson")
+      w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+    }
+
+    for _, e := range events {
+      err = enc.Encode(struct {
+        ID        int       `json:"id"`
+        Time      time.Time `json:"time"`
+        Action    string    `json:"action"`
+        ActorID   int       `json:"actor_id"`
+        Subject   string    `json:"subject"`
+        IPAddress string    `json:"ip_address"`
+        UserAgent string    `json:"user_agent"`
+        RequestID string    `json:"request_id"`
+      }{e.ID, e.Created, e.Action, e.ActorID, e.Subject, e.IPAddress, e.UserAgent, e.RequestID})
+
+      if err != nil {
+        app.errorLog.Output(2, err.Error())
+
+        return
+      }
+    }
+
+    if len(events) < batchSize {
+      return
+    }
+
+    filter.BeforeID = events[len(events)-1].ID
+  }
+}
+
+// The purgeAuditLog method deletes the audit events which are older than the
+// retention period, since they hold IP addresses and user agents. It is run
+// once an hour.
+func (app *application) purgeAuditLog() {
+  n, err := app.auditLog.DeleteBefore(time.Now().Add(-app.auditRetention))
+
+  if err != nil {
+    app.errorLog.Output(2, err.Error())
+
+    return
+  }
+
+  if n > 0 {
+    app.infoLog.Printf("Purged %d audit events", n)
+  }
+}
+
+// The adminReturnPath helper returns the admin list page to go back to after
+// an action, keeping the search query and page number the admin was on. Only
+// the query string is taken from the form, so it can't redirect off-site.
+func (app *application) adminReturnPath(r *http.Request, path string) string {
+  q := url.Values{}
+
+  if query := r.PostFormValue("q"); query != "" {
+    q.Set("q", query)
+  }
+
+  if page, err := strconv.Atoi(r.PostFormValue("page")); err == nil && page > 1 {
+    q.Set("page", strconv.Itoa(page))
+  }
+
+  if len(q) == 0 {
+    return path
+  }
+
+  return path + "?" + q.Encode()
+}
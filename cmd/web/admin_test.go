@@ -0,0 +1,299 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    "mateuszurbanski/snippetbox/pkg/models"
+    "mateuszurbanski/snippetbox/pkg/models/mock"
+)
+
+func TestAdmin(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    // Anonymous users are sent to the login page.
+    code, header, _ := ts.get(t, "/admin")
+    if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+        t.Fatalf("want redirect to /user/login; got %d %q", code, header.Get("Location"))
+    }
+
+    // Users without the admin role are turned away.
+    ts.login(t, "mallory@example.com")
+
+    code, _, _ = ts.get(t, "/admin")
+    if code != http.StatusForbidden {
+        t.Fatalf("want %d; got %d", http.StatusForbidden, code)
+    }
+
+    ts.login(t, "ada@example.com")
+
+    tests := []struct {
+        name     string
+        urlPath  string
+        wantCode int
+        wantBody []byte
+    }{
+        {"Dashboard", "/admin", http.StatusOK, []byte("<td>3</td>")},
+        {"Users", "/admin/users", http.StatusOK, []byte("alice@example.com")},
+        {"Users search", "/admin/users?q=mallory", http.StatusOK, []byte("1 results")},
+        {"Snippets", "/admin/snippets", http.StatusOK, []byte("An old silent pond")},
+        {"Snippets search", "/admin/snippets?q=nothing", http.StatusOK, []byte("No snippets found.")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            code, _, body := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body %s to contain %q", body, tt.wantBody)
+            }
+        })
+    }
+
+    _, _, body := ts.get(t, "/admin/users")
+    csrfToken := extractCSRFToken(t, body)
+
+    actions := []struct {
+        name         string
+        urlPath      string
+        form         url.Values
+        wantCode     int
+        wantLocation string
+    }{
+        {"Deactivate user", "/admin/users/1/deactivate", url.Values{"q": {"alice"}, "page": {"2"}}, http.StatusSeeOther, "/admin/users?page=2&q=alice"},
+        {"Activate user", "/admin/users/1/activate", url.Values{}, http.StatusSeeOther, "/admin/users"},
+        {"Deactivate non-existent user", "/admin/users/99/deactivate", url.Values{}, http.StatusNotFound, ""},
+        {"Extend snippet", "/admin/snippets/1/extend", url.Values{"days": {"7"}}, http.StatusSeeOther, "/admin/snippets"},
+        {"Extend by invalid period", "/admin/snippets/1/extend", url.Values{"days": {"1000"}}, http.StatusBadRequest, ""},
+        {"Delete snippet", "/admin/snippets/1/delete", url.Values{}, http.StatusSeeOther, "/admin/snippets"},
+        {"Delete non-existent snippet", "/admin/snippets/2/delete", url.Values{}, http.StatusNotFound, ""},
+    }
+
+    for _, tt := range actions {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.form.Add("csrf_token", csrfToken)
+
+            code, header, _ := ts.postForm(t, tt.urlPath, tt.form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if got := header.Get("Location"); got != tt.wantLocation {
+                t.Errorf("want Location %q; got %q", tt.wantLocation, got)
+            }
+        })
+    }
+}
+
+func TestAdminExtendSnippet(t *testing.T) {
+    tests := []struct {
+        name      string
+        urlPath   string
+        wantFlash []byte
+        wantAudit int
+    }{
+        {"Expiring", "/admin/snippets/1/extend", []byte("Snippet #1 now expires on"), 1},
+        {"Never expires", "/admin/snippets/7/extend", []byte("Snippet #7 never expires, so it can&#39;t be extended."), 0},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            ts.login(t, "ada@example.com")
+
+            _, _, body := ts.get(t, "/admin/snippets")
+
+            form := url.Values{}
+            form.Add("days", "7")
+            form.Add("csrf_token", extractCSRFToken(t, body))
+
+            code, _, _ := ts.postForm(t, tt.urlPath, form)
+            if code != http.StatusSeeOther {
+                t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+            }
+
+            _, _, body = ts.get(t, "/admin/snippets")
+            if !bytes.Contains(body, tt.wantFlash) {
+                t.Errorf("want body to contain %q", tt.wantFlash)
+            }
+
+            events, _, _ := app.auditLog.List(models.AuditFilter{Action: models.AuditAdminSnippetExtend}, 0, 10)
+            if len(events) != tt.wantAudit {
+                t.Errorf("want %d audit events; got %d", tt.wantAudit, len(events))
+            }
+        })
+    }
+}
+
+func TestAdminAudit(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    // Failed and successful logins are both recorded.
+    _, _, body := ts.get(t, "/user/login")
+
+    form := url.Values{}
+    form.Add("email", "nobody@example.com")
+    form.Add("password", "wrongPa$$word")
+    form.Add("csrf_token", extractCSRFToken(t, body))
+    ts.postForm(t, "/user/login", form)
+
+    ts.login(t, "ada@example.com")
+
+    tests := []struct {
+        name        string
+        urlPath     string
+        wantCode    int
+        wantBody    []byte
+        notWantBody []byte
+    }{
+        {"All events", "/admin/audit", http.StatusOK, []byte("user.login_failed"), nil},
+        {"Filter by action", "/admin/audit?action=user.login", http.StatusOK, []byte("user:3"), []byte("email-hash:")},
+        {"Filter by actor", "/admin/audit?actor=3", http.StatusOK, []byte("<td>user.login</td>"), []byte("<td>user.login_failed</td>")},
+        {"Invalid date", "/admin/audit?since=yesterday", http.StatusOK, []byte("Since: This field is invalid"), nil},
+        {"Export", "/admin/audit/export?action=user.login_failed", http.StatusOK, []byte(`"subject":"email-hash:`), []byte(`"action":"user.login"`)},
+        {"No email addresses", "/admin/audit/export", http.StatusOK, []byte(`"action":"user.login_failed"`), []byte("nobody@example.com")},
+        {"Export with invalid filter", "/admin/audit/export?actor=abc", http.StatusBadRequest, nil, nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            code, _, body := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body %s to contain %q", body, tt.wantBody)
+            }
+
+            if tt.notWantBody != nil && bytes.Contains(body, tt.notWantBody) {
+                t.Errorf("want body %s to not contain %q", body, tt.notWantBody)
+            }
+        })
+    }
+}
+
+func TestEmailPseudonym(t *testing.T) {
+    app := newTestApplication(t)
+
+    alice := app.emailPseudonym("alice@example.com")
+
+    if !strings.HasPrefix(alice, "email-hash:") || strings.Contains(alice, "alice") {
+        t.Errorf("want a pseudonym; got %q", alice)
+    }
+
+    if got := app.emailPseudonym(" Alice@Example.com"); got != alice {
+        t.Errorf("want the same pseudonym for the same address; got %q and %q", got, alice)
+    }
+
+    if got := app.emailPseudonym("bob@example.com"); got == alice {
+        t.Errorf("want different pseudonyms for different addresses; got %q", got)
+    }
+
+    app.auditKey = auditKey([]byte("a different session key"))
+
+    if got := app.emailPseudonym("alice@example.com"); got == alice {
+        t.Errorf("want the pseudonym to depend on the key; got %q", got)
+    }
+}
+
+func TestPurgeAuditLog(t *testing.T) {
+    app := newTestApplication(t)
+
+    app.auditLog.Insert(&models.AuditEvent{Action: models.AuditLogin, ActorID: 1})
+    app.auditLog.Insert(&models.AuditEvent{Action: models.AuditLogout, ActorID: 1})
+
+    // Nothing is old enough to purge yet.
+    app.purgeAuditLog()
+
+    if _, total, _ := app.auditLog.List(models.AuditFilter{}, 0, 10); total != 2 {
+        t.Fatalf("want 2 events; got %d", total)
+    }
+
+    // Once the retention period has passed, the events are deleted.
+    app.auditRetention = -time.Minute
+    app.purgeAuditLog()
+
+    if _, total, _ := app.auditLog.List(models.AuditFilter{}, 0, 10); total != 0 {
+        t.Errorf("want 0 events; got %d", total)
+    }
+}
+
+// A busyAuditModel records a new event every time the log is listed, as a
+// busy site would while an export is running.
+type busyAuditModel struct {
+    *mock.AuditModel
+}
+
+func (m busyAuditModel) List(f models.AuditFilter, offset, limit int) ([]*models.AuditEvent, int, error) {
+    m.Insert(&models.AuditEvent{Action: models.AuditLogin, ActorID: 1})
+
+    return m.AuditModel.List(f, offset, limit)
+}
+
+func TestAdminAuditExportBatches(t *testing.T) {
+    app := newTestApplication(t)
+
+    audit := busyAuditModel{&mock.AuditModel{}}
+    for i := 0; i < 1234; i++ {
+        audit.Insert(&models.AuditEvent{Action: models.AuditLogin, ActorID: 1})
+    }
+
+    app.auditLog = audit
+
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    ts.login(t, "ada@example.com")
+
+    code, _, body := ts.get(t, "/admin/audit/export?action=user.login&actor=1")
+
+    if code != http.StatusOK {
+        t.Fatalf("want %d; got %d", http.StatusOK, code)
+    }
+
+    seen := map[int]bool{}
+
+    for _, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+        var e struct {
+            ID int `json:"id"`
+        }
+
+        err := json.Unmarshal(line, &e)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if seen[e.ID] {
+            t.Errorf("want every event once; got %d twice", e.ID)
+        }
+
+        seen[e.ID] = true
+    }
+
+    // Every event from before the export started is there; the ones written
+    // during it may or may not be.
+    for id := 1; id <= 1234; id++ {
+        if !seen[id] {
+            t.Errorf("want event %d in the export", id)
+        }
+    }
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "mime"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The prefix of every API token, which makes them easy to recognise (and for
+// secret scanners to spot in code).
+const apiTokenPrefix = "sbx_"
+
+// The number of results in each page of an API search.
+const apiPageSize = 50
+
+// The wantsJSON function returns true if the client asked for a JSON
+// response. Everything else gets plain text, which is friendlier in a
+// terminal.
+func wantsJSON(r *http.Request) bool {
+  for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+    mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+    if err == nil && mediaType == "application/json" {
+      return true
+    }
+  }
+
+  return false
+}
+
+// The apiRespond helper sends a successful API response: v encoded as JSON if
+// the client wants JSON, or else text, followed by a newline.
+func (app *application) apiRespond(w http.ResponseWriter, r *http.Request, status int, v interface{}, text string) {
+  if wantsJSON(r) {
+    js, err := json.Marshal(v)
+    if err != nil {
+      app.serverError(w, err)
+      return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    w.Write(append(js, '\n'))
+
+    return
+  }
+
+  w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+  w.WriteHeader(status)
+  fmt.Fprintln(w, text)
+}
+
+// The apiError helper sends an error from the API, as {"error": message} or as
+// plain text. API clients don't have a browser to show the HTML error pages.
+func (app *application) apiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+  app.apiRespond(w, r, status, map[string]string{"error": message}, message)
+}
+
+// The apiValidationError helper sends a 422 Unprocessable Entity response
+// listing what was wrong with each field of a request.
+func (app *application) apiValidationError(w http.ResponseWriter, r *http.Request, errs map[string][]string) {
+  var lines []string
+  for field, messages := range errs {
+    lines = append(lines, fmt.Sprintf("%s: %s", field, strings.Join(messages, "; ")))
+  }
+
+  app.apiRespond(w, r, http.StatusUnprocessableEntity, map[string]interface{}{"errors": errs}, strings.Join(lines, "\n"))
+}
+
+// The bearerToken function returns the token from the request's
+// "Authorization: Bearer" header, or "" if it hasn't got one.
+func bearerToken(r *http.Request) string {
+  parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+  if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+    return ""
+  }
+
+  return strings.TrimSpace(parts[1])
+}
+
+// The authenticateToken middleware authenticates API requests by their bearer
+// token, in place of the session cookie used by the website. Requests without
+// a valid token, or whose user has been deactivated, get a 401 Unauthorized.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    // The API rate limit is counted per user, so it only applies once the
+    // token has been checked. To stop tokens being guessed, failed attempts
+    // are counted against the client's IP address in the same limiter, and
+    // once they have used it up we don't check any more tokens from there.
+    failures := app.limiters.api
+    failureKey := "ip:" + app.clientIP(r)
+
+    if failures != nil {
+      if blocked, wait := failures.blocked(failureKey); blocked {
+        w.Header().Set("Retry-After", retryAfter(wait))
+        app.apiError(w, r, http.StatusTooManyRequests, "Too many requests. Please wait a moment and try again.")
+        return
+      }
+    }
+
+    unauthorized := func(message string) {
+      if failures != nil {
+        failures.allow(failureKey)
+      }
+
+      w.Header().Set("WWW-Authenticate", `Bearer realm="snippetbox"`)
+      app.apiError(w, r, http.StatusUnauthorized, message)
+    }
+
+    token := bearerToken(r)
+    if token == "" {
+      unauthorized("An API token is required. Create one on your account page.")
+      return
+    }
+
+    t, err := app.apiTokens.GetByToken(token)
+    if errors.Is(err, models.ErrNoRecord) {
+      unauthorized("The API token is invalid or has been revoked.")
+      return
+    } else if err != nil {
+      app.serverError(w, err)
+      return
+    }
+
+    user, err := app.users.Get(t.UserID)
+    if errors.Is(err, models.ErrNoRecord) || (err == nil && !user.Active) {
+      unauthorized("The API token is invalid or has been revoked.")
+      return
+    } else if err != nil {
+      app.serverError(w, err)
+      return
+    }
+
+    // As with sessions, only record when the token was used once a minute.
+    if time.Since(t.LastUsed) > time.Minute {
+      err = app.apiTokens.Touch(t.ID)
+      if err != nil {
+        app.serverError(w, err)
+        return
+      }
+    }
+
+    ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+    ctx = context.WithValue(ctx, contextKeyUser, user)
+    next.ServeHTTP(w, r.WithContext(ctx))
+  })
+}
+
+// The limitAPI method returns a middleware which rate limits API requests like
+// limit does for pages, answering in the API's format instead of with the
+// HTML error page.
+func (app *application) limitAPI(l *rateLimiter) func(http.Handler) http.Handler {
+  return func(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      if l == nil {
+        next.ServeHTTP(w, r)
+        return
+      }
+
+      ok, wait := l.allow(app.rateLimitKey(r))
+      if !ok {
+        w.Header().Set("Retry-After", retryAfter(wait))
+        app.apiError(w, r, http.StatusTooManyRequests, "Too many requests. Please wait a moment and try again.")
+        return
+      }
+
+      next.ServeHTTP(w, r)
+    })
+  }
+}
+
+// An apiSnippet is a snippet as it's sent to API clients. Lists of snippets
+// leave out the files, which can be large. Expires is null for snippets which
+// never expire. Like the website, the API only names snippets by their slug.
+type apiSnippet struct {
+  Slug             string     `json:"slug"`
+  Title            string     `json:"title"`
+  URL              string     `json:"url"`
+  RawURL           string     `json:"raw_url"`
+  Visibility       string     `json:"visibility"`
+  Format           string     `json:"format"`
+  Created          time.Time  `json:"created"`
+  Updated          time.Time  `json:"updated"`
+  Expires          *time.Time `json:"expires"`
+  BurnAfterReading bool       `json:"burn_after_reading"`
+  Files            []*apiFile `json:"files,omitempty"`
+}
+
+// An apiFile is one of the files of a snippet, as sent to and from API
+// clients.
+type apiFile struct {
+  Filename string `json:"filename,omitempty"`
+  Language string `json:"language,omitempty"`
+  Content  string `json:"content"`
+}
+
+// An apiSnippetRequest is the body of a request to create or edit a snippet.
+// Fields which are left out get their defaults when creating a snippet, and
+// are left as they are when editing one. Expires takes a duration, date or
+// "never", as parseExpiry describes. The expiry and burning after reading
+// can't be changed by an edit.
+type apiSnippetRequest struct {
+  Title            string     `json:"title"`
+  Expires          string     `json:"expires"`
+  Visibility       string     `json:"visibility"`
+  Format           string     `json:"format"`
+  BurnAfterReading bool       `json:"burn_after_reading"`
+  Files            []*apiFile `json:"files"`
+}
+
+// The expiryJSON function returns a snippet's expiry for JSON responses, which
+// is nil (null) if it never expires.
+func expiryJSON(s *models.Snippet) *time.Time {
+  if s.NeverExpires() {
+    return nil
+  }
+
+  return &s.Expires
+}
+
+// The toAPISnippet helper converts a snippet for sending to an API client,
+// with absolute URLs. Its files are only included if withFiles is true.
+func toAPISnippet(r *http.Request, s *models.Snippet, withFiles bool) *apiSnippet {
+  base := requestScheme(r) + "://" + r.Host
+
+  as := &apiSnippet{
+    Slug:             s.Slug,
+    Title:            s.Title,
+    URL:              base + s.URL(),
+    RawURL:           base + s.URL() + "/raw",
+    Visibility:       s.Visibility,
+    Format:           s.Format,
+    Created:          s.Created,
+    Updated:          s.Updated,
+    Expires:          expiryJSON(s),
+    BurnAfterReading: s.BurnAfterReading,
+  }
+
+  if withFiles {
+    for _, f := range s.AllFiles() {
+      as.Files = append(as.Files, &apiFile{Filename: f.Filename, Language: f.Language, Content: f.Content})
+    }
+  }
+
+  return as
+}
+
+// The toAPISnippets helper converts a list of snippets for an API client.
+func toAPISnippets(r *http.Request, snippets []*models.Snippet) []*apiSnippet {
+  list := []*apiSnippet{}
+
+  for _, s := range snippets {
+    list = append(list, toAPISnippet(r, s, false))
+  }
+
+  return list
+}
+
+// The readSnippetRequest helper decodes the JSON body of a request to create or
+// edit a snippet into a form, with the files repeated in the filename,
+// language and content fields as they are in the website's forms. This lets
+// the API share their validation. It sends an error response and returns
+// false if the body can't be read.
+func (app *application) readSnippetRequest(w http.ResponseWriter, r *http.Request) (*forms.Form, bool) {
+  r.Body = http.MaxBytesReader(w, r.Body, app.maxPasteSize)
+
+  var req apiSnippetRequest
+
+  err := json.NewDecoder(r.Body).Decode(&req)
+
+  if err != nil {
+    if isTooLarge(err) {
+      app.apiError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("The snippet is too large (the limit is %d bytes).", app.maxPasteSize))
+    } else {
+      app.apiError(w, r, http.StatusBadRequest, "The request body isn't valid JSON.")
+    }
+
+    return nil, false
+  }
+
+  values := url.Values{
+    "title":      {req.Title},
+    "expires":    {req.Expires},
+    "visibility": {req.Visibility},
+    "format":     {req.Format},
+    "burn":       {strconv.FormatBool(req.BurnAfterReading)},
+  }
+
+  for _, f := range req.Files {
+    values.Add("filename", f.Filename)
+    values.Add("language", f.Language)
+    values.Add("content", f.Content)
+  }
+
+  return forms.New(values), true
+}
+
+// The apiSnippetBySlug helper looks up the snippet named by the :slug in the
+// URL for an API request. Like snippetBySlug, it only finds private snippets
+// for their owner.
+func (app *application) apiSnippetBySlug(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+  s, err := app.snippets.GetBySlug(r.URL.Query().Get(":slug"))
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.apiError(w, r, http.StatusNotFound, "There is no snippet with that slug.")
+    } else {
+      app.serverError(w, err)
+    }
+
+    return nil, false
+  }
+
+  if !s.VisibleTo(app.authenticatedUser(r)) {
+    app.apiError(w, r, http.StatusNotFound, "There is no snippet with that slug.")
+    return nil, false
+  }
+
+  return s, true
+}
+
+// The apiListSnippets handler lists the user's own snippets, newest first.
+func (app *application) apiListSnippets(w http.ResponseWriter, r *http.Request) {
+  snippets, err := app.snippets.ListForUser(app.authenticatedUser(r).ID)
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  list := toAPISnippets(r, snippets)
+
+  var lines []string
+  for _, s := range list {
+    lines = append(lines, s.URL+" "+s.Title)
+  }
+
+  app.apiRespond(w, r, http.StatusOK, map[string]interface{}{"snippets": list}, strings.Join(lines, "\n"))
+}
+
+// The apiSearchSnippets handler searches the snippets the user can find (the
+// public ones, and their own) for the q parameter. Results come a page at a
+// time, chosen with the page parameter.
+func (app *application) apiSearchSnippets(w http.ResponseWriter, r *http.Request) {
+  query := r.URL.Query()
+
+  page := 1
+  if v := query.Get("page"); v != "" {
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 1 {
+      app.apiError(w, r, http.StatusBadRequest, "The page must be a positive number.")
+      return
+    }
+
+    page = n
+  }
+
+  snippets, total, err := app.snippets.Search(query.Get("q"), app.authenticatedUser(r).ID, (page-1)*apiPageSize, apiPageSize)
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  list := toAPISnippets(r, snippets)
+
+  var lines []string
+  for _, s := range list {
+    lines = append(lines, s.URL+" "+s.Title)
+  }
+
+  app.apiRespond(w, r, http.StatusOK, map[string]interface{}{"snippets": list, "total": total, "page": page}, strings.Join(lines, "\n"))
+}
+
+// The apiShowSnippet handler sends a snippet, with its files. Reading a
+// snippet to be burnt after reading deletes it, as it does on the website.
+func (app *application) apiShowSnippet(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.apiSnippetBySlug(w, r)
+  if !ok {
+    return
+  }
+
+  err := app.burnOnRead(r, s)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.apiError(w, r, http.StatusNotFound, "There is no snippet with that slug.")
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  as := toAPISnippet(r, s, true)
+  app.apiRespond(w, r, http.StatusOK, as, as.URL)
+}
+
+// The apiCreateSnippet handler creates a snippet from a JSON request, which
+// unlike /paste can have several files.
+func (app *application) apiCreateSnippet(w http.ResponseWriter, r *http.Request) {
+  form, ok := app.readSnippetRequest(w, r)
+  if !ok {
+    return
+  }
+
+  for key, value := range map[string]string{"expires": "365", "visibility": models.VisibilityPublic, "format": models.FormatPlain} {
+    if form.Get(key) == "" {
+      form.Set(key, value)
+    }
+  }
+
+  form.Required("title")
+  form.MaxLength("title", 100)
+  form.PermittedValues("visibility", models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate)
+  form.PermittedValues("format", models.FormatPlain, models.FormatMarkdown)
+
+  files := formFiles(form)
+  expires, burn := formExpiry(form, time.Now())
+
+  if !form.Valid() {
+    app.apiValidationError(w, r, form.Errors)
+    return
+  }
+
+  detectLanguages(files, form.Get("format"))
+
+  slug, err := newSlug()
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  id, err := app.snippets.Insert(app.authenticatedUser(r).ID, slug, form.Get("title"), expires, form.Get("visibility"), form.Get("format"), burn, files)
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  app.audit(r, models.AuditSnippetCreate, fmt.Sprintf("snippet:%d", id))
+
+  app.respondCreated(w, r, &models.Snippet{ID: id, Slug: slug})
+}
+
+// The apiEditSnippet handler saves an edit of a snippet as its next revision,
+// as the edit page does. Only the title, format and files can be changed.
+func (app *application) apiEditSnippet(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.apiSnippetBySlug(w, r)
+  if !ok {
+    return
+  }
+
+  user := app.authenticatedUser(r)
+
+  if !user.CanEditSnippet(s) {
+    app.apiError(w, r, http.StatusForbidden, "You don't have permission to do that.")
+    return
+  }
+
+  form, ok := app.readSnippetRequest(w, r)
+  if !ok {
+    return
+  }
+
+  if form.Get("title") == "" {
+    form.Set("title", s.Title)
+  }
+
+  if form.Get("format") == "" {
+    form.Set("format", s.Format)
+  }
+
+  if len(form.Values["content"]) == 0 {
+    for _, f := range s.AllFiles() {
+      form.Add("filename", f.Filename)
+      form.Add("language", f.Language)
+      form.Add("content", f.Content)
+    }
+  }
+
+  form.MaxLength("title", 100)
+  form.PermittedValues("format", models.FormatPlain, models.FormatMarkdown)
+
+  files := formFiles(form)
+
+  if !form.Valid() {
+    app.apiValidationError(w, r, form.Errors)
+    return
+  }
+
+  detectLanguages(files, form.Get("format"))
+
+  if form.Get("title") != s.Title || form.Get("format") != s.Format || !models.SameFiles(files, s.AllFiles()) {
+    number, err := app.snippets.Update(s.ID, user.ID, form.Get("title"), form.Get("format"), files)
+
+    if err != nil {
+      app.serverError(w, err)
+      return
+    }
+
+    app.audit(r, models.AuditSnippetEdit, fmt.Sprintf("snippet:%d revision:%d", s.ID, number))
+
+    s, err = app.snippets.Get(s.ID)
+
+    if err != nil {
+      app.serverError(w, err)
+      return
+    }
+  }
+
+  as := toAPISnippet(r, s, true)
+  app.apiRespond(w, r, http.StatusOK, as, as.URL)
+}
+
+// The apiDeleteSnippet handler deletes a snippet, if the user is its owner or
+// a moderator.
+func (app *application) apiDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.apiSnippetBySlug(w, r)
+  if !ok {
+    return
+  }
+
+  if !app.authenticatedUser(r).CanDeleteSnippet(s) {
+    app.apiError(w, r, http.StatusForbidden, "You don't have permission to do that.")
+    return
+  }
+
+  err := app.snippets.Delete(s.ID)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.apiError(w, r, http.StatusNotFound, "There is no snippet with that slug.")
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  app.audit(r, models.AuditSnippetDelete, fmt.Sprintf("snippet:%d", s.ID))
+
+  w.WriteHeader(http.StatusNoContent)
+}
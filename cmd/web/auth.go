@@ -0,0 +1,161 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "log"
+  "strings"
+
+  "mateuszurbanski/snippetbox/pkg/ldap"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The errAuthUnavailable error is returned by an authenticator which can't
+// check credentials right now, such as when the directory is down.
+var errAuthUnavailable = errors.New("authentication is unavailable")
+
+// Define an authenticator interface for checking a user's email address and
+// password. It returns the user's ID, or models.ErrInvalidCredentials. The
+// local users model satisfies it, and so does ldapAuthenticator.
+type authenticator interface {
+  Authenticate(string, string) (int, error)
+}
+
+// The userStore interface is the part of the users model needed to create and
+// update accounts for users who authenticate somewhere else.
+type userStore interface {
+  Insert(string, string, string) error
+  GetByEmail(string) (*models.User, error)
+  SetRole(int, string) error
+}
+
+// The provisionUser function creates an account for a user who has been
+// authenticated by an external provider. The account gets a random password
+// which nobody knows, so it can only be used through that provider.
+func provisionUser(users userStore, name, email string) (*models.User, error) {
+  if name == "" {
+    name = email
+  }
+
+  password, err := generateSecret()
+  if err != nil {
+    return nil, err
+  }
+
+  err = users.Insert(name, email, password)
+  if err != nil {
+    return nil, err
+  }
+
+  return users.GetByEmail(email)
+}
+
+// A roleGroup maps members of a directory group to a role.
+type roleGroup struct {
+  role string
+  dn   string
+}
+
+// The parseRoleGroups function parses the -ldap-role-groups flag, which is a
+// semicolon-separated list of role:groupDN pairs, for example
+// "admin:cn=admins,ou=groups,dc=example,dc=com".
+func parseRoleGroups(s string) ([]roleGroup, error) {
+  var groups []roleGroup
+
+  for _, field := range strings.Split(s, ";") {
+    field = strings.TrimSpace(field)
+    if field == "" {
+      continue
+    }
+
+    parts := strings.SplitN(field, ":", 2)
+    if len(parts) != 2 || !models.ValidRole(parts[0]) || strings.TrimSpace(parts[1]) == "" {
+      return nil, fmt.Errorf("invalid role group %q: want <role>:<group DN>", field)
+    }
+
+    groups = append(groups, roleGroup{role: parts[0], dn: strings.TrimSpace(parts[1])})
+  }
+
+  return groups, nil
+}
+
+// The ldapAuthenticator checks passwords against an LDAP directory. Directory
+// users get a local account the first time they log in, and if role groups
+// are configured their role is kept in sync with their group membership.
+// Users who aren't in the directory are handed on to the fallback
+// authenticator. If the directory can't be reached nobody can log in, since
+// a user removed from the directory could otherwise get in with an old local
+// password.
+type ldapAuthenticator struct {
+  client         *ldap.Client
+  users          userStore
+  fallback       authenticator
+  errorLog       *log.Logger
+  roleGroups     []roleGroup
+  mailAttribute  string
+  nameAttribute  string
+  groupAttribute string
+}
+
+func (a *ldapAuthenticator) Authenticate(email, password string) (int, error) {
+  entry, err := a.client.Authenticate(email, password)
+
+  switch {
+  case errors.Is(err, ldap.ErrInvalidCredentials):
+    return 0, models.ErrInvalidCredentials
+  case errors.Is(err, ldap.ErrNoSuchUser):
+    return a.fallback.Authenticate(email, password)
+  case err != nil:
+    a.errorLog.Output(2, fmt.Sprintf("LDAP authentication failed: %s", err))
+    return 0, errAuthUnavailable
+  }
+
+  // Prefer the address held by the directory, so that users who log in with
+  // a differently-cased email still map to a single account.
+  if mail := entry.Get(a.mailAttribute); mail != "" {
+    email = mail
+  }
+
+  user, err := a.users.GetByEmail(email)
+  if errors.Is(err, models.ErrNoRecord) {
+    user, err = provisionUser(a.users, entry.Get(a.nameAttribute), email)
+  }
+
+  if err != nil {
+    return 0, err
+  }
+
+  if !user.Active {
+    return 0, models.ErrInvalidCredentials
+  }
+
+  if len(a.roleGroups) > 0 {
+    role := a.role(entry)
+
+    if role != user.Role {
+      err = a.users.SetRole(user.ID, role)
+      if err != nil {
+        return 0, err
+      }
+    }
+  }
+
+  return user.ID, nil
+}
+
+// The role method returns the highest role granted by the groups the entry is
+// a member of. Users who aren't in any of the configured groups are ordinary
+// users.
+func (a *ldapAuthenticator) role(entry *ldap.Entry) string {
+  role := models.RoleUser
+
+  for _, dn := range entry.GetAll(a.groupAttribute) {
+    for _, g := range a.roleGroups {
+      if strings.EqualFold(dn, g.dn) && models.OutranksRole(g.role, role) {
+        role = g.role
+      }
+    }
+  }
+
+  return role
+}
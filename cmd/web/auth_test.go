@@ -0,0 +1,225 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "testing"
+
+    "mateuszurbanski/snippetbox/pkg/ldap"
+    "mateuszurbanski/snippetbox/pkg/ldap/ldaptest"
+    "mateuszurbanski/snippetbox/pkg/models/mock"
+)
+
+func newTestDirectory() *ldaptest.Server {
+    return ldaptest.NewServer(
+        ldaptest.Entry{
+            DN:       "cn=snippetbox,ou=services,dc=example,dc=com",
+            Password: "service-pa$$word",
+        },
+        ldaptest.Entry{
+            DN:       "uid=bob,ou=people,dc=example,dc=com",
+            Password: "bobs-pa$$word",
+            Attributes: map[string][]string{
+                "objectClass": {"person"},
+                "cn":          {"Bob"},
+                "mail":        {"bob@example.com"},
+                "memberOf":    {"cn=admins,ou=groups,dc=example,dc=com"},
+            },
+        },
+        ldaptest.Entry{
+            DN:       "uid=carol,ou=people,dc=example,dc=com",
+            Password: "carols-pa$$word",
+            Attributes: map[string][]string{
+                "objectClass": {"person"},
+                "cn":          {"Carol"},
+                "mail":        {"carol@example.com"},
+            },
+        },
+    )
+}
+
+func TestLDAPLogin(t *testing.T) {
+    directory := newTestDirectory()
+    defer directory.Close()
+
+    roleGroups, err := parseRoleGroups("admin:cn=admins,ou=groups,dc=example,dc=com; moderator:cn=mods,ou=groups,dc=example,dc=com")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client, err := ldap.New(ldap.Config{
+        URL:          directory.URL,
+        BindDN:       "cn=snippetbox,ou=services,dc=example,dc=com",
+        BindPassword: "service-pa$$word",
+        BaseDN:       "ou=people,dc=example,dc=com",
+        UserFilter:   "(&(objectClass=person)(mail=%s))",
+        Attributes:   []string{"mail", "cn", "memberOf"},
+        TLSConfig:    directory.TLSConfig(),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name      string
+        email     string
+        password  string
+        wantCode  int
+        wantBody  []byte
+        wantAdmin int
+    }{
+        {"Directory admin", "bob@example.com", "bobs-pa$$word", http.StatusSeeOther, nil, http.StatusOK},
+        {"Directory user", "carol@example.com", "carols-pa$$word", http.StatusSeeOther, nil, http.StatusForbidden},
+        {"Wrong password", "bob@example.com", "pa$$word", http.StatusOK, []byte("Email or password is incorrect"), http.StatusSeeOther},
+        {"Empty password", "bob@example.com", "", http.StatusOK, []byte("Email or password is incorrect"), http.StatusSeeOther},
+        {"Filter injection", "*", "bobs-pa$$word", http.StatusOK, []byte("Email or password is incorrect"), http.StatusSeeOther},
+        {"Local fallback", "alice@example.com", "pa$$word", http.StatusSeeOther, nil, http.StatusForbidden},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            users := app.users.(*mock.UserModel)
+
+            app.authenticator = &ldapAuthenticator{
+                client:         client,
+                users:          users,
+                fallback:       users,
+                errorLog:       log.New(io.Discard, "", 0),
+                roleGroups:     roleGroups,
+                mailAttribute:  "mail",
+                nameAttribute:  "cn",
+                groupAttribute: "memberOf",
+            }
+
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            _, _, body := ts.get(t, "/user/login")
+
+            form := url.Values{}
+            form.Add("email", tt.email)
+            form.Add("password", tt.password)
+            form.Add("csrf_token", extractCSRFToken(t, body))
+
+            code, _, body := ts.postForm(t, "/user/login", form)
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+
+            code, _, _ = ts.get(t, "/admin")
+            if code != tt.wantAdmin {
+                t.Errorf("admin: want %d; got %d", tt.wantAdmin, code)
+            }
+        })
+    }
+}
+
+func TestLDAPUnreachable(t *testing.T) {
+    directory := newTestDirectory()
+    defer directory.Close()
+
+    stopped := newTestDirectory()
+    stopped.Close()
+
+    tests := []struct {
+        name   string
+        config ldap.Config
+    }{
+        {"Directory down", ldap.Config{URL: stopped.URL}},
+        {"Untrusted certificate", ldap.Config{URL: directory.URL}},
+        {"Without StartTLS", ldap.Config{URL: directory.URL, Insecure: true, BindDN: "cn=snippetbox,ou=services,dc=example,dc=com", BindPassword: "service-pa$$word"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.config.BaseDN = "dc=example,dc=com"
+
+            client, err := ldap.New(tt.config)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            users := &mock.UserModel{}
+
+            a := &ldapAuthenticator{
+                client:   client,
+                users:    users,
+                fallback: users,
+                errorLog: log.New(io.Discard, "", 0),
+            }
+
+            // Local passwords aren't accepted either, since they may belong to
+            // somebody who has been removed from the directory.
+            id, err := a.Authenticate("alice@example.com", "pa$$word")
+            if err != errAuthUnavailable {
+                t.Errorf("want %v; got %d, %v", errAuthUnavailable, id, err)
+            }
+        })
+    }
+
+    // The login form says so, rather than blaming the password.
+    app := newTestApplication(t)
+
+    client, err := ldap.New(ldap.Config{URL: stopped.URL})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    app.authenticator = &ldapAuthenticator{
+        client:   client,
+        users:    app.users.(*mock.UserModel),
+        fallback: app.users.(*mock.UserModel),
+        errorLog: log.New(io.Discard, "", 0),
+    }
+
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    _, _, body := ts.get(t, "/user/login")
+
+    form := url.Values{}
+    form.Add("email", "alice@example.com")
+    form.Add("password", "pa$$word")
+    form.Add("csrf_token", extractCSRFToken(t, body))
+
+    code, _, body := ts.postForm(t, "/user/login", form)
+
+    if code != http.StatusOK || !bytes.Contains(body, []byte("Logging in isn&#39;t possible right now")) {
+        t.Errorf("want the login form with an error; got %d", code)
+    }
+}
+
+func TestParseRoleGroups(t *testing.T) {
+    tests := []struct {
+        name    string
+        input   string
+        want    int
+        wantErr bool
+    }{
+        {"Empty", "", 0, false},
+        {"Two groups", "admin:cn=admins,dc=example;moderator:cn=mods,dc=example", 2, false},
+        {"Unknown role", "root:cn=admins,dc=example", 0, true},
+        {"Missing DN", "admin:", 0, true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            groups, err := parseRoleGroups(tt.input)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("want error %v; got %v", tt.wantErr, err)
+            }
+
+            if len(groups) != tt.want {
+                t.Errorf("want %d groups; got %d", tt.want, len(groups))
+            }
+        })
+    }
+}
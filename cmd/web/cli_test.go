@@ -0,0 +1,239 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "mateuszurbanski/snippetbox/pkg/cli"
+    "mateuszurbanski/snippetbox/pkg/models/mock"
+)
+
+// The newTestCLI function returns a command line client which talks to ts as
+// Alice, with the given standard input, and buffers for its output.
+func newTestCLI(t *testing.T, ts *testServer, stdin string) (*cli.CLI, *bytes.Buffer, *bytes.Buffer) {
+    stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+    env := map[string]string{
+        "SNIPPETBOX_SERVER": ts.URL,
+        "SNIPPETBOX_TOKEN":  mock.MockAPIToken,
+    }
+
+    c := &cli.CLI{
+        Stdin:      strings.NewReader(stdin),
+        Stdout:     stdout,
+        Stderr:     stderr,
+        Getenv:     func(key string) string { return env[key] },
+        ConfigPath: filepath.Join(t.TempDir(), "config.json"),
+        HTTPClient: ts.Client(),
+        Edit: func(paths []string) error {
+            t.Fatal("the editor shouldn't be run")
+            return nil
+        },
+    }
+
+    return c, stdout, stderr
+}
+
+func TestCLI(t *testing.T) {
+    dir := t.TempDir()
+
+    mainGo := filepath.Join(dir, "main.go")
+    err := os.WriteFile(mainGo, []byte("package main\n"), 0600)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name       string
+        args       []string
+        stdin      string
+        wantStatus int
+        wantStdout string
+        wantStderr string
+    }{
+        {"No command", nil, "", 2, "", "Usage: snippetbox"},
+        {"Unknown command", []string{"frobnicate"}, "", 2, "", `unknown command "frobnicate"`},
+        {"Create from stdin", []string{"create", "-title", "A pond"}, "An old silent pond...", 0, "/s/", ""},
+        {"Create from files", []string{"create", mainGo}, "", 0, "/s/", ""},
+        {"Create as JSON", []string{"create", "-o", "json", mainGo}, "", 0, `"raw_url": "https://`, ""},
+        {"Create an empty snippet", []string{"create"}, "", 1, "", "files: This field cannot be blank"},
+        {"Create with a bad expiry", []string{"create", "-expires", "soon"}, "Some text", 1, "", "expires: This field is invalid"},
+        {"Create from a missing file", []string{"create", filepath.Join(dir, "missing.go")}, "", 1, "", "no such file"},
+        {"List", []string{"list"}, "", 0, "noteB4nW8cYe1sZa  private", ""},
+        {"List as JSON", []string{"list", "-o", "json"}, "", 0, `"slug": "noteB4nW8cYe1sZa"`, ""},
+        {"Bad output format", []string{"list", "-o", "yaml"}, "", 2, "", `invalid output format "yaml"`},
+        {"Search", []string{"search", "pond"}, "", 0, "pondXq7kLm2vRt9w", ""},
+        {"Search finds own private snippets", []string{"search", "eyes"}, "", 0, "noteB4nW8cYe1sZa", ""},
+        {"Search skips unlisted snippets", []string{"search", "wintry"}, "", 0, "0 of 0 results", ""},
+        {"Search without a query", []string{"search"}, "", 2, "", "Usage: snippetbox search"},
+        {"Show", []string{"show", "pondXq7kLm2vRt9w"}, "", 0, "An old silent pond...\n", ""},
+        {"Show by URL", []string{"show", "https://snippetbox.example.com/s/pondXq7kLm2vRt9w/raw"}, "", 0, "An old silent pond...\n", ""},
+        {"Show several files", []string{"show", "gistM8nB2vC4xZ6q"}, "", 0, "==> main.go <==\npackage main\n\nfunc main() {}\n\n==> go.mod <==", ""},
+        {"Show as JSON", []string{"show", "-o", "json", "gistM8nB2vC4xZ6q"}, "", 0, `"filename": "go.mod"`, ""},
+        {"Show a missing snippet", []string{"show", "missingAAAAAAAAA"}, "", 1, "", "There is no snippet with that slug."},
+        {"Delete", []string{"delete", "noteB4nW8cYe1sZa"}, "", 0, "Deleted noteB4nW8cYe1sZa.", ""},
+        {"Delete someone else's", []string{"delete", "pondXq7kLm2vRt9w"}, "", 1, "", "You don't have permission to do that."},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            c, stdout, stderr := newTestCLI(t, ts, tt.stdin)
+
+            status := c.Run(tt.args)
+
+            if status != tt.wantStatus {
+                t.Errorf("want status %d; got %d (%s)", tt.wantStatus, status, stderr)
+            }
+
+            if !strings.Contains(stdout.String(), tt.wantStdout) {
+                t.Errorf("want stdout to contain %q; got %q", tt.wantStdout, stdout)
+            }
+
+            if !strings.Contains(stderr.String(), tt.wantStderr) {
+                t.Errorf("want stderr to contain %q; got %q", tt.wantStderr, stderr)
+            }
+        })
+    }
+}
+
+func TestCLILogin(t *testing.T) {
+    tests := []struct {
+        name       string
+        token      string
+        wantStatus int
+    }{
+        {"Valid token", mock.MockAPIToken, 0},
+        {"Invalid token", "sbx_wrong", 1},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            c, _, stderr := newTestCLI(t, ts, tt.token+"\n")
+            c.Getenv = func(string) string { return "" }
+
+            status := c.Run([]string{"login", "-server", ts.URL + "/"})
+
+            if status != tt.wantStatus {
+                t.Fatalf("want status %d; got %d (%s)", tt.wantStatus, status, stderr)
+            }
+
+            b, err := os.ReadFile(c.ConfigPath)
+
+            if tt.wantStatus != 0 {
+                if !os.IsNotExist(err) {
+                    t.Error("want no config to be saved")
+                }
+
+                return
+            }
+
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            var cfg cli.Config
+            err = json.Unmarshal(b, &cfg)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if cfg.Server != ts.URL || cfg.Token != tt.token {
+                t.Errorf("want the server and token saved; got %+v", cfg)
+            }
+
+            // The saved config is used by later commands.
+            status = c.Run([]string{"list"})
+
+            if status != 0 {
+                t.Errorf("want status 0; got %d (%s)", status, stderr)
+            }
+        })
+    }
+}
+
+func TestCLIEdit(t *testing.T) {
+    tests := []struct {
+        name       string
+        slug       string
+        edit       func(t *testing.T, paths []string)
+        wantStatus int
+        wantStdout string
+        wantStderr string
+    }{
+        {
+            "Changed",
+            "noteB4nW8cYe1sZa",
+            func(t *testing.T, paths []string) {
+                if len(paths) != 1 || filepath.Base(paths[0]) != "snippet.txt" {
+                    t.Errorf("want snippet.txt; got %q", paths)
+                }
+
+                os.WriteFile(paths[0], []byte("For my eyes only, again..."), 0600)
+            },
+            0,
+            "/s/noteB4nW8cYe1sZa",
+            "",
+        },
+        {"Unchanged", "noteB4nW8cYe1sZa", func(t *testing.T, paths []string) {}, 0, "", "No changes to save."},
+        {
+            "Emptied",
+            "noteB4nW8cYe1sZa",
+            func(t *testing.T, paths []string) {
+                os.WriteFile(paths[0], nil, 0600)
+            },
+            1,
+            "",
+            "every file is empty",
+        },
+        {
+            "Not the owner",
+            "pondXq7kLm2vRt9w",
+            func(t *testing.T, paths []string) {
+                os.WriteFile(paths[0], []byte("A new pond"), 0600)
+            },
+            1,
+            "",
+            "You don't have permission to do that.",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            c, stdout, stderr := newTestCLI(t, ts, "")
+            c.Edit = func(paths []string) error {
+                tt.edit(t, paths)
+                return nil
+            }
+
+            status := c.Run([]string{"edit", tt.slug})
+
+            if status != tt.wantStatus {
+                t.Errorf("want status %d; got %d (%s)", tt.wantStatus, status, stderr)
+            }
+
+            if !strings.Contains(stdout.String(), tt.wantStdout) {
+                t.Errorf("want stdout to contain %q; got %q", tt.wantStdout, stdout)
+            }
+
+            if !strings.Contains(stderr.String(), tt.wantStderr) {
+                t.Errorf("want stderr to contain %q; got %q", tt.wantStderr, stderr)
+            }
+        })
+    }
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+  "errors"
+  "regexp"
+  "strconv"
+  "strings"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/forms"
+)
+
+// The longest a snippet can be kept for with an expiry. Snippets which should
+// be kept for longer never expire.
+const maxExpiry = 10 * 365 * 24 * time.Hour
+
+// Durations can be given in minutes, hours, days or weeks, like "90m", "12h",
+// "3d" or "2w". A bare number is a number of days, as the create form used to
+// offer.
+var expiryDurationRX = regexp.MustCompile(`^(\d{1,6})\s*(m|min|h|d|w)?$`)
+
+// The layouts accepted for an absolute expiry. Times without a zone are in
+// UTC, which is what the site shows.
+var expiryLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04"}
+
+var (
+  errExpiryInvalid = errors.New("This field is invalid (use a duration like 90m, 12h or 7d, a date like 2030-12-31, or never)")
+  errExpiryPast    = errors.New("This must be in the future")
+  errExpiryTooLate = errors.New("This must be within 10 years (choose never to keep the snippet for longer)")
+)
+
+// The parseExpiry function works out when a snippet expires from the expires
+// value of a form or API request, which is one of:
+//
+//   - "never", for which it returns the zero time;
+//   - a duration from now, like "90m", "12h", "3d", "2w", or "7" for 7 days;
+//   - a date, like "2030-12-31", meaning the end of that day (UTC);
+//   - a time, like "2030-12-31T18:00" (UTC) or in RFC 3339 format.
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+  value = strings.ToLower(strings.TrimSpace(value))
+
+  if value == "never" {
+    return time.Time{}, nil
+  }
+
+  var expires time.Time
+
+  if m := expiryDurationRX.FindStringSubmatch(value); m != nil {
+    n, _ := strconv.Atoi(m[1])
+
+    unit := map[string]time.Duration{
+      "m":   time.Minute,
+      "min": time.Minute,
+      "h":   time.Hour,
+      "":    24 * time.Hour,
+      "d":   24 * time.Hour,
+      "w":   7 * 24 * time.Hour,
+    }[m[2]]
+
+    // Check the number against the limit before multiplying, so that huge
+    // numbers can't overflow.
+    if time.Duration(n) > maxExpiry/unit {
+      return time.Time{}, errExpiryTooLate
+    }
+
+    expires = now.Add(time.Duration(n) * unit)
+  } else if t, err := time.Parse("2006-01-02", value); err == nil {
+    expires = t.AddDate(0, 0, 1)
+  } else {
+    for _, layout := range expiryLayouts {
+      t, err := time.Parse(layout, strings.ToUpper(value))
+      if err == nil {
+        expires = t
+        break
+      }
+    }
+
+    if expires.IsZero() {
+      return time.Time{}, errExpiryInvalid
+    }
+  }
+
+  switch {
+  case !expires.After(now):
+    return time.Time{}, errExpiryPast
+  case expires.Sub(now) > maxExpiry:
+    return time.Time{}, errExpiryTooLate
+  }
+
+  return expires.UTC(), nil
+}
+
+// The formExpiry function reads when a snippet expires, and whether it's to be
+// burnt after reading, from a form. The create page chooses the expiry with
+// the expires field, which can be "custom" to use the expires_at field
+// instead. Problems are added to the form errors. A zero time means the
+// snippet never expires.
+func formExpiry(form *forms.Form, now time.Time) (time.Time, bool) {
+  value := form.Get("expires")
+  if value == "custom" {
+    value = form.Get("expires_at")
+  }
+
+  expires, err := parseExpiry(value, now)
+  if err != nil {
+    form.Errors.Add("expires", err.Error())
+  }
+
+  var burn bool
+
+  if v := form.Get("burn"); v != "" {
+    burn, err = strconv.ParseBool(v)
+    if err != nil {
+      form.Errors.Add("burn", "This field is invalid")
+    }
+  }
+
+  return expires, burn
+}
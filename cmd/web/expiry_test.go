@@ -0,0 +1,341 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "net/http"
+    "net/url"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestParseExpiry(t *testing.T) {
+    now := time.Date(2030, 6, 15, 12, 0, 0, 0, time.UTC)
+
+    tests := []struct {
+        name    string
+        value   string
+        want    time.Time
+        wantErr error
+    }{
+        {"Never", "never", time.Time{}, nil},
+        {"Never, shouting", " NEVER ", time.Time{}, nil},
+        {"Days", "7", now.AddDate(0, 0, 7), nil},
+        {"Minutes", "90m", now.Add(90 * time.Minute), nil},
+        {"Minutes, spelt out", "10 min", now.Add(10 * time.Minute), nil},
+        {"Hours", "36h", now.Add(36 * time.Hour), nil},
+        {"Days with a unit", "3d", now.AddDate(0, 0, 3), nil},
+        {"Weeks", "2w", now.AddDate(0, 0, 14), nil},
+        {"Date", "2030-12-31", time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), nil},
+        {"Date and time", "2030-12-31T18:00", time.Date(2030, 12, 31, 18, 0, 0, 0, time.UTC), nil},
+        {"Date and time with a space", "2030-12-31 18:00", time.Date(2030, 12, 31, 18, 0, 0, 0, time.UTC), nil},
+        {"RFC 3339", "2030-12-31T18:00:00+02:00", time.Date(2030, 12, 31, 16, 0, 0, 0, time.UTC), nil},
+        {"Zero", "0", time.Time{}, errExpiryPast},
+        {"Past date", "2030-06-14", time.Time{}, errExpiryPast},
+        {"Past time", "2030-06-15T11:59", time.Time{}, errExpiryPast},
+        {"Too far", "11000d", time.Time{}, errExpiryTooLate},
+        {"Huge number", "999999w", time.Time{}, errExpiryTooLate},
+        {"Too far away date", "2045-01-01", time.Time{}, errExpiryTooLate},
+        {"Empty", "", time.Time{}, errExpiryInvalid},
+        {"Unknown unit", "5y", time.Time{}, errExpiryInvalid},
+        {"Negative", "-5m", time.Time{}, errExpiryInvalid},
+        {"Nonsense", "soon", time.Time{}, errExpiryInvalid},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := parseExpiry(tt.value, now)
+
+            if err != tt.wantErr {
+                t.Fatalf("want error %v; got %v", tt.wantErr, err)
+            }
+
+            if !got.Equal(tt.want) {
+                t.Errorf("want %v; got %v", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestCreateSnippetExpiry(t *testing.T) {
+    tests := []struct {
+        name      string
+        expires   string
+        expiresAt string
+        burn      string
+        wantCode  int
+        wantBody  []byte
+    }{
+        {"Preset", "1h", "", "", http.StatusSeeOther, nil},
+        {"Days", "365", "", "", http.StatusSeeOther, nil},
+        {"Never", "never", "", "", http.StatusSeeOther, nil},
+        {"Custom duration", "custom", "90m", "", http.StatusSeeOther, nil},
+        {"Custom date", "custom", "2099-12-31", "", http.StatusOK, []byte("This must be within 10 years")},
+        {"Custom in the past", "custom", "2001-01-01 12:00", "", http.StatusOK, []byte("This must be in the future")},
+        {"Custom without a value", "custom", "", "", http.StatusOK, []byte("This field is invalid (use a duration")},
+        {"Burn after reading", "7", "", "true", http.StatusSeeOther, nil},
+        {"Invalid burn", "7", "", "maybe", http.StatusOK, []byte("This field is invalid")},
+        {"Missing", "", "", "", http.StatusOK, []byte("This field cannot be blank")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            ts.login(t, "alice@example.com")
+
+            _, _, body := ts.get(t, "/snippet/create")
+
+            form := url.Values{}
+            form.Add("csrf_token", extractCSRFToken(t, body))
+            form.Add("title", "A title")
+            form.Add("content", "Some text")
+            form.Add("visibility", "unlisted")
+            form.Add("expires", tt.expires)
+            form.Add("expires_at", tt.expiresAt)
+            form.Add("burn", tt.burn)
+
+            code, _, body := ts.postForm(t, "/snippet/create", form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+        })
+    }
+}
+
+func TestBurnAfterReading(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    // Link previews often only make a HEAD request, which doesn't burn it.
+    rs, err := ts.Client().Head(ts.URL + "/s/burnR2tY4uI6oP8a")
+    if err != nil {
+        t.Fatal(err)
+    }
+    rs.Body.Close()
+
+    if rs.StatusCode != http.StatusOK {
+        t.Fatalf("HEAD: want %d; got %d", http.StatusOK, rs.StatusCode)
+    }
+
+    // The history would show it without burning it, so it isn't found.
+    code, _, _ := ts.get(t, "/s/burnR2tY4uI6oP8a/revisions")
+
+    if code != http.StatusNotFound {
+        t.Errorf("history: want %d; got %d", http.StatusNotFound, code)
+    }
+
+    code, header, body := ts.get(t, "/s/burnR2tY4uI6oP8a/raw")
+
+    if code != http.StatusOK {
+        t.Fatalf("first read: want %d; got %d", http.StatusOK, code)
+    }
+
+    if !bytes.Equal(body, []byte("This message will self-destruct...")) {
+        t.Errorf("want the content; got %q", body)
+    }
+
+    if cc := header.Get("Cache-Control"); cc != "no-store" {
+        t.Errorf("want Cache-Control no-store; got %q", cc)
+    }
+
+    for _, urlPath := range []string{"/s/burnR2tY4uI6oP8a", "/s/burnR2tY4uI6oP8a/raw", "/s/burnR2tY4uI6oP8a/download"} {
+        code, _, _ = ts.get(t, urlPath)
+
+        if code != http.StatusNotFound {
+            t.Errorf("%s after reading: want %d; got %d", urlPath, http.StatusNotFound, code)
+        }
+    }
+}
+
+func TestBurnAfterReadingConditional(t *testing.T) {
+    tests := []struct {
+        name   string
+        header string
+        value  string
+    }{
+        {"Range", "Range", "bytes=0-3"},
+        {"If-None-Match", "If-None-Match", "*"},
+        {"If-Modified-Since", "If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            // Reading the snippet burns it, so whatever the request asks for,
+            // it gets the whole content.
+            req, err := http.NewRequest(http.MethodGet, ts.URL+"/s/burnR2tY4uI6oP8a/raw", nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            req.Header.Set(tt.header, tt.value)
+
+            rs, err := ts.Client().Do(req)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer rs.Body.Close()
+
+            body, err := io.ReadAll(rs.Body)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if rs.StatusCode != http.StatusOK {
+                t.Errorf("want %d; got %d", http.StatusOK, rs.StatusCode)
+            }
+
+            if !bytes.Equal(body, []byte("This message will self-destruct...")) {
+                t.Errorf("want the whole content; got %q", body)
+            }
+
+            code, _, _ := ts.get(t, "/s/burnR2tY4uI6oP8a/raw")
+            if code != http.StatusNotFound {
+                t.Errorf("after reading: want %d; got %d", http.StatusNotFound, code)
+            }
+        })
+    }
+}
+
+func TestBurnAfterReadingFiles(t *testing.T) {
+    tests := []struct {
+        name      string
+        email     string
+        urlPath   string
+        wantCode  int
+        afterPath string
+        wantAfter int
+    }{
+        {"Non-existent file", "", "/s/burnR2tY4uI6oP8a/raw/99", http.StatusNotFound, "/s/burnR2tY4uI6oP8a/raw", http.StatusOK},
+        {"Only file", "", "/s/burnR2tY4uI6oP8a/raw/1", http.StatusOK, "/s/burnR2tY4uI6oP8a/raw", http.StatusNotFound},
+        {"One of several files", "", "/s/burnF3gH5jK7lZ9x/raw/1", http.StatusNotFound, "/s/burnF3gH5jK7lZ9x/raw", http.StatusOK},
+        {"One of several files, owner", "ada@example.com", "/s/burnF3gH5jK7lZ9x/raw/2", http.StatusOK, "/s/burnF3gH5jK7lZ9x/raw", http.StatusOK},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            if tt.email != "" {
+                ts.login(t, tt.email)
+            }
+
+            code, _, _ := ts.get(t, tt.urlPath)
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            // Only a file which was actually sent burns the snippet.
+            code, _, _ = ts.get(t, tt.afterPath)
+            if code != tt.wantAfter {
+                t.Errorf("%s afterwards: want %d; got %d", tt.afterPath, tt.wantAfter, code)
+            }
+        })
+    }
+}
+
+func TestBurnAfterReadingPage(t *testing.T) {
+    tests := []struct {
+        name      string
+        email     string
+        wantBody  []byte
+        wantBurnt bool
+    }{
+        {"Owner", "ada@example.com", []byte("This snippet will be deleted the first time somebody else reads it."), false},
+        {"Someone else", "alice@example.com", []byte("This snippet has been deleted now that you've read it."), true},
+        {"Anonymous", "", []byte("This snippet has been deleted now that you've read it."), true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            if tt.email != "" {
+                ts.login(t, tt.email)
+            }
+
+            code, _, body := ts.get(t, "/s/burnR2tY4uI6oP8a")
+
+            if code != http.StatusOK {
+                t.Fatalf("want %d; got %d", http.StatusOK, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+
+            if !bytes.Contains(body, []byte("This message will self-destruct...")) {
+                t.Error("want the content to be shown")
+            }
+
+            // Once it's burnt, there's nothing left to link to.
+            if links := bytes.Contains(body, []byte("/s/burnR2tY4uI6oP8a/raw")); links == tt.wantBurnt {
+                t.Errorf("want raw links %t; got %t", !tt.wantBurnt, links)
+            }
+
+            code, _, _ = ts.get(t, "/s/burnR2tY4uI6oP8a")
+
+            if burnt := code == http.StatusNotFound; burnt != tt.wantBurnt {
+                t.Errorf("want burnt %t; got %t", tt.wantBurnt, burnt)
+            }
+        })
+    }
+}
+
+func TestBurnAfterReadingRace(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    const readers = 10
+
+    var wg sync.WaitGroup
+    codes := make(chan int, readers)
+
+    for i := 0; i < readers; i++ {
+        wg.Add(1)
+
+        go func() {
+            defer wg.Done()
+
+            rs, err := ts.Client().Get(ts.URL + "/s/burnR2tY4uI6oP8a/raw")
+            if err != nil {
+                t.Error(err)
+                return
+            }
+            rs.Body.Close()
+
+            codes <- rs.StatusCode
+        }()
+    }
+
+    wg.Wait()
+    close(codes)
+
+    var ok int
+    for code := range codes {
+        if code == http.StatusOK {
+            ok++
+        }
+    }
+
+    if ok != 1 {
+        t.Errorf("want exactly one reader to see the snippet; got %d", ok)
+    }
+}
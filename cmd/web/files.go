@@ -0,0 +1,140 @@
+package main
+
+import (
+  "fmt"
+  "html/template"
+  "regexp"
+  "strings"
+
+  "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/highlight"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// Filenames are kept to characters which are safe in URLs, archives and
+// Content-Disposition headers.
+var filenameRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+
+// A renderedFile is a file of a snippet, ready for the snippet page. Number
+// counts from 1, as in the file's raw URL. Markdown files carry their HTML;
+// the others are highlighted by the template.
+type renderedFile struct {
+  *models.SnippetFile
+  Number   int
+  Markdown bool
+  HTML     template.HTML
+}
+
+// The renderFiles helper prepares the files of a snippet for showing. The
+// markdown cache means each file is only parsed the first time it's shown.
+func (app *application) renderFiles(s *models.Snippet) []*renderedFile {
+  var files []*renderedFile
+
+  for i, f := range s.AllFiles() {
+    rf := &renderedFile{SnippetFile: f, Number: i + 1}
+
+    if f.IsMarkdown(s.Format) {
+      rf.Markdown = true
+      rf.HTML = app.markdown.Render(f.Content)
+    }
+
+    files = append(files, rf)
+  }
+
+  return files
+}
+
+// The formFiles function reads the files from a create or edit form, which
+// repeats the filename, language and content fields once for each file. Files
+// left completely blank, like a spare one added in the browser, are skipped.
+// Problems are added to the form errors: under "files" for the snippet as a
+// whole, and under "file.N" for the Nth file (from 0) of those returned.
+func formFiles(form *forms.Form) []*models.SnippetFile {
+  names, languages, contents := form.Values["filename"], form.Values["language"], form.Values["content"]
+
+  n := len(contents)
+  if len(names) > n {
+    n = len(names)
+  }
+
+  value := func(values []string, i int) string {
+    if i < len(values) {
+      return values[i]
+    }
+
+    return ""
+  }
+
+  var files []*models.SnippetFile
+
+  for i := 0; i < n; i++ {
+    f := &models.SnippetFile{
+      Filename: strings.TrimSpace(value(names, i)),
+      Language: value(languages, i),
+      Content:  value(contents, i),
+    }
+
+    if f.Filename == "" && strings.TrimSpace(f.Content) == "" {
+      continue
+    }
+
+    files = append(files, f)
+  }
+
+  switch {
+  case len(files) == 0:
+    form.Errors.Add("files", "This field cannot be blank")
+  case len(files) > models.MaxSnippetFiles:
+    form.Errors.Add("files", fmt.Sprintf("A snippet can have at most %d files", models.MaxSnippetFiles))
+  }
+
+  seen := map[string]bool{}
+
+  for i, f := range files {
+    field := fmt.Sprintf("file.%d", i)
+
+    switch {
+    case f.Filename == "" && len(files) > 1:
+      form.Errors.Add(field, "Each file needs a name when there are several")
+    case f.Filename != "" && (!filenameRX.MatchString(f.Filename) || f.Filename == "." || f.Filename == ".."):
+      form.Errors.Add(field, "This name is invalid (use letters, digits, dots, dashes and underscores)")
+    case seen[f.Filename]:
+      form.Errors.Add(field, "This name is already used by another file")
+    case strings.TrimSpace(f.Content) == "":
+      form.Errors.Add(field, "This field cannot be blank")
+    case f.Language != "" && highlight.Lookup(f.Language) == nil:
+      form.Errors.Add(field, "This field is invalid")
+    }
+
+    seen[f.Filename] = true
+  }
+
+  return files
+}
+
+// The detectLanguages function fills in the language of each file the user
+// didn't pick one for: from its extension if it has one we know, or else
+// from its content. Markdown files name the language of each code block
+// instead, so they are left alone.
+func detectLanguages(files []*models.SnippetFile, format string) {
+  for _, f := range files {
+    if f.Language != "" || f.IsMarkdown(format) {
+      continue
+    }
+
+    f.Language = highlight.ForFilename(f.Filename)
+    if f.Language == "" {
+      f.Language = highlight.Detect(f.Content)
+    }
+  }
+}
+
+// The orEmptyFile function returns the files to show in a form, which always
+// has at least one, even if it's empty.
+func orEmptyFile(files []*models.SnippetFile) []*models.SnippetFile {
+  if len(files) == 0 {
+    return []*models.SnippetFile{{}}
+  }
+
+  return files
+}
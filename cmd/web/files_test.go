@@ -0,0 +1,160 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "net/url"
+    "testing"
+
+    "mateuszurbanski/snippetbox/pkg/models"
+)
+
+func TestMultiFileSnippet(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    code, _, body := ts.get(t, "/s/gistM8nB2vC4xZ6q")
+
+    if code != http.StatusOK {
+        t.Fatalf("want %d; got %d", http.StatusOK, code)
+    }
+
+    for _, want := range []string{
+        "<strong>main.go</strong>",
+        `<code class='language-go'><span class="hl-keyword">package</span>`,
+        "<strong>go.mod</strong>",
+        "<h1>Hello</h1>",
+        "<a href='/s/gistM8nB2vC4xZ6q/raw/3'>Raw</a>",
+    } {
+        if !bytes.Contains(body, []byte(want)) {
+            t.Errorf("want body to contain %q", want)
+        }
+    }
+}
+
+func TestCreateSnippetFiles(t *testing.T) {
+    tests := []struct {
+        name     string
+        files    [][3]string
+        wantCode int
+        wantBody []byte
+    }{
+        {"One unnamed file", [][3]string{{"", "", "An old silent pond..."}}, http.StatusSeeOther, nil},
+        {"Several files", [][3]string{{"main.go", "", "package main"}, {"go.mod", "", "module hello"}}, http.StatusSeeOther, nil},
+        {"Spare blank file", [][3]string{{"main.go", "", "package main"}, {"", "", "  "}}, http.StatusSeeOther, nil},
+        {"No files", [][3]string{{"", "", ""}}, http.StatusOK, []byte("This field cannot be blank")},
+        {"Empty named file", [][3]string{{"main.go", "", ""}}, http.StatusOK, []byte("This field cannot be blank")},
+        {"Missing name", [][3]string{{"main.go", "", "package main"}, {"", "", "module hello"}}, http.StatusOK, []byte("Each file needs a name")},
+        {"Duplicate name", [][3]string{{"main.go", "", "package main"}, {"main.go", "", "package main"}}, http.StatusOK, []byte("This name is already used")},
+        {"Path in name", [][3]string{{"../main.go", "", "package main"}}, http.StatusOK, []byte("This name is invalid")},
+        {"Dot dot", [][3]string{{"..", "", "package main"}}, http.StatusOK, []byte("This name is invalid")},
+        {"Unknown language", [][3]string{{"main.cob", "cobol", "DISPLAY 'HI'."}}, http.StatusOK, []byte("This field is invalid")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            ts.login(t, "alice@example.com")
+
+            _, _, body := ts.get(t, "/snippet/create")
+
+            form := url.Values{}
+            form.Add("csrf_token", extractCSRFToken(t, body))
+            form.Add("title", "A title")
+            form.Add("expires", "7")
+            form.Add("visibility", "public")
+
+            for _, f := range tt.files {
+                form.Add("filename", f[0])
+                form.Add("language", f[1])
+                form.Add("content", f[2])
+            }
+
+            code, _, body := ts.postForm(t, "/snippet/create", form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+        })
+    }
+}
+
+func TestCreateSnippetTooManyFiles(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    ts.login(t, "alice@example.com")
+
+    _, _, body := ts.get(t, "/snippet/create")
+
+    form := url.Values{}
+    form.Add("csrf_token", extractCSRFToken(t, body))
+    form.Add("title", "A title")
+    form.Add("expires", "7")
+    form.Add("visibility", "public")
+
+    for i := 0; i <= models.MaxSnippetFiles; i++ {
+        form.Add("filename", string(rune('a'+i))+".txt")
+        form.Add("language", "")
+        form.Add("content", "Some text")
+    }
+
+    code, _, body := ts.postForm(t, "/snippet/create", form)
+
+    if code != http.StatusOK {
+        t.Fatalf("want %d; got %d", http.StatusOK, code)
+    }
+
+    if !bytes.Contains(body, []byte("A snippet can have at most 10 files")) {
+        t.Error("want the too many files error")
+    }
+}
+
+func TestDiffFiles(t *testing.T) {
+    from := []*models.SnippetFile{
+        {Filename: "main.go", Content: "package main\n"},
+        {Filename: "go.mod", Content: "module hello\n"},
+        {Filename: "old.txt", Content: "gone\n"},
+    }
+
+    to := []*models.SnippetFile{
+        {Filename: "main.go", Content: "package main\n"},
+        {Filename: "go.mod", Content: "module hello\n\ngo 1.16\n"},
+        {Filename: "README.md", Content: "# Hello\n"},
+    }
+
+    want := map[string]string{
+        "main.go":   "unchanged",
+        "go.mod":    "changed",
+        "README.md": "added",
+        "old.txt":   "removed",
+    }
+
+    diffs := diffFiles(from, to)
+
+    if len(diffs) != len(want) {
+        t.Fatalf("want %d files; got %d", len(want), len(diffs))
+    }
+
+    for _, d := range diffs {
+        if d.Status != want[d.Filename] {
+            t.Errorf("%s: want %s; got %s", d.Filename, want[d.Filename], d.Status)
+        }
+    }
+
+    // Naming the only file compares it with the old unnamed one.
+    diffs = diffFiles([]*models.SnippetFile{{Content: "a\n"}}, []*models.SnippetFile{{Filename: "a.txt", Content: "a\nb\n"}})
+
+    if len(diffs) != 1 || diffs[0].Status != "changed" || len(diffs[0].Hunks) != 1 {
+        t.Errorf("want one changed file; got %+v", diffs)
+    }
+}
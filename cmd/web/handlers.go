@@ -5,8 +5,10 @@ import (
   "fmt"
   "net/http"
   "strconv"
+  "time"
 
   "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/highlight"
   "mateuszurbanski/snippetbox/pkg/models"
 )
 
@@ -24,19 +26,31 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
-  // Pat doesn't strip the colon from the named capture key, so we need to
-  // get the value of ":id" from the query string instead of "id".
-  id, err := strconv.Atoi(r.URL.Query().Get(":id"))
-
-  if err != nil || id < 1 {
-    app.notFound(w) // Use the notFound() helper.
+  s, ok := app.snippetBySlug(w, r)
+  if !ok {
     return
   }
 
-  // Use the SnippetModel object's Get method to retrieve the data for a
-  // specific record based on its ID. If no matching record is found,
-  // return a 404 Not Found response.
-  s, err := app.snippets.Get(id)
+  app.render(w, r, "show.page.tmpl", &templateData{
+    Burnt:   s.BurnsFor(app.authenticatedUser(r)),
+    Files:   app.renderFiles(s),
+    Snippet: s,
+  })
+}
+
+// The snippetBySlug helper looks up the snippet named by the :slug in the URL,
+// for showing it. Private snippets can only be seen by their owner. Everybody
+// else gets a 404, so that we don't give away that the snippet exists. A
+// snippet to be burnt after reading is deleted as it's found (see
+// burnOnRead). If the snippet can't be shown it sends the error response and
+// returns false.
+func (app *application) snippetBySlug(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+  s, ok := app.findSnippet(w, r)
+  if !ok {
+    return nil, false
+  }
+
+  err := app.burnOnRead(r, s)
 
   if err != nil {
     if errors.Is(err, models.ErrNoRecord) {
@@ -45,18 +59,99 @@ func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
       app.serverError(w, err)
     }
 
+    return nil, false
+  }
+
+  return s, true
+}
+
+// The snippetHistory helper looks up the snippet named by the :slug in the URL
+// for the routes which show its history or change it. Snippets to be burnt
+// after reading are only found by their owner, since these routes would show
+// them without burning them. Otherwise it works like snippetBySlug.
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+  s, ok := app.findSnippet(w, r)
+  if !ok {
+    return nil, false
+  }
+
+  if s.BurnsFor(app.authenticatedUser(r)) {
+    app.notFound(w)
+    return nil, false
+  }
+
+  return s, true
+}
+
+// The findSnippet helper looks up the snippet named by the :slug in the URL,
+// only finding private snippets for their owner. If there is no such snippet
+// it sends a 404 (or a 500 if something went wrong) and returns false.
+func (app *application) findSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+  // Pat doesn't strip the colon from the named capture key, so we need to
+  // get the value of ":slug" from the query string instead of "slug".
+  slug := r.URL.Query().Get(":slug")
+
+  // Use the SnippetModel object's GetBySlug method to retrieve the data for
+  // the snippet with that slug. If no matching record is found, return a 404
+  // Not Found response.
+  s, err := app.snippets.GetBySlug(slug)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return nil, false
+  }
+
+  if !s.VisibleTo(app.authenticatedUser(r)) {
+    app.notFound(w)
+    return nil, false
+  }
+
+  return s, true
+}
+
+// The burnOnRead helper deletes a snippet which is to be burnt after reading,
+// as somebody other than its owner reads it. If somebody else got there
+// first it returns models.ErrNoRecord, and the snippet mustn't be shown. HEAD
+// requests, which link previews often make, don't burn the snippet, since
+// they don't get to read it.
+func (app *application) burnOnRead(r *http.Request, s *models.Snippet) error {
+  if r.Method == http.MethodHead || !s.BurnsFor(app.authenticatedUser(r)) {
+    return nil
+  }
+
+  err := app.snippets.Burn(s.ID)
+  if err != nil {
+    return err
+  }
+
+  app.audit(r, models.AuditSnippetBurn, fmt.Sprintf("snippet:%d", s.ID))
+
+  return nil
+}
+
+// The redirectSnippet handler keeps old /snippet/:id links working, by
+// redirecting them to the snippet's slug URL.
+func (app *application) redirectSnippet(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.snippetByID(w, r)
+  if !ok {
     return
   }
 
-  app.render(w, r, "show.page.tmpl", &templateData{
-    Snippet: s,
-  })
+  http.Redirect(w, r, s.URL(), http.StatusMovedPermanently)
 }
 
 func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
   app.render(w, r, "create.page.tmpl", &templateData{
-    // Pass a new empty forms.Form object to the template.
-    Form: forms.New(nil),
+    // Pass a new empty forms.Form object to the template, with one empty
+    // file to fill in.
+    Form:      forms.New(nil),
+    FormFiles: []*models.SnippetFile{{}},
+    Languages: highlight.Languages(),
   })
 }
 
@@ -74,26 +169,51 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
   }
 
   // Create a new forms.Form struct containing the POSTed data from the
-  // form, then use the validation methods to check the content.
+  // form, then use the validation methods to check the content. The files
+  // are checked by formFiles(), and the expiry by formExpiry().
   form := forms.New(r.PostForm)
-  form.Required("title", "content", "expires")
+  form.Required("title", "expires", "visibility")
   form.MaxLength("title", 100)
-  form.PermittedValues("expires", "365", "7", "1")
+  form.PermittedValues("visibility", models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate)
+  form.PermittedValues("format", models.FormatPlain, models.FormatMarkdown)
+
+  files := formFiles(form)
+  expires, burn := formExpiry(form, time.Now())
 
   // If the form isn't valid, redisplay the template passing in the
   // form.Form object as the data.
   if !form.Valid(){
     app.render(w, r, "create.page.tmpl", &templateData{
-      Form: form,
+      Form:      form,
+      FormFiles: orEmptyFile(files),
+      Languages: highlight.Languages(),
     })
 
     return
   }
 
+  // Snippets are plain text unless the user says otherwise.
+  format := form.Get("format")
+  if format == "" {
+    format = models.FormatPlain
+  }
+
+  // If the user didn't pick a language for a file, guess it.
+  detectLanguages(files, format)
+
+  // Every snippet gets a random slug for its public URL.
+  slug, err := newSlug()
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
   // Because the form data (with type url.Values) has been anonymously embedded
   // in the form.Form struct, we can use the Get() method to retrieve
   // the validated value for a particular form field.
-  id, err := app.snippets.Insert(form.Get("title"), form.Get("content"), form.Get("expires"))
+  id, err := app.snippets.Insert(app.authenticatedUser(r).ID, slug, form.Get("title"), expires, form.Get("visibility"), format, burn, files)
 
   if err != nil {
     app.serverError(w, err)
@@ -106,9 +226,82 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
   // data. Note that if there's no existing session for the current user
   // (or their session has expired) then a new, empty, session for them
   // will automatically be created by the session middleware.
+  app.audit(r, models.AuditSnippetCreate, fmt.Sprintf("snippet:%d", id))
+
   app.session.Put(r, "flash", "Snippet sucessfully created!")
 
-  http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
+  http.Redirect(w, r, "/s/"+slug, http.StatusSeeOther)
+}
+
+// The snippetByID helper looks up the snippet named by the :id in the URL of
+// the old numeric snippet URLs. Letting anybody use them for any snippet
+// would let people find unlisted snippets by counting through the IDs, so
+// only public snippets, and the user's own snippets, are found. Snippets to
+// be burnt after reading are only found by their owner, since the redirect
+// would give away their slug. Otherwise it sends a 404 (or a 500 if something
+// went wrong) and returns false.
+func (app *application) snippetByID(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+  id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+
+  if err != nil || id < 1 {
+    app.notFound(w)
+    return nil, false
+  }
+
+  s, err := app.snippets.Get(id)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return nil, false
+  }
+
+  user := app.authenticatedUser(r)
+
+  if (s.Visibility != models.VisibilityPublic && (user == nil || s.UserID != user.ID)) || s.BurnsFor(user) {
+    app.notFound(w)
+    return nil, false
+  }
+
+  return s, true
+}
+
+func (app *application) deleteSnippet(w http.ResponseWriter, r *http.Request) {
+  // Somebody else's private snippet gets a 404, like on every other route,
+  // rather than a 403 which would give away that it exists.
+  s, ok := app.findSnippet(w, r)
+  if !ok {
+    return
+  }
+
+  // Only the owner of a snippet, or a moderator, may delete it.
+  if !app.authenticatedUser(r).CanDeleteSnippet(s) {
+    app.forbidden(w, r)
+
+    return
+  }
+
+  err := app.snippets.Delete(s.ID)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  app.audit(r, models.AuditSnippetDelete, fmt.Sprintf("snippet:%d", s.ID))
+
+  app.session.Put(r, "flash", "Snippet successfully deleted!")
+
+  http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (app *application) signupUserForm(w http.ResponseWriter, r *http.Request) {
@@ -134,6 +327,18 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
   form.MatchesPattern("email", forms.EmailRX)
   form.MinLength("password", 10)
 
+  // Check the password against the password policy. This makes sure it
+  // doesn't contain the user's name or email, hasn't been seen in a breach
+  // and isn't too easy to guess.
+  err = form.Password("password", app.passwordPolicy, form.Get("name"), form.Get("email"))
+
+  // If the breach check couldn't be made (say the Pwned Passwords API is
+  // down), log it and carry on with the other rules rather than stopping
+  // everyone from signing up.
+  if err != nil {
+    app.errorLog.Output(2, fmt.Sprintf("Skipping the password breach check: %s", err))
+  }
+
   // If there are any errors, redisplay the signup form.
   if !form.Valid() {
     app.render(w, r, "signup.page.tmpl", &templateData{Form: form})
@@ -157,6 +362,16 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
     return
   }
 
+  user, err := app.users.GetByEmail(form.Get("email"))
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.auditAs(r, user.ID, models.AuditSignup, fmt.Sprintf("user:%d", user.ID))
+
   // Otherwise add a confirmation flash message to the session confirming that
   // their signup worked and asking them to log in.
   app.session.Put(r, "flash", "Your signup was successfull. Please log in.")
@@ -183,12 +398,18 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
   // Check whether the credentials are valid. If they're not, add a generic error
   // message to the form failures map and re-display the login page.
   form    := forms.New(r.PostForm)
-  id, err := app.users.Authenticate(form.Get("email"), form.Get("password"))
+  id, err := app.authenticator.Authenticate(form.Get("email"), form.Get("password"))
 
   if err != nil {
     if errors.Is(err, models.ErrInvalidCredentials) {
+      app.audit(r, models.AuditLoginFailed, app.emailPseudonym(form.Get("email")))
+
       form.Errors.Add("generic", "Email or password is incorrect")
 
+      app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+    } else if errors.Is(err, errAuthUnavailable) {
+      form.Errors.Add("generic", "Logging in isn't possible right now. Please try again in a few minutes")
+
       app.render(w, r, "login.page.tmpl", &templateData{Form: form})
     } else {
       app.serverError(w, err)
@@ -197,18 +418,45 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
     return
   }
 
-  // Add the ID of the current user to the session, so that they are now 'logged
+  // Start a new server-side session for the user, so that they are now 'logged
   // in'.
-  app.session.Put(r, "authenticatedUserID", id)
+  _, err = app.startSession(w, r, id)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  // If they asked to be remembered, also give them a long-lived token which
+  // logs them back in once the session has expired.
+  if form.Get("remember") != "" {
+    err = app.issueRememberToken(w, r, id)
+
+    if err != nil {
+      app.serverError(w, err)
+
+      return
+    }
+  }
+
+  app.auditAs(r, id, models.AuditLogin, fmt.Sprintf("user:%d", id))
 
   // Redirect the user to the create snippet page.
   http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
 func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
-  // Remove the authenticatedUserID from the session data so that the user is
-  // 'logged out'.
-  app.session.Remove(r, "authenticatedUserID")
+  // Delete the user's server-side session so that they are 'logged out'.
+  err := app.endSession(w, r)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.audit(r, models.AuditLogout, fmt.Sprintf("user:%d", app.authenticatedUser(r).ID))
 
   // Add a flash message to the session to confirm to the user that they've been
   // logged out.
@@ -217,6 +465,178 @@ func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
   http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+func (app *application) listSessions(w http.ResponseWriter, r *http.Request) {
+  user := app.authenticatedUser(r)
+
+  sessions, err := app.sessions.ListForUser(user.ID)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.render(w, r, "sessions.page.tmpl", &templateData{
+    Sessions: sessions,
+  })
+}
+
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+  id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+
+  if err != nil || id < 1 {
+    app.notFound(w)
+
+    return
+  }
+
+  // Users can only revoke their own sessions; the model scopes the delete to
+  // the current user so anything else is simply not found.
+  err = app.sessions.Delete(id, app.authenticatedUser(r).ID)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  app.audit(r, models.AuditSessionRevoke, fmt.Sprintf("session:%d", id))
+
+  // Revoking the session we are using is the same as logging out.
+  if id == app.currentSession(r).ID {
+    err = app.endSession(w, r)
+
+    if err != nil {
+      app.serverError(w, err)
+
+      return
+    }
+
+    app.session.Put(r, "flash", "You've been logged out successfully!")
+    http.Redirect(w, r, "/", http.StatusSeeOther)
+
+    return
+  }
+
+  app.session.Put(r, "flash", "Session revoked.")
+  http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+func (app *application) logoutEverywhere(w http.ResponseWriter, r *http.Request) {
+  // Revoke every session and remember me token belonging to the user,
+  // including this one.
+  err := app.sessions.DeleteAllForUser(app.authenticatedUser(r).ID, 0)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  err = app.rememberTokens.DeleteAllForUser(app.authenticatedUser(r).ID)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  err = app.endSession(w, r)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.audit(r, models.AuditLogoutEverywhere, fmt.Sprintf("user:%d", app.authenticatedUser(r).ID))
+
+  app.session.Put(r, "flash", "You've been logged out on all devices.")
+
+  http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) changePasswordForm(w http.ResponseWriter, r *http.Request) {
+  app.render(w, r, "password.page.tmpl", &templateData{
+    Form: forms.New(nil),
+  })
+}
+
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+  err := r.ParseForm()
+
+  if err != nil {
+    app.clientError(w, http.StatusBadRequest)
+
+    return
+  }
+
+  form := forms.New(r.PostForm)
+  form.Required("currentPassword", "newPassword", "newPasswordConfirmation")
+  form.MinLength("newPassword", 10)
+
+  if form.Get("newPassword") != form.Get("newPasswordConfirmation") {
+    form.Errors.Add("newPasswordConfirmation", "Passwords do not match")
+  }
+
+  user := app.authenticatedUser(r)
+
+  err = form.Password("newPassword", app.passwordPolicy, user.Name, user.Email)
+
+  // As on signup, a failed breach check doesn't stop the change.
+  if err != nil {
+    app.errorLog.Output(2, fmt.Sprintf("Skipping the password breach check: %s", err))
+  }
+
+  if !form.Valid() {
+    app.render(w, r, "password.page.tmpl", &templateData{Form: form})
+
+    return
+  }
+
+  err = app.users.ChangePassword(user.ID, form.Get("currentPassword"), form.Get("newPassword"))
+
+  if err != nil {
+    if errors.Is(err, models.ErrInvalidCredentials) {
+      form.Errors.Add("currentPassword", "Current password is incorrect")
+
+      app.render(w, r, "password.page.tmpl", &templateData{Form: form})
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  // Changing the password logs the user out everywhere else, so that anyone
+  // who knew the old password loses access straight away.
+  err = app.sessions.DeleteAllForUser(user.ID, app.currentSession(r).ID)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  err = app.rememberTokens.DeleteAllForUser(user.ID)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.audit(r, models.AuditPasswordChange, fmt.Sprintf("user:%d", user.ID))
+
+  app.session.Put(r, "flash", "Your password has been changed. All other sessions have been logged out.")
+
+  http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
 func ping(w http.ResponseWriter, r *http.Request) {
    _, err := w.Write([]byte("OK"))
 
@@ -3,8 +3,12 @@ package main
 import (
     "bytes"
     "net/http"
+    "net/http/httptest"
     "net/url"
+    "regexp"
     "testing"
+
+    "mateuszurbanski/snippetbox/pkg/forms"
 )
 
 func TestPing(t *testing.T) {
@@ -90,6 +94,10 @@ func TestSignupUser(t *testing.T) {
         {"Invalid email (missing local part)", "Bob", "@example.com", "validPa$$word", csrfToken, http.StatusOK, []byte("This field is invalid")},
         {"Short password", "Bob", "bob@example.com", "pa$$word", csrfToken, http.StatusOK, []byte("This field is too short (minimum is 10 characters)")},
         {"Duplicate email", "Bob", "dupe@example.com", "validPa$$word", csrfToken, http.StatusOK, []byte("Address is already in use")},
+        {"Password contains name", "Bobby Tables", "bob@example.com", "tables-Xq7!kLm2", csrfToken, http.StatusOK, []byte("This field must not contain your name or email address")},
+        {"Password contains email", "Bob", "bob@example.com", "bob@example.comZ9", csrfToken, http.StatusOK, []byte("This field must not contain your name or email address")},
+        {"Breached password", "Bob", "bob@example.com", "password123", csrfToken, http.StatusOK, []byte("This password has appeared in a data breach")},
+        {"Guessable password", "Bob", "bob@example.com", "abcdefghijk", csrfToken, http.StatusOK, []byte("This password is too easy to guess: avoid sequences like abc or 6543")},
         {"Invalid CSRF Token", "", "", "", "wrongToken", http.StatusBadRequest, nil},
     }
 
@@ -113,3 +121,407 @@ func TestSignupUser(t *testing.T) {
         })
     }
 }
+
+func TestSignupBreachCheckUnavailable(t *testing.T) {
+    app := newTestApplication(t)
+
+    // Point the breach check at a server which is no longer there.
+    down := httptest.NewServer(http.NotFoundHandler())
+    down.Close()
+
+    app.passwordPolicy = &forms.PasswordPolicy{
+        MinScore: 2,
+        Breaches: &forms.PwnedPasswordsAPI{URL: down.URL},
+    }
+
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    tests := []struct {
+        name     string
+        password string
+        wantCode int
+        wantBody []byte
+    }{
+        {"Valid password", "validPa$$word", http.StatusSeeOther, nil},
+        {"Guessable password", "abcdefghijk", http.StatusOK, []byte("This password is too easy to guess")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, _, body := ts.get(t, "/user/signup")
+
+            form := url.Values{}
+            form.Add("name", "Bob")
+            form.Add("email", "bob@example.com")
+            form.Add("password", tt.password)
+            form.Add("csrf_token", extractCSRFToken(t, body))
+
+            code, _, body := ts.postForm(t, "/user/signup", form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+        })
+    }
+}
+
+func TestUserSessions(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    // Without a session the page redirects to the login form.
+    code, header, _ := ts.get(t, "/user/sessions")
+    if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+        t.Fatalf("want redirect to /user/login; got %d %q", code, header.Get("Location"))
+    }
+
+    ts.login(t, "alice@example.com")
+
+    code, _, body := ts.get(t, "/user/sessions")
+    if code != http.StatusOK {
+        t.Fatalf("want %d; got %d", http.StatusOK, code)
+    }
+
+    if !bytes.Contains(body, []byte("(this session)")) {
+        t.Errorf("want body %s to contain the current session", body)
+    }
+
+    // Logging out everywhere revokes the session on the server, so the page
+    // is no longer reachable.
+    form := url.Values{}
+    form.Add("csrf_token", extractCSRFToken(t, body))
+
+    code, _, _ = ts.postForm(t, "/user/sessions/logout-all", form)
+    if code != http.StatusSeeOther {
+        t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+    }
+
+    code, _, _ = ts.get(t, "/user/sessions")
+    if code != http.StatusSeeOther {
+        t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+    }
+}
+
+func TestDeleteSnippet(t *testing.T) {
+    tests := []struct {
+        name     string
+        email    string
+        urlPath  string
+        wantCode int
+    }{
+        {"Not the owner", "alice@example.com", "/s/pondXq7kLm2vRt9w/delete", http.StatusForbidden},
+        {"Owner", "mallory@example.com", "/s/pondXq7kLm2vRt9w/delete", http.StatusSeeOther},
+        {"Moderator", "mallory@example.com", "/s/gistM8nB2vC4xZ6q/delete", http.StatusSeeOther},
+        {"Private, someone else", "mallory@example.com", "/s/noteB4nW8cYe1sZa/delete", http.StatusNotFound},
+        {"Private, owner", "alice@example.com", "/s/noteB4nW8cYe1sZa/delete", http.StatusSeeOther},
+        {"Non-existent slug", "mallory@example.com", "/s/missingAAAAAAAAA/delete", http.StatusNotFound},
+        {"By ID", "mallory@example.com", "/snippet/1/delete", http.StatusNotFound},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            ts.login(t, tt.email)
+
+            _, _, body := ts.get(t, "/snippet/create")
+
+            form := url.Values{}
+            form.Add("csrf_token", extractCSRFToken(t, body))
+
+            code, _, _ := ts.postForm(t, tt.urlPath, form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+        })
+    }
+}
+
+func TestSnippetVisibility(t *testing.T) {
+    tests := []struct {
+        name     string
+        email    string
+        urlPath  string
+        wantCode int
+        wantBody []byte
+    }{
+        {"Public, anonymous", "", "/s/pondXq7kLm2vRt9w", http.StatusOK, []byte("An old silent pond...")},
+        {"Unlisted, anonymous", "", "/s/haikuJ6dF0gHp3uT", http.StatusOK, []byte("Over the wintry forest...")},
+        {"Unlisted, history link", "", "/s/haikuJ6dF0gHp3uT", http.StatusOK, []byte("<a href='/s/haikuJ6dF0gHp3uT/revisions'>History</a>")},
+        {"Private, anonymous", "", "/s/noteB4nW8cYe1sZa", http.StatusNotFound, nil},
+        {"Private, owner", "alice@example.com", "/s/noteB4nW8cYe1sZa", http.StatusOK, []byte("For my eyes only...")},
+        {"Private, other user", "mallory@example.com", "/s/noteB4nW8cYe1sZa", http.StatusNotFound, nil},
+        {"Non-existent slug", "", "/s/doesNotExist0000", http.StatusNotFound, nil},
+        {"Empty slug", "", "/s/", http.StatusNotFound, nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            if tt.email != "" {
+                ts.login(t, tt.email)
+            }
+
+            code, _, body := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+        })
+    }
+
+    // Only public snippets are listed on the home page.
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    _, _, body := ts.get(t, "/")
+
+    if !bytes.Contains(body, []byte("An old silent pond")) {
+        t.Error("want the public snippet on the home page")
+    }
+
+    for _, title := range []string{"A private note", "An unlisted haiku"} {
+        if bytes.Contains(body, []byte(title)) {
+            t.Errorf("want %q to be left off the home page", title)
+        }
+    }
+}
+
+func TestRedirectSnippet(t *testing.T) {
+    tests := []struct {
+        name         string
+        email        string
+        urlPath      string
+        wantCode     int
+        wantLocation string
+    }{
+        {"Public", "", "/snippet/1", http.StatusMovedPermanently, "/s/pondXq7kLm2vRt9w"},
+        {"Unlisted, anonymous", "", "/snippet/4", http.StatusNotFound, ""},
+        {"Unlisted, other user", "alice@example.com", "/snippet/4", http.StatusNotFound, ""},
+        {"Unlisted, owner", "ada@example.com", "/snippet/4", http.StatusMovedPermanently, "/s/haikuJ6dF0gHp3uT"},
+        {"Private, anonymous", "", "/snippet/3", http.StatusNotFound, ""},
+        {"Private, owner", "alice@example.com", "/snippet/3", http.StatusMovedPermanently, "/s/noteB4nW8cYe1sZa"},
+        {"Non-existent ID", "", "/snippet/2", http.StatusNotFound, ""},
+        {"Negative ID", "", "/snippet/-1", http.StatusNotFound, ""},
+        {"String ID", "", "/snippet/foo", http.StatusNotFound, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            if tt.email != "" {
+                ts.login(t, tt.email)
+            }
+
+            code, header, _ := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if got := header.Get("Location"); got != tt.wantLocation {
+                t.Errorf("want Location %q; got %q", tt.wantLocation, got)
+            }
+        })
+    }
+}
+
+func TestCreateSnippetVisibility(t *testing.T) {
+    tests := []struct {
+        name       string
+        visibility string
+        wantCode   int
+        wantBody   []byte
+    }{
+        {"Public", "public", http.StatusSeeOther, nil},
+        {"Unlisted", "unlisted", http.StatusSeeOther, nil},
+        {"Private", "private", http.StatusSeeOther, nil},
+        {"Missing", "", http.StatusOK, []byte("This field cannot be blank")},
+        {"Invalid", "secret", http.StatusOK, []byte("This field is invalid")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            ts.login(t, "alice@example.com")
+
+            _, _, body := ts.get(t, "/snippet/create")
+
+            form := url.Values{}
+            form.Add("csrf_token", extractCSRFToken(t, body))
+            form.Add("title", "A title")
+            form.Add("content", "Some content")
+            form.Add("expires", "7")
+            form.Add("visibility", tt.visibility)
+
+            code, header, body := ts.postForm(t, "/snippet/create", form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body %s to contain %q", body, tt.wantBody)
+            }
+
+            // New snippets are shown at a random slug URL.
+            if code == http.StatusSeeOther && !regexp.MustCompile(`^/s/[A-Za-z0-9_-]{16}$`).MatchString(header.Get("Location")) {
+                t.Errorf("want redirect to a slug URL; got %q", header.Get("Location"))
+            }
+        })
+    }
+}
+
+func TestCreateSnippetLanguage(t *testing.T) {
+    tests := []struct {
+        name     string
+        language string
+        wantCode int
+        wantBody []byte
+    }{
+        {"Detect automatically", "", http.StatusSeeOther, nil},
+        {"Go", "go", http.StatusSeeOther, nil},
+        {"Unknown language", "cobol", http.StatusOK, []byte("This field is invalid")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            ts.login(t, "alice@example.com")
+
+            _, _, body := ts.get(t, "/snippet/create")
+
+            if !bytes.Contains(body, []byte("<option value='go' >Go</option>")) {
+                t.Errorf("want the form to offer the languages")
+            }
+
+            form := url.Values{}
+            form.Add("csrf_token", extractCSRFToken(t, body))
+            form.Add("title", "A title")
+            form.Add("content", "package main")
+            form.Add("expires", "7")
+            form.Add("visibility", "public")
+            form.Add("language", tt.language)
+
+            code, _, body := ts.postForm(t, "/snippet/create", form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body %s to contain %q", body, tt.wantBody)
+            }
+        })
+    }
+}
+
+func TestMarkdownSnippet(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    code, _, body := ts.get(t, "/s/notesQ5rT7yU9iOp")
+
+    if code != http.StatusOK {
+        t.Fatalf("want %d; got %d", http.StatusOK, code)
+    }
+
+    for _, want := range []string{
+        "<h1>Release notes</h1>",
+        "<li>Faster <em>search</em></li>",
+        `<span class="hl-keyword">func</span>`,
+    } {
+        if !bytes.Contains(body, []byte(want)) {
+            t.Errorf("want body to contain %q", want)
+        }
+    }
+
+    if bytes.Contains(body, []byte("alert(1)")) {
+        t.Error("want the script stripped from the rendered Markdown")
+    }
+
+    // Showing the snippet again should be served from the cache.
+    ts.get(t, "/s/notesQ5rT7yU9iOp")
+
+    if n := app.markdown.Len(); n != 1 {
+        t.Errorf("want 1 cached document; got %d", n)
+    }
+
+    // Plain snippets aren't rendered as Markdown.
+    ts.get(t, "/s/pondXq7kLm2vRt9w")
+
+    if n := app.markdown.Len(); n != 1 {
+        t.Errorf("want plain snippets left out of the cache; got %d documents", n)
+    }
+}
+
+func TestCreateSnippetFormat(t *testing.T) {
+    tests := []struct {
+        name     string
+        format   string
+        wantCode int
+        wantBody []byte
+    }{
+        {"Default", "", http.StatusSeeOther, nil},
+        {"Plain", "plain", http.StatusSeeOther, nil},
+        {"Markdown", "markdown", http.StatusSeeOther, nil},
+        {"Unknown format", "html", http.StatusOK, []byte("This field is invalid")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            ts.login(t, "alice@example.com")
+
+            _, _, body := ts.get(t, "/snippet/create")
+
+            form := url.Values{}
+            form.Add("csrf_token", extractCSRFToken(t, body))
+            form.Add("title", "A title")
+            form.Add("content", "# Heading")
+            form.Add("expires", "7")
+            form.Add("visibility", "public")
+            form.Add("format", tt.format)
+
+            code, _, body := ts.postForm(t, "/snippet/create", form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body %s to contain %q", body, tt.wantBody)
+            }
+        })
+    }
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+)
+
+// The placeholder in a Content-Security-Policy which is replaced with the
+// nonce for the current request.
+const cspNoncePlaceholder = "{nonce}"
+
+// The securityHeaders type holds the configurable security headers sent with
+// every response.
+type securityHeaders struct {
+  // CSP is the Content-Security-Policy. Any {nonce} in it is replaced with a
+  // fresh random nonce for every request, which templates add to the inline
+  // scripts they trust.
+  CSP string
+
+  // HSTS is the max-age of the Strict-Transport-Security header. Zero leaves
+  // the header out, which is what we want outside production.
+  HSTS time.Duration
+
+  ReferrerPolicy    string
+  PermissionsPolicy string
+}
+
+// The defaultSecurityHeaders function returns the headers we send unless told
+// otherwise. The policy allows our own scripts, styles and images, plus the
+// Google Fonts stylesheet used by the layout, and reports violations to
+// /csp-report.
+func defaultSecurityHeaders() *securityHeaders {
+  return &securityHeaders{
+    CSP: "default-src 'self'; " +
+      "script-src 'self' 'nonce-{nonce}'; " +
+      "style-src 'self' https://fonts.googleapis.com; " +
+      "font-src https://fonts.gstatic.com; " +
+      "img-src 'self' data:; " +
+      "object-src 'none'; " +
+      "base-uri 'self'; " +
+      "form-action 'self'; " +
+      "frame-ancestors 'none'; " +
+      "report-uri /csp-report",
+    ReferrerPolicy:    "strict-origin-when-cross-origin",
+    PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+  }
+}
+
+// The handler method returns middleware which sets the security headers. If
+// the policy uses a nonce, the nonce is stored in the request context so that
+// addDefaultData can pass it to the templates.
+func (h *securityHeaders) handler(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("X-XSS-Protection", "1; mode=block")
+    w.Header().Set("X-Frame-Options", "deny")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
+    w.Header().Set("Cross-Origin-Resource-Policy", "same-origin")
+
+    if h.ReferrerPolicy != "" {
+      w.Header().Set("Referrer-Policy", h.ReferrerPolicy)
+    }
+
+    if h.PermissionsPolicy != "" {
+      w.Header().Set("Permissions-Policy", h.PermissionsPolicy)
+    }
+
+    // Browsers ignore Strict-Transport-Security over plain HTTP, so only send
+    // it with HTTPS responses.
+    if h.HSTS > 0 && requestScheme(r) == "https" {
+      w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int(h.HSTS.Seconds())))
+    }
+
+    if h.CSP != "" {
+      policy := h.CSP
+
+      if strings.Contains(policy, cspNoncePlaceholder) {
+        nonce, err := newCSPNonce()
+        if err != nil {
+          w.Header().Set("Connection", "close")
+          http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+          return
+        }
+
+        policy = strings.ReplaceAll(policy, cspNoncePlaceholder, nonce)
+        r = r.WithContext(context.WithValue(r.Context(), contextKeyCSPNonce, nonce))
+      }
+
+      w.Header().Set("Content-Security-Policy", policy)
+    }
+
+    next.ServeHTTP(w, r)
+  })
+}
+
+func newCSPNonce() (string, error) {
+  b := make([]byte, 16)
+
+  _, err := rand.Read(b)
+  if err != nil {
+    return "", err
+  }
+
+  // Use the URL-safe alphabet, which html/template leaves alone when the nonce
+  // is written into an attribute.
+  return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The cspNonce helper returns the Content-Security-Policy nonce for the
+// current request, or "" if the policy doesn't use one.
+func cspNonce(r *http.Request) string {
+  nonce, _ := r.Context().Value(contextKeyCSPNonce).(string)
+
+  return nonce
+}
+
+// The largest CSP violation report we are willing to read.
+const maxCSPReportSize = 16 * 1024
+
+// The cspReport handler receives Content-Security-Policy violation reports
+// from browsers and writes them to the info log. Browsers send either the
+// older application/csp-report format, a single object under "csp-report",
+// or the Reporting API's application/reports+json, a list of reports.
+func (app *application) cspReport(w http.ResponseWriter, r *http.Request) {
+  r.Body = http.MaxBytesReader(w, r.Body, maxCSPReportSize)
+
+  type violation struct {
+    DocumentURI        string `json:"document-uri"`
+    BlockedURI         string `json:"blocked-uri"`
+    ViolatedDirective  string `json:"violated-directive"`
+    EffectiveDirective string `json:"effective-directive"`
+    SourceFile         string `json:"source-file"`
+    LineNumber         int    `json:"line-number"`
+  }
+
+  var violations []violation
+
+  switch strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]) {
+  case "application/csp-report", "application/json":
+    var report struct {
+      Report violation `json:"csp-report"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+      app.clientError(w, http.StatusBadRequest)
+      return
+    }
+
+    violations = append(violations, report.Report)
+
+  case "application/reports+json":
+    var reports []struct {
+      Type string `json:"type"`
+      Body struct {
+        DocumentURL        string `json:"documentURL"`
+        BlockedURL         string `json:"blockedURL"`
+        EffectiveDirective string `json:"effectiveDirective"`
+        SourceFile         string `json:"sourceFile"`
+        LineNumber         int    `json:"lineNumber"`
+      } `json:"body"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&reports); err != nil {
+      app.clientError(w, http.StatusBadRequest)
+      return
+    }
+
+    for _, rep := range reports {
+      if rep.Type != "csp-violation" {
+        continue
+      }
+
+      violations = append(violations, violation{
+        DocumentURI:        rep.Body.DocumentURL,
+        BlockedURI:         rep.Body.BlockedURL,
+        EffectiveDirective: rep.Body.EffectiveDirective,
+        SourceFile:         rep.Body.SourceFile,
+        LineNumber:         rep.Body.LineNumber,
+      })
+    }
+
+  default:
+    app.clientError(w, http.StatusUnsupportedMediaType)
+    return
+  }
+
+  for _, v := range violations {
+    directive := v.EffectiveDirective
+    if directive == "" {
+      directive = v.ViolatedDirective
+    }
+
+    app.infoLog.Printf("[%s] CSP violation: %q blocked %q on %s (%s:%d)", requestID(r), directive, v.BlockedURI, v.DocumentURI, v.SourceFile, v.LineNumber)
+  }
+
+  w.WriteHeader(http.StatusNoContent)
+}
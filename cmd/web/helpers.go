@@ -2,14 +2,27 @@ package main
 
 import(
   "bytes"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
   "fmt"
+  "net"
   "net/http"
   "runtime/debug"
+  "strings"
   "time"
 
+  "mateuszurbanski/snippetbox/pkg/models"
+
   "github.com/justinas/nosurf"
 )
 
+// The name of the cookie which carries the opaque token identifying a user's
+// server-side login session.
+const sessionCookieName = "sid"
+
 // The serverError helper writes an error message and stack trace to the errorLog,
 // then sends a generic 500 Internal Server Error response to the user.
 func (app *application) serverError(w http.ResponseWriter, err error) {
@@ -36,6 +49,30 @@ func (app *application) notFound(w http.ResponseWriter) {
   app.clientError(w, http.StatusNotFound)
 }
 
+// The renderError helper renders the error page with the given status code and
+// message, so that errors the user is expected to see (like being rate limited)
+// share the look of the rest of the site.
+func (app *application) renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+  app.render(w, r, "error.page.tmpl", &templateData{
+    ErrorStatus:  status,
+    ErrorMessage: message,
+  })
+}
+
+// The tooManyRequests helper sends a 429 Too Many Requests response, with a
+// Retry-After header telling the client how many seconds to wait.
+func (app *application) tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+  w.Header().Set("Retry-After", retryAfter(wait))
+
+  app.renderError(w, r, http.StatusTooManyRequests, "You're doing that too often. Please wait a moment and try again.")
+}
+
+// The forbidden helper sends a 403 Forbidden response, for authenticated users
+// who aren't allowed to do what they asked.
+func (app *application) forbidden(w http.ResponseWriter, r *http.Request) {
+  app.renderError(w, r, http.StatusForbidden, "You don't have permission to do that.")
+}
+
 // Create an addDefaultData helper. This takes a pointer to a templateData
 // struct, adds the current year to the CurrentYear field, and then returns
 // the pointer. Again, we're not using the *http.Request parameter at the
@@ -48,13 +85,28 @@ func(app *application) addDefaultData(td *templateData, r *http.Request) *templa
   // Add the CSRF token to the templateData struct.
   td.CSRFToken = nosurf.Token(r)
 
+  // Add the Content-Security-Policy nonce, for templates to put on the
+  // inline scripts they trust.
+  td.CSPNonce = cspNonce(r)
+
   td.CurrentYear = time.Now().Year()
 
   // Add the flash message to the template data, if one exists.
   td.Flash = app.session.PopString(r, "flash")
 
-  // Add the authentication status to the template data.
+  // Add the authentication status and current session to the template data.
   td.IsAuthenticated = app.isAuthenticated(r)
+  td.CurrentSession = app.currentSession(r)
+
+  // Let the login page offer single sign-on if a provider is configured.
+  td.SSOEnabled = app.oidc != nil
+
+  // Add the current user and their role, so that templates can decide which
+  // actions to offer.
+  if user := app.authenticatedUser(r); user != nil {
+    td.AuthenticatedUser = user
+    td.Role = user.Role
+  }
 
   return td
 }
@@ -84,6 +136,11 @@ func(app *application) render(w http.ResponseWriter, r *http.Request, name strin
     return
   }
 
+  // If we are rendering an error page, send its status code before the body.
+  if td != nil && td.ErrorStatus != 0 {
+    w.WriteHeader(td.ErrorStatus)
+  }
+
   // Write the contents of the buffer to the http.ResponseWriter. Again, this
   // is another time where we pass our http.ResponseWriter to a function that
   // takes an io.Writer.
@@ -96,6 +153,200 @@ func(app *application) render(w http.ResponseWriter, r *http.Request, name strin
   }
 }
 
+// The authenticatedUser helper returns the user making the current request, or
+// nil if the request isn't authenticated.
+func (app *application) authenticatedUser(r *http.Request) *models.User {
+  user, ok := r.Context().Value(contextKeyUser).(*models.User)
+  if !ok {
+    return nil
+  }
+
+  return user
+}
+
+// The currentSession helper returns the server-side login session the current
+// request belongs to, or nil if the request isn't authenticated.
+func (app *application) currentSession(r *http.Request) *models.Session {
+  s, ok := r.Context().Value(contextKeySession).(*models.Session)
+  if !ok {
+    return nil
+  }
+
+  return s
+}
+
+// The randomToken helper returns 32 random bytes, base64url-encoded, for use as
+// a session or remember me token.
+func randomToken() (string, error) {
+  b := make([]byte, 32)
+
+  _, err := rand.Read(b)
+  if err != nil {
+    return "", err
+  }
+
+  return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The newSlug helper returns a random, URL-safe slug for a snippet's public
+// URL. 12 random bytes make 16 characters, which can't be guessed or counted
+// through like the numeric IDs.
+func newSlug() (string, error) {
+  b := make([]byte, 12)
+
+  _, err := rand.Read(b)
+  if err != nil {
+    return "", err
+  }
+
+  return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The startSession helper logs a user in. It generates a random token, stores a
+// new server-side session for it and sends the token to the browser in the
+// session cookie. A new token is issued on every login, so a token planted
+// before login is of no use to an attacker.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, userID int) (*models.Session, error) {
+  token, err := randomToken()
+  if err != nil {
+    return nil, err
+  }
+
+  now := time.Now()
+  expires := now.Add(app.session.Lifetime)
+
+  userAgent := r.UserAgent()
+  if len(userAgent) > 512 {
+    userAgent = userAgent[:512]
+  }
+
+  id, err := app.sessions.Insert(userID, token, app.clientIP(r), userAgent, expires)
+  if err != nil {
+    return nil, err
+  }
+
+  http.SetCookie(w, &http.Cookie{
+    Name:     sessionCookieName,
+    Value:    token,
+    Path:     "/",
+    Expires:  expires,
+    HttpOnly: true,
+    Secure:   true,
+    SameSite: http.SameSiteLaxMode,
+  })
+
+  session := &models.Session{
+    ID:        id,
+    UserID:    userID,
+    IPAddress: app.clientIP(r),
+    UserAgent: userAgent,
+    Created:   now,
+    LastSeen:  now,
+    Expires:   expires,
+  }
+
+  return session, nil
+}
+
+// The endSession helper logs the current request out by deleting its
+// server-side session and expiring the session cookie.
+func (app *application) endSession(w http.ResponseWriter, r *http.Request) error {
+  if cookie, err := r.Cookie(sessionCookieName); err == nil {
+    err = app.sessions.DeleteByToken(cookie.Value)
+    if err != nil {
+      return err
+    }
+  }
+
+  // Logging out also forgets the browser's remember me token, if it has one.
+  err := app.forgetRememberToken(w, r)
+  if err != nil {
+    return err
+  }
+
+  http.SetCookie(w, &http.Cookie{
+    Name:     sessionCookieName,
+    Value:    "",
+    Path:     "/",
+    MaxAge:   -1,
+    HttpOnly: true,
+    Secure:   true,
+    SameSite: http.SameSiteLaxMode,
+  })
+
+  return nil
+}
+
+// The deleteExpiredSessions method removes the login sessions and remember me
+// tokens which have expired. It is run every few minutes.
+func (app *application) deleteExpiredSessions() {
+  if err := app.sessions.DeleteExpired(); err != nil {
+    app.errorLog.Output(2, err.Error())
+  }
+
+  if err := app.rememberTokens.DeleteExpired(); err != nil {
+    app.errorLog.Output(2, err.Error())
+  }
+}
+
+// The audit helper appends an event to the audit log, performed by the current
+// user (if any) on the given subject.
+func (app *application) audit(r *http.Request, action, subject string) {
+  actorID := 0
+  if user := app.authenticatedUser(r); user != nil {
+    actorID = user.ID
+  }
+
+  app.auditAs(r, actorID, action, subject)
+}
+
+// The auditAs helper appends an event to the audit log on behalf of a specific
+// user, for the times when they aren't logged in yet (like when they log in).
+// A failure to write the audit log is logged but doesn't fail the request.
+func (app *application) auditAs(r *http.Request, actorID int, action, subject string) {
+  userAgent := r.UserAgent()
+  if len(userAgent) > 512 {
+    userAgent = userAgent[:512]
+  }
+
+  if len(subject) > 255 {
+    subject = subject[:255]
+  }
+
+  err := app.auditLog.Insert(&models.AuditEvent{
+    Action:    action,
+    ActorID:   actorID,
+    Subject:   subject,
+    IPAddress: app.clientIP(r),
+    UserAgent: userAgent,
+    RequestID: requestID(r),
+  })
+
+  if err != nil {
+    app.errorLog.Output(2, fmt.Sprintf("audit: %s: %s", action, err))
+  }
+}
+
+// The emailPseudonym helper returns the audit log subject for an email address
+// which doesn't belong to an account we can name, like the address in a failed
+// login. Audit events outlive the accounts they mention, so rather than the
+// address itself we record a keyed hash of it: repeated failures for the same
+// address can still be told apart, but the address can't be read back.
+func (app *application) emailPseudonym(email string) string {
+  mac := hmac.New(sha256.New, app.auditKey)
+  mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+
+  return "email-hash:" + hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// The requestID helper returns the ID assigned to the current request by the
+// requestID middleware.
+func requestID(r *http.Request) string {
+  id, _ := r.Context().Value(contextKeyRequestID).(string)
+
+  return id
+}
+
 // Return true if the current request is from authenticated user, otherwise return false.
 func (app *application) isAuthenticated(r *http.Request) bool {
   isAuthenticated, ok := r.Context().Value(contextKeyIsAuthenticated).(bool)
@@ -104,3 +355,58 @@ func (app *application) isAuthenticated(r *http.Request) bool {
   }
   return isAuthenticated
 }
+
+// The clientIP helper returns the IP address of the client which made the
+// request, as worked out by the proxyHeaders middleware.
+func (app *application) clientIP(r *http.Request) string {
+  if ip, ok := r.Context().Value(contextKeyClientIP).(string); ok {
+    return ip
+  }
+
+  ip, _, _ := app.resolveClient(r)
+
+  return ip
+}
+
+// The requestScheme helper returns the scheme ("http" or "https") the client
+// used to make the request, which may differ from our own connection's when
+// TLS is terminated by a proxy.
+func requestScheme(r *http.Request) string {
+  if scheme, ok := r.Context().Value(contextKeyScheme).(string); ok {
+    return scheme
+  }
+
+  if r.TLS != nil {
+    return "https"
+  }
+
+  return "http"
+}
+
+// The remoteIP function returns the IP address the request's connection came
+// from, without the port.
+func remoteIP(r *http.Request) string {
+  ip, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    return r.RemoteAddr
+  }
+
+  return ip
+}
+
+// Return true if the given IP address is inside one of the trusted proxy
+// ranges, otherwise return false.
+func (app *application) isTrustedProxy(ip string) bool {
+  parsed := net.ParseIP(ip)
+  if parsed == nil {
+    return false
+  }
+
+  for _, n := range app.trustedProxies {
+    if n.Contains(parsed) {
+      return true
+    }
+  }
+
+  return false
+}
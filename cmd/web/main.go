@@ -6,12 +6,20 @@ import (
   "flag"
   "html/template"
   "log"
+  "math"
+  "net"
   "net/http"
   "os"
   "time"
 
+  "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/ldap"
+  "mateuszurbanski/snippetbox/pkg/markdown"
   "mateuszurbanski/snippetbox/pkg/models"
+  "mateuszurbanski/snippetbox/pkg/models/memory"
   "mateuszurbanski/snippetbox/pkg/models/mysql"
+  "mateuszurbanski/snippetbox/pkg/oidc"
+  "mateuszurbanski/snippetbox/pkg/passwords"
 
   _ "github.com/go-sql-driver/mysql"
   "github.com/golangcollege/sessions"
@@ -26,23 +34,97 @@ import (
 
 type contextKey string
 
-const contextKeyIsAuthenticated = contextKey("isAuthenticated")
+const (
+  contextKeyClientIP        = contextKey("clientIP")
+  contextKeyCSPNonce        = contextKey("cspNonce")
+  contextKeyIsAuthenticated = contextKey("isAuthenticated")
+  contextKeyRequestID       = contextKey("requestID")
+  contextKeyScheme          = contextKey("scheme")
+  contextKeySession         = contextKey("session")
+  contextKeyUser            = contextKey("user")
+)
 
 // Define an application struct to hold the application-wide dependencies.
 type application struct {
-  errorLog *log.Logger
-  infoLog  *log.Logger
-  session  *sessions.Session
-  snippets interface {
-    Insert(string, string, string) (int, error)
+  apiTokens        interface {
+    Insert(int, string, string) (int, error)
+    GetByToken(string) (*models.APIToken, error)
+    Touch(int) error
+    ListForUser(int) ([]*models.APIToken, error)
+    Delete(int, int) error
+  }
+  auditKey         []byte
+  auditLog         interface {
+    Insert(*models.AuditEvent) error
+    List(models.AuditFilter, int, int) ([]*models.AuditEvent, int, error)
+    DeleteBefore(time.Time) (int, error)
+  }
+  auditRetention   time.Duration
+  authenticator    authenticator
+  deletionGrace    time.Duration
+  errorLog         *log.Logger
+  headers          *securityHeaders
+  infoLog          *log.Logger
+  limiters         rateLimiters
+  markdown         *markdown.Cache
+  maxPasteSize     int64
+  oidc             *oidc.Provider
+  oidcProvision    bool
+  passwordPolicy   *forms.PasswordPolicy
+  rememberLifetime time.Duration
+  rememberTokens   interface {
+    Insert(int, string, string, time.Time) error
+    Get(string) (*models.RememberToken, error)
+    Rotate(string, string, string) error
+    Delete(string) error
+    DeleteAllForUser(int) error
+    DeleteExpired() error
+  }
+  session          *sessions.Session
+  sessionKeyID     string
+  sessions         interface {
+    Insert(int, string, string, string, time.Time) (int, error)
+    GetByToken(string) (*models.Session, error)
+    Touch(int, string) error
+    ListForUser(int) ([]*models.Session, error)
+    Delete(int, int) error
+    DeleteByToken(string) error
+    DeleteAllForUser(int, int) error
+    DeleteExpired() error
+  }
+  snippets         interface {
+    Insert(int, string, string, time.Time, string, string, bool, []*models.SnippetFile) (int, error)
     Get(int) (*models.Snippet, error)
+    GetBySlug(string) (*models.Snippet, error)
     Latest() ([]*models.Snippet, error)
+    Delete(int) error
+    Burn(int) error
+    List(string, int, int) ([]*models.Snippet, int, error)
+    Search(string, int, int, int) ([]*models.Snippet, int, error)
+    Extend(int, int, time.Time) error
+    Count() (int, int, error)
+    ListForUser(int) ([]*models.Snippet, error)
+    Update(int, int, string, string, []*models.SnippetFile) (int, error)
+    Revisions(int) ([]*models.SnippetRevision, error)
+    Revision(int, int) (*models.SnippetRevision, error)
   }
-  templateCache map[string]*template.Template
-  users         interface {
+  templateCache    map[string]*template.Template
+  trustedProxies   []*net.IPNet
+  users            interface {
     Insert(string, string, string) error
     Authenticate(string, string) (int, error)
     Get(int) (*models.User, error)
+    ChangePassword(int, string, string) error
+    List(string, int, int) ([]*models.User, int, error)
+    SetActive(int, bool) error
+    Count() (int, int, error)
+    GetByEmail(string) (*models.User, error)
+    GetByIdentity(string, string) (*models.User, error)
+    LinkIdentity(int, string, string) error
+    SetRole(int, string) error
+    ScheduleDeletion(int, time.Time, bool) error
+    CancelDeletion(int) error
+    DeleteScheduled(time.Time) ([]int, error)
   }
 }
 
@@ -55,14 +137,132 @@ func main() {
   // Define a new command-line flag for the MySQL DSN string.
   dsn := flag.String("dsn", "web:password@/snippetbox?parseTime=true", "MySQL data source name")
 
+  // The "gensecret" command prints a new random session secret and explains how
+  // to rotate it, instead of starting the server.
+  if len(os.Args) > 1 && os.Args[1] == "gensecret" {
+    if err := genSecret(os.Stdout, os.Stderr); err != nil {
+      log.Fatal(err)
+    }
+
+    return
+  }
+
+  // The "gencert" command creates a development certificate authority and a
+  // certificate for the local server, instead of starting the server.
+  if len(os.Args) > 1 && os.Args[1] == "gencert" {
+    if err := genCert(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+      log.Fatal(err)
+    }
+
+    return
+  }
+
   // Define a new command-line flag for the session secret (a random key which
-  // will be used to encrypt and authenticate session cookies). It should be 32
-  // bytes long.
-  secret := flag.String("secret", "n6Gdh+pPbnzHbS*+9Pk8qGWhTzbpa@gd", "Secret key")
+  // will be used to encrypt and authenticate session cookies). It must be 32
+  // bytes long. It defaults to the SNIPPETBOX_SECRET environment variable.
+  secret := flag.String("secret", envOr("SNIPPETBOX_SECRET", "n6Gdh+pPbnzHbS*+9Pk8qGWhTzbpa@gd"), "Secret key")
+
+  // Define a new command-line flag for the comma-separated list of secrets we
+  // used previously. Cookies encrypted with these are still accepted, so the
+  // secret can be rotated without logging everyone out.
+  previousSecrets := flag.String("previous-secrets", envOr("SNIPPETBOX_PREVIOUS_SECRETS", ""), "Comma-separated list of previous secret keys")
 
   // Define a new command-line flag for the current environment.
   environment := flag.String("environment", "development", "Current Environment")
 
+  // Define flags for the TLS certificate and key. Outside production they
+  // default to the files created by "web gencert". In production TLS is usually
+  // terminated by the router, so we only serve HTTPS if a certificate is given.
+  // The files are reloaded when they change, so a renewed certificate is picked
+  // up without a restart.
+  tlsCert := flag.String("tls-cert", "", "TLS certificate file (default ./tls/cert.pem outside production)")
+  tlsKey := flag.String("tls-key", "", "TLS private key file (default ./tls/key.pem outside production)")
+
+  // Define a flag for the address of a plain HTTP listener which redirects
+  // every request to HTTPS. It is disabled when empty.
+  redirectAddr := flag.String("redirect-addr", "", "HTTP network address which redirects to HTTPS, e.g. :8080")
+
+  // Define flags for the security headers. The Content-Security-Policy may
+  // contain {nonce}, which is replaced with a random nonce for each request.
+  // Strict-Transport-Security is only sent in production.
+  headers := defaultSecurityHeaders()
+  flag.StringVar(&headers.CSP, "csp", headers.CSP, "Content-Security-Policy header")
+  flag.StringVar(&headers.ReferrerPolicy, "referrer-policy", headers.ReferrerPolicy, "Referrer-Policy header")
+  flag.StringVar(&headers.PermissionsPolicy, "permissions-policy", headers.PermissionsPolicy, "Permissions-Policy header")
+  hstsMaxAge := flag.Duration("hsts-max-age", 365*24*time.Hour, "Strict-Transport-Security max-age in production")
+
+  // Define a flag for how many rendered Markdown snippets are kept in memory,
+  // so that popular ones aren't parsed again on every view.
+  markdownCache := flag.Int("markdown-cache", 1000, "Number of rendered Markdown snippets to cache")
+
+  // Define a flag for the largest request body accepted by /paste. The
+  // default fits the snippets table's TEXT column.
+  maxPasteSize := flag.Int64("max-paste-size", 65535, "Maximum size in bytes of a /paste request body")
+
+  // Define command-line flags for the rate limits of each group of routes, in
+  // the form "<requests>/<interval>". Set a limit to "0" to disable it.
+  signupLimit := flag.String("limit-signup", "5/1h", "Rate limit for signups")
+  loginLimit := flag.String("limit-login", "10/1m", "Rate limit for login attempts")
+  snippetLimit := flag.String("limit-snippet", "30/1h", "Rate limit for creating snippets")
+  apiLimit := flag.String("limit-api", "60/1m", "Rate limit for API requests")
+
+  // Define a command-line flag for the comma-separated list of proxy CIDRs we
+  // trust to set the Forwarded, X-Forwarded-For and X-Forwarded-Proto headers
+  // (e.g. the Heroku router).
+  trustedProxies := flag.String("trusted-proxies", "", "Comma-separated list of trusted proxy CIDRs")
+
+  // Define a command-line flag for where login sessions are stored: "mysql"
+  // keeps them (and remember me tokens) in the database, "memory" keeps them in
+  // the process and is only suitable when running a single instance.
+  sessionStore := flag.String("session-store", "mysql", "Login session store (mysql or memory)")
+
+  // Define a flag for how long "remember me" logins last.
+  rememberLifetime := flag.Duration("remember-lifetime", 30*24*time.Hour, "How long \"remember me\" logins last")
+
+  // Define a flag for how long accounts are kept after their owner asks for
+  // them to be deleted, so that they can change their mind.
+  deletionGrace := flag.Duration("deletion-grace", 14*24*time.Hour, "Grace period before a deleted account is removed")
+
+  // Define a flag for how long audit events are kept. They record IP addresses
+  // and user agents, so they shouldn't be kept forever, but the database
+  // refuses to delete events younger than minAuditRetention.
+  auditRetention := flag.Duration("audit-retention", 365*24*time.Hour, "How long audit events are kept (at least 90 days)")
+
+  // Define command-line flags for the password hashing policy. New passwords
+  // are hashed with it, and weaker hashes are upgraded on the next login.
+  passwordHash := flag.String("password-hash", "argon2id", "Password hashing algorithm (argon2id or bcrypt)")
+  argon2Memory := flag.Uint("argon2-memory", 64*1024, "Argon2id memory cost in KiB")
+  argon2Iterations := flag.Uint("argon2-iterations", 3, "Argon2id number of iterations")
+  argon2Parallelism := flag.Uint("argon2-parallelism", 2, "Argon2id degree of parallelism")
+  bcryptCost := flag.Int("bcrypt-cost", 12, "Bcrypt cost")
+
+  // Define command-line flags for the password strength policy: the lowest
+  // acceptable strength score (0-4) and the Pwned Passwords range API used to
+  // reject breached passwords. If no API is given, the bundled list of common
+  // passwords is used instead.
+  passwordMinScore := flag.Int("password-min-score", 2, "Minimum password strength score (0-4)")
+  pwnedPasswordsAPI := flag.String("pwned-passwords-api", "", "Pwned Passwords range API URL (e.g. https://api.pwnedpasswords.com)")
+
+  // Define flags for single sign-on through an OpenID Connect provider. SSO is
+  // enabled when an issuer is given. Users are matched to existing accounts by
+  // their verified email address, and with -oidc-auto-provision an account is
+  // created for users who don't have one yet.
+  oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL (enables single sign-on)")
+  oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+  oidcClientSecret := flag.String("oidc-client-secret", envOr("SNIPPETBOX_OIDC_CLIENT_SECRET", ""), "OpenID Connect client secret")
+  oidcRedirectURL := flag.String("oidc-redirect-url", "https://localhost:4000/user/login/oidc/callback", "OpenID Connect redirect URL")
+  oidcAutoProvision := flag.Bool("oidc-auto-provision", false, "Create accounts for new single sign-on users")
+
+  // Define flags for authenticating users against an LDAP directory, which is
+  // enabled when a URL is given. Users who aren't in the directory can still
+  // log in with a local account.
+  ldapURL := flag.String("ldap-url", "", "LDAP server URL, e.g. ldaps://ldap.example.com (enables LDAP authentication)")
+  ldapInsecure := flag.Bool("ldap-insecure", false, "Don't upgrade ldap:// connections with StartTLS, sending passwords in the clear")
+  ldapBindDN := flag.String("ldap-bind-dn", "", "DN of the LDAP service account used to look up users")
+  ldapBindPassword := flag.String("ldap-bind-password", envOr("SNIPPETBOX_LDAP_BIND_PASSWORD", ""), "Password of the LDAP service account")
+  ldapBaseDN := flag.String("ldap-base-dn", "", "LDAP base DN to search for users")
+  ldapUserFilter := flag.String("ldap-user-filter", "(mail=%s)", "LDAP filter to find a user by email address")
+  ldapRoleGroups := flag.String("ldap-role-groups", "", "Semicolon-separated list of role:groupDN pairs, e.g. admin:cn=admins,dc=example,dc=com")
 
   // Importantly, we use the flag.Parse() function to parse the command-line flag.
   // This reads in the command-line flag value and assigns it to the addr
@@ -95,6 +295,37 @@ func main() {
   // before the main() function exits.
   defer db.Close()
 
+  // Parse the rate limit and trusted proxy flags.
+  var limiters rateLimiters
+
+  for _, l := range []struct {
+    limiter **rateLimiter
+    flag    string
+  }{
+    {&limiters.signup, *signupLimit},
+    {&limiters.login, *loginLimit},
+    {&limiters.snippet, *snippetLimit},
+    {&limiters.api, *apiLimit},
+  } {
+    *l.limiter, err = parseRateLimit(l.flag)
+    if err != nil {
+      errorLog.Fatal(err)
+    }
+  }
+
+  proxies, err := parseCIDRs(*trustedProxies)
+  if err != nil {
+    errorLog.Fatal(err)
+  }
+
+  // Periodically forget the token buckets of clients we haven't seen for a
+  // while, so that the limiters don't grow without bound.
+  go func() {
+    for range time.Tick(time.Minute) {
+      limiters.each(func(l *rateLimiter) { l.cleanup() })
+    }
+  }()
+
   // Initialize a new template cache...
   templateCache, err := newTemplateCache("./ui/html/")
 
@@ -102,23 +333,175 @@ func main() {
     errorLog.Fatal(err)
   }
 
+  // Build the password hasher for the configured policy.
+  hasher, err := passwords.New(*passwordHash)
+  if err != nil {
+    errorLog.Fatal(err)
+  }
+
+  // The flags are wider than the argon2id parameters, so check that they fit
+  // rather than letting them silently wrap around.
+  switch h := hasher.(type) {
+  case *passwords.Argon2id:
+    switch {
+    case *argon2Memory > math.MaxUint32:
+      errorLog.Fatalf("-argon2-memory must be at most %d", uint64(math.MaxUint32))
+    case *argon2Iterations > math.MaxUint32:
+      errorLog.Fatalf("-argon2-iterations must be at most %d", uint64(math.MaxUint32))
+    case *argon2Parallelism > math.MaxUint8:
+      errorLog.Fatalf("-argon2-parallelism must be at most %d", math.MaxUint8)
+    }
+
+    h.Memory = uint32(*argon2Memory)
+    h.Iterations = uint32(*argon2Iterations)
+    h.Parallelism = uint8(*argon2Parallelism)
+
+    err = h.Validate()
+    if err != nil {
+      errorLog.Fatal(err)
+    }
+  case *passwords.Bcrypt:
+    h.Cost = *bcryptCost
+
+    err = h.Validate()
+    if err != nil {
+      errorLog.Fatal(err)
+    }
+  }
+
+  // Set up the password strength policy.
+  passwordPolicy := &forms.PasswordPolicy{MinScore: *passwordMinScore}
+
+  if *pwnedPasswordsAPI != "" {
+    passwordPolicy.Breaches = &forms.PwnedPasswordsAPI{URL: *pwnedPasswordsAPI}
+  } else {
+    passwordPolicy.Breaches = forms.NewLocalBreachList()
+  }
+
+  // Validate the current and previous session secrets.
+  key, oldKeys, err := parseSecrets(*secret, *previousSecrets)
+  if err != nil {
+    errorLog.Fatal(err)
+  }
+
+  if *auditRetention < minAuditRetention {
+    errorLog.Fatalf("-audit-retention must be at least %s", minAuditRetention)
+  }
+
   // Use the sessions.New() function to initialize a new session manager,
-  // passing in the secret key as the parameter. Then we configure it so
-  // sessions always expires after 12 hours.
-  session := sessions.New([]byte(*secret))
+  // passing in the secret key and any previous keys as the parameters. Then we
+  // configure it so sessions always expires after 12 hours.
+  session := sessions.New(key, oldKeys...)
   session.Lifetime = 12 * time.Hour
   session.Secure = true // Set the Secure flag on our session cookies
 
+  if *environment == "production" {
+    headers.HSTS = *hstsMaxAge
+  }
+
   // Initialize a new instance of application containing the dependencies.
   app := &application{
-    errorLog:      errorLog,
-    infoLog:       infoLog,
-    session:       session,
-    snippets:      &mysql.SnippetModel{DB: db},
-    templateCache: templateCache,
-    users:         &mysql.UserModel{DB: db},
+    apiTokens:        &mysql.APITokenModel{DB: db},
+    auditKey:         auditKey(key),
+    auditLog:         &mysql.AuditModel{DB: db},
+    auditRetention:   *auditRetention,
+    deletionGrace:    *deletionGrace,
+    errorLog:         errorLog,
+    headers:          headers,
+    infoLog:          infoLog,
+    limiters:         limiters,
+    markdown:         markdown.NewCache(*markdownCache),
+    maxPasteSize:     *maxPasteSize,
+    passwordPolicy:   passwordPolicy,
+    rememberLifetime: *rememberLifetime,
+    session:          session,
+    sessionKeyID:     keyID(key),
+    snippets:         &mysql.SnippetModel{DB: db},
+    templateCache:    templateCache,
+    trustedProxies:   proxies,
+    users:            &mysql.UserModel{DB: db, Hasher: hasher},
+  }
+
+  // Discover the OpenID Connect provider's endpoints, if single sign-on is
+  // enabled.
+  if *oidcIssuer != "" {
+    app.oidc, err = oidc.NewProvider(oidc.Config{
+      Issuer:       *oidcIssuer,
+      ClientID:     *oidcClientID,
+      ClientSecret: *oidcClientSecret,
+      RedirectURL:  *oidcRedirectURL,
+    })
+    if err != nil {
+      errorLog.Fatal(err)
+    }
+
+    app.oidcProvision = *oidcAutoProvision
+  }
+
+  // Users log in with their local password, unless LDAP is enabled, in which
+  // case the directory is tried first.
+  app.authenticator = app.users
+
+  if *ldapURL != "" {
+    roleGroups, err := parseRoleGroups(*ldapRoleGroups)
+    if err != nil {
+      errorLog.Fatal(err)
+    }
+
+    client, err := ldap.New(ldap.Config{
+      URL:          *ldapURL,
+      Insecure:     *ldapInsecure,
+      BindDN:       *ldapBindDN,
+      BindPassword: *ldapBindPassword,
+      BaseDN:       *ldapBaseDN,
+      UserFilter:   *ldapUserFilter,
+      Attributes:   []string{"mail", "cn", "memberOf"},
+    })
+    if err != nil {
+      errorLog.Fatal(err)
+    }
+
+    app.authenticator = &ldapAuthenticator{
+      client:         client,
+      users:          app.users,
+      fallback:       app.users,
+      errorLog:       errorLog,
+      roleGroups:     roleGroups,
+      mailAttribute:  "mail",
+      nameAttribute:  "cn",
+      groupAttribute: "memberOf",
+    }
   }
 
+  // Pick the backend for server-side login sessions and remember me tokens.
+  switch *sessionStore {
+  case "mysql":
+    app.sessions = &mysql.SessionModel{DB: db}
+    app.rememberTokens = &mysql.RememberTokenModel{DB: db}
+  case "memory":
+    app.sessions = memory.NewSessionModel()
+    app.rememberTokens = memory.NewRememberTokenModel()
+  default:
+    errorLog.Fatalf("Unknown session store %q", *sessionStore)
+  }
+
+  // Nothing else removes the sessions and remember me tokens which expire
+  // without being used again, so sweep them out every few minutes.
+  go func() {
+    for range time.Tick(5 * time.Minute) {
+      app.deleteExpiredSessions()
+    }
+  }()
+
+  // Delete the accounts whose grace period is over, and the audit events
+  // which are past their retention period, once an hour.
+  go func() {
+    for range time.Tick(time.Hour) {
+      app.deleteScheduledAccounts()
+      app.purgeAuditLog()
+    }
+  }()
+
   // Initialize a tls.Config struct to hold the non-default TLS settings we want
   // the server to use.
   tlsConfig := &tls.Config{
@@ -149,15 +532,59 @@ func main() {
   // value, not the value itself. So we need to dereference the pointer (i.e.
   // prefix it with the * symbol) before using it. Note that we're using the
   // log.Printf() function to interpolate the address with the log message.
-  infoLog.Printf("Starting server on %s", *addr)
+  if *environment != "production" {
+    if *tlsCert == "" {
+      *tlsCert = "./tls/cert.pem"
+    }
 
+    if *tlsKey == "" {
+      *tlsKey = "./tls/key.pem"
+    }
+  }
 
-  if *environment == "production" {
+  if *tlsCert == "" {
+    if *redirectAddr != "" {
+      errorLog.Fatal("-redirect-addr needs a TLS certificate (-tls-cert and -tls-key)")
+    }
+
+    infoLog.Printf("Starting server on %s", *addr)
     err = srv.ListenAndServe()
-  } else {
-    err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
+    errorLog.Fatal(err)
   }
 
+  // Load the certificate through a certReloader, so that it is read again
+  // when the files change.
+  certs, err := newCertReloader(*tlsCert, *tlsKey, errorLog)
+  if err != nil {
+    errorLog.Fatal(err)
+  }
+
+  go certs.watch(certReloadInterval)
+
+  tlsConfig.GetCertificate = certs.GetCertificate
+
+  // Start the HTTP listener which redirects to HTTPS, if there is one.
+  if *redirectAddr != "" {
+    redirectSrv := &http.Server{
+      Addr:         *redirectAddr,
+      ErrorLog:     errorLog,
+      Handler:      redirectHTTPS(*addr),
+      IdleTimeout:  time.Minute,
+      ReadTimeout:  5 * time.Second,
+      WriteTimeout: 5 * time.Second,
+    }
+
+    go func() {
+      infoLog.Printf("Redirecting HTTP on %s to HTTPS", *redirectAddr)
+      errorLog.Fatal(redirectSrv.ListenAndServe())
+    }()
+  }
+
+  infoLog.Printf("Starting server on %s", *addr)
+
+  // The certificate comes from tlsConfig.GetCertificate, so no files are
+  // passed here.
+  err = srv.ListenAndServeTLS("", "")
   errorLog.Fatal(err)
 }
 
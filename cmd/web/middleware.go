@@ -2,27 +2,54 @@ package main
 
 import (
   "context"
+  "crypto/rand"
+  "encoding/hex"
   "errors"
   "fmt"
   "net/http"
+  "time"
 
   "mateuszurbanski/snippetbox/pkg/models"
 
   "github.com/justinas/nosurf"
 )
 
+// The secureHeaders middleware sets the default security headers. The
+// application uses app.headers instead, which can be configured.
 func secureHeaders(next http.Handler) http.Handler {
+  return defaultSecurityHeaders().handler(next)
+}
+
+// The requestID middleware gives every request an ID, which is sent back in the
+// X-Request-ID header and recorded in the logs and audit log, so that a
+// request can be traced through the system. An ID set by one of our trusted
+// proxies (like the Heroku router) is kept, so it matches the proxy's logs.
+func (app *application) requestID(next http.Handler) http.Handler {
   return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("X-XSS-Protection", "1; mode=block")
-    w.Header().Set("X-Frame-Options", "deny")
+    id := r.Header.Get("X-Request-ID")
 
-    next.ServeHTTP(w, r)
+    if id == "" || len(id) > 64 || !app.isTrustedProxy(remoteIP(r)) {
+      b := make([]byte, 16)
+
+      _, err := rand.Read(b)
+      if err != nil {
+        app.serverError(w, err)
+        return
+      }
+
+      id = hex.EncodeToString(b)
+    }
+
+    w.Header().Set("X-Request-ID", id)
+
+    ctx := context.WithValue(r.Context(), contextKeyRequestID, id)
+    next.ServeHTTP(w, r.WithContext(ctx))
   })
 }
 
 func (app *application) logRequest(next http.Handler) http.Handler {
   return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-    app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+    app.infoLog.Printf("%s - %s %s %s [%s]", app.clientIP(r), r.Proto, r.Method, r.URL.RequestURI(), requestID(r))
 
     next.ServeHTTP(w, r)
   })
@@ -70,6 +97,66 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
   })
 }
 
+// The limit method returns a middleware which rate limits requests using the
+// given token bucket limiter. Requests are keyed by the authenticated user or,
+// for anonymous visitors, by their IP address. A nil limiter disables the check.
+func (app *application) limit(l *rateLimiter) func(http.Handler) http.Handler {
+  return func(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      if l == nil {
+        next.ServeHTTP(w, r)
+        return
+      }
+
+      // If the bucket for this client is empty, tell them how long to wait
+      // with a Retry-After header and render a 429 Too Many Requests page.
+      ok, wait := l.allow(app.rateLimitKey(r))
+      if !ok {
+        app.tooManyRequests(w, r, wait)
+        return
+      }
+
+      next.ServeHTTP(w, r)
+    })
+  }
+}
+
+// The rotateSessionKey middleware makes sure session cookies end up encrypted
+// with the current secret. Cookies written with a previous secret are still
+// accepted by the session manager, but it only re-encrypts a cookie when the
+// session data changes. So we keep a fingerprint of the key in the session and
+// update it whenever it's out of date, which forces the cookie to be written
+// again with the current key.
+func (app *application) rotateSessionKey(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    // Visitors without a session cookie have nothing to re-encrypt, and we
+    // don't want to start a session for them.
+    if _, err := r.Cookie("session"); err == nil {
+      if app.session.GetString(r, "keyID") != app.sessionKeyID {
+        app.session.Put(r, "keyID", app.sessionKeyID)
+      }
+    }
+
+    next.ServeHTTP(w, r)
+  })
+}
+
+// The requireRole method returns a middleware which only lets through users
+// with the given role (or one that outranks it). Anonymous users are sent to
+// the login page, and authenticated users without the role get a 403.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+  return func(next http.Handler) http.Handler {
+    return app.requireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      if !app.authenticatedUser(r).HasRole(role) {
+        app.forbidden(w, r)
+        return
+      }
+
+      next.ServeHTTP(w, r)
+    }))
+  }
+}
+
 // Create a NoSurf middleware function which uses a customized CSRF cookie with
 // the Secure, Path and HttpOnly flags set.
 func noSurf(next http.Handler) http.Handler {
@@ -85,22 +172,47 @@ func noSurf(next http.Handler) http.Handler {
 
 func (app *application) authenticate(next http.Handler) http.Handler {
   return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        // Check if a authenticatedUserID value exists in the session. If this *isn't
-    // present* then call the next handler in the chain as normal.
-    exists := app.session.Exists(r, "authenticatedUserID")
-    if !exists {
-      next.ServeHTTP(w, r)
-      return
+    // Check if a session cookie exists, and if so look up the server-side
+    // session for the token in it. If it has expired or been revoked, the
+    // session is nil.
+    var session *models.Session
+
+    if cookie, err := r.Cookie(sessionCookieName); err == nil {
+      session, err = app.sessions.GetByToken(cookie.Value)
+      if err != nil && !errors.Is(err, models.ErrNoRecord) {
+        app.serverError(w, err)
+        return
+      }
     }
 
+    // Without a session, the user may still have a remember me cookie which
+    // logs them back in. If they don't, call the next handler in the chain as
+    // normal.
+    if session == nil {
+      var err error
+
+      session, err = app.resumeSession(w, r)
+      if err != nil {
+        app.serverError(w, err)
+        return
+      }
+
+      if session == nil {
+        next.ServeHTTP(w, r)
+        return
+      }
+    }
+
+    // Fetch the details of the current user from the database. If no matching
+    // record is found, or the current user is has been deactivated, revoke
+    // the session and call the next handler in the chain as normal.
+    user, err := app.users.Get(session.UserID)
+    if errors.Is(err, models.ErrNoRecord) || (err == nil && !user.Active) {
+      if err := app.sessions.Delete(session.ID, session.UserID); err != nil && !errors.Is(err, models.ErrNoRecord) {
+        app.serverError(w, err)
+        return
+      }
 
-        // Fetch the details of the current user from the database. If no matching
-        // record is found, or the current user is has been deactivated, remove the
-        // (invalid) authenticatedUserID value from their session and call the next
-        // handler in the chain as normal.
-    user, err := app.users.Get(app.session.GetInt(r, "authenticatedUserID"))
-        if errors.Is(err, models.ErrNoRecord) || !user.Active {
-      app.session.Remove(r, "authenticatedUserID")
       next.ServeHTTP(w, r)
       return
     } else if err != nil {
@@ -108,11 +220,24 @@ func (app *application) authenticate(next http.Handler) http.Handler {
       return
     }
 
-        // Otherwise, we know that the request is coming from a active, authenticated,
-        // user. We create a new copy of the request, with a true boolean value
-        // added to the request context to indicate this, and call the next handler
-        // in the chain *using this new copy of the request*.
+    // Record when and from where the session was last used. We only do this
+    // once a minute, to avoid a database write on every request.
+    if time.Since(session.LastSeen) > time.Minute {
+      err = app.sessions.Touch(session.ID, app.clientIP(r))
+      if err != nil {
+        app.serverError(w, err)
+        return
+      }
+    }
+
+    // Otherwise, we know that the request is coming from a active, authenticated,
+    // user. We create a new copy of the request, with a true boolean value
+    // added to the request context to indicate this, along with the user and
+    // their session, and call the next handler in the chain *using this new
+    // copy of the request*.
     ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+    ctx = context.WithValue(ctx, contextKeyUser, user)
+    ctx = context.WithValue(ctx, contextKeySession, session)
     next.ServeHTTP(w, r.WithContext(ctx))
   })
 }
@@ -1,10 +1,17 @@
 package main
 
 import (
+    "bytes"
     "io"
+    "log"
     "net/http"
     "net/http/httptest"
+    "regexp"
+    "strings"
     "testing"
+    "time"
+
+    "github.com/golangcollege/sessions"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -66,3 +73,379 @@ func TestSecureHeaders(t *testing.T) {
         t.Errorf("want body to equal %q", "OK")
     }
 }
+
+func TestSecurityHeaders(t *testing.T) {
+    app := newTestApplication(t)
+    app.headers.HSTS = 365 * 24 * time.Hour
+
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    _, header, body := ts.get(t, "/")
+
+    tests := []struct {
+        name string
+        want string
+    }{
+        {"X-Content-Type-Options", "nosniff"},
+        {"Referrer-Policy", "strict-origin-when-cross-origin"},
+        {"Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=()"},
+        {"Cross-Origin-Opener-Policy", "same-origin"},
+        {"Cross-Origin-Resource-Policy", "same-origin"},
+        {"Strict-Transport-Security", "max-age=31536000; includeSubDomains"},
+    }
+
+    for _, tt := range tests {
+        if got := header.Get(tt.name); got != tt.want {
+            t.Errorf("%s: want %q; got %q", tt.name, tt.want, got)
+        }
+    }
+
+    // The policy's nonce must be fresh for each request, and the same one
+    // must be given to the page's scripts.
+    csp := header.Get("Content-Security-Policy")
+
+    matches := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(csp)
+    if matches == nil {
+        t.Fatalf("want a nonce in the policy; got %q", csp)
+    }
+
+    if !bytes.Contains(body, []byte("nonce='"+matches[1]+"'")) {
+        t.Error("want the script tag to carry the policy's nonce")
+    }
+
+    _, header, _ = ts.get(t, "/")
+    if strings.Contains(header.Get("Content-Security-Policy"), matches[1]) {
+        t.Error("want a new nonce for every request")
+    }
+
+    // Without the production setting, HSTS is left out.
+    rr := httptest.NewRecorder()
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+    secureHeaders(http.NotFoundHandler()).ServeHTTP(rr, r)
+
+    if hsts := rr.Header().Get("Strict-Transport-Security"); hsts != "" {
+        t.Errorf("want no Strict-Transport-Security header; got %q", hsts)
+    }
+}
+
+func TestCSPReport(t *testing.T) {
+    tests := []struct {
+        name        string
+        contentType string
+        body        string
+        wantCode    int
+        wantLog     string
+    }{
+        {"CSP report", "application/csp-report", `{"csp-report":{"document-uri":"https://example.com/","blocked-uri":"https://evil.example/x.js","violated-directive":"script-src"}}`, http.StatusNoContent, `"script-src" blocked "https://evil.example/x.js"`},
+        {"Reporting API", "application/reports+json", `[{"type":"csp-violation","body":{"documentURL":"https://example.com/","blockedURL":"inline","effectiveDirective":"script-src-elem"}}]`, http.StatusNoContent, `"script-src-elem" blocked "inline"`},
+        {"Malformed", "application/csp-report", `{`, http.StatusBadRequest, ""},
+        {"Wrong type", "text/plain", `hello`, http.StatusUnsupportedMediaType, ""},
+        {"Too large", "application/csp-report", `{"csp-report":{"blocked-uri":"` + strings.Repeat("a", maxCSPReportSize) + `"}}`, http.StatusBadRequest, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+
+            var logs bytes.Buffer
+            app.infoLog = log.New(&logs, "", 0)
+
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            rs, err := ts.Client().Post(ts.URL+"/csp-report", tt.contentType, strings.NewReader(tt.body))
+            if err != nil {
+                t.Fatal(err)
+            }
+            rs.Body.Close()
+
+            if rs.StatusCode != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+            }
+
+            if tt.wantLog != "" && !strings.Contains(logs.String(), tt.wantLog) {
+                t.Errorf("want log to contain %q; got %q", tt.wantLog, logs.String())
+            }
+        })
+    }
+}
+
+func TestLimit(t *testing.T) {
+    app := newTestApplication(t)
+
+    // Allow two requests per minute, and freeze the clock so that no tokens
+    // are refilled during the test.
+    l := newRateLimiter(2, time.Minute)
+    now := time.Now()
+    l.now = func() time.Time { return now }
+
+    next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("OK"))
+    })
+
+    handler := app.session.Enable(app.limit(l)(next))
+
+    tests := []struct {
+        name           string
+        remoteAddr     string
+        wantCode       int
+        wantRetryAfter string
+    }{
+        {"First request", "192.0.2.1:1234", http.StatusOK, ""},
+        {"Second request", "192.0.2.1:1234", http.StatusOK, ""},
+        {"Third request", "192.0.2.1:1234", http.StatusTooManyRequests, "30"},
+        {"Other client", "192.0.2.2:1234", http.StatusOK, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rr := httptest.NewRecorder()
+
+            r, err := http.NewRequest(http.MethodPost, "/", nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            r.RemoteAddr = tt.remoteAddr
+
+            handler.ServeHTTP(rr, r)
+
+            if rr.Code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, rr.Code)
+            }
+
+            if got := rr.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+                t.Errorf("want Retry-After %q; got %q", tt.wantRetryAfter, got)
+            }
+        })
+    }
+}
+
+func TestClientIP(t *testing.T) {
+    app := newTestApplication(t)
+
+    proxies, err := parseCIDRs("10.0.0.0/8, 192.0.2.10")
+    if err != nil {
+        t.Fatal(err)
+    }
+    app.trustedProxies = proxies
+
+    tests := []struct {
+        name          string
+        remoteAddr    string
+        xForwardedFor string
+        want          string
+    }{
+        {"Direct", "198.51.100.1:1234", "", "198.51.100.1"},
+        {"Untrusted proxy", "198.51.100.1:1234", "203.0.113.5", "198.51.100.1"},
+        {"Trusted proxy", "10.1.2.3:1234", "203.0.113.5", "203.0.113.5"},
+        {"Chain of trusted proxies", "10.1.2.3:1234", "203.0.113.5, 192.0.2.10", "203.0.113.5"},
+        {"Spoofed header", "10.1.2.3:1234", "1.1.1.1, 203.0.113.5", "203.0.113.5"},
+        {"Garbage header", "10.1.2.3:1234", "nonsense", "10.1.2.3"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r, err := http.NewRequest(http.MethodGet, "/", nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            r.RemoteAddr = tt.remoteAddr
+
+            if tt.xForwardedFor != "" {
+                r.Header.Set("X-Forwarded-For", tt.xForwardedFor)
+            }
+
+            if got := app.clientIP(r); got != tt.want {
+                t.Errorf("want %q; got %q", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestResolveClient(t *testing.T) {
+    app := newTestApplication(t)
+
+    proxies, err := parseCIDRs("10.0.0.0/8, 2001:db8::/32")
+    if err != nil {
+        t.Fatal(err)
+    }
+    app.trustedProxies = proxies
+
+    tests := []struct {
+        name          string
+        remoteAddr    string
+        headers       map[string]string
+        wantIP        string
+        wantScheme    string
+        wantForwarded bool
+    }{
+        {"Direct", "198.51.100.1:1234", map[string]string{"Forwarded": "for=1.1.1.1;proto=https"}, "198.51.100.1", "http", false},
+        {"Forwarded", "10.1.2.3:1234", map[string]string{"Forwarded": "for=203.0.113.5;proto=https"}, "203.0.113.5", "https", true},
+        {"Forwarded chain", "10.1.2.3:1234", map[string]string{"Forwarded": `for=1.1.1.1, for="[2001:db8::17]:4711";proto=http, for=10.9.9.9;proto=https`}, "1.1.1.1", "http", true},
+        {"Forwarded quoted IPv6", "10.1.2.3:1234", map[string]string{"Forwarded": `For="[2001:db8:cafe::17]:4711";Proto=HTTPS`}, "2001:db8:cafe::17", "https", true},
+        {"Forwarded obfuscated", "10.1.2.3:1234", map[string]string{"Forwarded": "for=_hidden;proto=https"}, "10.1.2.3", "http", false},
+        {"Forwarded wins", "10.1.2.3:1234", map[string]string{"Forwarded": "for=203.0.113.5", "X-Forwarded-For": "203.0.113.9"}, "203.0.113.5", "http", false},
+        {"X-Forwarded-Proto", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "203.0.113.5", "X-Forwarded-Proto": "https"}, "203.0.113.5", "https", true},
+        {"X-Forwarded-Proto spoofed", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.5", "X-Forwarded-Proto": "http, https"}, "203.0.113.5", "https", true},
+        {"Untrusted X-Forwarded-Proto", "198.51.100.1:1234", map[string]string{"X-Forwarded-Proto": "https"}, "198.51.100.1", "http", false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r, err := http.NewRequest(http.MethodGet, "/", nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            r.RemoteAddr = tt.remoteAddr
+
+            for k, v := range tt.headers {
+                r.Header.Set(k, v)
+            }
+
+            ip, scheme, forwarded := app.resolveClient(r)
+
+            if ip != tt.wantIP {
+                t.Errorf("want IP %q; got %q", tt.wantIP, ip)
+            }
+
+            if scheme != tt.wantScheme {
+                t.Errorf("want scheme %q; got %q", tt.wantScheme, scheme)
+            }
+
+            if forwarded != tt.wantForwarded {
+                t.Errorf("want forwarded %v; got %v", tt.wantForwarded, forwarded)
+            }
+        })
+    }
+}
+
+func TestProxyHeaders(t *testing.T) {
+    app := newTestApplication(t)
+
+    proxies, err := parseCIDRs("10.0.0.0/8")
+    if err != nil {
+        t.Fatal(err)
+    }
+    app.trustedProxies = proxies
+
+    headers := defaultSecurityHeaders()
+    headers.HSTS = time.Hour
+
+    var gotIP, gotScheme string
+
+    next := headers.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        gotIP, gotScheme = app.clientIP(r), requestScheme(r)
+    }))
+
+    tests := []struct {
+        name         string
+        method       string
+        proto        string
+        wantCode     int
+        wantLocation string
+        wantScheme   string
+        wantHSTS     bool
+    }{
+        {"TLS terminated upstream", http.MethodGet, "https", http.StatusOK, "", "https", true},
+        {"Plain HTTP GET", http.MethodGet, "http", http.StatusMovedPermanently, "https://example.com/snippet/1?x=y", "", false},
+        {"Plain HTTP POST", http.MethodPost, "http", http.StatusPermanentRedirect, "https://example.com/snippet/1?x=y", "", false},
+        {"No proto", http.MethodGet, "", http.StatusOK, "", "http", false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            gotIP, gotScheme = "", ""
+
+            rr := httptest.NewRecorder()
+
+            r := httptest.NewRequest(tt.method, "http://example.com/snippet/1?x=y", nil)
+            r.RemoteAddr = "10.1.2.3:1234"
+            r.Header.Set("X-Forwarded-For", "203.0.113.5")
+            if tt.proto != "" {
+                r.Header.Set("X-Forwarded-Proto", tt.proto)
+            }
+
+            app.proxyHeaders(next).ServeHTTP(rr, r)
+
+            if rr.Code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, rr.Code)
+            }
+
+            if got := rr.Header().Get("Location"); got != tt.wantLocation {
+                t.Errorf("want Location %q; got %q", tt.wantLocation, got)
+            }
+
+            if tt.wantCode == http.StatusOK {
+                if gotIP != "203.0.113.5" {
+                    t.Errorf("want client IP %q; got %q", "203.0.113.5", gotIP)
+                }
+
+                if gotScheme != tt.wantScheme {
+                    t.Errorf("want scheme %q; got %q", tt.wantScheme, gotScheme)
+                }
+            }
+
+            if got := rr.Header().Get("Strict-Transport-Security") != ""; got != tt.wantHSTS {
+                t.Errorf("want Strict-Transport-Security %v; got %v", tt.wantHSTS, got)
+            }
+        })
+    }
+}
+
+func TestRotateSessionKey(t *testing.T) {
+    oldKey := []byte("Mw8Tz+Kq3pLr@xV6nYc2HbJ5sDf9GhA1")
+    newKey := []byte("Qe4Ru7Wt0Yp*Ia3Sd6Fg9Hj2Kl5Zx8Cv")
+
+    // Issue a session cookie encrypted with the old key.
+    oldSession := sessions.New(oldKey)
+
+    rr := httptest.NewRecorder()
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+    oldSession.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        oldSession.Put(r, "flash", "Hello")
+    })).ServeHTTP(rr, r)
+
+    oldCookie := rr.Result().Cookies()[0]
+
+    // Now rotate: the old key becomes a previous key.
+    app := newTestApplication(t)
+    app.session = sessions.New(newKey, oldKey)
+    app.sessionKeyID = keyID(newKey)
+
+    rr = httptest.NewRecorder()
+    r = httptest.NewRequest(http.MethodGet, "/", nil)
+    r.AddCookie(oldCookie)
+
+    var flash string
+    next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        flash = app.session.GetString(r, "flash")
+    })
+
+    app.session.Enable(app.rotateSessionKey(next)).ServeHTTP(rr, r)
+
+    // The old cookie is still accepted...
+    if flash != "Hello" {
+        t.Fatalf("want flash %q; got %q", "Hello", flash)
+    }
+
+    // ...and a replacement is issued, which can be read with only the new key.
+    cookies := rr.Result().Cookies()
+    if len(cookies) != 1 {
+        t.Fatalf("want a new session cookie; got %d cookies", len(cookies))
+    }
+
+    newSession := sessions.New(newKey)
+
+    r = httptest.NewRequest(http.MethodGet, "/", nil)
+    r.AddCookie(cookies[0])
+
+    newSession.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        flash = newSession.GetString(r, "flash")
+    })).ServeHTTP(httptest.NewRecorder(), r)
+
+    if flash != "Hello" {
+        t.Errorf("want flash %q from re-encrypted cookie; got %q", "Hello", flash)
+    }
+}
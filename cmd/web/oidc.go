@@ -0,0 +1,221 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+  "mateuszurbanski/snippetbox/pkg/oidc"
+)
+
+var (
+  errSSOUnverifiedEmail = errors.New("sso: email address not verified")
+  errSSONoAccount       = errors.New("sso: no account for email address")
+)
+
+// The oidcLogin handler starts a single sign-on login.
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+  if app.oidc == nil {
+    app.notFound(w)
+
+    return
+  }
+
+  app.redirectToProvider(w, r, "login")
+}
+
+// The redirectToProvider helper sends the user to the identity provider. We
+// remember a random state, nonce and PKCE verifier in the session, along with
+// the purpose of the round trip: "login", or "delete" when a user confirms
+// the deletion of their account.
+func (app *application) redirectToProvider(w http.ResponseWriter, r *http.Request, purpose string) {
+  var values [3]string
+
+  for i := range values {
+    v, err := oidc.RandomString()
+
+    if err != nil {
+      app.serverError(w, err)
+
+      return
+    }
+
+    values[i] = v
+  }
+
+  state, nonce, verifier := values[0], values[1], values[2]
+
+  app.session.Put(r, "oidcState", state)
+  app.session.Put(r, "oidcNonce", nonce)
+  app.session.Put(r, "oidcVerifier", verifier)
+  app.session.Put(r, "oidcPurpose", purpose)
+
+  http.Redirect(w, r, app.oidc.AuthCodeURL(state, nonce, verifier), http.StatusSeeOther)
+}
+
+// The oidcCallback handler is where the identity provider sends the user back
+// to once they have logged in. We exchange the authorization code for an ID
+// token, find (or create) the matching user and log them in.
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+  if app.oidc == nil {
+    app.notFound(w)
+
+    return
+  }
+
+  // Pop the values stored by oidcLogin, so that they can only be used once.
+  state := app.session.PopString(r, "oidcState")
+  nonce := app.session.PopString(r, "oidcNonce")
+  verifier := app.session.PopString(r, "oidcVerifier")
+  purpose := app.session.PopString(r, "oidcPurpose")
+
+  q := r.URL.Query()
+
+  if state == "" || q.Get("state") != state {
+    app.clientError(w, http.StatusBadRequest)
+
+    return
+  }
+
+  if e := q.Get("error"); e != "" {
+    app.audit(r, models.AuditLoginFailed, "oidc:"+e)
+    app.session.Put(r, "flash", "Single sign-on failed. Please try again.")
+    http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+    return
+  }
+
+  claims, err := app.oidc.Exchange(q.Get("code"), verifier, nonce)
+
+  if err != nil {
+    app.errorLog.Output(2, err.Error())
+    app.audit(r, models.AuditLoginFailed, "oidc:invalid_token")
+    app.session.Put(r, "flash", "Single sign-on failed. Please try again.")
+    http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+    return
+  }
+
+  if purpose == "delete" {
+    app.confirmDeletionSSO(w, r, claims)
+
+    return
+  }
+
+  user, err := app.oidcUser(r, claims)
+
+  if err != nil {
+    switch {
+    case errors.Is(err, errSSOUnverifiedEmail):
+      app.session.Put(r, "flash", "Your identity provider hasn't verified your email address.")
+    case errors.Is(err, errSSONoAccount):
+      app.session.Put(r, "flash", fmt.Sprintf("There's no account for %s. Please sign up first.", claims.Email))
+    default:
+      app.serverError(w, err)
+
+      return
+    }
+
+    app.audit(r, models.AuditLoginFailed, app.emailPseudonym(claims.Email))
+    http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+    return
+  }
+
+  // Deactivated users can't log in, however they authenticate.
+  if !user.Active {
+    app.auditAs(r, user.ID, models.AuditLoginFailed, fmt.Sprintf("user:%d", user.ID))
+    app.session.Put(r, "flash", "Your account has been deactivated.")
+    http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+    return
+  }
+
+  _, err = app.startSession(w, r, user.ID)
+
+  if err != nil {
+    app.serverError(w, err)
+
+    return
+  }
+
+  app.auditAs(r, user.ID, models.AuditLogin, fmt.Sprintf("user:%d", user.ID))
+
+  http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// The oidcUser helper returns the user for a verified ID token. Users who have
+// logged in with the provider before are found by their subject. Otherwise the
+// identity is linked to the account with the same (verified) email address,
+// which is created first if auto-provisioning is enabled.
+func (app *application) oidcUser(r *http.Request, claims *oidc.Claims) (*models.User, error) {
+  user, err := app.users.GetByIdentity(claims.Issuer, claims.Subject)
+  if err == nil {
+    return user, nil
+  } else if !errors.Is(err, models.ErrNoRecord) {
+    return nil, err
+  }
+
+  // Linking by email is only safe if the provider vouches for the address.
+  if claims.Email == "" || !claims.EmailVerified {
+    return nil, errSSOUnverifiedEmail
+  }
+
+  user, err = app.users.GetByEmail(claims.Email)
+  if errors.Is(err, models.ErrNoRecord) {
+    if !app.oidcProvision {
+      return nil, errSSONoAccount
+    }
+
+    user, err = provisionUser(app.users, claims.Name, claims.Email)
+    if err == nil {
+      app.auditAs(r, user.ID, models.AuditSignup, fmt.Sprintf("user:%d", user.ID))
+    }
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  err = app.users.LinkIdentity(user.ID, claims.Issuer, claims.Subject)
+  if err != nil {
+    return nil, err
+  }
+
+  app.auditAs(r, user.ID, models.AuditIdentityLink, fmt.Sprintf("user:%d", user.ID))
+
+  return user, nil
+}
+
+// The confirmDeletionSSO helper finishes confirming the deletion of an account
+// through the identity provider, for users who don't know their password
+// because their account was created by single sign-on. The identity the user
+// just logged in with has to be linked to the account being deleted.
+func (app *application) confirmDeletionSSO(w http.ResponseWriter, r *http.Request, claims *oidc.Claims) {
+  user := app.authenticatedUser(r)
+  keep := app.session.PopString(r, "deleteSnippets") == "keep"
+
+  if user == nil {
+    http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+    return
+  }
+
+  linked, err := app.users.GetByIdentity(claims.Issuer, claims.Subject)
+
+  if err != nil && !errors.Is(err, models.ErrNoRecord) {
+    app.serverError(w, err)
+
+    return
+  }
+
+  if err != nil || linked.ID != user.ID {
+    app.session.Put(r, "flash", "That single sign-on account isn't linked to yours, so your account hasn't been deleted.")
+    http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+
+    return
+  }
+
+  app.scheduleDeletion(w, r, user, keep)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "net/url"
+    "testing"
+    "time"
+
+    "mateuszurbanski/snippetbox/pkg/models"
+    "mateuszurbanski/snippetbox/pkg/oidc"
+    "mateuszurbanski/snippetbox/pkg/oidc/oidctest"
+)
+
+// Create a loginSSO method which goes through a complete single sign-on login
+// against the stand-in provider: start the login, let the provider redirect
+// back, and hand the callback to our application. It returns the status and
+// Location header of the callback response.
+func (ts *testServer) loginSSO(t *testing.T, mangleState bool) (int, string) {
+    code, header, _ := ts.get(t, "/user/login/oidc")
+    if code != http.StatusSeeOther {
+        t.Fatalf("start: want %d; got %d", http.StatusSeeOther, code)
+    }
+
+    return ts.followSSO(t, header.Get("Location"), mangleState)
+}
+
+// Create a followSSO method which follows a redirect to the stand-in provider
+// and hands its callback to our application. It returns the status and
+// Location header of the callback response.
+func (ts *testServer) followSSO(t *testing.T, location string, mangleState bool) (int, string) {
+    rs, err := ts.Client().Get(location)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rs.Body.Close()
+
+    if rs.StatusCode != http.StatusFound {
+        t.Fatalf("authorize: want %d; got %d", http.StatusFound, rs.StatusCode)
+    }
+
+    callback, err := url.Parse(rs.Header.Get("Location"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if mangleState {
+        q := callback.Query()
+        q.Set("state", "forged")
+        callback.RawQuery = q.Encode()
+    }
+
+    code, header, _ := ts.get(t, callback.RequestURI())
+
+    return code, header.Get("Location")
+}
+
+func TestOIDCLogin(t *testing.T) {
+    provider := oidctest.NewProvider("snippetbox", "s3cret")
+    defer provider.Close()
+
+    tests := []struct {
+        name         string
+        user         oidctest.User
+        provision    bool
+        mangleState  bool
+        wantCode     int
+        wantLocation string
+        wantFlash    []byte
+        wantAudit    string
+    }{
+        {"Existing user", oidctest.User{Subject: "1001", Email: "alice@example.com", EmailVerified: true}, false, false, http.StatusSeeOther, "/snippet/create", nil, models.AuditIdentityLink},
+        {"Unverified email", oidctest.User{Subject: "1002", Email: "alice@example.com"}, false, false, http.StatusSeeOther, "/user/login", []byte("hasn&#39;t verified your email"), models.AuditLoginFailed},
+        {"No account", oidctest.User{Subject: "1003", Email: "bob@example.com", EmailVerified: true}, false, false, http.StatusSeeOther, "/user/login", []byte("There&#39;s no account for bob@example.com"), models.AuditLoginFailed},
+        {"Auto-provision", oidctest.User{Subject: "1004", Email: "bob@example.com", EmailVerified: true, Name: "Bob"}, true, false, http.StatusSeeOther, "/snippet/create", nil, models.AuditSignup},
+        {"Forged state", oidctest.User{Subject: "1001", Email: "alice@example.com", EmailVerified: true}, false, true, http.StatusBadRequest, "", nil, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            var err error
+            app.oidc, err = oidc.NewProvider(oidc.Config{
+                Issuer:       provider.Issuer(),
+                ClientID:     provider.ClientID,
+                ClientSecret: provider.ClientSecret,
+                RedirectURL:  ts.URL + "/user/login/oidc/callback",
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            app.oidcProvision = tt.provision
+            provider.SetUser(tt.user)
+
+            code, location := ts.loginSSO(t, tt.mangleState)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if location != tt.wantLocation {
+                t.Errorf("want location %q; got %q", tt.wantLocation, location)
+            }
+
+            // Logged in users can see their sessions, everyone else is sent
+            // to the login page.
+            wantSessions := http.StatusSeeOther
+            if tt.wantLocation == "/snippet/create" {
+                wantSessions = http.StatusOK
+            }
+
+            code, _, _ = ts.get(t, "/user/sessions")
+            if code != wantSessions {
+                t.Errorf("sessions: want %d; got %d", wantSessions, code)
+            }
+
+            if tt.wantFlash != nil {
+                _, _, body := ts.get(t, "/user/login")
+                if !bytes.Contains(body, tt.wantFlash) {
+                    t.Errorf("want body to contain %q", tt.wantFlash)
+                }
+            }
+
+            if tt.wantAudit != "" {
+                events, _, _ := app.auditLog.List(models.AuditFilter{Action: tt.wantAudit}, 0, 10)
+                if len(events) == 0 {
+                    t.Errorf("want a %s audit event", tt.wantAudit)
+                }
+            }
+        })
+    }
+}
+
+func TestOIDCLinkedIdentity(t *testing.T) {
+    provider := oidctest.NewProvider("snippetbox", "s3cret")
+    defer provider.Close()
+
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    var err error
+    app.oidc, err = oidc.NewProvider(oidc.Config{
+        Issuer:       provider.Issuer(),
+        ClientID:     provider.ClientID,
+        ClientSecret: provider.ClientSecret,
+        RedirectURL:  ts.URL + "/user/login/oidc/callback",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // The first login links the identity to Alice's account by email.
+    provider.SetUser(oidctest.User{Subject: "1001", Email: "alice@example.com", EmailVerified: true})
+
+    if _, location := ts.loginSSO(t, false); location != "/snippet/create" {
+        t.Fatalf("first login: want redirect to /snippet/create; got %q", location)
+    }
+
+    // Once linked, the identity is recognised by its subject even if the
+    // email address at the provider changes.
+    provider.SetUser(oidctest.User{Subject: "1001", Email: "alice@new.example.com"})
+
+    if _, location := ts.loginSSO(t, false); location != "/snippet/create" {
+        t.Fatalf("second login: want redirect to /snippet/create; got %q", location)
+    }
+
+    user, err := app.users.GetByIdentity(provider.Issuer(), "1001")
+    if err != nil || user.ID != 1 {
+        t.Errorf("want identity linked to user 1; got %v, %v", user, err)
+    }
+}
+
+func TestOIDCConfirmDeletion(t *testing.T) {
+    provider := oidctest.NewProvider("snippetbox", "s3cret")
+    defer provider.Close()
+
+    tests := []struct {
+        name          string
+        subject       string
+        wantScheduled bool
+        wantFlash     []byte
+    }{
+        {"Linked identity", "1001", true, []byte("Your account will be deleted")},
+        {"Someone else", "2002", false, []byte("isn&#39;t linked to yours")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            app.deletionGrace = time.Hour
+
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            var err error
+            app.oidc, err = oidc.NewProvider(oidc.Config{
+                Issuer:       provider.Issuer(),
+                ClientID:     provider.ClientID,
+                ClientSecret: provider.ClientSecret,
+                RedirectURL:  ts.URL + "/user/login/oidc/callback",
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            app.users.LinkIdentity(1, provider.Issuer(), "1001")
+            provider.SetUser(oidctest.User{Subject: tt.subject, Email: "someone@example.com", EmailVerified: true})
+
+            ts.login(t, "alice@example.com")
+
+            // Users who signed up by single sign-on don't know their
+            // password, so they confirm with the provider instead.
+            _, _, body := ts.get(t, "/user/account")
+
+            form := url.Values{}
+            form.Add("snippets", "keep")
+            form.Add("confirm", "sso")
+            form.Add("csrf_token", extractCSRFToken(t, body))
+
+            code, header, _ := ts.postForm(t, "/user/delete", form)
+            if code != http.StatusSeeOther {
+                t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+            }
+
+            code, location := ts.followSSO(t, header.Get("Location"), false)
+            if code != http.StatusSeeOther || location != "/user/account" {
+                t.Fatalf("callback: want redirect to /user/account; got %d %q", code, location)
+            }
+
+            user, err := app.users.Get(1)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if user.DeletionScheduled() != tt.wantScheduled {
+                t.Errorf("want deletion scheduled %t; got %t", tt.wantScheduled, user.DeletionScheduled())
+            }
+
+            if tt.wantScheduled && !user.KeepSnippets {
+                t.Error("want the choice to keep snippets to survive the round trip")
+            }
+
+            _, _, body = ts.get(t, "/user/account")
+            if !bytes.Contains(body, tt.wantFlash) {
+                t.Errorf("want body to contain %q", tt.wantFlash)
+            }
+        })
+    }
+}
+
+func TestOIDCDisabled(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    code, _, _ := ts.get(t, "/user/login/oidc")
+    if code != http.StatusNotFound {
+        t.Errorf("want %d; got %d", http.StatusNotFound, code)
+    }
+
+    _, _, body := ts.get(t, "/user/login")
+    if bytes.Contains(body, []byte("single sign-on")) {
+        t.Error("want no single sign-on link when it isn't configured")
+    }
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "mime"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/highlight"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The pasteResponse type is the JSON body returned when a snippet is created
+// through the API, by /paste or /api/snippets. Snippets are only ever named
+// by their slug, never their numeric ID, which would let people count
+// through them.
+type pasteResponse struct {
+  Slug   string `json:"slug"`
+  URL    string `json:"url"`
+  RawURL string `json:"raw_url"`
+}
+
+// The isTooLarge function returns true if err came from reading more than a
+// http.MaxBytesReader allows.
+func isTooLarge(err error) bool {
+  return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// The pasteBody helper reads the snippet content and parameters from a paste
+// request. The content can be sent as the "content" part of a multipart form
+// (as a file or a plain value), as the "content" field of a URL-encoded form,
+// or as the whole request body. The other parameters come from the form, if
+// there is one, or else the query string. A file upload's name is used as the
+// filename unless one is given.
+func (app *application) pasteBody(r *http.Request) (url.Values, error) {
+  params := r.URL.Query()
+
+  mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+  if mediaType == "multipart/form-data" {
+    err := r.ParseMultipartForm(app.maxPasteSize)
+    if err != nil {
+      return nil, err
+    }
+
+    for key, values := range r.MultipartForm.Value {
+      params[key] = values
+    }
+
+    file, header, err := r.FormFile("content")
+    if err == http.ErrMissingFile {
+      return params, nil
+    } else if err != nil {
+      return nil, err
+    }
+    defer file.Close()
+
+    content, err := io.ReadAll(file)
+    if err != nil {
+      return nil, err
+    }
+
+    params.Set("content", string(content))
+
+    if params.Get("filename") == "" && header.Filename != "-" {
+      params.Set("filename", header.Filename)
+    }
+
+    return params, nil
+  }
+
+  body, err := io.ReadAll(r.Body)
+  if err != nil {
+    return nil, err
+  }
+
+  // Tools like curl --data-binary label any body as a URL-encoded form, so
+  // it is only treated as one if it has a content field.
+  if mediaType == "application/x-www-form-urlencoded" {
+    values, err := url.ParseQuery(string(body))
+    if err == nil && values.Get("content") != "" {
+      for key, v := range values {
+        params[key] = v
+      }
+
+      return params, nil
+    }
+  }
+
+  params.Set("content", string(body))
+
+  return params, nil
+}
+
+// The paste handler creates a snippet for an API client, authenticated with a
+// token instead of a session. It's meant for scripts and the command line:
+//
+//   curl -H "Authorization: Bearer $TOKEN" --data-binary @main.go \
+//     "https://snippetbox.example.com/paste?title=Hello&language=go"
+//
+// The title defaults to the filename, or "Untitled", and the expiry and
+// visibility to a year and public. The expires parameter takes a duration,
+// date or "never", as parseExpiry describes, and burn=true makes a snippet
+// which is deleted once it's read. The response is the snippet's URL as
+// plain text, or JSON if the client accepts it.
+func (app *application) paste(w http.ResponseWriter, r *http.Request) {
+  r.Body = http.MaxBytesReader(w, r.Body, app.maxPasteSize)
+
+  params, err := app.pasteBody(r)
+
+  if err != nil {
+    if isTooLarge(err) {
+      app.apiError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("The snippet is too large (the limit is %d bytes).", app.maxPasteSize))
+    } else {
+      app.apiError(w, r, http.StatusBadRequest, "The request body couldn't be read.")
+    }
+
+    return
+  }
+
+  f := &models.SnippetFile{
+    Filename: strings.TrimSpace(params.Get("filename")),
+    Language: params.Get("language"),
+    Content:  params.Get("content"),
+  }
+
+  for key, value := range map[string]string{"expires": "365", "visibility": models.VisibilityPublic, "format": models.FormatPlain} {
+    if params.Get(key) == "" {
+      params.Set(key, value)
+    }
+  }
+
+  if params.Get("title") == "" {
+    params.Set("title", "Untitled")
+    if f.Filename != "" {
+      params.Set("title", f.Filename)
+    }
+  }
+
+  form := forms.New(params)
+  form.MaxLength("title", 100)
+  form.PermittedValues("visibility", models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate)
+  form.PermittedValues("format", models.FormatPlain, models.FormatMarkdown)
+
+  expires, burn := formExpiry(form, time.Now())
+
+  if strings.TrimSpace(f.Content) == "" {
+    form.Errors.Add("content", "This field cannot be blank")
+  }
+
+  if f.Filename != "" && (!filenameRX.MatchString(f.Filename) || f.Filename == "." || f.Filename == "..") {
+    form.Errors.Add("filename", "This name is invalid (use letters, digits, dots, dashes and underscores)")
+  }
+
+  // The API accepts the same aliases for languages as Markdown code blocks.
+  if f.Language != "" {
+    f.Language = highlight.Resolve(f.Language)
+    if f.Language == "" {
+      form.Errors.Add("language", "This field is invalid")
+    }
+  }
+
+  if !form.Valid() {
+    app.apiValidationError(w, r, form.Errors)
+    return
+  }
+
+  files := []*models.SnippetFile{f}
+  detectLanguages(files, form.Get("format"))
+
+  slug, err := newSlug()
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  id, err := app.snippets.Insert(app.authenticatedUser(r).ID, slug, form.Get("title"), expires, form.Get("visibility"), form.Get("format"), burn, files)
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  app.audit(r, models.AuditSnippetCreate, fmt.Sprintf("snippet:%d", id))
+
+  app.respondCreated(w, r, &models.Snippet{ID: id, Slug: slug})
+}
+
+// The respondCreated helper sends the response to a request which created a
+// snippet: a 201 Created with its URL.
+func (app *application) respondCreated(w http.ResponseWriter, r *http.Request, s *models.Snippet) {
+  base := requestScheme(r) + "://" + r.Host
+
+  w.Header().Set("Location", s.URL())
+  app.apiRespond(w, r, http.StatusCreated, &pasteResponse{
+    Slug:   s.Slug,
+    URL:    base + s.URL(),
+    RawURL: base + s.URL() + "/raw",
+  }, base+s.URL())
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "mime/multipart"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    "mateuszurbanski/snippetbox/pkg/models/mock"
+)
+
+// The sendPaste helper sends a request to the /paste endpoint with the given
+// token, Content-Type and Accept headers.
+func (ts *testServer) sendPaste(t *testing.T, query, token, contentType, accept string, body io.Reader) (int, http.Header, []byte) {
+    req, err := http.NewRequest(http.MethodPost, ts.URL+"/paste"+query, body)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+
+    if accept != "" {
+        req.Header.Set("Accept", accept)
+    }
+
+    rs, err := ts.Client().Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rs.Body.Close()
+
+    b, err := io.ReadAll(rs.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return rs.StatusCode, rs.Header, b
+}
+
+func TestPaste(t *testing.T) {
+    tests := []struct {
+        name        string
+        query       string
+        token       string
+        contentType string
+        accept      string
+        body        string
+        wantCode    int
+        wantBody    string
+    }{
+        {"Raw body", "", mock.MockAPIToken, "text/plain", "", "An old silent pond...", http.StatusCreated, "/s/"},
+        {"With parameters", "?title=Hello&language=golang&expires=7&visibility=unlisted", mock.MockAPIToken, "", "", "package main", http.StatusCreated, "/s/"},
+        {"curl --data-binary", "", mock.MockAPIToken, "application/x-www-form-urlencoded", "", "a = b & c", http.StatusCreated, "/s/"},
+        {"Form", "", mock.MockAPIToken, "application/x-www-form-urlencoded", "", "title=Hello&content=An+old+silent+pond", http.StatusCreated, "/s/"},
+        {"JSON", "", mock.MockAPIToken, "text/plain", "application/json", "An old silent pond...", http.StatusCreated, `"raw_url":"https://`},
+        {"No token", "", "", "text/plain", "", "An old silent pond...", http.StatusUnauthorized, "An API token is required"},
+        {"Invalid token", "", "sbx_wrong", "text/plain", "application/json", "An old silent pond...", http.StatusUnauthorized, `{"error":"The API token is invalid`},
+        {"Empty body", "", mock.MockAPIToken, "text/plain", "", "  ", http.StatusUnprocessableEntity, "content: This field cannot be blank"},
+        {"Unknown language", "?language=cobol", mock.MockAPIToken, "text/plain", "", "DISPLAY 'HI'.", http.StatusUnprocessableEntity, "language: This field is invalid"},
+        {"Invalid expiry", "?expires=soon", mock.MockAPIToken, "text/plain", "", "An old silent pond...", http.StatusUnprocessableEntity, "expires: This field is invalid"},
+        {"Invalid filename", "?filename=../main.go", mock.MockAPIToken, "text/plain", "", "package main", http.StatusUnprocessableEntity, "filename: This name is invalid"},
+        {"Too large", "", mock.MockAPIToken, "text/plain", "", strings.Repeat("a", 2048), http.StatusRequestEntityTooLarge, "the limit is 1024 bytes"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            code, header, body := ts.sendPaste(t, tt.query, tt.token, tt.contentType, tt.accept, strings.NewReader(tt.body))
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, []byte(tt.wantBody)) {
+                t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+            }
+
+            if code == http.StatusUnauthorized && header.Get("WWW-Authenticate") == "" {
+                t.Error("want a WWW-Authenticate header")
+            }
+
+            if code == http.StatusCreated && !strings.HasPrefix(header.Get("Location"), "/s/") {
+                t.Errorf("want a Location header; got %q", header.Get("Location"))
+            }
+
+            // Snippets are only named by their slug, never their numeric ID.
+            if bytes.Contains(body, []byte(`"id"`)) {
+                t.Errorf("want no numeric ID in %q", body)
+            }
+        })
+    }
+}
+
+func TestPasteTokenGuessing(t *testing.T) {
+    app := newTestApplication(t)
+
+    // Allow two requests per minute, and freeze the clock so that no tokens
+    // are refilled during the test.
+    app.limiters.api = newRateLimiter(2, time.Minute)
+    now := time.Now()
+    app.limiters.api.now = func() time.Time { return now }
+
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    tests := []struct {
+        name     string
+        token    string
+        wantCode int
+    }{
+        {"First guess", "sbx_guess1", http.StatusUnauthorized},
+        {"Second guess", "sbx_guess2", http.StatusUnauthorized},
+        {"Third guess", "sbx_guess3", http.StatusTooManyRequests},
+        {"Valid token from the same address", mock.MockAPIToken, http.StatusTooManyRequests},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            code, header, _ := ts.sendPaste(t, "", tt.token, "text/plain", "", strings.NewReader("An old silent pond..."))
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if code == http.StatusTooManyRequests && header.Get("Retry-After") == "" {
+                t.Error("want a Retry-After header")
+            }
+        })
+    }
+}
+
+func TestPasteMultipart(t *testing.T) {
+    tests := []struct {
+        name     string
+        filename string
+        fields   url.Values
+        wantCode int
+    }{
+        {"File", "main.go", nil, http.StatusCreated},
+        {"Stdin", "-", url.Values{"title": {"From stdin"}}, http.StatusCreated},
+        {"Invalid name", "my main.go", nil, http.StatusUnprocessableEntity},
+        {"Content field", "", url.Values{"content": {"An old silent pond..."}}, http.StatusCreated},
+        {"Nothing", "", nil, http.StatusUnprocessableEntity},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            var buf bytes.Buffer
+            mw := multipart.NewWriter(&buf)
+
+            for key, values := range tt.fields {
+                for _, v := range values {
+                    mw.WriteField(key, v)
+                }
+            }
+
+            if tt.filename != "" {
+                fw, err := mw.CreateFormFile("content", tt.filename)
+                if err != nil {
+                    t.Fatal(err)
+                }
+
+                fw.Write([]byte("package main\n"))
+            }
+
+            mw.Close()
+
+            code, _, body := ts.sendPaste(t, "", mock.MockAPIToken, mw.FormDataContentType(), "", &buf)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d: %s", tt.wantCode, code, body)
+            }
+        })
+    }
+}
+
+func TestAPITokens(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    ts.login(t, "alice@example.com")
+
+    code, _, body := ts.get(t, "/user/tokens")
+
+    if code != http.StatusOK {
+        t.Fatalf("want %d; got %d", http.StatusOK, code)
+    }
+
+    if !bytes.Contains(body, []byte("<td>Laptop</td>")) {
+        t.Error("want the token list to contain Laptop")
+    }
+
+    csrfToken := extractCSRFToken(t, body)
+
+    tests := []struct {
+        name      string
+        urlPath   string
+        tokenName string
+        wantCode  int
+        wantBody  []byte
+    }{
+        {"Create", "/user/tokens", "Desktop", http.StatusOK, []byte("Your new token is <code>sbx_")},
+        {"Create without a name", "/user/tokens", "", http.StatusOK, []byte("This field cannot be blank")},
+        {"Revoke", "/user/tokens/1/revoke", "", http.StatusSeeOther, nil},
+        {"Revoke someone else's", "/user/tokens/9/revoke", "", http.StatusNotFound, nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            form := url.Values{}
+            form.Add("csrf_token", csrfToken)
+            form.Add("name", tt.tokenName)
+
+            code, _, body := ts.postForm(t, tt.urlPath, form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+        })
+    }
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+  "context"
+  "net"
+  "net/http"
+  "net/url"
+  "strings"
+)
+
+// A proxyHop is one step in the chain of proxies a request came through: the
+// address the proxy received the request from, and the scheme it was received
+// over (if the proxy told us).
+type proxyHop struct {
+  ip     string
+  scheme string
+}
+
+// The proxyHeaders middleware works out the real client IP address and scheme
+// of the request, and stores them in the request context. Requests which come
+// straight from the client are taken at face value. If the request came through
+// one of our trusted proxies (e.g. the Heroku router), we read the standard
+// Forwarded header, or X-Forwarded-For and X-Forwarded-Proto if there isn't
+// one, instead. A trusted proxy which received the request over plain HTTP is
+// terminating TLS for us, so the client is redirected to HTTPS.
+func (app *application) proxyHeaders(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    ip, scheme, forwarded := app.resolveClient(r)
+
+    if forwarded && scheme == "http" {
+      target := url.URL{Scheme: "https", Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+
+      code := http.StatusMovedPermanently
+      if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        code = http.StatusPermanentRedirect
+      }
+
+      http.Redirect(w, r, target.String(), code)
+      return
+    }
+
+    ctx := context.WithValue(r.Context(), contextKeyClientIP, ip)
+    ctx = context.WithValue(ctx, contextKeyScheme, scheme)
+
+    next.ServeHTTP(w, r.WithContext(ctx))
+  })
+}
+
+// The resolveClient method returns the IP address and scheme of the client
+// which made the request, and whether the scheme was given by a trusted proxy.
+// We walk the chain of proxies from right to left, starting with the
+// connection itself, and stop at the first address that isn't a trusted proxy.
+// Anything to the left of that is supplied by the client and can't be trusted.
+func (app *application) resolveClient(r *http.Request) (string, string, bool) {
+  ip, scheme, forwarded := remoteIP(r), "http", false
+  if r.TLS != nil {
+    scheme = "https"
+  }
+
+  if !app.isTrustedProxy(ip) {
+    return ip, scheme, false
+  }
+
+  var hops []proxyHop
+
+  if values := r.Header.Values("Forwarded"); len(values) > 0 {
+    hops = parseForwarded(values)
+  } else {
+    hops = parseXForwarded(r.Header.Values("X-Forwarded-For"), r.Header.Values("X-Forwarded-Proto"))
+  }
+
+  for i := len(hops) - 1; i >= 0; i-- {
+    hop := hops[i]
+    if net.ParseIP(hop.ip) == nil {
+      break
+    }
+
+    ip = hop.ip
+
+    if hop.scheme == "http" || hop.scheme == "https" {
+      scheme, forwarded = hop.scheme, true
+    }
+
+    if !app.isTrustedProxy(hop.ip) {
+      break
+    }
+  }
+
+  return ip, scheme, forwarded
+}
+
+// The parseForwarded function parses the Forwarded header defined by RFC 7239,
+// e.g. `for=192.0.2.60;proto=https, for="[2001:db8::17]:4711"`. It returns
+// one hop for each element, in order. Nodes which are hidden or obfuscated
+// ("unknown" or "_abc") are returned as they are, and stop the walk in
+// resolveClient.
+func parseForwarded(values []string) []proxyHop {
+  var hops []proxyHop
+
+  for _, element := range splitQuoted(strings.Join(values, ","), ',') {
+    var hop proxyHop
+
+    for _, pair := range splitQuoted(element, ';') {
+      parts := strings.SplitN(pair, "=", 2)
+      if len(parts) != 2 {
+        continue
+      }
+
+      value := strings.TrimSpace(parts[1])
+      if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+        value = strings.ReplaceAll(value[1:len(value)-1], `\`, "")
+      }
+
+      switch strings.ToLower(strings.TrimSpace(parts[0])) {
+      case "for":
+        hop.ip = forwardedNode(value)
+      case "proto":
+        hop.scheme = strings.ToLower(value)
+      }
+    }
+
+    hops = append(hops, hop)
+  }
+
+  return hops
+}
+
+// The forwardedNode function strips the port (and brackets around an IPv6
+// address) from a node in the Forwarded header.
+func forwardedNode(node string) string {
+  if host, _, err := net.SplitHostPort(node); err == nil {
+    return host
+  }
+
+  return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
+
+// The splitQuoted function splits s at every sep which isn't inside a quoted
+// string.
+func splitQuoted(s string, sep byte) []string {
+  var (
+    fields []string
+    quoted bool
+    start  int
+  )
+
+  for i := 0; i < len(s); i++ {
+    switch {
+    case s[i] == '\\' && quoted:
+      i++
+    case s[i] == '"':
+      quoted = !quoted
+    case s[i] == sep && !quoted:
+      fields = append(fields, strings.TrimSpace(s[start:i]))
+      start = i + 1
+    }
+  }
+
+  return append(fields, strings.TrimSpace(s[start:]))
+}
+
+// The parseXForwarded function turns the X-Forwarded-For and X-Forwarded-Proto
+// headers into hops. Proxies usually only send a single X-Forwarded-Proto, for
+// the connection they received, so the schemes are lined up with the
+// addresses from the right.
+func parseXForwarded(forValues, protoValues []string) []proxyHop {
+  if len(forValues) == 0 {
+    return nil
+  }
+
+  addrs := strings.Split(strings.Join(forValues, ","), ",")
+
+  var protos []string
+  if len(protoValues) > 0 {
+    protos = strings.Split(strings.Join(protoValues, ","), ",")
+  }
+
+  hops := make([]proxyHop, len(addrs))
+
+  for i := range addrs {
+    hops[i].ip = forwardedNode(strings.TrimSpace(addrs[i]))
+
+    if j := len(protos) - len(addrs) + i; j >= 0 {
+      hops[i].scheme = strings.ToLower(strings.TrimSpace(protos[j]))
+    }
+  }
+
+  return hops
+}
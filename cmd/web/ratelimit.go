@@ -0,0 +1,214 @@
+package main
+
+import (
+  "fmt"
+  "math"
+  "net"
+  "net/http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// A bucket holds the state of a single token bucket: how many tokens are
+// currently available and when it was last refilled.
+type bucket struct {
+  tokens float64
+  last   time.Time
+}
+
+// A rateLimiter is a collection of token buckets, one per key (an IP address or
+// an authenticated user). Every bucket holds at most burst tokens and is refilled
+// at rate tokens per second. Each request takes one token; when the bucket is
+// empty the request is rejected.
+type rateLimiter struct {
+  mu      sync.Mutex
+  rate    float64
+  burst   float64
+  buckets map[string]*bucket
+  now     func() time.Time
+}
+
+// Define a newRateLimiter function which returns a rateLimiter allowing burst
+// requests per interval for every key.
+func newRateLimiter(burst int, interval time.Duration) *rateLimiter {
+  return &rateLimiter{
+    rate:    float64(burst) / interval.Seconds(),
+    burst:   float64(burst),
+    buckets: map[string]*bucket{},
+    now:     time.Now,
+  }
+}
+
+// The allow method takes a token from the bucket for the given key. If no token
+// is available it returns false along with the time the client should wait
+// before the next token becomes available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  b := l.refill(key)
+
+  if b.tokens < 1 {
+    return false, l.wait(b)
+  }
+
+  b.tokens--
+
+  return true, 0
+}
+
+// The blocked method reports whether the bucket for the given key is empty,
+// without taking a token from it, along with the time the client should wait.
+func (l *rateLimiter) blocked(key string) (bool, time.Duration) {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  b := l.refill(key)
+
+  if b.tokens < 1 {
+    return true, l.wait(b)
+  }
+
+  return false, 0
+}
+
+// The refill method returns the bucket for the given key, refilled with the
+// tokens earned since it was last used, never going above the burst size. The
+// caller must hold the mutex.
+func (l *rateLimiter) refill(key string) *bucket {
+  now := l.now()
+
+  b, ok := l.buckets[key]
+  if !ok {
+    b = &bucket{tokens: l.burst, last: now}
+    l.buckets[key] = b
+  }
+
+  b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+  b.last = now
+
+  return b
+}
+
+// The wait method returns how long until an empty bucket has a token again.
+func (l *rateLimiter) wait(b *bucket) time.Duration {
+  return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// The cleanup method removes buckets which have been idle long enough to be
+// full again. Forgetting them is equivalent to keeping them, and stops the map
+// from growing without bound.
+func (l *rateLimiter) cleanup() {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  full := time.Duration(l.burst / l.rate * float64(time.Second))
+
+  for key, b := range l.buckets {
+    if l.now().Sub(b.last) > full {
+      delete(l.buckets, key)
+    }
+  }
+}
+
+// Define a rateLimiters type to hold a limiter for each group of routes that
+// we want to protect.
+type rateLimiters struct {
+  signup  *rateLimiter
+  login   *rateLimiter
+  snippet *rateLimiter
+  api     *rateLimiter
+}
+
+// The each method calls fn for every configured limiter in the group.
+func (rl *rateLimiters) each(fn func(*rateLimiter)) {
+  for _, l := range []*rateLimiter{rl.signup, rl.login, rl.snippet, rl.api} {
+    if l != nil {
+      fn(l)
+    }
+  }
+}
+
+// The parseRateLimit function parses a rate limit given on the command line in
+// the form "<requests>/<interval>", for example "10/1m" or "5/1h". An empty
+// string or "0" disables the limit and returns a nil limiter.
+func parseRateLimit(s string) (*rateLimiter, error) {
+  if s == "" || s == "0" {
+    return nil, nil
+  }
+
+  parts := strings.SplitN(s, "/", 2)
+  if len(parts) != 2 {
+    return nil, fmt.Errorf("invalid rate limit %q: want <requests>/<interval>", s)
+  }
+
+  burst, err := strconv.Atoi(parts[0])
+  if err != nil || burst < 1 {
+    return nil, fmt.Errorf("invalid rate limit %q: requests must be a positive integer", s)
+  }
+
+  interval, err := time.ParseDuration(parts[1])
+  if err != nil || interval <= 0 {
+    return nil, fmt.Errorf("invalid rate limit %q: interval must be a positive duration", s)
+  }
+
+  return newRateLimiter(burst, interval), nil
+}
+
+// The parseCIDRs function parses a comma-separated list of CIDR ranges, such
+// as the ones given by the -trusted-proxies flag. Bare IP addresses are
+// accepted too and treated as a single-host range.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+  var nets []*net.IPNet
+
+  for _, field := range strings.Split(s, ",") {
+    field = strings.TrimSpace(field)
+    if field == "" {
+      continue
+    }
+
+    if !strings.Contains(field, "/") {
+      ip := net.ParseIP(field)
+      if ip == nil {
+        return nil, fmt.Errorf("invalid proxy address %q", field)
+      }
+
+      bits := 8 * net.IPv6len
+      if ip.To4() != nil {
+        ip, bits = ip.To4(), 8*net.IPv4len
+      }
+
+      nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+      continue
+    }
+
+    _, n, err := net.ParseCIDR(field)
+    if err != nil {
+      return nil, err
+    }
+
+    nets = append(nets, n)
+  }
+
+  return nets, nil
+}
+
+// The retryAfter function formats a wait duration as the whole number of
+// seconds expected by the Retry-After header, rounding up so that clients
+// never retry too early.
+func retryAfter(d time.Duration) string {
+  return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// The rateLimitKey helper returns the key used to look up the token bucket for
+// the current request. Authenticated users get a bucket of their own,
+// everyone else is limited by their IP address.
+func (app *application) rateLimitKey(r *http.Request) string {
+  if user := app.authenticatedUser(r); user != nil {
+    return fmt.Sprintf("user:%d", user.ID)
+  }
+
+  return "ip:" + app.clientIP(r)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+  "archive/zip"
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "mime"
+  "net/http"
+  "strconv"
+  "strings"
+
+  "mateuszurbanski/snippetbox/pkg/highlight"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The rawSnippet handler sends a snippet's content as plain text, for curl
+// and other tools. The files of a multi-file snippet follow each other, each
+// under a "==> name <==" line, as head and tail print several files.
+func (app *application) rawSnippet(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.snippetBySlug(w, r)
+  if !ok {
+    return
+  }
+
+  files := s.AllFiles()
+
+  if len(files) == 1 {
+    serveSnippetContent(w, r, s, "text/plain; charset=utf-8", []byte(files[0].Content))
+    return
+  }
+
+  var b bytes.Buffer
+
+  for i, f := range files {
+    if i > 0 {
+      b.WriteString("\n")
+    }
+
+    fmt.Fprintf(&b, "==> %s <==\n", f.Filename)
+    b.WriteString(f.Content)
+
+    if !strings.HasSuffix(f.Content, "\n") {
+      b.WriteString("\n")
+    }
+  }
+
+  serveSnippetContent(w, r, s, "text/plain; charset=utf-8", b.Bytes())
+}
+
+// The rawFile handler sends one file of a snippet, numbered from 1, as plain
+// text. A snippet to be burnt after reading is only burnt once we know the
+// file exists. If it has several files, reading one would burn the others
+// without anybody seeing them, so it has to be read as a whole instead.
+func (app *application) rawFile(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.findSnippet(w, r)
+  if !ok {
+    return
+  }
+
+  files := s.AllFiles()
+
+  n, err := strconv.Atoi(r.URL.Query().Get(":n"))
+
+  if err != nil || n < 1 || n > len(files) {
+    app.notFound(w)
+    return
+  }
+
+  if len(files) > 1 && s.BurnsFor(app.authenticatedUser(r)) {
+    app.notFound(w)
+    return
+  }
+
+  err = app.burnOnRead(r, s)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  serveSnippetContent(w, r, s, "text/plain; charset=utf-8", []byte(files[n-1].Content))
+}
+
+// The downloadSnippet handler sends a snippet as a file to save. A single
+// file keeps its name, or is named after the snippet's slug with the usual
+// extension for its language. Multi-file snippets come as a ZIP archive.
+func (app *application) downloadSnippet(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.snippetBySlug(w, r)
+  if !ok {
+    return
+  }
+
+  files := s.AllFiles()
+
+  if len(files) == 1 {
+    f := files[0]
+
+    name := f.Filename
+    if name == "" {
+      name = s.Slug + highlight.Extension(f.Language)
+
+      if f.IsMarkdown(s.Format) {
+        name = s.Slug + ".md"
+      }
+    }
+
+    w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+    serveSnippetContent(w, r, s, "text/plain; charset=utf-8", []byte(f.Content))
+
+    return
+  }
+
+  var b bytes.Buffer
+
+  zw := zip.NewWriter(&b)
+
+  for _, f := range files {
+    // Giving every entry the snippet's modification time keeps the archive
+    // the same from one download to the next, and so its ETag.
+    fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Filename, Method: zip.Deflate, Modified: s.Updated})
+    if err != nil {
+      app.serverError(w, err)
+      return
+    }
+
+    if _, err = fw.Write([]byte(f.Content)); err != nil {
+      app.serverError(w, err)
+      return
+    }
+  }
+
+  if err := zw.Close(); err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": s.Slug + ".zip"}))
+  serveSnippetContent(w, r, s, "application/zip", b.Bytes())
+}
+
+// The serveSnippetContent function sends the content of a snippet with
+// caching headers. The ETag is a hash of the content and Last-Modified is
+// when the snippet was last edited, so http.ServeContent can answer
+// conditional requests with 304 Not Modified (and range requests too).
+// Caches have to check back every time, since the snippet may have been
+// edited, deleted or expired; private snippets are kept out of shared
+// caches altogether.
+//
+// Snippets burnt after reading are kept out of every cache, and always sent
+// in full. They may already have been burnt by this request, so a 304 or a
+// part of the content would be all anybody ever got to see.
+func serveSnippetContent(w http.ResponseWriter, r *http.Request, s *models.Snippet, contentType string, content []byte) {
+  w.Header().Set("Content-Type", contentType)
+  w.Header().Set("X-Content-Type-Options", "nosniff")
+
+  if s.BurnAfterReading {
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+    w.Write(content)
+
+    return
+  }
+
+  sum := sha256.Sum256(content)
+
+  w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+
+  switch {
+  case s.Visibility == models.VisibilityPrivate:
+    w.Header().Set("Cache-Control", "private, no-cache")
+  default:
+    w.Header().Set("Cache-Control", "no-cache")
+  }
+
+  http.ServeContent(w, r, "", s.Updated, bytes.NewReader(content))
+}
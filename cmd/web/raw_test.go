@@ -0,0 +1,254 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "fmt"
+    "net/http"
+    "testing"
+)
+
+func TestRawSnippet(t *testing.T) {
+    tests := []struct {
+        name     string
+        email    string
+        urlPath  string
+        wantCode int
+        wantBody []byte
+    }{
+        {"By slug", "", "/s/pondXq7kLm2vRt9w/raw", http.StatusOK, []byte("An old silent pond...")},
+        {"By ID", "mallory@example.com", "/snippet/1/raw", http.StatusNotFound, nil},
+        {"Unlisted, by slug", "", "/s/haikuJ6dF0gHp3uT/raw", http.StatusOK, []byte("Over the wintry forest...")},
+        {"Private, anonymous", "", "/s/noteB4nW8cYe1sZa/raw", http.StatusNotFound, nil},
+        {"Private, owner", "alice@example.com", "/s/noteB4nW8cYe1sZa/raw", http.StatusOK, []byte("For my eyes only...")},
+        {"Private, someone else", "mallory@example.com", "/s/noteB4nW8cYe1sZa/raw", http.StatusNotFound, nil},
+        {
+            "Several files",
+            "",
+            "/s/gistM8nB2vC4xZ6q/raw",
+            http.StatusOK,
+            []byte("==> main.go <==\npackage main\n\nfunc main() {}\n\n==> go.mod <==\nmodule hello\n\ngo 1.16\n\n==> README.md <==\n# Hello\n\nRun it with *go run*.\n"),
+        },
+        {"Non-existent slug", "", "/s/missingAAAAAAAAA/raw", http.StatusNotFound, nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            if tt.email != "" {
+                ts.login(t, tt.email)
+            }
+
+            code, header, body := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Fatalf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if code != http.StatusOK {
+                return
+            }
+
+            if !bytes.Equal(body, tt.wantBody) {
+                t.Errorf("want body %q; got %q", tt.wantBody, body)
+            }
+
+            for name, want := range map[string]string{
+                "Content-Type":           "text/plain; charset=utf-8",
+                "X-Content-Type-Options": "nosniff",
+            } {
+                if got := header.Get(name); got != want {
+                    t.Errorf("want %s %q; got %q", name, want, got)
+                }
+            }
+        })
+    }
+}
+
+func TestRawFile(t *testing.T) {
+    tests := []struct {
+        name     string
+        email    string
+        urlPath  string
+        wantCode int
+        wantBody []byte
+    }{
+        {"First file", "", "/s/gistM8nB2vC4xZ6q/raw/1", http.StatusOK, []byte("package main\n\nfunc main() {}\n")},
+        {"Last file", "", "/s/gistM8nB2vC4xZ6q/raw/3", http.StatusOK, []byte("# Hello\n\nRun it with *go run*.\n")},
+        {"Single-file snippet", "", "/s/pondXq7kLm2vRt9w/raw/1", http.StatusOK, []byte("An old silent pond...")},
+        {"Past the last file", "", "/s/gistM8nB2vC4xZ6q/raw/4", http.StatusNotFound, nil},
+        {"Zero", "", "/s/gistM8nB2vC4xZ6q/raw/0", http.StatusNotFound, nil},
+        {"Not a number", "", "/s/gistM8nB2vC4xZ6q/raw/main.go", http.StatusNotFound, nil},
+        {"Private, anonymous", "", "/s/noteB4nW8cYe1sZa/raw/1", http.StatusNotFound, nil},
+        {"Private, owner", "alice@example.com", "/s/noteB4nW8cYe1sZa/raw/1", http.StatusOK, []byte("For my eyes only...")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            if tt.email != "" {
+                ts.login(t, tt.email)
+            }
+
+            code, header, body := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Fatalf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if code != http.StatusOK {
+                return
+            }
+
+            if !bytes.Equal(body, tt.wantBody) {
+                t.Errorf("want body %q; got %q", tt.wantBody, body)
+            }
+
+            if ct := header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+                t.Errorf("want plain text; got %q", ct)
+            }
+
+            if nosniff := header.Get("X-Content-Type-Options"); nosniff != "nosniff" {
+                t.Errorf("want nosniff; got %q", nosniff)
+            }
+        })
+    }
+}
+
+func TestRawSnippetCaching(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    _, header, _ := ts.get(t, "/s/pondXq7kLm2vRt9w/raw")
+
+    etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+
+    if etag == "" || lastModified == "" {
+        t.Fatalf("want ETag and Last-Modified; got %q and %q", etag, lastModified)
+    }
+
+    if cc := header.Get("Cache-Control"); cc != "no-cache" {
+        t.Errorf("want Cache-Control no-cache; got %q", cc)
+    }
+
+    tests := []struct {
+        name     string
+        header   string
+        value    string
+        wantCode int
+    }{
+        {"Same ETag", "If-None-Match", etag, http.StatusNotModified},
+        {"Other ETag", "If-None-Match", `"0123456789abcdef"`, http.StatusOK},
+        {"Not modified since", "If-Modified-Since", lastModified, http.StatusNotModified},
+        {"Modified since", "If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req, err := http.NewRequest(http.MethodGet, ts.URL+"/s/pondXq7kLm2vRt9w/raw", nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            req.Header.Set(tt.header, tt.value)
+
+            rs, err := ts.Client().Do(req)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            rs.Body.Close()
+
+            if rs.StatusCode != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+            }
+        })
+    }
+
+    // Private snippets stay out of shared caches.
+    ts.login(t, "alice@example.com")
+
+    _, header, _ = ts.get(t, "/s/noteB4nW8cYe1sZa/raw")
+
+    if cc := header.Get("Cache-Control"); cc != "private, no-cache" {
+        t.Errorf("want Cache-Control private, no-cache; got %q", cc)
+    }
+}
+
+func TestDownloadSnippet(t *testing.T) {
+    tests := []struct {
+        name            string
+        urlPath         string
+        wantCode        int
+        wantType        string
+        wantDisposition string
+    }{
+        {"Plain", "/s/pondXq7kLm2vRt9w/download", http.StatusOK, "text/plain; charset=utf-8", `attachment; filename=pondXq7kLm2vRt9w.txt`},
+        {"By ID", "/snippet/1/download", http.StatusNotFound, "", ""},
+        {"Markdown", "/s/notesQ5rT7yU9iOp/download", http.StatusOK, "text/plain; charset=utf-8", `attachment; filename=notesQ5rT7yU9iOp.md`},
+        {"Several files", "/s/gistM8nB2vC4xZ6q/download", http.StatusOK, "application/zip", `attachment; filename=gistM8nB2vC4xZ6q.zip`},
+        {"Private", "/s/noteB4nW8cYe1sZa/download", http.StatusNotFound, "", ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            code, header, _ := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Fatalf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if code != http.StatusOK {
+                return
+            }
+
+            if ct := header.Get("Content-Type"); ct != tt.wantType {
+                t.Errorf("want Content-Type %q; got %q", tt.wantType, ct)
+            }
+
+            if cd := header.Get("Content-Disposition"); cd != tt.wantDisposition {
+                t.Errorf("want Content-Disposition %q; got %q", tt.wantDisposition, cd)
+            }
+        })
+    }
+}
+
+func TestDownloadSnippetArchive(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    _, header, body := ts.get(t, "/s/gistM8nB2vC4xZ6q/download")
+
+    zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var names []string
+    for _, f := range zr.File {
+        names = append(names, f.Name)
+    }
+
+    if got := fmt.Sprint(names); got != "[main.go go.mod README.md]" {
+        t.Errorf("want the snippet's files in the archive; got %s", got)
+    }
+
+    // The archive is the same each time, so its ETag is too.
+    _, again, _ := ts.get(t, "/s/gistM8nB2vC4xZ6q/download")
+
+    if header.Get("ETag") != again.Get("ETag") {
+        t.Errorf("want a stable ETag; got %q and %q", header.Get("ETag"), again.Get("ETag"))
+    }
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The name of the cookie which carries a user's remember me series and token,
+// separated by a colon.
+const rememberCookieName = "remember"
+
+// How long a remember me token is still accepted after it has been replaced.
+// A browser sending several requests at once sends the same cookie with all of
+// them, and all but the first arrive with the token it has just replaced.
+const rememberGracePeriod = 30 * time.Second
+
+// The issueRememberToken helper starts a new remember me series for a user and
+// sends it to the browser in a long-lived cookie.
+func (app *application) issueRememberToken(w http.ResponseWriter, r *http.Request, userID int) error {
+  series, err := randomToken()
+  if err != nil {
+    return err
+  }
+
+  token, err := randomToken()
+  if err != nil {
+    return err
+  }
+
+  expires := time.Now().Add(app.rememberLifetime)
+
+  err = app.rememberTokens.Insert(userID, series, token, expires)
+  if err != nil {
+    return err
+  }
+
+  app.setRememberCookie(w, series+":"+token, expires)
+
+  return nil
+}
+
+// The resumeSession helper logs a user back in from their remember me cookie,
+// once their short-lived session has gone. The token is replaced on every use,
+// so a stolen cookie stops working as soon as either copy is used. If a series
+// turns up with a token which has already been replaced, both the user and a
+// thief have a copy, and we can't tell which is which: we log the user out
+// everywhere and forget all of their remember me tokens. The exception is the
+// token replaced in the last rememberGracePeriod, which is most likely from a
+// request sent alongside the one which replaced it.
+//
+// It returns the new session, or nil if the request can't be logged in.
+func (app *application) resumeSession(w http.ResponseWriter, r *http.Request) (*models.Session, error) {
+  cookie, err := r.Cookie(rememberCookieName)
+  if err != nil {
+    return nil, nil
+  }
+
+  series, token, ok := splitRememberCookie(cookie.Value)
+  if !ok {
+    app.clearRememberCookie(w)
+    return nil, nil
+  }
+
+  t, err := app.rememberTokens.Get(series)
+  if errors.Is(err, models.ErrNoRecord) {
+    app.clearRememberCookie(w)
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  }
+
+  if !t.Matches(token) {
+    // The browser will get the new token from the response to the request
+    // which replaced it, so leave the cookie alone.
+    if t.MatchesPrevious(token, time.Now().Add(-rememberGracePeriod)) {
+      return app.startSession(w, r, t.UserID)
+    }
+
+    err = app.rememberTokens.DeleteAllForUser(t.UserID)
+    if err != nil {
+      return nil, err
+    }
+
+    err = app.sessions.DeleteAllForUser(t.UserID, 0)
+    if err != nil {
+      return nil, err
+    }
+
+    app.auditAs(r, t.UserID, models.AuditRememberTokenReuse, fmt.Sprintf("user:%d", t.UserID))
+    app.session.Put(r, "flash", "Your login may have been copied to another device, so you've been logged out everywhere. Please log in again.")
+    app.clearRememberCookie(w)
+
+    return nil, nil
+  }
+
+  newToken, err := randomToken()
+  if err != nil {
+    return nil, err
+  }
+
+  // If another request used the token first, it has already been rotated
+  // under us. That's the same race, so as long as the series still exists
+  // the request is logged in without touching the cookie.
+  err = app.rememberTokens.Rotate(series, token, newToken)
+  if errors.Is(err, models.ErrNoRecord) {
+    t, err = app.rememberTokens.Get(series)
+    if errors.Is(err, models.ErrNoRecord) {
+      return nil, nil
+    } else if err != nil {
+      return nil, err
+    }
+
+    if !t.MatchesPrevious(token, time.Now().Add(-rememberGracePeriod)) {
+      return nil, nil
+    }
+
+    return app.startSession(w, r, t.UserID)
+  } else if err != nil {
+    return nil, err
+  }
+
+  app.setRememberCookie(w, series+":"+newToken, t.Expires)
+
+  return app.startSession(w, r, t.UserID)
+}
+
+// The forgetRememberToken helper deletes the browser's remember me series, if
+// it has one, and expires the cookie.
+func (app *application) forgetRememberToken(w http.ResponseWriter, r *http.Request) error {
+  cookie, err := r.Cookie(rememberCookieName)
+  if err != nil {
+    return nil
+  }
+
+  if series, _, ok := splitRememberCookie(cookie.Value); ok {
+    err = app.rememberTokens.Delete(series)
+    if err != nil {
+      return err
+    }
+  }
+
+  app.clearRememberCookie(w)
+
+  return nil
+}
+
+func (app *application) setRememberCookie(w http.ResponseWriter, value string, expires time.Time) {
+  http.SetCookie(w, &http.Cookie{
+    Name:     rememberCookieName,
+    Value:    value,
+    Path:     "/",
+    Expires:  expires,
+    HttpOnly: true,
+    Secure:   true,
+    SameSite: http.SameSiteLaxMode,
+  })
+}
+
+func (app *application) clearRememberCookie(w http.ResponseWriter) {
+  http.SetCookie(w, &http.Cookie{
+    Name:     rememberCookieName,
+    Value:    "",
+    Path:     "/",
+    MaxAge:   -1,
+    HttpOnly: true,
+    Secure:   true,
+    SameSite: http.SameSiteLaxMode,
+  })
+}
+
+func splitRememberCookie(value string) (series, token string, ok bool) {
+  parts := strings.SplitN(value, ":", 2)
+  if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+    return "", "", false
+  }
+
+  return parts[0], parts[1], true
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+    "testing"
+    "time"
+
+    "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The cookie helper returns the value of the named cookie in the test
+// client's jar, or "" if there isn't one.
+func (ts *testServer) cookie(t *testing.T, name string) string {
+    u, err := url.Parse(ts.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, c := range ts.Client().Jar.Cookies(u) {
+        if c.Name == name {
+            return c.Value
+        }
+    }
+
+    return ""
+}
+
+// The setCookie helper replaces a cookie in the test client's jar. An empty
+// value removes it, as if the browser had been restarted.
+func (ts *testServer) setCookie(t *testing.T, name, value string) {
+    u, err := url.Parse(ts.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    c := &http.Cookie{Name: name, Value: value, Path: "/"}
+    if value == "" {
+        c.MaxAge = -1
+    }
+
+    ts.Client().Jar.SetCookies(u, []*http.Cookie{c})
+}
+
+// The loginRemember method logs in as a mock user, ticking "remember me" if
+// remember is true.
+func (ts *testServer) loginRemember(t *testing.T, email string, remember bool) {
+    _, _, body := ts.get(t, "/user/login")
+
+    form := url.Values{}
+    form.Add("email", email)
+    form.Add("password", "pa$$word")
+    form.Add("csrf_token", extractCSRFToken(t, body))
+
+    if remember {
+        form.Add("remember", "true")
+    }
+
+    code, _, _ := ts.postForm(t, "/user/login", form)
+    if code != http.StatusSeeOther {
+        t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+    }
+}
+
+func TestRememberMe(t *testing.T) {
+    t.Run("Not remembered", func(t *testing.T) {
+        app := newTestApplication(t)
+        ts := newTestServer(t, app.routes())
+        defer ts.Close()
+
+        ts.loginRemember(t, "alice@example.com", false)
+
+        if ts.cookie(t, rememberCookieName) != "" {
+            t.Error("want no remember cookie")
+        }
+
+        // Without a remember token, losing the session logs the user out.
+        ts.setCookie(t, sessionCookieName, "")
+
+        code, _, _ := ts.get(t, "/user/sessions")
+        if code != http.StatusSeeOther {
+            t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+        }
+    })
+
+    t.Run("Resume and rotate", func(t *testing.T) {
+        app := newTestApplication(t)
+        ts := newTestServer(t, app.routes())
+        defer ts.Close()
+
+        ts.loginRemember(t, "alice@example.com", true)
+
+        first := ts.cookie(t, rememberCookieName)
+        if first == "" {
+            t.Fatal("want a remember cookie")
+        }
+
+        ts.setCookie(t, sessionCookieName, "")
+
+        code, _, _ := ts.get(t, "/user/sessions")
+        if code != http.StatusOK {
+            t.Fatalf("want %d; got %d", http.StatusOK, code)
+        }
+
+        second := ts.cookie(t, rememberCookieName)
+        if second == first || second == "" {
+            t.Error("want the remember token to be rotated")
+        }
+
+        if ts.cookie(t, sessionCookieName) == "" {
+            t.Error("want a new session cookie")
+        }
+
+        // Logging out forgets the remember token too.
+        _, _, body := ts.get(t, "/user/sessions")
+
+        form := url.Values{}
+        form.Add("csrf_token", extractCSRFToken(t, body))
+        ts.postForm(t, "/user/logout", form)
+
+        ts.setCookie(t, rememberCookieName, second)
+
+        code, _, _ = ts.get(t, "/user/sessions")
+        if code != http.StatusSeeOther {
+            t.Errorf("after logout: want %d; got %d", http.StatusSeeOther, code)
+        }
+    })
+
+    t.Run("Theft detection", func(t *testing.T) {
+        app := newTestApplication(t)
+        ts := newTestServer(t, app.routes())
+        defer ts.Close()
+
+        ts.loginRemember(t, "alice@example.com", true)
+        stolen := ts.cookie(t, rememberCookieName)
+
+        // The thief uses the stolen cookie first, and keeps using it, which
+        // rotates the token more than once.
+        for i := 0; i < 2; i++ {
+            ts.setCookie(t, sessionCookieName, "")
+
+            code, _, _ := ts.get(t, "/user/sessions")
+            if code != http.StatusOK {
+                t.Fatalf("thief: want %d; got %d", http.StatusOK, code)
+            }
+        }
+
+        // Then the real user comes back with the old token. We can't tell who
+        // is who, so everybody is logged out.
+        ts.setCookie(t, sessionCookieName, "")
+        ts.setCookie(t, rememberCookieName, stolen)
+
+        code, _, _ := ts.get(t, "/user/sessions")
+        if code != http.StatusSeeOther {
+            t.Errorf("replay: want %d; got %d", http.StatusSeeOther, code)
+        }
+
+        sessions, _ := app.sessions.ListForUser(1)
+        if len(sessions) != 0 {
+            t.Errorf("want all sessions revoked; got %d", len(sessions))
+        }
+
+        series, _, _ := splitRememberCookie(stolen)
+        if _, err := app.rememberTokens.Get(series); err != models.ErrNoRecord {
+            t.Errorf("want remember token deleted; got %v", err)
+        }
+
+        events, _, _ := app.auditLog.List(models.AuditFilter{Action: models.AuditRememberTokenReuse}, 0, 10)
+        if len(events) != 1 {
+            t.Errorf("want 1 %s audit event; got %d", models.AuditRememberTokenReuse, len(events))
+        }
+    })
+    t.Run("Concurrent requests", func(t *testing.T) {
+        app := newTestApplication(t)
+        ts := newTestServer(t, app.routes())
+        defer ts.Close()
+
+        ts.loginRemember(t, "alice@example.com", true)
+        first := ts.cookie(t, rememberCookieName)
+
+        // The browser sends two requests at once with the same cookie. The
+        // first to arrive rotates the token.
+        ts.setCookie(t, sessionCookieName, "")
+
+        code, _, _ := ts.get(t, "/user/sessions")
+        if code != http.StatusOK {
+            t.Fatalf("first request: want %d; got %d", http.StatusOK, code)
+        }
+
+        rotated := ts.cookie(t, rememberCookieName)
+
+        // The second arrives with the token the first has just replaced, and
+        // is logged in too.
+        ts.setCookie(t, sessionCookieName, "")
+        ts.setCookie(t, rememberCookieName, first)
+
+        code, _, _ = ts.get(t, "/user/sessions")
+        if code != http.StatusOK {
+            t.Errorf("second request: want %d; got %d", http.StatusOK, code)
+        }
+
+        if got := ts.cookie(t, rememberCookieName); got != first {
+            t.Errorf("want the cookie to be left for the first response to update; got %q", got)
+        }
+
+        // Nothing was revoked, and the new token still works.
+        ts.setCookie(t, sessionCookieName, "")
+        ts.setCookie(t, rememberCookieName, rotated)
+
+        code, _, _ = ts.get(t, "/user/sessions")
+        if code != http.StatusOK {
+            t.Errorf("new token: want %d; got %d", http.StatusOK, code)
+        }
+
+        events, _, _ := app.auditLog.List(models.AuditFilter{Action: models.AuditRememberTokenReuse}, 0, 10)
+        if len(events) != 0 {
+            t.Errorf("want no %s audit events; got %d", models.AuditRememberTokenReuse, len(events))
+        }
+    })
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+    app := newTestApplication(t)
+
+    expired, err := app.sessions.Insert(1, "expired", "192.0.2.1", "Go", time.Now().Add(-time.Minute))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    current, err := app.sessions.Insert(1, "current", "192.0.2.1", "Go", time.Now().Add(time.Hour))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    app.deleteExpiredSessions()
+
+    // Touch finds sessions whether or not they have expired, so it tells us
+    // which ones are still stored.
+    if err := app.sessions.Touch(expired, "192.0.2.1"); err != models.ErrNoRecord {
+        t.Errorf("expired session: want %v; got %v", models.ErrNoRecord, err)
+    }
+
+    if err := app.sessions.Touch(current, "192.0.2.1"); err != nil {
+        t.Errorf("current session: want no error; got %v", err)
+    }
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strconv"
+
+  "mateuszurbanski/snippetbox/pkg/diff"
+  "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/highlight"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The number of unchanged lines shown around each change in a diff.
+const diffContext = 3
+
+// A revisionDiff holds the differences between two revisions of a snippet,
+// for the diff page. View is "unified" or "split" (side by side).
+type revisionDiff struct {
+  From         *models.SnippetRevision
+  To           *models.SnippetRevision
+  View         string
+  Files        []*fileDiff
+  TitleChanged bool
+}
+
+// Changed returns true if any of the files differ between the revisions.
+func (d *revisionDiff) Changed() bool {
+  for _, f := range d.Files {
+    if f.Status != "unchanged" {
+      return true
+    }
+  }
+
+  return false
+}
+
+// A fileDiff holds the changes to one file between two revisions. Filename is
+// its name in the newer revision (or the older one, if it was removed), and
+// OldFilename its name in the older one. Status is "added", "removed",
+// "changed" or "unchanged".
+type fileDiff struct {
+  Filename    string
+  OldFilename string
+  Status      string
+  Hunks       []diff.Hunk
+}
+
+// The diffFiles function compares the files of two revisions. Files are
+// matched up by name, except that when both revisions have a single file
+// they are compared whatever they are called, so that naming or renaming
+// the only file shows as a change to it.
+func diffFiles(from, to []*models.SnippetFile) []*fileDiff {
+  old := map[string]*models.SnippetFile{}
+  for _, f := range from {
+    old[f.Filename] = f
+  }
+
+  if len(from) == 1 && len(to) == 1 {
+    old = map[string]*models.SnippetFile{to[0].Filename: from[0]}
+  }
+
+  var diffs []*fileDiff
+
+  for _, f := range to {
+    d := &fileDiff{Filename: f.Filename, OldFilename: f.Filename, Status: "added"}
+
+    previous := &models.SnippetFile{}
+    if o, ok := old[f.Filename]; ok {
+      previous = o
+      d.OldFilename = o.Filename
+      d.Status = "changed"
+
+      delete(old, f.Filename)
+    }
+
+    d.Hunks = diff.Hunks(diff.Lines(previous.Content, f.Content), diffContext)
+
+    if d.Status == "changed" && len(d.Hunks) == 0 && d.OldFilename == d.Filename {
+      d.Status = "unchanged"
+    }
+
+    diffs = append(diffs, d)
+  }
+
+  // Whatever is left of the old files was removed.
+  for _, f := range from {
+    if _, ok := old[f.Filename]; ok {
+      diffs = append(diffs, &fileDiff{
+        Filename:    f.Filename,
+        OldFilename: f.Filename,
+        Status:      "removed",
+        Hunks:       diff.Hunks(diff.Lines(f.Content, ""), diffContext),
+      })
+    }
+  }
+
+  return diffs
+}
+
+// The editSnippetForm handler shows the form for editing a snippet, filled in
+// with its current title, format and files.
+func (app *application) editSnippetForm(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.snippetHistory(w, r)
+  if !ok {
+    return
+  }
+
+  if !app.authenticatedUser(r).CanEditSnippet(s) {
+    app.forbidden(w, r)
+    return
+  }
+
+  app.render(w, r, "edit.page.tmpl", &templateData{
+    Form: forms.New(url.Values{
+      "title":  {s.Title},
+      "format": {s.Format},
+    }),
+    FormFiles: s.AllFiles(),
+    Languages: highlight.Languages(),
+    Snippet:   s,
+  })
+}
+
+// The editSnippet handler saves an edit of a snippet as its next revision.
+func (app *application) editSnippet(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.snippetHistory(w, r)
+  if !ok {
+    return
+  }
+
+  user := app.authenticatedUser(r)
+
+  if !user.CanEditSnippet(s) {
+    app.forbidden(w, r)
+    return
+  }
+
+  err := r.ParseForm()
+
+  if err != nil {
+    app.clientError(w, http.StatusBadRequest)
+    return
+  }
+
+  form := forms.New(r.PostForm)
+  form.Required("title")
+  form.MaxLength("title", 100)
+  form.PermittedValues("format", models.FormatPlain, models.FormatMarkdown)
+
+  files := formFiles(form)
+
+  if !form.Valid() {
+    app.render(w, r, "edit.page.tmpl", &templateData{
+      Form:      form,
+      FormFiles: orEmptyFile(files),
+      Languages: highlight.Languages(),
+      Snippet:   s,
+    })
+
+    return
+  }
+
+  format := form.Get("format")
+  if format == "" {
+    format = models.FormatPlain
+  }
+
+  detectLanguages(files, format)
+
+  // Saving the form without changing anything doesn't make a new revision.
+  if form.Get("title") == s.Title && format == s.Format && models.SameFiles(files, s.AllFiles()) {
+    app.session.Put(r, "flash", "No changes to save.")
+    http.Redirect(w, r, s.URL(), http.StatusSeeOther)
+
+    return
+  }
+
+  number, err := app.snippets.Update(s.ID, user.ID, form.Get("title"), format, files)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  app.audit(r, models.AuditSnippetEdit, fmt.Sprintf("snippet:%d revision:%d", s.ID, number))
+
+  app.session.Put(r, "flash", "Snippet successfully updated!")
+
+  http.Redirect(w, r, s.URL(), http.StatusSeeOther)
+}
+
+// The listRevisions handler shows the history of a snippet, newest first.
+func (app *application) listRevisions(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.snippetHistory(w, r)
+  if !ok {
+    return
+  }
+
+  revisions, err := app.snippets.Revisions(s.ID)
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  app.render(w, r, "revisions.page.tmpl", &templateData{
+    Revisions: revisions,
+    Snippet:   s,
+  })
+}
+
+// The diffRevisions handler shows the differences between two revisions of a
+// snippet, chosen with the from and to query parameters. By default it shows
+// the change which made the to revision (or the latest one). The view
+// parameter picks a unified or side-by-side diff.
+func (app *application) diffRevisions(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.snippetHistory(w, r)
+  if !ok {
+    return
+  }
+
+  revisions, err := app.snippets.Revisions(s.ID)
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  if len(revisions) == 0 {
+    app.notFound(w)
+    return
+  }
+
+  // Revisions come newest first.
+  to, from := revisions[0].Number, 0
+
+  query := r.URL.Query()
+
+  for _, p := range []struct {
+    name  string
+    value *int
+  }{
+    {"from", &from},
+    {"to", &to},
+  } {
+    if v := query.Get(p.name); v != "" {
+      n, err := strconv.Atoi(v)
+      if err != nil || n < 1 {
+        app.clientError(w, http.StatusBadRequest)
+        return
+      }
+
+      *p.value = n
+    }
+  }
+
+  if from == 0 {
+    from = to - 1
+    if from < 1 {
+      from = 1
+    }
+  }
+
+  view := query.Get("view")
+  if view != "split" {
+    view = "unified"
+  }
+
+  d := &revisionDiff{View: view}
+
+  for _, rev := range revisions {
+    if rev.Number == from {
+      d.From = rev
+    }
+
+    if rev.Number == to {
+      d.To = rev
+    }
+  }
+
+  if d.From == nil || d.To == nil {
+    app.notFound(w)
+    return
+  }
+
+  d.Files = diffFiles(d.From.AllFiles(), d.To.AllFiles())
+  d.TitleChanged = d.From.Title != d.To.Title
+
+  app.render(w, r, "diff.page.tmpl", &templateData{
+    Diff:      d,
+    Revisions: revisions,
+    Snippet:   s,
+  })
+}
+
+// The restoreRevision handler makes an older revision of a snippet current
+// again. The history isn't rewritten: the restored title and files are saved
+// as a new revision, so the restore itself can be undone.
+func (app *application) restoreRevision(w http.ResponseWriter, r *http.Request) {
+  s, ok := app.snippetHistory(w, r)
+  if !ok {
+    return
+  }
+
+  user := app.authenticatedUser(r)
+
+  if !user.CanEditSnippet(s) {
+    app.forbidden(w, r)
+    return
+  }
+
+  number, err := strconv.Atoi(r.URL.Query().Get(":number"))
+
+  if err != nil || number < 1 {
+    app.notFound(w)
+    return
+  }
+
+  rev, err := app.snippets.Revision(s.ID, number)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  historyURL := s.URL() + "/revisions"
+
+  if rev.Title == s.Title && rev.Format == s.Format && models.SameFiles(rev.AllFiles(), s.AllFiles()) {
+    app.session.Put(r, "flash", "That revision is the same as the current one.")
+    http.Redirect(w, r, historyURL, http.StatusSeeOther)
+
+    return
+  }
+
+  restored, err := app.snippets.Update(s.ID, user.ID, rev.Title, rev.Format, rev.AllFiles())
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  app.audit(r, models.AuditSnippetEdit, fmt.Sprintf("snippet:%d revision:%d restored:%d", s.ID, restored, rev.Number))
+
+  app.session.Put(r, "flash", fmt.Sprintf("Revision %d restored.", rev.Number))
+
+  http.Redirect(w, r, historyURL, http.StatusSeeOther)
+}
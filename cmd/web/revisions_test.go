@@ -0,0 +1,239 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "net/url"
+    "testing"
+)
+
+func TestEditSnippet(t *testing.T) {
+    tests := []struct {
+        name         string
+        email        string
+        urlPath      string
+        title        string
+        content      string
+        wantCode     int
+        wantLocation string
+        wantBody     []byte
+    }{
+        {"Anonymous", "", "/s/pondXq7kLm2vRt9w/edit", "New title", "New content", http.StatusSeeOther, "/user/login", nil},
+        {"Not the owner", "alice@example.com", "/s/pondXq7kLm2vRt9w/edit", "New title", "New content", http.StatusForbidden, "", nil},
+        {"Owner", "mallory@example.com", "/s/pondXq7kLm2vRt9w/edit", "New title", "New content", http.StatusSeeOther, "/s/pondXq7kLm2vRt9w", nil},
+        {"No changes", "mallory@example.com", "/s/pondXq7kLm2vRt9w/edit", "An old silent pond", "An old silent pond...", http.StatusSeeOther, "/s/pondXq7kLm2vRt9w", nil},
+        {"Empty title", "mallory@example.com", "/s/pondXq7kLm2vRt9w/edit", "", "New content", http.StatusOK, "", []byte("This field cannot be blank")},
+        {"Unlisted, not the owner", "mallory@example.com", "/s/haikuJ6dF0gHp3uT/edit", "New title", "New content", http.StatusForbidden, "", nil},
+        {"Private, not the owner", "mallory@example.com", "/s/noteB4nW8cYe1sZa/edit", "New title", "New content", http.StatusNotFound, "", nil},
+        {"Non-existent slug", "mallory@example.com", "/s/missingAAAAAAAAA/edit", "New title", "New content", http.StatusNotFound, "", nil},
+        {"By ID", "mallory@example.com", "/snippet/1/edit", "New title", "New content", http.StatusNotFound, "", nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            csrfPage := "/user/login"
+            if tt.email != "" {
+                ts.login(t, tt.email)
+                csrfPage = "/snippet/create"
+            }
+
+            _, _, body := ts.get(t, csrfPage)
+
+            form := url.Values{}
+            form.Add("csrf_token", extractCSRFToken(t, body))
+            form.Add("title", tt.title)
+            form.Add("content", tt.content)
+            form.Add("language", "text")
+            form.Add("format", "plain")
+
+            code, header, body := ts.postForm(t, tt.urlPath, form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if loc := header.Get("Location"); loc != tt.wantLocation {
+                t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+        })
+    }
+}
+
+func TestEditSnippetForm(t *testing.T) {
+    app := newTestApplication(t)
+    ts := newTestServer(t, app.routes())
+    defer ts.Close()
+
+    ts.login(t, "mallory@example.com")
+
+    code, _, body := ts.get(t, "/s/pondXq7kLm2vRt9w/edit")
+
+    if code != http.StatusOK {
+        t.Fatalf("want %d; got %d", http.StatusOK, code)
+    }
+
+    for _, want := range []string{
+        "value='An old silent pond'",
+        "<textarea name='content'>An old silent pond...</textarea>",
+        "<option value='text'  selected >Plain text</option>",
+    } {
+        if !bytes.Contains(body, []byte(want)) {
+            t.Errorf("want the form to contain %q", want)
+        }
+    }
+}
+
+func TestListRevisions(t *testing.T) {
+    tests := []struct {
+        name        string
+        email       string
+        urlPath     string
+        wantCode    int
+        wantBody    []byte
+        wantRestore bool
+    }{
+        {"Anonymous", "", "/s/pondXq7kLm2vRt9w/revisions", http.StatusOK, []byte("An old pond"), false},
+        {"Owner", "mallory@example.com", "/s/pondXq7kLm2vRt9w/revisions", http.StatusOK, []byte("Mallory"), true},
+        {"Not the owner", "alice@example.com", "/s/pondXq7kLm2vRt9w/revisions", http.StatusOK, []byte("#2 (current)"), false},
+        {"Unlisted, anonymous", "", "/s/haikuJ6dF0gHp3uT/revisions", http.StatusOK, []byte("#1 (current)"), false},
+        {"Private, anonymous", "", "/s/noteB4nW8cYe1sZa/revisions", http.StatusNotFound, nil, false},
+        {"Private, owner", "alice@example.com", "/s/noteB4nW8cYe1sZa/revisions", http.StatusOK, []byte("A private note"), false},
+        {"By ID", "", "/snippet/1/revisions", http.StatusNotFound, nil, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            if tt.email != "" {
+                ts.login(t, tt.email)
+            }
+
+            code, _, body := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if !bytes.Contains(body, tt.wantBody) {
+                t.Errorf("want body to contain %q", tt.wantBody)
+            }
+
+            if restore := bytes.Contains(body, []byte("/restore")); restore != tt.wantRestore {
+                t.Errorf("want restore button %t; got %t", tt.wantRestore, restore)
+            }
+        })
+    }
+}
+
+func TestDiffRevisions(t *testing.T) {
+    tests := []struct {
+        name     string
+        urlPath  string
+        wantCode int
+        wantBody []string
+    }{
+        {
+            "Latest change",
+            "/s/pondXq7kLm2vRt9w/diff",
+            http.StatusOK,
+            []string{
+                "Changes from revision 1 to 2",
+                "Title changed from <del>An old pond</del> to <ins>An old silent pond</ins>",
+                "<tr class='diff-delete'>",
+                "<code>-An old pond...</code>",
+                "<code>+An old silent pond...</code>",
+            },
+        },
+        {
+            "Side by side",
+            "/s/pondXq7kLm2vRt9w/diff?from=1&to=2&view=split",
+            http.StatusOK,
+            []string{
+                "<td class='diff-delete'><code>An old pond...</code></td>",
+                "<td class='diff-insert'><code>An old silent pond...</code></td>",
+            },
+        },
+        {
+            "Same revision",
+            "/s/pondXq7kLm2vRt9w/diff?from=2&to=2",
+            http.StatusOK,
+            []string{"The content is the same in both revisions."},
+        },
+        {"Only one revision", "/s/pondXq7kLm2vRt9w/diff?to=1", http.StatusOK, []string{"Changes from revision 1 to 1"}},
+        {"Non-existent revision", "/s/pondXq7kLm2vRt9w/diff?from=9", http.StatusNotFound, nil},
+        {"Invalid revision", "/s/pondXq7kLm2vRt9w/diff?from=x", http.StatusBadRequest, nil},
+        {"Non-existent slug", "/s/missingAAAAAAAAA/diff", http.StatusNotFound, nil},
+        {"By ID", "/snippet/1/diff", http.StatusNotFound, nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            code, _, body := ts.get(t, tt.urlPath)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            for _, want := range tt.wantBody {
+                if !bytes.Contains(body, []byte(want)) {
+                    t.Errorf("want body to contain %q", want)
+                }
+            }
+        })
+    }
+}
+
+func TestRestoreRevision(t *testing.T) {
+    tests := []struct {
+        name         string
+        email        string
+        urlPath      string
+        wantCode     int
+        wantLocation string
+    }{
+        {"Owner", "mallory@example.com", "/s/pondXq7kLm2vRt9w/revisions/1/restore", http.StatusSeeOther, "/s/pondXq7kLm2vRt9w/revisions"},
+        {"Current revision", "mallory@example.com", "/s/pondXq7kLm2vRt9w/revisions/2/restore", http.StatusSeeOther, "/s/pondXq7kLm2vRt9w/revisions"},
+        {"Not the owner", "alice@example.com", "/s/pondXq7kLm2vRt9w/revisions/1/restore", http.StatusForbidden, ""},
+        {"Non-existent revision", "mallory@example.com", "/s/pondXq7kLm2vRt9w/revisions/9/restore", http.StatusNotFound, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t)
+            ts := newTestServer(t, app.routes())
+            defer ts.Close()
+
+            ts.login(t, tt.email)
+
+            _, _, body := ts.get(t, "/snippet/create")
+
+            form := url.Values{}
+            form.Add("csrf_token", extractCSRFToken(t, body))
+
+            code, header, _ := ts.postForm(t, tt.urlPath, form)
+
+            if code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, code)
+            }
+
+            if loc := header.Get("Location"); loc != tt.wantLocation {
+                t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+            }
+        })
+    }
+}
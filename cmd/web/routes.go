@@ -2,6 +2,9 @@ package main
 
 import(
   "net/http"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+
   "github.com/bmizerany/pat"
   "github.com/justinas/alice"
 )
@@ -9,12 +12,12 @@ import(
 func(app *application) routes() http.Handler {
   // Create a middleware chain containing our 'standard' middleware
   // which will be used for every request our application receives.
-  standardMiddleware := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+  standardMiddleware := alice.New(app.recoverPanic, app.proxyHeaders, app.requestID, app.logRequest, app.headers.handler)
 
   // Create a new middleware chain containing the middleware specific to
   // our dynamic application routes. For now, this chain will only contain
   // the session middleware but we'll add more to it later.
-  dynamicMiddleware := alice.New(app.session.Enable, noSurf, app.authenticate)
+  dynamicMiddleware := alice.New(app.session.Enable, app.rotateSessionKey, noSurf, app.authenticate)
 
   // Use the pat.New() function to initialize a new Pat router.
   mux := pat.New()
@@ -26,21 +29,98 @@ func(app *application) routes() http.Handler {
   mux.Get("/snippet/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippetForm))
 
   // Register the createSnippet function as the handler for the POST "/snippet/create" URL pattern.
-  mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippet))
+  mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthentication, app.limit(app.limiters.snippet)).ThenFunc(app.createSnippet))
+
+  // Register the showSnippet function as the handler for the "/s/:slug" URL pattern.
+  // Anybody can see public and unlisted snippets, so this route doesn't
+  // require authentication. showSnippet checks who can see private ones.
+  mux.Get("/s/:slug", dynamicMiddleware.ThenFunc(app.showSnippet))
+
+  // Serve the content of a snippet, and each of its files, as plain text,
+  // and as a file to download.
+  mux.Get("/s/:slug/raw", dynamicMiddleware.ThenFunc(app.rawSnippet))
+  mux.Get("/s/:slug/raw/:n", dynamicMiddleware.ThenFunc(app.rawFile))
+  mux.Get("/s/:slug/download", dynamicMiddleware.ThenFunc(app.downloadSnippet))
 
-  // Register the showSnippet function as the handler for the "/snippet/:id" URL pattern.
-  mux.Get("/snippet/:id", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.showSnippet))
+  // Add routes for editing a snippet and browsing its revisions.
+  mux.Get("/s/:slug/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editSnippetForm))
+  mux.Post("/s/:slug/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editSnippet))
+  mux.Get("/s/:slug/revisions", dynamicMiddleware.ThenFunc(app.listRevisions))
+  mux.Get("/s/:slug/diff", dynamicMiddleware.ThenFunc(app.diffRevisions))
+  mux.Post("/s/:slug/revisions/:number/restore", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.restoreRevision))
+
+  // Register the deleteSnippet function as the handler for the POST "/s/:slug/delete" URL pattern.
+  mux.Post("/s/:slug/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteSnippet))
+
+  // Redirect the old numeric snippet URLs to the slug ones. Nothing else is
+  // found by its numeric ID, since counting through the IDs would find
+  // unlisted snippets.
+  mux.Get("/snippet/:id", dynamicMiddleware.ThenFunc(app.redirectSnippet))
 
   // Add routes for user signup, login and logout.
   mux.Get("/user/signup", dynamicMiddleware.ThenFunc(app.signupUserForm))
-  mux.Post("/user/signup", dynamicMiddleware.ThenFunc(app.signupUser))
+  mux.Post("/user/signup", dynamicMiddleware.Append(app.limit(app.limiters.signup)).ThenFunc(app.signupUser))
   mux.Get("/user/login", dynamicMiddleware.ThenFunc(app.loginUserForm))
-  mux.Post("/user/login", dynamicMiddleware.ThenFunc(app.loginUser))
+  mux.Post("/user/login", dynamicMiddleware.Append(app.limit(app.limiters.login)).ThenFunc(app.loginUser))
+  mux.Get("/user/login/oidc", dynamicMiddleware.Append(app.limit(app.limiters.login)).ThenFunc(app.oidcLogin))
+  mux.Get("/user/login/oidc/callback", dynamicMiddleware.ThenFunc(app.oidcCallback))
   mux.Post("/user/logout", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.logoutUser))
 
+  // Add routes for managing the user's active sessions and password.
+  mux.Get("/user/sessions", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.listSessions))
+  mux.Post("/user/sessions/logout-all", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.logoutEverywhere))
+  mux.Post("/user/sessions/:id/revoke", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.revokeSession))
+  mux.Get("/user/password", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.changePasswordForm))
+  mux.Post("/user/password", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.changePassword))
+
+  // Add routes for managing the user's API tokens.
+  mux.Get("/user/tokens", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.listAPITokens))
+  mux.Post("/user/tokens", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createAPIToken))
+  mux.Post("/user/tokens/:id/revoke", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.revokeAPIToken))
+
+  // Add routes for exporting and deleting the user's account.
+  mux.Get("/user/account", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.account))
+  mux.Get("/user/export", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.exportAccount))
+  mux.Post("/user/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteAccount))
+  mux.Post("/user/delete/cancel", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.cancelAccountDeletion))
+
+  // Create a middleware chain for the admin area, which is only open to admins.
+  adminMiddleware := dynamicMiddleware.Append(app.requireRole(models.RoleAdmin))
+
+  // Add routes for the admin dashboard.
+  mux.Get("/admin", adminMiddleware.ThenFunc(app.adminDashboard))
+  mux.Get("/admin/users", adminMiddleware.ThenFunc(app.adminUsers))
+  mux.Post("/admin/users/:id/activate", adminMiddleware.Then(app.adminSetUserActive(true)))
+  mux.Post("/admin/users/:id/deactivate", adminMiddleware.Then(app.adminSetUserActive(false)))
+  mux.Get("/admin/snippets", adminMiddleware.ThenFunc(app.adminSnippets))
+  mux.Post("/admin/snippets/:id/delete", adminMiddleware.ThenFunc(app.adminDeleteSnippet))
+  mux.Post("/admin/snippets/:id/extend", adminMiddleware.ThenFunc(app.adminExtendSnippet))
+  mux.Get("/admin/audit", adminMiddleware.ThenFunc(app.adminAudit))
+  mux.Get("/admin/audit/export", adminMiddleware.ThenFunc(app.adminAuditExport))
+
+  // Add a route for creating snippets through the API. API clients
+  // authenticate with a token instead of a session cookie, so it is outside
+  // the dynamic middleware, and doesn't need a CSRF token. The rate limit is
+  // per user, so authenticateToken limits failed attempts by IP address itself.
+  apiMiddleware := alice.New(app.authenticateToken, app.limitAPI(app.limiters.api))
+  mux.Post("/paste", apiMiddleware.ThenFunc(app.paste))
+
+  // Add the JSON API used by the command line client.
+  mux.Get("/api/snippets", apiMiddleware.ThenFunc(app.apiListSnippets))
+  mux.Post("/api/snippets", apiMiddleware.ThenFunc(app.apiCreateSnippet))
+  mux.Get("/api/snippets/:slug", apiMiddleware.ThenFunc(app.apiShowSnippet))
+  mux.Put("/api/snippets/:slug", apiMiddleware.ThenFunc(app.apiEditSnippet))
+  mux.Del("/api/snippets/:slug", apiMiddleware.ThenFunc(app.apiDeleteSnippet))
+  mux.Get("/api/search", apiMiddleware.ThenFunc(app.apiSearchSnippets))
+
   // Add a new GET /ping route.
   mux.Get("/ping", http.HandlerFunc(ping))
 
+  // Add a route for browsers to report Content-Security-Policy violations.
+  // It is outside the dynamic middleware because reports don't carry a CSRF
+  // token.
+  mux.Post("/csp-report", http.HandlerFunc(app.cspReport))
+
   // Create a file server which serves files out of the "./ui/static" directory.
   // Note that the path given to the http.Dir function is relative to the project
   // directory root.
@@ -0,0 +1,121 @@
+package main
+
+import (
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "math/big"
+  "os"
+  "strings"
+)
+
+// The length, in bytes, of the keys used to encrypt and authenticate session
+// cookies.
+const secretLength = 32
+
+// The characters used for generated secrets. Every character is a single byte,
+// so a secret of secretLength characters is exactly secretLength bytes long.
+const secretAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+*@#%-_"
+
+// The envOr function returns the value of the environment variable key, or
+// fallback if it isn't set. We use it for flag defaults, so that secrets can be
+// kept out of the process list.
+func envOr(key, fallback string) string {
+  if value, ok := os.LookupEnv(key); ok {
+    return value
+  }
+
+  return fallback
+}
+
+// The parseSecrets function validates the primary session secret and the
+// comma-separated list of previous secrets, and returns them as keys for
+// sessions.New().
+func parseSecrets(secret, previous string) ([]byte, [][]byte, error) {
+  if len(secret) != secretLength {
+    return nil, nil, fmt.Errorf("secret must be exactly %d bytes long, got %d", secretLength, len(secret))
+  }
+
+  var oldKeys [][]byte
+
+  for _, old := range strings.Split(previous, ",") {
+    old = strings.TrimSpace(old)
+    if old == "" {
+      continue
+    }
+
+    if len(old) != secretLength {
+      return nil, nil, fmt.Errorf("previous secrets must be exactly %d bytes long, got %d", secretLength, len(old))
+    }
+
+    oldKeys = append(oldKeys, []byte(old))
+  }
+
+  return []byte(secret), oldKeys, nil
+}
+
+// The keyID function returns a short fingerprint of a session key. We store it
+// in the session so that we can tell whether a cookie was last written with
+// the current key, without revealing anything about the key itself.
+func keyID(key []byte) string {
+  sum := sha256.Sum256(key)
+
+  return hex.EncodeToString(sum[:8])
+}
+
+// The auditKey function derives the key used to pseudonymise email addresses
+// in the audit log from a session key, so that there isn't another secret to
+// manage. The pseudonyms change when the session secret is rotated.
+func auditKey(key []byte) []byte {
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte("snippetbox audit log"))
+
+  return mac.Sum(nil)
+}
+
+// The generateSecret function returns a new random secret of secretLength
+// characters, suitable for the -secret flag.
+func generateSecret() (string, error) {
+  b := make([]byte, secretLength)
+  max := big.NewInt(int64(len(secretAlphabet)))
+
+  for i := range b {
+    n, err := rand.Int(rand.Reader, max)
+    if err != nil {
+      return "", err
+    }
+
+    b[i] = secretAlphabet[n.Int64()]
+  }
+
+  return string(b), nil
+}
+
+// The genSecret function implements the "web gensecret" command. It prints a
+// fresh secret to stdout and the key rotation procedure to stderr, so the
+// output can be piped straight into a secret store.
+func genSecret(stdout, stderr io.Writer) error {
+  secret, err := generateSecret()
+  if err != nil {
+    return err
+  }
+
+  fmt.Fprintln(stdout, secret)
+
+  fmt.Fprint(stderr, `
+To rotate the session secret without logging anyone out:
+
+  1. Add the current secret to the front of -previous-secrets (or
+     SNIPPETBOX_PREVIOUS_SECRETS), separated from any older ones by commas.
+  2. Set -secret (or SNIPPETBOX_SECRET) to the secret printed above.
+  3. Restart the application. Cookies encrypted with a previous secret are
+     still accepted, and are re-encrypted with the new one on the next request.
+  4. Once the session lifetime (12 hours) has passed, remove the oldest entries
+     from the list of previous secrets.
+`)
+
+  return nil
+}
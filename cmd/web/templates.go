@@ -2,9 +2,12 @@ package main
 
 import (
   "html/template"
+  "net/http"
   "path/filepath"
+  "strings"
   "time"
   "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/highlight"
   "mateuszurbanski/snippetbox/pkg/models"
 )
 
@@ -13,13 +16,34 @@ import (
 // At the moment it only contains one field, but we'll add more
 // to it as the build progresses.
 type templateData struct {
-  CSRFToken       string
-  CurrentYear     int
-  Flash           string
-  Form            *forms.Form
-  IsAuthenticated bool
-  Snippet         *models.Snippet
-  Snippets        []*models.Snippet
+  APITokens         []*models.APIToken
+  AdminStats        *adminStats
+  AuditActions      []string
+  AuditEvents       []*models.AuditEvent
+  AuthenticatedUser *models.User
+  Burnt             bool
+  CSPNonce          string
+  CSRFToken         string
+  CurrentSession    *models.Session
+  CurrentYear       int
+  Diff              *revisionDiff
+  ErrorMessage      string
+  ErrorStatus       int
+  Files             []*renderedFile
+  Flash             string
+  Form              *forms.Form
+  FormFiles         []*models.SnippetFile
+  IsAuthenticated   bool
+  Languages         []*highlight.Language
+  NewAPIToken       string
+  Pagination        *pagination
+  Revisions         []*models.SnippetRevision
+  Role              string
+  SSOEnabled        bool
+  Sessions          []*models.Session
+  Snippet           *models.Snippet
+  Snippets          []*models.Snippet
+  Users             []*models.User
 }
 
 // Create a humanDate function which returns a nicely formatted string
@@ -33,11 +57,50 @@ func humanDate(t time.Time) string {
   // Convert the time to UTC before formatting it.
   return t.UTC().Format("02 Jan 2006 at 15:04")
 }
+// Create a device function which turns a User-Agent header into a short
+// description like "Firefox on Linux", for the active sessions page.
+func device(userAgent string) string {
+  browser := "Unknown browser"
+
+  for _, b := range []struct{ token, name string }{
+    {"Edg/", "Edge"},
+    {"OPR/", "Opera"},
+    {"Firefox/", "Firefox"},
+    {"Chrome/", "Chrome"},
+    {"Safari/", "Safari"},
+    {"curl/", "curl"},
+  } {
+    if strings.Contains(userAgent, b.token) {
+      browser = b.name
+      break
+    }
+  }
+
+  for _, os := range []struct{ token, name string }{
+    {"Android", "Android"},
+    {"iPhone", "iOS"},
+    {"iPad", "iOS"},
+    {"Windows", "Windows"},
+    {"Mac OS X", "macOS"},
+    {"Linux", "Linux"},
+  } {
+    if strings.Contains(userAgent, os.token) {
+      return browser + " on " + os.name
+    }
+  }
+
+  return browser
+}
+
 // Initialize a template.FuncMap object and store it in a global variable. This is
 // essentially a string-keyed map which acts as a lookup between the names of our
 // custom template functions and the functions themselves.
 var functions = template.FuncMap{
-  "humanDate": humanDate,
+  "device":     device,
+  "highlight":  highlight.HTML,
+  "humanDate":  humanDate,
+  "language":   highlight.Lookup,
+  "statusText": http.StatusText,
 }
 
 func newTemplateCache(dir string) (map[string]*template.Template, error) {
@@ -12,6 +12,9 @@ import (
     "testing"
     "time"
 
+    "mateuszurbanski/snippetbox/pkg/forms"
+    "mateuszurbanski/snippetbox/pkg/markdown"
+    "mateuszurbanski/snippetbox/pkg/models/memory"
     "mateuszurbanski/snippetbox/pkg/models/mock"
 
     "github.com/golangcollege/sessions"
@@ -31,19 +34,38 @@ func newTestApplication(t *testing.T) *application {
     }
 
     // Create a session manager instance, with the same settings as production.
-    session := sessions.New([]byte("3dSm5MnygFHh7XidAtbskXrjbwfoJcbJ"))
+    key := []byte("3dSm5MnygFHh7XidAtbskXrjbwfoJcbJ")
+    session := sessions.New(key)
     session.Lifetime = 12 * time.Hour
     session.Secure = true
 
+    users := &mock.UserModel{}
+
     // Initialize the dependencies, using the mocks for the loggers and
     // database models.
     return &application{
-        errorLog:      log.New(io.Discard, "", 0),
-        infoLog:       log.New(io.Discard, "", 0),
-        session:       session,
-        snippets:      &mock.SnippetModel{},
-        templateCache: templateCache,
-        users:         &mock.UserModel{},
+        apiTokens:        &mock.APITokenModel{},
+        auditKey:         auditKey(key),
+        auditLog:         &mock.AuditModel{},
+        auditRetention:   minAuditRetention,
+        authenticator:    users,
+        errorLog:         log.New(io.Discard, "", 0),
+        headers:          defaultSecurityHeaders(),
+        infoLog:          log.New(io.Discard, "", 0),
+        markdown:         markdown.NewCache(10),
+        maxPasteSize:     1024,
+        passwordPolicy: &forms.PasswordPolicy{
+            MinScore: 2,
+            Breaches: forms.NewLocalBreachList(),
+        },
+        rememberLifetime: 30 * 24 * time.Hour,
+        rememberTokens:   memory.NewRememberTokenModel(),
+        session:          session,
+        sessionKeyID:     keyID(key),
+        sessions:         memory.NewSessionModel(),
+        snippets:         &mock.SnippetModel{},
+        templateCache:    templateCache,
+        users:            users,
     }
 }
 
@@ -126,3 +148,20 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
     // Return the response status, headers and body.
     return rs.StatusCode, rs.Header, body
 }
+
+// Create a login method which logs in as one of the mock users (such as
+// alice@example.com), so that tests can reach the routes behind
+// requireAuthentication.
+func (ts *testServer) login(t *testing.T, email string) {
+    _, _, body := ts.get(t, "/user/login")
+
+    form := url.Values{}
+    form.Add("email", email)
+    form.Add("password", "pa$$word")
+    form.Add("csrf_token", extractCSRFToken(t, body))
+
+    code, _, _ := ts.postForm(t, "/user/login", form)
+    if code != http.StatusSeeOther {
+        t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+    }
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/tls"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/pem"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "log"
+  "math/big"
+  "net"
+  "net/http"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+)
+
+// How often the certificate files are checked for changes.
+const certReloadInterval = 10 * time.Second
+
+// The certReloader type serves a TLS certificate from a pair of PEM files. It
+// is plugged into tls.Config.GetCertificate, so that a renewed certificate is
+// picked up without restarting the server.
+type certReloader struct {
+  certFile string
+  keyFile  string
+  errorLog *log.Logger
+
+  mu      sync.RWMutex
+  cert    *tls.Certificate
+  modTime time.Time
+}
+
+// The newCertReloader function loads the certificate and key, and returns an
+// error if they can't be used.
+func newCertReloader(certFile, keyFile string, errorLog *log.Logger) (*certReloader, error) {
+  c := &certReloader{certFile: certFile, keyFile: keyFile, errorLog: errorLog}
+
+  err := c.reload()
+  if err != nil {
+    return nil, err
+  }
+
+  return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+  c.mu.RLock()
+  defer c.mu.RUnlock()
+
+  return c.cert, nil
+}
+
+// The reload method loads the certificate and key again if either file has
+// changed since they were last loaded. If the new files can't be loaded, for
+// example because only one of them has been replaced so far, we carry on with
+// the old certificate and try again next time.
+func (c *certReloader) reload() error {
+  var modTime time.Time
+
+  for _, name := range []string{c.certFile, c.keyFile} {
+    info, err := os.Stat(name)
+    if err != nil {
+      return err
+    }
+
+    if info.ModTime().After(modTime) {
+      modTime = info.ModTime()
+    }
+  }
+
+  c.mu.RLock()
+  unchanged := c.cert != nil && modTime.Equal(c.modTime)
+  c.mu.RUnlock()
+
+  if unchanged {
+    return nil
+  }
+
+  cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+  if err != nil {
+    return err
+  }
+
+  c.mu.Lock()
+  c.cert = &cert
+  c.modTime = modTime
+  c.mu.Unlock()
+
+  return nil
+}
+
+// The watch method checks the certificate files for changes every interval. It
+// never returns, so call it in its own goroutine.
+func (c *certReloader) watch(interval time.Duration) {
+  for range time.Tick(interval) {
+    if err := c.reload(); err != nil {
+      c.errorLog.Printf("Keeping the current TLS certificate: %s", err)
+    }
+  }
+}
+
+// The redirectHTTPS function returns the handler for the plain HTTP listener,
+// which sends every request to the same URL over HTTPS. httpsAddr is the
+// address of the TLS server, whose port is added to the host unless it's the
+// default.
+func redirectHTTPS(httpsAddr string) http.Handler {
+  _, port, _ := net.SplitHostPort(httpsAddr)
+  if port == "443" {
+    port = ""
+  }
+
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil {
+      host = h
+    }
+
+    host = strings.Trim(host, "[]")
+    if host == "" {
+      http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+      return
+    }
+
+    if port != "" {
+      host = net.JoinHostPort(host, port)
+    } else if strings.Contains(host, ":") {
+      host = "[" + host + "]"
+    }
+
+    target := url.URL{
+      Scheme:   "https",
+      Host:     host,
+      Path:     r.URL.Path,
+      RawPath:  r.URL.RawPath,
+      RawQuery: r.URL.RawQuery,
+    }
+
+    // Browsers turn a 301 into a GET, so use a 308 for anything else to keep
+    // the method and body.
+    code := http.StatusMovedPermanently
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+      code = http.StatusPermanentRedirect
+    }
+
+    w.Header().Set("Connection", "close")
+    http.Redirect(w, r, target.String(), code)
+  })
+}
+
+// The genCert function implements the "web gencert" command. It creates a
+// development certificate authority in the given directory (or reuses the one
+// which is already there), and uses it to issue a certificate for the local
+// server. Trusting the CA once in the browser or OS keeps later certificates
+// trusted too.
+func genCert(args []string, stdout, stderr io.Writer) error {
+  flags := flag.NewFlagSet("gencert", flag.ContinueOnError)
+  flags.SetOutput(stderr)
+
+  dir := flags.String("dir", "./tls", "Directory to write the certificates to")
+  hosts := flags.String("hosts", "localhost,127.0.0.1,::1", "Comma-separated list of host names and IP addresses")
+  validFor := flags.Duration("valid-for", 365*24*time.Hour, "How long the server certificate is valid for")
+
+  err := flags.Parse(args)
+  if errors.Is(err, flag.ErrHelp) {
+    return nil
+  } else if err != nil {
+    return err
+  }
+
+  err = os.MkdirAll(*dir, 0700)
+  if err != nil {
+    return err
+  }
+
+  caFile := filepath.Join(*dir, "ca.pem")
+  caKeyFile := filepath.Join(*dir, "ca-key.pem")
+
+  ca, caKey, err := loadCA(caFile, caKeyFile)
+  if errors.Is(err, os.ErrNotExist) {
+    ca, caKey, err = createCA(caFile, caKeyFile)
+    if err == nil {
+      fmt.Fprintf(stdout, "Created a new certificate authority in %s\n", caFile)
+    }
+  }
+
+  if err != nil {
+    return err
+  }
+
+  template := &x509.Certificate{
+    Subject:     pkix.Name{Organization: []string{"Snippetbox development"}, CommonName: "localhost"},
+    NotBefore:   time.Now().Add(-time.Hour),
+    NotAfter:    time.Now().Add(*validFor),
+    KeyUsage:    x509.KeyUsageDigitalSignature,
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+  }
+
+  for _, h := range strings.Split(*hosts, ",") {
+    h = strings.TrimSpace(h)
+
+    switch ip := net.ParseIP(h); {
+    case h == "":
+    case ip != nil:
+      template.IPAddresses = append(template.IPAddresses, ip)
+    default:
+      template.DNSNames = append(template.DNSNames, h)
+    }
+  }
+
+  if len(template.DNSNames) > 0 {
+    template.Subject.CommonName = template.DNSNames[0]
+  }
+
+  certFile := filepath.Join(*dir, "cert.pem")
+  keyFile := filepath.Join(*dir, "key.pem")
+
+  err = issueCert(template, ca, caKey, certFile, keyFile)
+  if err != nil {
+    return err
+  }
+
+  fmt.Fprintf(stdout, "Created a certificate for %s in %s and %s\n", *hosts, certFile, keyFile)
+
+  fmt.Fprintf(stderr, `
+To stop browsers warning about the certificate, add %s to your browser's or
+operating system's trusted certificate authorities. Keep %s private: anyone
+who has it can issue certificates your machine will trust.
+`, caFile, caKeyFile)
+
+  return nil
+}
+
+// The loadCA function reads a certificate authority and its private key.
+func loadCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+  pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  cert, err := x509.ParseCertificate(pair.Certificate[0])
+  if err != nil {
+    return nil, nil, err
+  }
+
+  key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+  if !ok || !cert.IsCA {
+    return nil, nil, fmt.Errorf("%s is not a certificate authority created by gencert", certFile)
+  }
+
+  return cert, key, nil
+}
+
+// The createCA function creates a self-signed certificate authority for
+// signing development certificates. It can't be used to create intermediate
+// authorities.
+func createCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+  template := &x509.Certificate{
+    Subject:               pkix.Name{Organization: []string{"Snippetbox development"}, CommonName: "Snippetbox development CA"},
+    NotBefore:             time.Now().Add(-time.Hour),
+    NotAfter:              time.Now().AddDate(10, 0, 0),
+    KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+    BasicConstraintsValid: true,
+    IsCA:                  true,
+    MaxPathLenZero:        true,
+  }
+
+  err := issueCert(template, nil, nil, certFile, keyFile)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  return loadCA(certFile, keyFile)
+}
+
+// The issueCert function creates a new key and a certificate for it from the
+// template, signed by the parent, and writes both to PEM files. If parent is
+// nil the certificate is self-signed.
+func issueCert(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, certFile, keyFile string) error {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return err
+  }
+
+  template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+  if err != nil {
+    return err
+  }
+
+  if parent == nil {
+    parent, parentKey = template, key
+  }
+
+  der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+  if err != nil {
+    return err
+  }
+
+  keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+  if err != nil {
+    return err
+  }
+
+  err = writePEM(keyFile, "PRIVATE KEY", keyDER, 0600)
+  if err != nil {
+    return err
+  }
+
+  return writePEM(certFile, "CERTIFICATE", der, 0644)
+}
+
+func writePEM(name, blockType string, der []byte, perm os.FileMode) error {
+  f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+  if err != nil {
+    return err
+  }
+
+  err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: der})
+  if err != nil {
+    f.Close()
+    return err
+  }
+
+  return f.Close()
+}
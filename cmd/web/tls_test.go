@@ -0,0 +1,205 @@
+package main
+
+import (
+    "bytes"
+    "crypto/tls"
+    "crypto/x509"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestGenCert(t *testing.T) {
+    dir := t.TempDir()
+
+    err := genCert([]string{"-dir", dir, "-hosts", "localhost,example.test,127.0.0.1"}, io.Discard, io.Discard)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    caPEM, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    roots := x509.NewCertPool()
+    if !roots.AppendCertsFromPEM(caPEM) {
+        t.Fatal("want ca.pem to hold a certificate")
+    }
+
+    verify := func(host string) error {
+        pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        cert, err := x509.ParseCertificate(pair.Certificate[0])
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        _, err = cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+        return err
+    }
+
+    for _, host := range []string{"localhost", "example.test", "127.0.0.1"} {
+        if err := verify(host); err != nil {
+            t.Errorf("want the certificate to be valid for %s; got %v", host, err)
+        }
+    }
+
+    if err := verify("example.com"); err == nil {
+        t.Error("want the certificate to be invalid for example.com")
+    }
+
+    info, err := os.Stat(filepath.Join(dir, "ca-key.pem"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if perm := info.Mode().Perm(); perm != 0600 {
+        t.Errorf("want the CA key to be private; got mode %o", perm)
+    }
+
+    // Running the command again reuses the CA, so certificates it issues are
+    // still trusted.
+    err = genCert([]string{"-dir", dir}, io.Discard, io.Discard)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    again, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !bytes.Equal(again, caPEM) {
+        t.Error("want the existing CA to be reused")
+    }
+
+    if err := verify("localhost"); err != nil {
+        t.Errorf("want the new certificate to be signed by the same CA; got %v", err)
+    }
+}
+
+func TestCertReloader(t *testing.T) {
+    dir := t.TempDir()
+
+    err := genCert([]string{"-dir", dir, "-hosts", "first.test"}, io.Discard, io.Discard)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+    certs, err := newCertReloader(certFile, keyFile, log.New(io.Discard, "", 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    name := func() string {
+        cert, err := certs.GetCertificate(nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        leaf, err := x509.ParseCertificate(cert.Certificate[0])
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        return leaf.Subject.CommonName
+    }
+
+    if got := name(); got != "first.test" {
+        t.Fatalf("want certificate for first.test; got %s", got)
+    }
+
+    // A key which doesn't match the certificate is ignored, and the old
+    // certificate is kept.
+    oldKey, err := os.ReadFile(keyFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = genCert([]string{"-dir", dir, "-hosts", "second.test"}, io.Discard, io.Discard)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = os.WriteFile(keyFile, oldKey, 0600)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    later := time.Now().Add(time.Minute)
+    os.Chtimes(keyFile, later, later)
+
+    if err := certs.reload(); err == nil {
+        t.Error("want an error for a mismatched key")
+    }
+
+    if got := name(); got != "first.test" {
+        t.Errorf("want the old certificate to be kept; got %s", got)
+    }
+
+    // Once both files have been replaced, the new certificate is served.
+    err = genCert([]string{"-dir", dir, "-hosts", "second.test"}, io.Discard, io.Discard)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    later = later.Add(time.Minute)
+    os.Chtimes(certFile, later, later)
+
+    if err := certs.reload(); err != nil {
+        t.Fatal(err)
+    }
+
+    if got := name(); got != "second.test" {
+        t.Errorf("want the new certificate; got %s", got)
+    }
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+    tests := []struct {
+        name      string
+        httpsAddr string
+        method    string
+        target    string
+        host      string
+        wantCode  int
+        wantURL   string
+    }{
+        {"Default port", ":443", http.MethodGet, "/snippet/1?x=y", "example.com", http.StatusMovedPermanently, "https://example.com/snippet/1?x=y"},
+        {"Host with port", ":443", http.MethodGet, "/", "example.com:80", http.StatusMovedPermanently, "https://example.com/"},
+        {"Custom port", ":4000", http.MethodGet, "/about", "localhost:8080", http.StatusMovedPermanently, "https://localhost:4000/about"},
+        {"IPv6 host", ":443", http.MethodGet, "/", "[::1]:80", http.StatusMovedPermanently, "https://[::1]/"},
+        {"POST", ":4000", http.MethodPost, "/user/login", "localhost", http.StatusPermanentRedirect, "https://localhost:4000/user/login"},
+        {"No host", ":443", http.MethodGet, "/", "", http.StatusBadRequest, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rr := httptest.NewRecorder()
+
+            r := httptest.NewRequest(tt.method, tt.target, nil)
+            r.Host = tt.host
+
+            redirectHTTPS(tt.httpsAddr).ServeHTTP(rr, r)
+
+            if rr.Code != tt.wantCode {
+                t.Errorf("want %d; got %d", tt.wantCode, rr.Code)
+            }
+
+            if got := rr.Header().Get("Location"); got != tt.wantURL {
+                t.Errorf("want Location %q; got %q", tt.wantURL, got)
+            }
+        })
+    }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+  "strconv"
+
+  "mateuszurbanski/snippetbox/pkg/forms"
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// The listAPITokens handler shows the user's API tokens, with a form for
+// creating another.
+func (app *application) listAPITokens(w http.ResponseWriter, r *http.Request) {
+  app.renderAPITokens(w, r, forms.New(nil), "")
+}
+
+// The renderAPITokens helper renders the API tokens page. A newly created
+// token is passed in so it can be shown once; only its hash is stored, so it
+// can't be shown again.
+func (app *application) renderAPITokens(w http.ResponseWriter, r *http.Request, form *forms.Form, newToken string) {
+  tokens, err := app.apiTokens.ListForUser(app.authenticatedUser(r).ID)
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  app.render(w, r, "tokens.page.tmpl", &templateData{
+    APITokens:   tokens,
+    Form:        form,
+    NewAPIToken: newToken,
+  })
+}
+
+// The createAPIToken handler creates an API token with the name the user gave
+// it, so they can tell their tokens apart.
+func (app *application) createAPIToken(w http.ResponseWriter, r *http.Request) {
+  err := r.ParseForm()
+
+  if err != nil {
+    app.clientError(w, http.StatusBadRequest)
+    return
+  }
+
+  form := forms.New(r.PostForm)
+  form.Required("name")
+  form.MaxLength("name", 100)
+
+  if !form.Valid() {
+    app.renderAPITokens(w, r, form, "")
+    return
+  }
+
+  random, err := randomToken()
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  token := apiTokenPrefix + random
+
+  id, err := app.apiTokens.Insert(app.authenticatedUser(r).ID, form.Get("name"), token)
+
+  if err != nil {
+    app.serverError(w, err)
+    return
+  }
+
+  app.audit(r, models.AuditAPITokenCreate, fmt.Sprintf("api_token:%d", id))
+
+  app.renderAPITokens(w, r, forms.New(nil), token)
+}
+
+// The revokeAPIToken handler deletes one of the user's API tokens. As with
+// sessions, the model scopes the delete to the current user, so other users'
+// tokens are simply not found.
+func (app *application) revokeAPIToken(w http.ResponseWriter, r *http.Request) {
+  id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+
+  if err != nil || id < 1 {
+    app.notFound(w)
+    return
+  }
+
+  err = app.apiTokens.Delete(id, app.authenticatedUser(r).ID)
+
+  if err != nil {
+    if errors.Is(err, models.ErrNoRecord) {
+      app.notFound(w)
+    } else {
+      app.serverError(w, err)
+    }
+
+    return
+  }
+
+  app.audit(r, models.AuditAPITokenRevoke, fmt.Sprintf("api_token:%d", id))
+
+  app.session.Put(r, "flash", "API token revoked.")
+  http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
@@ -0,0 +1,3 @@
+-- Argon2id hashes are stored as PHC strings, which are longer than the 60
+-- characters of a bcrypt hash.
+ALTER TABLE users MODIFY hashed_password VARCHAR(255) NOT NULL;
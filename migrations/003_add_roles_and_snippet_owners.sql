@@ -0,0 +1,8 @@
+-- Every user has a role: user, moderator or admin.
+ALTER TABLE users ADD role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+-- Snippets belong to the user who created them. Snippets created before this
+-- migration have no owner, so the column is nullable.
+ALTER TABLE snippets ADD user_id INTEGER NULL;
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+CREATE INDEX idx_snippets_user_id ON snippets(user_id);
@@ -0,0 +1,16 @@
+-- Long-lived "remember me" logins. The cookie carries a series identifier and
+-- a secret token; we store the SHA-256 hash of the token, which is replaced
+-- every time the series is used.
+CREATE TABLE remember_tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    series CHAR(43) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT remember_tokens_uc_series UNIQUE (series),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_remember_tokens_user_id ON remember_tokens(user_id);
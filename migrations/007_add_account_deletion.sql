@@ -0,0 +1,6 @@
+-- Users can ask for their account to be deleted. It is kept until delete_after
+-- has passed, so that they can change their mind, and keep_snippets records
+-- whether their snippets should be kept (without an owner) or deleted too.
+ALTER TABLE users ADD delete_after DATETIME NULL;
+ALTER TABLE users ADD keep_snippets BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX idx_users_delete_after ON users(delete_after);
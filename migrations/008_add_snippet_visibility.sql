@@ -0,0 +1,5 @@
+-- Snippets are public (listed on the home page), unlisted (visible to anybody
+-- with the link) or private (visible to their owner only). Existing snippets
+-- were all listed, so they stay public.
+ALTER TABLE snippets ADD visibility VARCHAR(10) NOT NULL DEFAULT 'public';
+CREATE INDEX idx_snippets_visibility_created ON snippets(visibility, created);
@@ -0,0 +1,7 @@
+-- Snippets are shown at /s/<slug>, where the slug is random, so that their
+-- URLs can't be guessed by counting through the numeric IDs. Existing snippets
+-- get a random slug of the same form (16 base64url characters).
+ALTER TABLE snippets ADD slug VARCHAR(22) NULL;
+UPDATE snippets SET slug = REPLACE(REPLACE(TO_BASE64(RANDOM_BYTES(12)), '+', '-'), '/', '_') WHERE slug IS NULL;
+ALTER TABLE snippets MODIFY slug VARCHAR(22) NOT NULL;
+CREATE UNIQUE INDEX idx_snippets_slug ON snippets(slug);
@@ -0,0 +1,4 @@
+-- The language a snippet is written in, used to highlight its syntax. Existing
+-- snippets have no language, so it is detected from their content when they
+-- are shown.
+ALTER TABLE snippets ADD language VARCHAR(20) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- The format a snippet's content is written in: 'plain' text, which is shown
+-- as it is, or 'markdown', which is rendered to HTML. Existing snippets are
+-- plain text.
+ALTER TABLE snippets ADD format VARCHAR(10) NOT NULL DEFAULT 'plain';
@@ -0,0 +1,21 @@
+-- Every version of a snippet, kept when it is created and each time it is
+-- edited, so that changes can be compared and undone. Revisions are never
+-- updated, and go when their snippet does.
+CREATE TABLE snippet_revisions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    number INTEGER NOT NULL,
+    user_id INTEGER NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    language VARCHAR(20) NOT NULL DEFAULT '',
+    format VARCHAR(10) NOT NULL DEFAULT 'plain',
+    created DATETIME NOT NULL,
+    CONSTRAINT snippet_revisions_uc_number UNIQUE (snippet_id, number),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Existing snippets start their history with what they are now.
+INSERT INTO snippet_revisions (snippet_id, number, user_id, title, content, language, format, created)
+SELECT id, 1, user_id, title, content, language, format, created FROM snippets;
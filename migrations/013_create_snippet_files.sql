@@ -0,0 +1,33 @@
+-- The files of each snippet, in order. The snippets table keeps a copy of the
+-- first file's content and language, so that lists and searches don't need to
+-- join.
+CREATE TABLE snippet_files (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    filename VARCHAR(100) NOT NULL DEFAULT '',
+    language VARCHAR(20) NOT NULL DEFAULT '',
+    content TEXT NOT NULL,
+    CONSTRAINT snippet_files_uc_position UNIQUE (snippet_id, position),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+-- The files of each revision, so that a revision can be restored with all of
+-- them.
+CREATE TABLE snippet_revision_files (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    revision_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    filename VARCHAR(100) NOT NULL DEFAULT '',
+    language VARCHAR(20) NOT NULL DEFAULT '',
+    content TEXT NOT NULL,
+    CONSTRAINT snippet_revision_files_uc_position UNIQUE (revision_id, position),
+    FOREIGN KEY (revision_id) REFERENCES snippet_revisions(id) ON DELETE CASCADE
+);
+
+-- Existing snippets, and their revisions, have one unnamed file.
+INSERT INTO snippet_files (snippet_id, position, filename, language, content)
+SELECT id, 0, '', language, content FROM snippets;
+
+INSERT INTO snippet_revision_files (revision_id, position, filename, language, content)
+SELECT id, 0, '', language, content FROM snippet_revisions;
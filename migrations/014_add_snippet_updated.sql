@@ -0,0 +1,9 @@
+-- When a snippet was last edited, for the Last-Modified header of its raw and
+-- download URLs. Existing snippets were last changed by their latest
+-- revision.
+ALTER TABLE snippets ADD updated DATETIME NULL;
+
+UPDATE snippets s SET updated = (SELECT MAX(r.created) FROM snippet_revisions r WHERE r.snippet_id = s.id);
+UPDATE snippets SET updated = created WHERE updated IS NULL;
+
+ALTER TABLE snippets MODIFY updated DATETIME NOT NULL;
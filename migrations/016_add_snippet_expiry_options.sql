@@ -0,0 +1,5 @@
+-- Snippets which never expire have a NULL expiry, and snippets can be deleted
+-- the first time they're read by somebody other than their owner.
+ALTER TABLE snippets MODIFY expires DATETIME NULL;
+
+ALTER TABLE snippets ADD burn_after_reading BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,6 @@
+-- Keep the hash a remember me token had before it was last rotated, and when
+-- it was rotated, so that requests racing with the rotation aren't mistaken
+-- for a copied cookie.
+ALTER TABLE remember_tokens ADD previous_token_hash CHAR(64) NOT NULL DEFAULT '';
+
+ALTER TABLE remember_tokens ADD rotated DATETIME NULL;
@@ -0,0 +1,14 @@
+-- Audit events hold IP addresses and user agents, so they are purged once
+-- they are older than -audit-retention. Let the purge through, but keep
+-- refusing to remove anything from the last 90 days.
+DROP TRIGGER audit_events_no_delete;
+
+DELIMITER //
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+FOR EACH ROW
+BEGIN
+    IF OLD.created > UTC_TIMESTAMP() - INTERVAL 90 DAY THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+    END IF;
+END//
+DELIMITER ;
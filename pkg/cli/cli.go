@@ -0,0 +1,610 @@
+// Package cli implements snippetbox, the command line client for a
+// snippetbox server. It talks to the server's JSON API, authenticating with
+// an API token created on the account page.
+package cli
+
+import (
+  "bufio"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "net/http"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "text/tabwriter"
+
+  "mateuszurbanski/snippetbox/pkg/highlight"
+)
+
+const usage = `Usage: snippetbox <command> [flags] [arguments]
+
+Commands:
+  login    Save the server and API token to use
+  create   Create a snippet from files, or standard input
+  list     List your snippets
+  search   Search the public snippets, and your own
+  show     Print a snippet
+  edit     Edit a snippet in $EDITOR
+  delete   Delete a snippet
+
+Run "snippetbox <command> -h" for the flags of a command. The server and
+token can also be set with SNIPPETBOX_SERVER and SNIPPETBOX_TOKEN.
+`
+
+// errUsage is returned by commands which were given the wrong arguments,
+// after their usage has been printed.
+var errUsage = errors.New("usage")
+
+// A CLI runs snippetbox commands. Its fields are the outside world, so that
+// tests can provide their own.
+type CLI struct {
+  Stdin      io.Reader
+  Stdout     io.Writer
+  Stderr     io.Writer
+  Getenv     func(string) string
+  ConfigPath string
+  HTTPClient *http.Client
+
+  // Edit opens the files in the user's editor, and returns once they have
+  // finished editing them.
+  Edit func(paths []string) error
+}
+
+// The New function returns a CLI using the process's standard streams,
+// environment and config file, and the editor named by $VISUAL or $EDITOR.
+func New() *CLI {
+  c := &CLI{
+    Stdin:      os.Stdin,
+    Stdout:     os.Stdout,
+    Stderr:     os.Stderr,
+    Getenv:     os.Getenv,
+    ConfigPath: DefaultConfigPath(),
+    HTTPClient: http.DefaultClient,
+  }
+
+  if path := os.Getenv("SNIPPETBOX_CONFIG"); path != "" {
+    c.ConfigPath = path
+  }
+
+  c.Edit = c.runEditor
+
+  return c
+}
+
+// Run runs the command given by args (without the program name), and returns
+// the exit status: 0 for success, 1 for errors and 2 for bad usage.
+func (c *CLI) Run(args []string) int {
+  if len(args) == 0 {
+    fmt.Fprint(c.Stderr, usage)
+    return 2
+  }
+
+  commands := map[string]func([]string) error{
+    "login":  c.login,
+    "create": c.create,
+    "list":   c.list,
+    "search": c.search,
+    "show":   c.show,
+    "edit":   c.edit,
+    "delete": c.delete,
+  }
+
+  name, args := args[0], args[1:]
+
+  if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+    fmt.Fprint(c.Stdout, usage)
+    return 0
+  }
+
+  command, ok := commands[name]
+  if !ok {
+    fmt.Fprintf(c.Stderr, "snippetbox: unknown command %q\n\n%s", name, usage)
+    return 2
+  }
+
+  err := command(args)
+
+  switch {
+  case err == nil:
+    return 0
+  case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+    return 2
+  default:
+    fmt.Fprintf(c.Stderr, "snippetbox %s: %s\n", name, err)
+    return 1
+  }
+}
+
+// The flags method returns the flag set for a command. Commands which print
+// snippets pass output, for the -o flag choosing the table or json format.
+func (c *CLI) flags(name, args string, output *string) *flag.FlagSet {
+  fs := flag.NewFlagSet(name, flag.ContinueOnError)
+  fs.SetOutput(c.Stderr)
+
+  fs.Usage = func() {
+    fmt.Fprintf(c.Stderr, "Usage: snippetbox %s [flags] %s\n\nFlags:\n", name, args)
+    fs.PrintDefaults()
+  }
+
+  if output != nil {
+    fs.StringVar(output, "o", "table", "Output format: table or json")
+  }
+
+  return fs
+}
+
+// The parse method parses a command's flags, checks the output format, and
+// returns an error if the number of arguments left isn't between min and max
+// (or at least min, if max is -1).
+func (c *CLI) parse(fs *flag.FlagSet, args []string, output *string, min, max int) error {
+  err := fs.Parse(args)
+  if err != nil {
+    return err
+  }
+
+  if output != nil && *output != "table" && *output != "json" {
+    fmt.Fprintf(c.Stderr, "invalid output format %q: want table or json\n", *output)
+    fs.Usage()
+    return errUsage
+  }
+
+  if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+    fs.Usage()
+    return errUsage
+  }
+
+  return nil
+}
+
+// The client method returns an API client for the server and token in the
+// config file, or the environment, which takes precedence.
+func (c *CLI) client() (*Client, error) {
+  cfg, err := loadConfig(c.ConfigPath)
+  if err != nil {
+    return nil, fmt.Errorf("reading %s: %w", c.ConfigPath, err)
+  }
+
+  if server := c.Getenv("SNIPPETBOX_SERVER"); server != "" {
+    cfg.Server = server
+  }
+
+  if token := c.Getenv("SNIPPETBOX_TOKEN"); token != "" {
+    cfg.Token = token
+  }
+
+  if cfg.Server == "" || cfg.Token == "" {
+    return nil, errors.New(`not logged in: run "snippetbox login" first`)
+  }
+
+  return &Client{Server: cfg.Server, Token: cfg.Token, HTTPClient: c.HTTPClient}, nil
+}
+
+// The printJSON method prints v as indented JSON.
+func (c *CLI) printJSON(v interface{}) error {
+  enc := json.NewEncoder(c.Stdout)
+  enc.SetIndent("", "  ")
+
+  return enc.Encode(v)
+}
+
+// The printTable method prints snippets as a table, one per line.
+func (c *CLI) printTable(snippets []*Snippet) error {
+  tw := tabwriter.NewWriter(c.Stdout, 0, 4, 2, ' ', 0)
+
+  fmt.Fprintln(tw, "SLUG\tVISIBILITY\tEXPIRES\tTITLE")
+
+  for _, s := range snippets {
+    expires := "never"
+    if s.Expires != nil {
+      expires = s.Expires.UTC().Format("2006-01-02 15:04")
+    }
+
+    if s.BurnAfterReading {
+      expires += " (burn)"
+    }
+
+    fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Slug, s.Visibility, expires, s.Title)
+  }
+
+  return tw.Flush()
+}
+
+// The slugArg function returns the slug of a snippet given on the command
+// line, which may be its slug or its URL.
+func slugArg(arg string) string {
+  if i := strings.Index(arg, "/s/"); i >= 0 {
+    arg = arg[i+len("/s/"):]
+  }
+
+  return strings.SplitN(strings.TrimRight(arg, "/"), "/", 2)[0]
+}
+
+// The login command saves the server to talk to and the API token to use. If
+// the token isn't given with -token, it is read from standard input, so that
+// it doesn't end up in the shell's history. It's checked with the server
+// before it's saved.
+func (c *CLI) login(args []string) error {
+  fs := c.flags("login", "", nil)
+  server := fs.String("server", "", "The URL of the server, e.g. https://snippetbox.example.com")
+  token := fs.String("token", "", "An API token (read from standard input if not given)")
+
+  err := c.parse(fs, args, nil, 0, 0)
+  if err != nil {
+    return err
+  }
+
+  if *server == "" {
+    fs.Usage()
+    return errUsage
+  }
+
+  if *token == "" {
+    fmt.Fprint(c.Stderr, "API token: ")
+
+    line, err := bufio.NewReader(c.Stdin).ReadString('\n')
+    if err != nil && err != io.EOF {
+      return err
+    }
+
+    *token = strings.TrimSpace(line)
+  }
+
+  client := &Client{Server: strings.TrimRight(*server, "/"), Token: *token, HTTPClient: c.HTTPClient}
+
+  _, err = client.List()
+  if err != nil {
+    return err
+  }
+
+  err = saveConfig(c.ConfigPath, &Config{Server: client.Server, Token: client.Token})
+  if err != nil {
+    return err
+  }
+
+  fmt.Fprintf(c.Stdout, "Logged in to %s.\n", client.Server)
+
+  return nil
+}
+
+// The create command creates a snippet from the files named on the command
+// line, or from standard input if there are none (or one of them is "-").
+func (c *CLI) create(args []string) error {
+  var output string
+
+  fs := c.flags("create", "[file ...]", &output)
+  title := fs.String("title", "", "The title (defaults to the name of the first file)")
+  expires := fs.String("expires", "", "When the snippet expires: a duration (90m, 12h, 7d), a date (2030-12-31) or never")
+  burn := fs.Bool("burn", false, "Delete the snippet the first time somebody else reads it")
+  visibility := fs.String("visibility", "", "public, unlisted or private")
+  format := fs.String("format", "", "plain or markdown")
+  language := fs.String("language", "", "The language of the files (detected if not given)")
+
+  err := c.parse(fs, args, &output, 0, -1)
+  if err != nil {
+    return err
+  }
+
+  names := fs.Args()
+  if len(names) == 0 {
+    names = []string{"-"}
+  }
+
+  req := &SnippetRequest{
+    Title:            *title,
+    Expires:          *expires,
+    Visibility:       *visibility,
+    Format:           *format,
+    BurnAfterReading: *burn,
+  }
+
+  for _, name := range names {
+    f := &File{Language: *language}
+
+    var b []byte
+    if name == "-" {
+      b, err = io.ReadAll(c.Stdin)
+    } else {
+      b, err = os.ReadFile(name)
+      f.Filename = filepath.Base(name)
+    }
+
+    if err != nil {
+      return err
+    }
+
+    f.Content = string(b)
+    req.Files = append(req.Files, f)
+  }
+
+  if req.Title == "" {
+    req.Title = "Untitled"
+    if req.Files[0].Filename != "" {
+      req.Title = req.Files[0].Filename
+    }
+  }
+
+  client, err := c.client()
+  if err != nil {
+    return err
+  }
+
+  created, err := client.Create(req)
+  if err != nil {
+    return err
+  }
+
+  if output == "json" {
+    return c.printJSON(created)
+  }
+
+  fmt.Fprintln(c.Stdout, created.URL)
+
+  return nil
+}
+
+// The list command lists the user's snippets.
+func (c *CLI) list(args []string) error {
+  var output string
+
+  fs := c.flags("list", "", &output)
+
+  err := c.parse(fs, args, &output, 0, 0)
+  if err != nil {
+    return err
+  }
+
+  client, err := c.client()
+  if err != nil {
+    return err
+  }
+
+  snippets, err := client.List()
+  if err != nil {
+    return err
+  }
+
+  if output == "json" {
+    return c.printJSON(snippets)
+  }
+
+  return c.printTable(snippets)
+}
+
+// The search command searches the public snippets, and the user's own, a page
+// at a time.
+func (c *CLI) search(args []string) error {
+  var output string
+
+  fs := c.flags("search", "<query>", &output)
+  page := fs.Int("page", 1, "The page of results to show")
+
+  err := c.parse(fs, args, &output, 1, -1)
+  if err != nil {
+    return err
+  }
+
+  client, err := c.client()
+  if err != nil {
+    return err
+  }
+
+  result, err := client.Search(strings.Join(fs.Args(), " "), *page)
+  if err != nil {
+    return err
+  }
+
+  if output == "json" {
+    return c.printJSON(result)
+  }
+
+  err = c.printTable(result.Snippets)
+  if err != nil {
+    return err
+  }
+
+  fmt.Fprintf(c.Stdout, "\nPage %d: %d of %d results.\n", result.Page, len(result.Snippets), result.Total)
+
+  return nil
+}
+
+// The show command prints a snippet's content. Each file of a snippet with
+// several is printed after a "==> name <==" line, like the raw URL does.
+func (c *CLI) show(args []string) error {
+  var output string
+
+  fs := c.flags("show", "<slug or URL>", &output)
+
+  err := c.parse(fs, args, &output, 1, 1)
+  if err != nil {
+    return err
+  }
+
+  client, err := c.client()
+  if err != nil {
+    return err
+  }
+
+  s, err := client.Get(slugArg(fs.Arg(0)))
+  if err != nil {
+    return err
+  }
+
+  if output == "json" {
+    return c.printJSON(s)
+  }
+
+  for i, f := range s.Files {
+    if len(s.Files) > 1 {
+      if i > 0 {
+        fmt.Fprintln(c.Stdout)
+      }
+
+      fmt.Fprintf(c.Stdout, "==> %s <==\n", f.Filename)
+    }
+
+    fmt.Fprint(c.Stdout, f.Content)
+
+    if !strings.HasSuffix(f.Content, "\n") {
+      fmt.Fprintln(c.Stdout)
+    }
+  }
+
+  return nil
+}
+
+// The edit command opens the files of a snippet in the user's editor, and
+// saves them as a new revision if they were changed. Files which are emptied
+// are removed from the snippet.
+func (c *CLI) edit(args []string) error {
+  var output string
+
+  fs := c.flags("edit", "<slug or URL>", &output)
+  title := fs.String("title", "", "A new title")
+
+  err := c.parse(fs, args, &output, 1, 1)
+  if err != nil {
+    return err
+  }
+
+  client, err := c.client()
+  if err != nil {
+    return err
+  }
+
+  slug := slugArg(fs.Arg(0))
+
+  s, err := client.Get(slug)
+  if err != nil {
+    return err
+  }
+
+  dir, err := os.MkdirTemp("", "snippetbox-")
+  if err != nil {
+    return err
+  }
+  defer os.RemoveAll(dir)
+
+  var paths []string
+
+  for _, f := range s.Files {
+    // An unnamed file is given one, so the editor can recognise its
+    // language.
+    name := f.Filename
+    if name == "" {
+      name = "snippet" + highlight.Extension(f.Language)
+      if s.Format == "markdown" {
+        name = "snippet.md"
+      }
+    }
+
+    p := filepath.Join(dir, name)
+    paths = append(paths, p)
+
+    err = os.WriteFile(p, []byte(f.Content), 0600)
+    if err != nil {
+      return err
+    }
+  }
+
+  err = c.Edit(paths)
+  if err != nil {
+    return fmt.Errorf("running the editor: %w", err)
+  }
+
+  req := &SnippetRequest{Title: *title}
+  changed := *title != "" && *title != s.Title
+
+  for i, p := range paths {
+    b, err := os.ReadFile(p)
+    if err != nil {
+      return err
+    }
+
+    f := &File{Filename: s.Files[i].Filename, Language: s.Files[i].Language, Content: string(b)}
+
+    if f.Content != s.Files[i].Content {
+      changed = true
+    }
+
+    if strings.TrimSpace(f.Content) != "" {
+      req.Files = append(req.Files, f)
+    }
+  }
+
+  if !changed {
+    fmt.Fprintln(c.Stderr, "No changes to save.")
+    return nil
+  }
+
+  if len(req.Files) == 0 {
+    return errors.New(`every file is empty: use "snippetbox delete" to delete the snippet`)
+  }
+
+  s, err = client.Update(slug, req)
+  if err != nil {
+    return err
+  }
+
+  if output == "json" {
+    return c.printJSON(s)
+  }
+
+  fmt.Fprintln(c.Stdout, s.URL)
+
+  return nil
+}
+
+// The runEditor method opens paths in the editor named by $VISUAL or
+// $EDITOR, or vi if neither is set. The variable may include arguments, like
+// "code --wait".
+func (c *CLI) runEditor(paths []string) error {
+  editor := c.Getenv("VISUAL")
+  if editor == "" {
+    editor = c.Getenv("EDITOR")
+  }
+
+  if editor == "" {
+    editor = "vi"
+  }
+
+  fields := strings.Fields(editor)
+
+  cmd := exec.Command(fields[0], append(fields[1:], paths...)...)
+  cmd.Stdin = c.Stdin
+  cmd.Stdout = c.Stdout
+  cmd.Stderr = c.Stderr
+
+  return cmd.Run()
+}
+
+// The delete command deletes snippets.
+func (c *CLI) delete(args []string) error {
+  fs := c.flags("delete", "<slug or URL> ...", nil)
+
+  err := c.parse(fs, args, nil, 1, -1)
+  if err != nil {
+    return err
+  }
+
+  client, err := c.client()
+  if err != nil {
+    return err
+  }
+
+  for _, arg := range fs.Args() {
+    slug := slugArg(arg)
+
+    err = client.Delete(slug)
+    if err != nil {
+      return fmt.Errorf("%s: %w", slug, err)
+    }
+
+    fmt.Fprintf(c.Stdout, "Deleted %s.\n", slug)
+  }
+
+  return nil
+}
@@ -0,0 +1,229 @@
+package cli
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "sort"
+  "strings"
+  "time"
+)
+
+// A Snippet is a snippet as the server's API sends it. Lists of snippets
+// don't include the files. Expires is nil if the snippet never expires.
+type Snippet struct {
+  Slug             string     `json:"slug"`
+  Title            string     `json:"title"`
+  URL              string     `json:"url"`
+  RawURL           string     `json:"raw_url"`
+  Visibility       string     `json:"visibility"`
+  Format           string     `json:"format"`
+  Created          time.Time  `json:"created"`
+  Updated          time.Time  `json:"updated"`
+  Expires          *time.Time `json:"expires"`
+  BurnAfterReading bool       `json:"burn_after_reading"`
+  Files            []*File    `json:"files,omitempty"`
+}
+
+// A File is one of the files of a snippet. A snippet with a single file
+// doesn't need to name it.
+type File struct {
+  Filename string `json:"filename,omitempty"`
+  Language string `json:"language,omitempty"`
+  Content  string `json:"content"`
+}
+
+// A SnippetRequest is the body of a request to create or edit a snippet.
+// Empty fields get the server's defaults when creating a snippet, and are
+// left as they are when editing one. Expires is a duration (like "90m",
+// "12h" or "7d"), a date (like "2030-12-31") or "never".
+type SnippetRequest struct {
+  Title            string  `json:"title,omitempty"`
+  Expires          string  `json:"expires,omitempty"`
+  Visibility       string  `json:"visibility,omitempty"`
+  Format           string  `json:"format,omitempty"`
+  BurnAfterReading bool    `json:"burn_after_reading,omitempty"`
+  Files            []*File `json:"files,omitempty"`
+}
+
+// A Created is the server's answer to a request which created a snippet.
+type Created struct {
+  Slug   string `json:"slug"`
+  URL    string `json:"url"`
+  RawURL string `json:"raw_url"`
+}
+
+// A SearchResult is a page of the snippets matching a search.
+type SearchResult struct {
+  Snippets []*Snippet `json:"snippets"`
+  Total    int        `json:"total"`
+  Page     int        `json:"page"`
+}
+
+// An APIError is an error response from the server. Fields holds the problems
+// with each field of the request, if it failed validation.
+type APIError struct {
+  Status  int
+  Message string              `json:"error"`
+  Fields  map[string][]string `json:"errors"`
+}
+
+func (e *APIError) Error() string {
+  if len(e.Fields) > 0 {
+    var fields []string
+    for field := range e.Fields {
+      fields = append(fields, field)
+    }
+
+    sort.Strings(fields)
+
+    var problems []string
+    for _, field := range fields {
+      problems = append(problems, fmt.Sprintf("%s: %s", field, strings.Join(e.Fields[field], "; ")))
+    }
+
+    return strings.Join(problems, ", ")
+  }
+
+  if e.Message != "" {
+    return e.Message
+  }
+
+  return fmt.Sprintf("the server responded %d %s", e.Status, http.StatusText(e.Status))
+}
+
+// A Client talks to the JSON API of a snippetbox server, authenticating with
+// an API token.
+type Client struct {
+  Server     string
+  Token      string
+  HTTPClient *http.Client
+}
+
+// The do method sends a request to the API, with in (if it isn't nil) as the
+// JSON body, and decodes the JSON response into out (if it isn't nil).
+// Responses other than 2xx are returned as an *APIError.
+func (c *Client) do(method, path string, in, out interface{}) error {
+  var body io.Reader
+
+  if in != nil {
+    js, err := json.Marshal(in)
+    if err != nil {
+      return err
+    }
+
+    body = bytes.NewReader(js)
+  }
+
+  req, err := http.NewRequest(method, strings.TrimRight(c.Server, "/")+path, body)
+  if err != nil {
+    return err
+  }
+
+  req.Header.Set("Accept", "application/json")
+  req.Header.Set("Authorization", "Bearer "+c.Token)
+
+  if in != nil {
+    req.Header.Set("Content-Type", "application/json")
+  }
+
+  httpClient := c.HTTPClient
+  if httpClient == nil {
+    httpClient = http.DefaultClient
+  }
+
+  rs, err := httpClient.Do(req)
+  if err != nil {
+    return err
+  }
+  defer rs.Body.Close()
+
+  if rs.StatusCode < 200 || rs.StatusCode > 299 {
+    apiErr := &APIError{Status: rs.StatusCode}
+
+    // The body of an error is only a hint; if it isn't JSON, the status
+    // says enough.
+    json.NewDecoder(rs.Body).Decode(apiErr)
+
+    return apiErr
+  }
+
+  if out == nil || rs.StatusCode == http.StatusNoContent {
+    return nil
+  }
+
+  return json.NewDecoder(rs.Body).Decode(out)
+}
+
+// List returns the user's own snippets, newest first.
+func (c *Client) List() ([]*Snippet, error) {
+  var result struct {
+    Snippets []*Snippet `json:"snippets"`
+  }
+
+  err := c.do(http.MethodGet, "/api/snippets", nil, &result)
+  if err != nil {
+    return nil, err
+  }
+
+  return result.Snippets, nil
+}
+
+// Search returns a page (counting from 1) of the public snippets, and the
+// user's own, matching query.
+func (c *Client) Search(query string, page int) (*SearchResult, error) {
+  params := url.Values{"q": {query}, "page": {fmt.Sprint(page)}}
+
+  result := &SearchResult{}
+
+  err := c.do(http.MethodGet, "/api/search?"+params.Encode(), nil, result)
+  if err != nil {
+    return nil, err
+  }
+
+  return result, nil
+}
+
+// Get returns a snippet, with its files.
+func (c *Client) Get(slug string) (*Snippet, error) {
+  s := &Snippet{}
+
+  err := c.do(http.MethodGet, "/api/snippets/"+url.PathEscape(slug), nil, s)
+  if err != nil {
+    return nil, err
+  }
+
+  return s, nil
+}
+
+// Create creates a snippet.
+func (c *Client) Create(req *SnippetRequest) (*Created, error) {
+  created := &Created{}
+
+  err := c.do(http.MethodPost, "/api/snippets", req, created)
+  if err != nil {
+    return nil, err
+  }
+
+  return created, nil
+}
+
+// Update saves an edit of a snippet, and returns the snippet as it now is.
+func (c *Client) Update(slug string, req *SnippetRequest) (*Snippet, error) {
+  s := &Snippet{}
+
+  err := c.do(http.MethodPut, "/api/snippets/"+url.PathEscape(slug), req, s)
+  if err != nil {
+    return nil, err
+  }
+
+  return s, nil
+}
+
+// Delete deletes a snippet.
+func (c *Client) Delete(slug string) error {
+  return c.do(http.MethodDelete, "/api/snippets/"+url.PathEscape(slug), nil, nil)
+}
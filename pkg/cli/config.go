@@ -0,0 +1,63 @@
+package cli
+
+import (
+  "encoding/json"
+  "errors"
+  "os"
+  "path/filepath"
+)
+
+// A Config holds the server to talk to and the API token to use, saved by the
+// login command so that they don't have to be given every time.
+type Config struct {
+  Server string `json:"server"`
+  Token  string `json:"token"`
+}
+
+// The DefaultConfigPath function returns where the config is kept:
+// snippetbox/config.json in the user's config directory (like ~/.config on
+// Linux).
+func DefaultConfigPath() string {
+  dir, err := os.UserConfigDir()
+  if err != nil {
+    return ".snippetbox.json"
+  }
+
+  return filepath.Join(dir, "snippetbox", "config.json")
+}
+
+// The loadConfig function reads the config at path. A missing file is an
+// empty config, since the user hasn't logged in yet.
+func loadConfig(path string) (*Config, error) {
+  cfg := &Config{}
+
+  b, err := os.ReadFile(path)
+  if errors.Is(err, os.ErrNotExist) {
+    return cfg, nil
+  } else if err != nil {
+    return nil, err
+  }
+
+  err = json.Unmarshal(b, cfg)
+  if err != nil {
+    return nil, err
+  }
+
+  return cfg, nil
+}
+
+// The saveConfig function writes the config to path. The file holds the API
+// token, so only the user may read it.
+func saveConfig(path string, cfg *Config) error {
+  err := os.MkdirAll(filepath.Dir(path), 0700)
+  if err != nil {
+    return err
+  }
+
+  b, err := json.MarshalIndent(cfg, "", "  ")
+  if err != nil {
+    return err
+  }
+
+  return os.WriteFile(path, append(b, '\n'), 0600)
+}
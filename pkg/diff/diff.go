@@ -0,0 +1,204 @@
+// Package diff compares two texts line by line, and presents the differences
+// as unified hunks or side-by-side rows. It uses Myers' algorithm, which finds
+// the smallest set of inserted and deleted lines.
+package diff
+
+import (
+  "strings"
+)
+
+// The kinds of line in a diff.
+const (
+  Equal  = "equal"
+  Delete = "delete"
+  Insert = "insert"
+)
+
+// MaxEdits is the largest number of inserted and deleted lines the algorithm
+// looks for. Texts which differ by more than that are shown as one text
+// replaced by the other, which bounds the time and memory a diff takes.
+const MaxEdits = 1000
+
+// A Line is a line of one or both texts. OldNumber and NewNumber are its line
+// numbers (from 1) in the old and new text, or 0 if it isn't in that text.
+type Line struct {
+  Kind      string
+  Text      string
+  OldNumber int
+  NewNumber int
+}
+
+// Lines returns the lines of the diff from a to b: every line of both texts,
+// marked as equal, deleted from a or inserted in b.
+func Lines(a, b string) []Line {
+  old, new := split(a), split(b)
+
+  // Lines which are the same at the start and end of both texts aren't
+  // part of the problem, which keeps it small for the usual small edits.
+  prefix := 0
+  for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+    prefix++
+  }
+
+  suffix := 0
+  for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+    suffix++
+  }
+
+  var lines []Line
+
+  for i := 0; i < prefix; i++ {
+    lines = append(lines, Line{Equal, old[i], i + 1, i + 1})
+  }
+
+  for _, e := range myers(old[prefix:len(old)-suffix], new[prefix:len(new)-suffix]) {
+    l := Line{Kind: e.kind}
+
+    if e.kind != Insert {
+      l.Text, l.OldNumber = old[prefix+e.old], prefix+e.old+1
+    }
+
+    if e.kind != Delete {
+      l.Text, l.NewNumber = new[prefix+e.new], prefix+e.new+1
+    }
+
+    lines = append(lines, l)
+  }
+
+  for i := suffix; i > 0; i-- {
+    lines = append(lines, Line{Equal, old[len(old)-i], len(old) - i + 1, len(new) - i + 1})
+  }
+
+  return lines
+}
+
+// The split function splits a text into lines, without their line endings.
+func split(s string) []string {
+  if s == "" {
+    return nil
+  }
+
+  s = strings.ReplaceAll(s, "\r\n", "\n")
+
+  return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// An edit is one step of the shortest edit script: a line kept, deleted from
+// the old text or inserted from the new one. old and new index the lines.
+type edit struct {
+  kind string
+  old  int
+  new  int
+}
+
+// The myers function returns the shortest edit script turning a into b. See
+// "An O(ND) Difference Algorithm and Its Variations" (Myers, 1986).
+func myers(a, b []string) []edit {
+  n, m := len(a), len(b)
+  max := n + m
+  if max > MaxEdits {
+    max = MaxEdits
+  }
+
+  // v[k] holds the furthest x reached on diagonal k (offset by max+1), and
+  // trace holds a copy of v for each number of edits d, to walk back through.
+  offset := max + 1
+  v := make([]int, 2*max+3)
+
+  var trace [][]int
+
+  for d := 0; d <= max; d++ {
+    trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+    for k := -d; k <= d; k += 2 {
+      var x int
+      if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+        x = v[offset+k+1]
+      } else {
+        x = v[offset+k-1] + 1
+      }
+
+      y := x - k
+      for x < n && y < m && a[x] == b[y] {
+        x, y = x+1, y+1
+      }
+
+      v[offset+k] = x
+
+      if x >= n && y >= m {
+        return backtrack(trace, n, m)
+      }
+    }
+  }
+
+  // Too many edits: delete everything, then insert everything.
+  var edits []edit
+
+  for i := range a {
+    edits = append(edits, edit{Delete, i, 0})
+  }
+
+  for j := range b {
+    edits = append(edits, edit{Insert, 0, j})
+  }
+
+  return edits
+}
+
+// The backtrack function walks back from (n, m) through the saved copies of v,
+// to recover the edits which got there.
+func backtrack(trace [][]int, n, m int) []edit {
+  var edits []edit
+
+  x, y := n, m
+
+  for d := len(trace) - 1; d >= 0; d-- {
+    // trace[d] holds v before step d, for diagonals -d-1 to d+1.
+    v := trace[d]
+    get := func(k int) int { return v[k+d+1] }
+
+    k := x - y
+
+    var prevK int
+    if k == -d || (k != d && get(k-1) < get(k+1)) {
+      prevK = k + 1
+    } else {
+      prevK = k - 1
+    }
+
+    prevX := get(prevK)
+    prevY := prevX - prevK
+
+    for x > prevX && y > prevY {
+      x, y = x-1, y-1
+      edits = append(edits, edit{Equal, x, y})
+    }
+
+    if d > 0 {
+      if x == prevX {
+        edits = append(edits, edit{Insert, x, prevY})
+      } else {
+        edits = append(edits, edit{Delete, prevX, y})
+      }
+    }
+
+    x, y = prevX, prevY
+  }
+
+  for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+    edits[i], edits[j] = edits[j], edits[i]
+  }
+
+  return edits
+}
+
+// Changed returns true if any of the lines was inserted or deleted.
+func Changed(lines []Line) bool {
+  for _, l := range lines {
+    if l.Kind != Equal {
+      return true
+    }
+  }
+
+  return false
+}
@@ -0,0 +1,178 @@
+package diff
+
+import (
+    "fmt"
+    "math/rand"
+    "strings"
+    "testing"
+)
+
+// The unified function formats hunks like diff -u, to compare against.
+func unified(hunks []Hunk) string {
+    var b strings.Builder
+
+    for _, h := range hunks {
+        b.WriteString(h.Header() + "\n")
+
+        for _, l := range h.Lines {
+            switch l.Kind {
+            case Equal:
+                b.WriteString(" ")
+            case Delete:
+                b.WriteString("-")
+            case Insert:
+                b.WriteString("+")
+            }
+
+            b.WriteString(l.Text + "\n")
+        }
+    }
+
+    return b.String()
+}
+
+func TestHunks(t *testing.T) {
+    tests := []struct {
+        name string
+        a    string
+        b    string
+        want string
+    }{
+        {"Identical", "a\nb\n", "a\nb\n", ""},
+        {"Both empty", "", "", ""},
+        {"Insert at end", "a\nb\nc\nd\ne\n", "a\nb\nc\nd\ne\nf\n", "@@ -3,3 +3,4 @@\n c\n d\n e\n+f\n"},
+        {"Delete in the middle", "a\nb\nc\nd\ne\nf\ng\n", "a\nb\nc\ne\nf\ng\n", "@@ -1,7 +1,6 @@\n a\n b\n c\n-d\n e\n f\n g\n"},
+        {"Replace", "a\nb\nc\n", "a\nx\nc\n", "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+        {"From empty", "", "a\nb\n", "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+        {"To empty", "a\nb\n", "", "@@ -1,2 +0,0 @@\n-a\n-b\n"},
+        {"Missing final newline", "a\nb", "a\nb\n", ""},
+        {"Windows line endings", "a\r\nb\r\n", "a\nb\n", ""},
+        {
+            "Changes far apart get their own hunks",
+            "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+            "1\nX\n3\n4\n5\n6\n7\n8\n9\n10\nY\n12\n",
+            "@@ -1,5 +1,5 @@\n 1\n-2\n+X\n 3\n 4\n 5\n@@ -8,5 +8,5 @@\n 8\n 9\n 10\n-11\n+Y\n 12\n",
+        },
+        {
+            "Changes close together share a hunk",
+            "1\n2\n3\n4\n5\n6\n7\n8\n",
+            "1\nX\n3\n4\n5\n6\nY\n8\n",
+            "@@ -1,8 +1,8 @@\n 1\n-2\n+X\n 3\n 4\n 5\n 6\n-7\n+Y\n 8\n",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := unified(Hunks(Lines(tt.a, tt.b), 3))
+
+            if got != tt.want {
+                t.Errorf("want\n%s\ngot\n%s", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestLinesIsMinimal(t *testing.T) {
+    r := rand.New(rand.NewSource(1))
+
+    random := func() []string {
+        lines := make([]string, r.Intn(12))
+        for i := range lines {
+            lines[i] = string(rune('a' + r.Intn(4)))
+        }
+
+        return lines
+    }
+
+    for i := 0; i < 500; i++ {
+        a, b := random(), random()
+        lines := Lines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+
+        var old, new []string
+        edits := 0
+
+        for _, l := range lines {
+            if l.Kind != Insert {
+                old = append(old, l.Text)
+            }
+            if l.Kind != Delete {
+                new = append(new, l.Text)
+            }
+            if l.Kind != Equal {
+                edits++
+            }
+        }
+
+        if fmt.Sprint(old) != fmt.Sprint(a) || fmt.Sprint(new) != fmt.Sprint(b) {
+            t.Fatalf("diff of %q and %q doesn't rebuild them: %+v", a, b, lines)
+        }
+
+        // The fewest edits is the lines of both which aren't in their longest
+        // common subsequence.
+        if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+            t.Fatalf("diff of %q and %q: want %d edits; got %d", a, b, want, edits)
+        }
+    }
+}
+
+func lcs(a, b []string) int {
+    dp := make([][]int, len(a)+1)
+    for i := range dp {
+        dp[i] = make([]int, len(b)+1)
+    }
+
+    for i := len(a) - 1; i >= 0; i-- {
+        for j := len(b) - 1; j >= 0; j-- {
+            switch {
+            case a[i] == b[j]:
+                dp[i][j] = dp[i+1][j+1] + 1
+            case dp[i+1][j] > dp[i][j+1]:
+                dp[i][j] = dp[i+1][j]
+            default:
+                dp[i][j] = dp[i][j+1]
+            }
+        }
+    }
+
+    return dp[0][0]
+}
+
+func TestLinesTooManyEdits(t *testing.T) {
+    var a, b []string
+    for i := 0; i < MaxEdits; i++ {
+        a = append(a, fmt.Sprintf("old %d", i))
+        b = append(b, fmt.Sprintf("new %d", i))
+    }
+
+    lines := Lines("same\n"+strings.Join(a, "\n"), "same\n"+strings.Join(b, "\n"))
+
+    if len(lines) != 1+2*MaxEdits {
+        t.Fatalf("want %d lines; got %d", 1+2*MaxEdits, len(lines))
+    }
+
+    if lines[0].Kind != Equal || lines[1].Kind != Delete || lines[len(lines)-1].Kind != Insert {
+        t.Errorf("want the old text replaced by the new one")
+    }
+}
+
+func TestSideBySide(t *testing.T) {
+    rows := SideBySide(Lines("a\nb\nc\nd\n", "a\nx\ny\nz\nd\n"))
+
+    var got []string
+    for _, r := range rows {
+        old, new := "-", "-"
+        if r.Old != nil {
+            old = r.Old.Text
+        }
+        if r.New != nil {
+            new = r.New.Text
+        }
+
+        got = append(got, old+"|"+new)
+    }
+
+    want := "a|a b|x c|y -|z d|d"
+    if strings.Join(got, " ") != want {
+        t.Errorf("want %s; got %s", want, strings.Join(got, " "))
+    }
+}
@@ -0,0 +1,165 @@
+package diff
+
+import (
+  "fmt"
+)
+
+// A Hunk is a run of changed lines, with a few unchanged lines either side
+// for context, as in the output of diff -u.
+type Hunk struct {
+  OldStart int
+  OldLines int
+  NewStart int
+  NewLines int
+  Lines    []Line
+}
+
+// Header returns the hunk's range line, e.g. "@@ -3,7 +3,8 @@".
+func (h Hunk) Header() string {
+  return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Hunks groups the lines of a diff into hunks, keeping context unchanged lines
+// around each change. Changes closer together than that share a hunk.
+func Hunks(lines []Line, context int) []Hunk {
+  var hunks []Hunk
+
+  for i := 0; i < len(lines); {
+    if lines[i].Kind == Equal {
+      i++
+      continue
+    }
+
+    start := i - context
+    if start < 0 {
+      start = 0
+    }
+
+    // Carry on past the change while the next one is close enough to join
+    // this hunk.
+    end := i
+    for end < len(lines) {
+      if lines[end].Kind != Equal {
+        end++
+        continue
+      }
+
+      next := end
+      for next < len(lines) && lines[next].Kind == Equal {
+        next++
+      }
+
+      if next == len(lines) || next-end > 2*context {
+        break
+      }
+
+      end = next
+    }
+
+    stop := end + context
+    if stop > len(lines) {
+      stop = len(lines)
+    }
+
+    hunks = append(hunks, newHunk(lines, start, stop))
+    i = stop
+  }
+
+  return hunks
+}
+
+// The newHunk function makes a hunk of lines[start:stop], working out the
+// ranges it covers in both texts.
+func newHunk(lines []Line, start, stop int) Hunk {
+  h := Hunk{Lines: lines[start:stop]}
+
+  // A range which is empty in one of the texts starts at the line before it,
+  // as in diff -u.
+  oldBefore, newBefore := 0, 0
+  for _, l := range lines[:start] {
+    if l.OldNumber > 0 {
+      oldBefore = l.OldNumber
+    }
+    if l.NewNumber > 0 {
+      newBefore = l.NewNumber
+    }
+  }
+
+  for _, l := range h.Lines {
+    if l.OldNumber > 0 {
+      if h.OldLines == 0 {
+        h.OldStart = l.OldNumber
+      }
+      h.OldLines++
+    }
+
+    if l.NewNumber > 0 {
+      if h.NewLines == 0 {
+        h.NewStart = l.NewNumber
+      }
+      h.NewLines++
+    }
+  }
+
+  if h.OldLines == 0 {
+    h.OldStart = oldBefore
+  }
+
+  if h.NewLines == 0 {
+    h.NewStart = newBefore
+  }
+
+  return h
+}
+
+// A Row is a line of a side-by-side diff. Old is the line from the old text,
+// New the one from the new text; either may be nil where a line was inserted
+// or deleted.
+type Row struct {
+  Old *Line
+  New *Line
+}
+
+// Rows lines up the hunk for showing side by side. Unchanged lines appear on
+// both sides, and each run of deleted lines is paired with the run of inserted
+// lines which replaced it.
+func (h Hunk) Rows() []Row {
+  return SideBySide(h.Lines)
+}
+
+// SideBySide lines up the lines of a diff in rows, for showing the two texts
+// next to each other.
+func SideBySide(lines []Line) []Row {
+  var rows []Row
+
+  for i := 0; i < len(lines); {
+    if lines[i].Kind == Equal {
+      rows = append(rows, Row{&lines[i], &lines[i]})
+      i++
+      continue
+    }
+
+    var deleted, inserted []*Line
+    for ; i < len(lines) && lines[i].Kind != Equal; i++ {
+      if lines[i].Kind == Delete {
+        deleted = append(deleted, &lines[i])
+      } else {
+        inserted = append(inserted, &lines[i])
+      }
+    }
+
+    for j := 0; j < len(deleted) || j < len(inserted); j++ {
+      var row Row
+      if j < len(deleted) {
+        row.Old = deleted[j]
+      }
+      if j < len(inserted) {
+        row.New = inserted[j]
+      }
+
+      rows = append(rows, row)
+    }
+  }
+
+  return rows
+}
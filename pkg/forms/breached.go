@@ -0,0 +1,117 @@
+package forms
+
+import (
+  "bufio"
+  "crypto/sha1"
+  _ "embed"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// The bundled list of the most common passwords seen in public breaches, one
+// per line. It doubles as the dictionary used to estimate password strength.
+//go:embed common-passwords.txt
+var commonPasswords string
+
+// A BreachRange answers k-anonymity range queries in the style of the Have I
+// Been Pwned Passwords API. Given the first five hex characters of a password's
+// SHA-1 hash, it returns every known breached hash with that prefix as lines of
+// "<remaining 35 characters>:<count>". The full hash (let alone the password)
+// never has to leave the application.
+type BreachRange interface {
+  Range(prefix string) (io.ReadCloser, error)
+}
+
+// Breached reports how many times password has been seen in a breach,
+// according to source.
+func Breached(source BreachRange, password string) (int, error) {
+  sum := sha1.Sum([]byte(password))
+  hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+  body, err := source.Range(hash[:5])
+  if err != nil {
+    return 0, err
+  }
+  defer body.Close()
+
+  scanner := bufio.NewScanner(body)
+
+  for scanner.Scan() {
+    parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+    if len(parts) != 2 || !strings.EqualFold(parts[0], hash[5:]) {
+      continue
+    }
+
+    count, err := strconv.Atoi(parts[1])
+    if err != nil {
+      return 0, fmt.Errorf("forms: malformed breach range line %q", scanner.Text())
+    }
+
+    return count, nil
+  }
+
+  return 0, scanner.Err()
+}
+
+// LocalBreachList is a stand-in for the Have I Been Pwned range API, answering
+// queries from the bundled list of common passwords. It lets us reject the
+// worst passwords without any network access.
+type LocalBreachList struct {
+  ranges map[string][]string
+}
+
+// NewLocalBreachList builds a LocalBreachList from the bundled password list.
+func NewLocalBreachList() *LocalBreachList {
+  l := &LocalBreachList{ranges: map[string][]string{}}
+
+  for _, password := range strings.Split(commonPasswords, "\n") {
+    password = strings.TrimSpace(password)
+    if password == "" {
+      continue
+    }
+
+    sum := sha1.Sum([]byte(password))
+    hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+    l.ranges[hash[:5]] = append(l.ranges[hash[:5]], hash[5:]+":1")
+  }
+
+  return l
+}
+
+func (l *LocalBreachList) Range(prefix string) (io.ReadCloser, error) {
+  prefix = strings.ToUpper(prefix)
+
+  return io.NopCloser(strings.NewReader(strings.Join(l.ranges[prefix], "\r\n"))), nil
+}
+
+// PwnedPasswordsAPI queries a range API over HTTP, such as the real Have I Been
+// Pwned service at https://api.pwnedpasswords.com.
+type PwnedPasswordsAPI struct {
+  URL    string
+  Client *http.Client
+}
+
+func (p *PwnedPasswordsAPI) Range(prefix string) (io.ReadCloser, error) {
+  client := p.Client
+  if client == nil {
+    client = &http.Client{Timeout: 5 * time.Second}
+  }
+
+  rs, err := client.Get(strings.TrimSuffix(p.URL, "/") + "/range/" + prefix)
+  if err != nil {
+    return nil, err
+  }
+
+  if rs.StatusCode != http.StatusOK {
+    rs.Body.Close()
+    return nil, fmt.Errorf("forms: breach range API returned %s", rs.Status)
+  }
+
+  return rs.Body, nil
+}
@@ -0,0 +1,113 @@
+package forms
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestLocalBreachList(t *testing.T) {
+    list := NewLocalBreachList()
+
+    tests := []struct {
+        name     string
+        password string
+        want     int
+    }{
+        {"Most common", "123456", 1},
+        {"Common", "password", 1},
+        {"Common, further down", "sunshine", 1},
+        {"Case matters", "PASSWORD", 0},
+        {"Not in the list", "Xq7!kLm2vRt9", 0},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            count, err := Breached(list, tt.password)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if count != tt.want {
+                t.Errorf("want %d; got %d", tt.want, count)
+            }
+        })
+    }
+
+    // The prefix is matched whatever its case, like the real API.
+    body, err := list.Range("5baa6")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer body.Close()
+
+    b, err := io.ReadAll(body)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !strings.Contains(string(b), "1E4C9B93F3F0682250B6CF8331B7EE68FD8:1") {
+        t.Errorf("want the range to contain the hash of %q; got %q", "password", b)
+    }
+}
+
+func TestPwnedPasswordsAPI(t *testing.T) {
+    // SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+    tests := []struct {
+        name      string
+        status    int
+        body      string
+        want      int
+        wantError bool
+    }{
+        {"Match", http.StatusOK, "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n", 3861493, false},
+        {"Lower case match", http.StatusOK, "1e4c9b93f3f0682250b6cf8331b7ee68fd8:12\r\n", 12, false},
+        {"No match", http.StatusOK, "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n", 0, false},
+        {"Empty range", http.StatusOK, "", 0, false},
+        {"Malformed line", http.StatusOK, "1E4C9B93F3F0682250B6CF8331B7EE68FD8:lots\r\n", 0, true},
+        {"Unrelated malformed line", http.StatusOK, "garbage\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:2\r\n", 2, false},
+        {"Server error", http.StatusServiceUnavailable, "", 0, true},
+        {"Rate limited", http.StatusTooManyRequests, "", 0, true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var gotPath string
+
+            ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                gotPath = r.URL.Path
+                w.WriteHeader(tt.status)
+                w.Write([]byte(tt.body))
+            }))
+            defer ts.Close()
+
+            api := &PwnedPasswordsAPI{URL: ts.URL + "/", Client: ts.Client()}
+
+            count, err := Breached(api, "password")
+
+            if (err != nil) != tt.wantError {
+                t.Errorf("want error %t; got %v", tt.wantError, err)
+            }
+
+            if count != tt.want {
+                t.Errorf("want %d; got %d", tt.want, count)
+            }
+
+            // Only the first five characters of the hash are sent.
+            if gotPath != "/range/5BAA6" {
+                t.Errorf("want path %q; got %q", "/range/5BAA6", gotPath)
+            }
+        })
+    }
+
+    // A server which can't be reached is an error too.
+    ts := httptest.NewServer(http.NotFoundHandler())
+    ts.Close()
+
+    _, err := Breached(&PwnedPasswordsAPI{URL: ts.URL}, "password")
+    if err == nil {
+        t.Error("want an error for an unreachable server")
+    }
+}
@@ -0,0 +1,90 @@
+package forms
+
+import (
+  "strings"
+)
+
+// A PasswordPolicy describes the rules a new password has to follow, beyond
+// the usual length checks.
+type PasswordPolicy struct {
+  // MinScore is the lowest acceptable PasswordStrength score, from 0 to 4.
+  MinScore int
+
+  // Breaches is queried for passwords which have appeared in a breach. Set
+  // it to nil to skip the check.
+  Breaches BreachRange
+}
+
+// Hints shown alongside the "too easy to guess" error, keyed by the weakest
+// pattern PasswordStrength found in the password.
+var strengthHints = map[string]string{
+  "dictionary": "avoid common words and passwords",
+  "sequence":   "avoid sequences like abc or 6543",
+  "keyboard":   "avoid runs of keys like qwerty",
+  "repeat":     "avoid repeated characters",
+  "date":       "avoid dates and years",
+  "":           "add another word or two",
+}
+
+// Implement a Password method to check that a specific field in the form holds
+// a password satisfying the policy. The personal parameters are the user's own
+// details, such as their name and email address, which mustn't appear in the
+// password. If any rule fails then add the appropriate message to the form
+// errors. An error is only returned if the breach check itself fails, in which
+// case the other rules have still been checked.
+func (f *Form) Password(field string, policy *PasswordPolicy, personal ...string) error {
+  value := f.Get(field)
+
+  if value == "" || policy == nil {
+    return nil
+  }
+
+  if containsPersonal(value, personal) {
+    f.Errors.Add(field, "This field must not contain your name or email address")
+  }
+
+  var breachErr error
+
+  if policy.Breaches != nil {
+    count, err := Breached(policy.Breaches, value)
+    if err != nil {
+      breachErr = err
+    } else if count > 0 {
+      f.Errors.Add(field, "This password has appeared in a data breach and can't be used")
+    }
+  }
+
+  score, weakest := PasswordStrength(value, personal...)
+  if score < policy.MinScore {
+    f.Errors.Add(field, "This password is too easy to guess: "+strengthHints[weakest])
+  }
+
+  return breachErr
+}
+
+// The containsPersonal function reports whether password contains, ignoring
+// case, any of the personal values, the local part of an email address among
+// them, or any word of at least three letters from them.
+func containsPersonal(password string, personal []string) bool {
+  password = strings.ToLower(password)
+
+  for _, value := range personal {
+    value = strings.ToLower(strings.TrimSpace(value))
+
+    candidates := []string{value}
+
+    if at := strings.LastIndex(value, "@"); at > 0 {
+      candidates = append(candidates, value[:at])
+    }
+
+    candidates = append(candidates, strings.Fields(value)...)
+
+    for _, c := range candidates {
+      if len([]rune(c)) >= 3 && strings.Contains(password, c) {
+        return true
+      }
+    }
+  }
+
+  return false
+}
@@ -0,0 +1,85 @@
+package forms
+
+import (
+    "fmt"
+    "io"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+func TestContainsPersonal(t *testing.T) {
+    tests := []struct {
+        name     string
+        password string
+        personal []string
+        want     bool
+    }{
+        {"Nothing personal", "Xq7!kLm2vRt9", []string{"Bob Tables", "bob@example.com"}, false},
+        {"Full name", "bobbytables99", []string{"Bobby Tables"}, true},
+        {"One word of the name", "my-TABLES-pass", []string{"Bobby Tables"}, true},
+        {"Email address", "bob@example.com!", []string{"bob@example.com"}, true},
+        {"Local part of the email", "xxbobbyxx", []string{"bobby@example.com"}, true},
+        {"Short words are ignored", "al-is-here-2024", []string{"Al Li"}, false},
+        {"Blank details", "Xq7!kLm2vRt9", []string{"", "  "}, false},
+        {"No details", "Xq7!kLm2vRt9", nil, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := containsPersonal(tt.password, tt.personal); got != tt.want {
+                t.Errorf("want %t; got %t", tt.want, got)
+            }
+        })
+    }
+}
+
+// A failingBreachRange is a BreachRange which can't be reached.
+type failingBreachRange struct{}
+
+func (failingBreachRange) Range(prefix string) (io.ReadCloser, error) {
+    return nil, fmt.Errorf("connection refused")
+}
+
+func TestPassword(t *testing.T) {
+    policy := &PasswordPolicy{MinScore: 3, Breaches: NewLocalBreachList()}
+    failing := &PasswordPolicy{MinScore: 3, Breaches: failingBreachRange{}}
+
+    tests := []struct {
+        name      string
+        password  string
+        policy    *PasswordPolicy
+        wantErr   bool
+        wantError string
+    }{
+        {"Valid", "Xq7!kLm2vRt9", policy, false, ""},
+        {"Personal", "bobtables-Xq7!", policy, false, "must not contain your name"},
+        {"Breached", "password123", policy, false, "appeared in a data breach"},
+        {"Guessable", "abcdefghijk", policy, false, "avoid sequences"},
+        {"Breach check unavailable", "Xq7!kLm2vRt9", failing, true, ""},
+        {"Breach check unavailable, guessable", "abcdefghijk", failing, true, "avoid sequences"},
+        {"No policy", "abc", nil, false, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            form := New(url.Values{"password": {tt.password}})
+
+            err := form.Password("password", tt.policy, "Bob Tables", "bob@example.com")
+
+            if (err != nil) != tt.wantErr {
+                t.Errorf("want error %t; got %v", tt.wantErr, err)
+            }
+
+            got := form.Errors.Get("password")
+
+            if tt.wantError == "" && got != "" {
+                t.Errorf("want no error; got %q", got)
+            }
+
+            if !strings.Contains(got, tt.wantError) {
+                t.Errorf("want error containing %q; got %q", tt.wantError, got)
+            }
+        })
+    }
+}
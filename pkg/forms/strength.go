@@ -0,0 +1,501 @@
+package forms
+
+import (
+  "math"
+  "strconv"
+  "strings"
+  "time"
+  "unicode"
+)
+
+// The strength estimator below is a simplified take on Dropbox's zxcvbn. It
+// splits a password into the cheapest sequence of patterns an attacker would
+// try (dictionary words, character sequences, keyboard runs, repeats, dates
+// and, failing those, brute force), estimates the number of guesses needed for
+// each, and turns the total into a score from 0 (trivial) to 4 (very strong).
+
+// The longest password we analyse; anything after this is ignored, since it
+// can only make the password stronger and the analysis is quadratic.
+const maxStrengthLength = 100
+
+var keyboardRows = []string{
+  "1234567890-=",
+  "qwertyuiop[]",
+  "asdfghjkl;'",
+  "zxcvbnm,./",
+}
+
+// The characters which can separate the parts of a date, as in "12/06/1987".
+const dateSeparators = " /\\_.-"
+
+// Years are only guessed between these; an attacker would try recent years and
+// birth years long before anything else.
+const (
+  minDateYear = 1900
+  maxDateYear = 2050
+)
+
+// Common "l33t" substitutions, mapped back to the letter they stand in for.
+var l33tTable = map[rune]rune{
+  '4': 'a',
+  '@': 'a',
+  '8': 'b',
+  '(': 'c',
+  '3': 'e',
+  '6': 'g',
+  '1': 'i',
+  '!': 'i',
+  '|': 'l',
+  '0': 'o',
+  '$': 's',
+  '5': 's',
+  '7': 't',
+  '+': 't',
+  '2': 'z',
+}
+
+// The dictionary maps each bundled common password to its rank: the most
+// common password has rank 1.
+var dictionary = func() map[string]int {
+  d := map[string]int{}
+
+  for i, word := range strings.Split(commonPasswords, "\n") {
+    word = strings.ToLower(strings.TrimSpace(word))
+    if _, ok := d[word]; word != "" && !ok {
+      d[word] = i + 1
+    }
+  }
+
+  return d
+}()
+
+// A match is a pattern found in the password, covering the runes from i up to
+// (but not including) j, along with the guesses needed to crack it.
+type match struct {
+  i, j    int
+  pattern string
+  guesses float64
+}
+
+// PasswordStrength estimates how hard password is to guess and returns a
+// score from 0 to 4, along with the pattern which contributes most to the
+// password being guessable ("dictionary", "sequence", "keyboard", "repeat",
+// "date" or "" if only brute force applies). Words in userInputs, such as the user's
+// name, are treated as the first entries of the dictionary.
+func PasswordStrength(password string, userInputs ...string) (int, string) {
+  runes := []rune(password)
+  if len(runes) > maxStrengthLength {
+    runes = runes[:maxStrengthLength]
+  }
+
+  n := len(runes)
+  if n == 0 {
+    return 0, ""
+  }
+
+  matches := findMatches(runes, userInputs)
+
+  // Use dynamic programming to find the cheapest way of covering the whole
+  // password with l matches, for every l. best[k][l] holds the lowest product
+  // of guesses covering the first k runes with l matches.
+  best := make([][]float64, n+1)
+  via := make([][]*match, n+1)
+
+  for k := range best {
+    best[k] = make([]float64, n+1)
+    via[k] = make([]*match, n+1)
+
+    for l := range best[k] {
+      best[k][l] = math.Inf(1)
+    }
+  }
+
+  best[0][0] = 1
+
+  byEnd := map[int][]*match{}
+  for _, m := range matches {
+    byEnd[m.j] = append(byEnd[m.j], m)
+  }
+
+  for k := 1; k <= n; k++ {
+    for _, m := range byEnd[k] {
+      for l := 1; l <= k; l++ {
+        g := best[m.i][l-1] * m.guesses
+        if g < best[k][l] {
+          best[k][l] = g
+          via[k][l] = m
+        }
+      }
+    }
+  }
+
+  // Like zxcvbn, account for the attacker not knowing how many patterns the
+  // password is made of by multiplying by l!, and add a term which grows with
+  // the number of patterns.
+  guesses := math.Inf(1)
+  bestL := 1
+
+  for l := 1; l <= n; l++ {
+    if math.IsInf(best[n][l], 1) {
+      continue
+    }
+
+    g := factorial(l)*best[n][l] + math.Pow(10000, float64(l-1))
+    if g < guesses {
+      guesses = g
+      bestL = l
+    }
+  }
+
+  // Walk back through the chosen matches to find the weakest non-brute-force
+  // pattern, which we use to give the user a hint.
+  weakest := ""
+  weakestGuesses := math.Inf(1)
+
+  for k, l := n, bestL; k > 0 && l > 0; l-- {
+    m := via[k][l]
+    if m.pattern != "bruteforce" && m.guesses < weakestGuesses {
+      weakest = m.pattern
+      weakestGuesses = m.guesses
+    }
+
+    k = m.i
+  }
+
+  switch {
+  case guesses < 1e3+5:
+    return 0, weakest
+  case guesses < 1e6+5:
+    return 1, weakest
+  case guesses < 1e8+5:
+    return 2, weakest
+  case guesses < 1e10+5:
+    return 3, weakest
+  default:
+    return 4, weakest
+  }
+}
+
+// The findMatches function returns every pattern found in the password,
+// including a brute force match for every substring so that the password can
+// always be covered.
+func findMatches(runes []rune, userInputs []string) []*match {
+  n := len(runes)
+  lower := []rune(strings.ToLower(string(runes)))
+
+  // The user's own details are the first thing an attacker would try.
+  words := map[string]int{}
+  for _, input := range userInputs {
+    for _, word := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+      return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    }) {
+      if len([]rune(word)) >= 3 {
+        words[word] = 1
+      }
+    }
+  }
+
+  var matches []*match
+
+  for i := 0; i < n; i++ {
+    for j := i + 1; j <= n; j++ {
+      // Brute force: 10 guesses per character, with zxcvbn's minimums.
+      bf := math.Pow(10, float64(j-i))
+      if j-i == 1 {
+        bf = math.Max(bf, 11)
+      } else {
+        bf = math.Max(bf, 51)
+      }
+      matches = append(matches, &match{i, j, "bruteforce", bf})
+
+      if j-i < 3 {
+        continue
+      }
+
+      // Years and dates, like "1987" or "12/06/1987".
+      if g := dateGuesses(lower[i:j]); g > 0 {
+        matches = append(matches, &match{i, j, "date", g})
+      }
+
+      // Dictionary words, with and without l33t substitutions undone.
+      word := string(lower[i:j])
+      unl33t, subs := undoL33t(lower[i:j])
+
+      for _, candidate := range []struct {
+        word string
+        subs int
+      }{{word, 0}, {unl33t, subs}} {
+        if candidate.subs == 0 && candidate.word != word {
+          continue
+        }
+
+        rank, ok := words[candidate.word]
+        if !ok {
+          rank, ok = dictionary[candidate.word]
+        }
+
+        if ok {
+          g := float64(rank) * uppercaseVariations(runes[i:j]) * math.Pow(2, float64(candidate.subs))
+          matches = append(matches, &match{i, j, "dictionary", math.Max(g, 51)})
+        }
+      }
+    }
+  }
+
+  matches = append(matches, findRuns(lower)...)
+
+  return matches
+}
+
+// The findRuns function finds repeated characters, alphabetical or numeric
+// sequences (like "abc" or "9876") and runs of adjacent keys on a keyboard.
+func findRuns(lower []rune) []*match {
+  var matches []*match
+
+  n := len(lower)
+
+  for i := 0; i < n; i++ {
+    // Repeats, e.g. "aaaa".
+    j := i + 1
+    for j < n && lower[j] == lower[i] {
+      j++
+    }
+
+    if j-i >= 3 {
+      matches = append(matches, &match{i, j, "repeat", cardinality(lower[i]) * float64(j-i)})
+    }
+
+    // Sequences, e.g. "abcd" or "4321".
+    if i+1 < n {
+      delta := lower[i+1] - lower[i]
+
+      if delta == 1 || delta == -1 {
+        j := i + 1
+        for j+1 < n && lower[j+1]-lower[j] == delta {
+          j++
+        }
+
+        if j+1-i >= 3 {
+          base := 26.0
+          switch {
+          case lower[i] == 'a' || lower[i] == '1' || lower[i] == 'z' || lower[i] == '9':
+            base = 4
+          case unicode.IsDigit(lower[i]):
+            base = 10
+          }
+
+          if delta < 0 {
+            base *= 2
+          }
+
+          matches = append(matches, &match{i, j + 1, "sequence", base * float64(j+1-i)})
+        }
+      }
+    }
+
+    // Keyboard runs, e.g. "qwerty" or "asdf".
+    for _, row := range keyboardRows {
+      pos := strings.IndexRune(row, lower[i])
+      if pos < 0 {
+        continue
+      }
+
+      j := i + 1
+      for j < n && pos+(j-i) < len(row) && rune(row[pos+(j-i)]) == lower[j] {
+        j++
+      }
+
+      if j-i >= 4 {
+        matches = append(matches, &match{i, j, "keyboard", float64(len(row)) * 10 * float64(j-i)})
+      }
+    }
+  }
+
+  return matches
+}
+
+// The dateGuesses function returns the guesses needed for s if it's a year
+// (like "1987") or a date, with or without separators (like "12/06/1987",
+// "1987-6-12" or "120687"), and 0 if it's neither. As in zxcvbn, an attacker
+// tries the years closest to now first, and each day of the year in turn.
+func dateGuesses(s []rune) float64 {
+  str := string(s)
+
+  if len(s) == 4 {
+    if y, err := strconv.Atoi(str); err == nil && y >= minDateYear && y <= maxDateYear {
+      return yearSpace(y)
+    }
+  }
+
+  if len(s) < 4 || len(s) > 10 {
+    return 0
+  }
+
+  var candidates [][3]string
+  separated := false
+
+  fields := strings.FieldsFunc(str, func(r rune) bool {
+    return strings.ContainsRune(dateSeparators, r)
+  })
+
+  switch {
+  case len(fields) == 1 && len(s) <= 8:
+    // Without separators, try every way of splitting the digits in three.
+    for a := 1; a < len(str)-1; a++ {
+      for b := a + 1; b < len(str); b++ {
+        candidates = append(candidates, [3]string{str[:a], str[a:b], str[b:]})
+      }
+    }
+  case len(fields) == 3:
+    // Both separators have to be the same single character.
+    sep := str[len(fields[0]) : len(fields[0])+1]
+    if strings.Join(fields, sep) != str {
+      return 0
+    }
+
+    candidates = append(candidates, [3]string{fields[0], fields[1], fields[2]})
+    separated = true
+  default:
+    return 0
+  }
+
+  for _, parts := range candidates {
+    y, ok := parseDate(parts)
+    if !ok {
+      continue
+    }
+
+    g := yearSpace(y) * 365
+    if separated {
+      g *= 4
+    }
+
+    return g
+  }
+
+  return 0
+}
+
+// The parseDate function returns the year of a date split into three parts,
+// with the year (two or four digits) first or last and the day and month in
+// either order between them.
+func parseDate(parts [3]string) (int, bool) {
+  for _, order := range [][3]int{{2, 0, 1}, {0, 1, 2}} {
+    ys, ds, ms := parts[order[0]], parts[order[1]], parts[order[2]]
+    if (len(ys) != 2 && len(ys) != 4) || len(ds) > 2 || len(ms) > 2 {
+      continue
+    }
+
+    y, err := strconv.Atoi(ys)
+    if err != nil {
+      continue
+    }
+
+    // Two digit years are read as 1950 to 2049.
+    if len(ys) == 2 {
+      y += 1900
+      if y < 1950 {
+        y += 100
+      }
+    }
+
+    d, err1 := strconv.Atoi(ds)
+    m, err2 := strconv.Atoi(ms)
+    if err1 != nil || err2 != nil || y < minDateYear || y > maxDateYear {
+      continue
+    }
+
+    if (d >= 1 && d <= 31 && m >= 1 && m <= 12) || (m >= 1 && m <= 31 && d >= 1 && d <= 12) {
+      return y, true
+    }
+  }
+
+  return 0, false
+}
+
+// The yearSpace function returns how many years an attacker would try before
+// reaching y, counting out from the current year. Like zxcvbn, it's at least
+// 20, since recent years are all tried early on.
+func yearSpace(y int) float64 {
+  return math.Max(math.Abs(float64(y-time.Now().Year())), 20)
+}
+
+// The undoL33t function replaces common l33t substitutions with the letter
+// they stand for, and returns how many were replaced.
+func undoL33t(word []rune) (string, int) {
+  out := make([]rune, len(word))
+  subs := 0
+
+  for i, r := range word {
+    if letter, ok := l33tTable[r]; ok {
+      out[i] = letter
+      subs++
+    } else {
+      out[i] = r
+    }
+  }
+
+  return string(out), subs
+}
+
+// The uppercaseVariations function returns the number of ways a word could
+// have been capitalised, the way an attacker would try them: all lower case,
+// a capital first letter and all upper case are cheap; anything else costs the
+// number of ways of choosing which letters are upper case.
+func uppercaseVariations(word []rune) float64 {
+  upper, lower := 0, 0
+
+  for _, r := range word {
+    switch {
+    case unicode.IsUpper(r):
+      upper++
+    case unicode.IsLower(r):
+      lower++
+    }
+  }
+
+  if upper == 0 {
+    return 1
+  }
+
+  if lower == 0 || (upper == 1 && unicode.IsUpper(word[0])) {
+    return 2
+  }
+
+  variations := 0.0
+  for k := 1; k <= upper && k <= lower; k++ {
+    variations += binomial(upper+lower, k)
+  }
+
+  return variations
+}
+
+// The cardinality function returns the size of the character class r belongs to.
+func cardinality(r rune) float64 {
+  switch {
+  case unicode.IsDigit(r):
+    return 10
+  case unicode.IsLetter(r):
+    return 26
+  default:
+    return 33
+  }
+}
+
+func factorial(n int) float64 {
+  f := 1.0
+  for i := 2; i <= n; i++ {
+    f *= float64(i)
+  }
+
+  return f
+}
+
+func binomial(n, k int) float64 {
+  r := 1.0
+  for i := 1; i <= k; i++ {
+    r = r * float64(n-k+i) / float64(i)
+  }
+
+  return r
+}
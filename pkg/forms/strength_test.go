@@ -0,0 +1,76 @@
+package forms
+
+import (
+    "testing"
+)
+
+func TestPasswordStrength(t *testing.T) {
+    tests := []struct {
+        name        string
+        password    string
+        userInputs  []string
+        wantScore   int
+        wantWeakest string
+    }{
+        {"Empty", "", nil, 0, ""},
+        {"Common password", "password", nil, 0, "dictionary"},
+        {"Capitalised", "Password", nil, 0, "dictionary"},
+        {"Less common password", "sunshine", nil, 0, "dictionary"},
+        {"L33t", "P4$$w0rd", nil, 0, "dictionary"},
+        {"L33t with an @", "p@ssw0rd", nil, 0, "dictionary"},
+        {"Alphabetical sequence", "abcdefgh", nil, 0, "sequence"},
+        {"Descending numbers", "987654321", nil, 0, "sequence"},
+        {"Repeat", "aaaaaaaa", nil, 0, "repeat"},
+        {"Long repeat", "zzzzzzzzzzzz", nil, 0, "repeat"},
+        {"Year", "1987", nil, 0, "date"},
+        {"Date without separators", "19870612", nil, 1, "date"},
+        {"Date with slashes", "12/06/1987", nil, 1, "date"},
+        {"Date with dashes", "1987-6-12", nil, 1, "date"},
+        {"Short date", "120687", nil, 1, "date"},
+        {"User's name", "bobtables", []string{"Bob Tables"}, 1, "dictionary"},
+        {"Random", "tr0ub4dor&3", nil, 4, ""},
+        {"Passphrase", "correct horse battery staple", nil, 4, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            score, weakest := PasswordStrength(tt.password, tt.userInputs...)
+
+            if score != tt.wantScore {
+                t.Errorf("want score %d; got %d", tt.wantScore, score)
+            }
+
+            if weakest != tt.wantWeakest {
+                t.Errorf("want weakest pattern %q; got %q", tt.wantWeakest, weakest)
+            }
+        })
+    }
+}
+
+func TestDateGuesses(t *testing.T) {
+    tests := []struct {
+        name  string
+        value string
+        want  bool
+    }{
+        {"Year", "1987", true},
+        {"Zeros", "0000", false},
+        {"Day, month and year", "12061987", true},
+        {"Year, month and day", "19870612", true},
+        {"Two digit year", "120687", true},
+        {"Slashes", "12/06/1987", true},
+        {"Dots", "12.6.87", true},
+        {"Mixed separators", "12/06-1987", false},
+        {"No month", "99991987", false},
+        {"Too short", "123", false},
+        {"Letters", "12ab1987", false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := dateGuesses([]rune(tt.value)) > 0; got != tt.want {
+                t.Errorf("want date %t; got %t", tt.want, got)
+            }
+        })
+    }
+}
@@ -0,0 +1,109 @@
+package highlight
+
+import (
+  "encoding/json"
+  "regexp"
+  "strings"
+)
+
+// The signals which suggest code is written in a language. Each one that
+// matches counts as a point for the language.
+var signals = map[string][]*regexp.Regexp{
+  "go": {
+    regexp.MustCompile(`(?m)^package \w+\s*$`),
+    regexp.MustCompile(`(?m)^func \w*`),
+    regexp.MustCompile(`\w+ :=`),
+    regexp.MustCompile(`\berr != nil\b`),
+    regexp.MustCompile(`\bfmt\.\w+\(`),
+  },
+  "python": {
+    regexp.MustCompile(`(?m)^\s*def \w+\(.*\):\s*$`),
+    regexp.MustCompile(`(?m)^\s*(if|elif|else|for|while|with|try|except|class)\b.*:\s*$`),
+    regexp.MustCompile(`(?m)^(from \w+(\.\w+)* )?import \w+(\.\w+)*( as \w+)?\s*$`),
+    regexp.MustCompile(`\bself\.\w+`),
+    regexp.MustCompile(`\b(None|True|False)\b`),
+  },
+  "javascript": {
+    regexp.MustCompile(`\bfunction\s*\w*\s*\(`),
+    regexp.MustCompile(`\b(const|let|var) \w+\s*=`),
+    regexp.MustCompile(`=>`),
+    regexp.MustCompile(`\bconsole\.\w+\(`),
+    regexp.MustCompile(`===|!==`),
+    regexp.MustCompile(`\b(require\(|document\.|module\.exports)`),
+  },
+  "c": {
+    regexp.MustCompile(`(?m)^#include\s*[<"]`),
+    regexp.MustCompile(`\bint main\s*\(`),
+    regexp.MustCompile(`\b(printf|malloc|free|sizeof)\s*\(`),
+    regexp.MustCompile(`\b(unsigned|typedef|struct \w+ \*?\w+)\b`),
+  },
+  "sql": {
+    regexp.MustCompile(`(?is)\bselect\b.+\bfrom\b`),
+    regexp.MustCompile(`(?i)\binsert\s+into\b`),
+    regexp.MustCompile(`(?i)\b(create|alter|drop)\s+(table|index|database)\b`),
+    regexp.MustCompile(`(?is)\bupdate\b.+\bset\b`),
+    regexp.MustCompile(`(?i)\b(where|order by|group by|inner join|left join)\b`),
+  },
+  "shell": {
+    regexp.MustCompile(`(?m)^\s*(echo|cd|export|sudo|apt-get|apt|brew|git|npm|make|curl|wget|ls|mkdir|rm|cp|mv|chmod)\b`),
+    regexp.MustCompile(`(?m)^\s*(fi|done|esac)\s*$`),
+    regexp.MustCompile(`(?m)^\s*(if|while) \[\[? `),
+    regexp.MustCompile(`\$\{\w+\}|\$\w+`),
+    regexp.MustCompile(`\|\s*(grep|awk|sed|xargs|sort|head|tail)\b`),
+  },
+}
+
+// The languages named in a #! line.
+var interpreters = map[string]string{
+  "bash":    "shell",
+  "sh":      "shell",
+  "zsh":     "shell",
+  "python":  "python",
+  "python3": "python",
+  "node":    "javascript",
+}
+
+// Detect guesses the language of the code, and returns its name. Code which
+// doesn't look enough like any of them is plain text.
+func Detect(code string) string {
+  trimmed := strings.TrimSpace(code)
+
+  if strings.HasPrefix(trimmed, "#!") {
+    line := strings.SplitN(trimmed, "\n", 2)[0]
+    fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+
+    // Skip "/usr/bin/env" to get to the interpreter.
+    for _, f := range fields {
+      name := f[strings.LastIndex(f, "/")+1:]
+      if language, ok := interpreters[name]; ok {
+        return language
+      }
+    }
+  }
+
+  if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+    if json.Valid([]byte(trimmed)) {
+      return "json"
+    }
+  }
+
+  best, bestScore := Text, 1
+
+  // Go through the languages in a fixed order, so that ties always go the
+  // same way.
+  for _, l := range languages {
+    score := 0
+
+    for _, re := range signals[l.Name] {
+      if re.MatchString(code) {
+        score++
+      }
+    }
+
+    if score > bestScore {
+      best, bestScore = l.Name, score
+    }
+  }
+
+  return best
+}
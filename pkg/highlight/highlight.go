@@ -0,0 +1,444 @@
+// Package highlight does simple, server-side syntax highlighting of snippets.
+// It splits code into comments, strings, numbers, keywords and built-ins, and
+// wraps each of them in a <span> with a CSS class, so that no JavaScript is
+// needed to colour them. It isn't a parser: the aim is to make code easier to
+// read, not to get every corner of every language right.
+package highlight
+
+import (
+  "html/template"
+  "path"
+  "strings"
+)
+
+// The CSS classes given to each kind of token.
+const (
+  ClassComment = "hl-comment"
+  ClassString  = "hl-string"
+  ClassNumber  = "hl-number"
+  ClassKeyword = "hl-keyword"
+  ClassBuiltin = "hl-builtin"
+)
+
+// The name of the language used for code which isn't highlighted.
+const Text = "text"
+
+// A Language describes how to split code in one language into tokens.
+type Language struct {
+  // Name is the value stored with a snippet, e.g. "go".
+  Name string
+
+  // Label is the name shown to users, e.g. "Go".
+  Label string
+
+  keywords        map[string]bool
+  builtins        map[string]bool
+  caseInsensitive bool
+
+  // identStart holds characters, besides letters and underscores, which can
+  // start a keyword, like the # of C's preprocessor directives.
+  identStart string
+
+  lineComments []string
+  blockComment [2]string
+
+  // hashComment is true if # only starts a comment at the beginning of a
+  // word, as in shell scripts.
+  hashComment bool
+
+  quotes       string
+  rawQuotes    string
+  tripleQuotes bool
+}
+
+func words(s string) map[string]bool {
+  m := map[string]bool{}
+
+  for _, w := range strings.Fields(s) {
+    m[w] = true
+  }
+
+  return m
+}
+
+var languages = []*Language{
+  {
+    Name:  Text,
+    Label: "Plain text",
+  },
+  {
+    Name:         "go",
+    Label:        "Go",
+    keywords:     words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"),
+    builtins:     words("append bool byte cap close complex copy delete error false float32 float64 int int8 int16 int32 int64 iota len make new nil panic print println real recover rune string true uint uint8 uint16 uint32 uint64 uintptr"),
+    lineComments: []string{"//"},
+    blockComment: [2]string{"/*", "*/"},
+    quotes:       `"'`,
+    rawQuotes:    "`",
+  },
+  {
+    Name:         "python",
+    Label:        "Python",
+    keywords:     words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield"),
+    builtins:     words("False None True bool dict float int len list print range self set str super tuple"),
+    lineComments: []string{"#"},
+    quotes:       `"'`,
+    tripleQuotes: true,
+  },
+  {
+    Name:         "javascript",
+    Label:        "JavaScript",
+    keywords:     words("async await break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof let new of return static super switch this throw try typeof var void while yield"),
+    builtins:     words("Array JSON Math Object Promise String console document false null true undefined window"),
+    lineComments: []string{"//"},
+    blockComment: [2]string{"/*", "*/"},
+    quotes:       `"'`,
+    rawQuotes:    "`",
+  },
+  {
+    Name:         "c",
+    Label:        "C",
+    keywords:     words("#define #elif #else #endif #if #ifdef #ifndef #include #pragma #undef auto break case const continue default do else enum extern for goto if inline register return sizeof static struct switch typedef union volatile while"),
+    builtins:     words("NULL bool char double float int long short signed size_t unsigned void"),
+    identStart:   "#",
+    lineComments: []string{"//"},
+    blockComment: [2]string{"/*", "*/"},
+    quotes:       `"'`,
+  },
+  {
+    Name:            "sql",
+    Label:           "SQL",
+    keywords:        words("add all alter and as asc begin between by case commit create default delete desc distinct drop else end exists foreign from group having in index inner insert into is join key left like limit not null offset on or order outer primary references right rollback select set table then union unique update values when where"),
+    builtins:        words("avg bigint boolean char coalesce count date datetime decimal false int integer max min now sum text timestamp true varchar"),
+    caseInsensitive: true,
+    lineComments:    []string{"--"},
+    blockComment:    [2]string{"/*", "*/"},
+    quotes:          `"'`,
+  },
+  {
+    Name:         "shell",
+    Label:        "Shell",
+    keywords:     words("case do done elif else esac fi for function if in local return select then until while"),
+    builtins:     words("alias cd echo eval exec exit export printf read set shift source test trap unset"),
+    lineComments: []string{"#"},
+    hashComment:  true,
+    quotes:       `"`,
+    rawQuotes:    "'",
+  },
+  {
+    Name:     "json",
+    Label:    "JSON",
+    builtins: words("false null true"),
+    quotes:   `"`,
+  },
+}
+
+// Languages returns the languages which can be highlighted, in the order they
+// should be offered to users.
+func Languages() []*Language {
+  return append([]*Language(nil), languages...)
+}
+
+// Lookup returns the language with the given name, or nil if there isn't one.
+func Lookup(name string) *Language {
+  for _, l := range languages {
+    if l.Name == name {
+      return l
+    }
+  }
+
+  return nil
+}
+
+// Other names people use for the languages, e.g. in the info string of a
+// Markdown code fence.
+var aliases = map[string]string{
+  "bash":      "shell",
+  "console":   "shell",
+  "golang":    "go",
+  "h":         "c",
+  "js":        "javascript",
+  "mysql":     "sql",
+  "node":      "javascript",
+  "plain":     Text,
+  "plaintext": Text,
+  "py":        "python",
+  "python3":   "python",
+  "sh":        "shell",
+  "txt":       Text,
+  "zsh":       "shell",
+}
+
+// Resolve returns the name of the language called name, which may be one of
+// its aliases, or "" if there isn't one.
+func Resolve(name string) string {
+  name = strings.ToLower(strings.TrimSpace(name))
+
+  if alias, ok := aliases[name]; ok {
+    return alias
+  }
+
+  if Lookup(name) != nil {
+    return name
+  }
+
+  return ""
+}
+
+// The languages files are written in, by their extension.
+var extensions = map[string]string{
+  ".bash": "shell",
+  ".c":    "c",
+  ".cjs":  "javascript",
+  ".go":   "go",
+  ".h":    "c",
+  ".js":   "javascript",
+  ".json": "json",
+  ".mjs":  "javascript",
+  ".py":   "python",
+  ".sh":   "shell",
+  ".sql":  "sql",
+  ".txt":  Text,
+  ".zsh":  "shell",
+}
+
+// ForFilename returns the name of the language a file is written in, going by
+// its extension, or "" if it can't tell.
+func ForFilename(filename string) string {
+  return extensions[strings.ToLower(path.Ext(filename))]
+}
+
+// The extension files in each language are usually saved with.
+var languageExtensions = map[string]string{
+  "c":          ".c",
+  "go":         ".go",
+  "javascript": ".js",
+  "json":       ".json",
+  "python":     ".py",
+  "shell":      ".sh",
+  "sql":        ".sql",
+  Text:         ".txt",
+}
+
+// Extension returns the usual file extension for the language, like ".go",
+// or ".txt" if there isn't one.
+func Extension(language string) string {
+  if ext, ok := languageExtensions[language]; ok {
+    return ext
+  }
+
+  return ".txt"
+}
+
+// Names returns the names of all the languages.
+func Names() []string {
+  names := make([]string, len(languages))
+
+  for i, l := range languages {
+    names[i] = l.Name
+  }
+
+  return names
+}
+
+// HTML returns the code as HTML, with its tokens wrapped in <span> elements.
+// Everything in the code is escaped, so the result is safe to put inside a
+// <code> element. If the language isn't known, it is detected from the code.
+func HTML(language, code string) template.HTML {
+  l := Lookup(language)
+  if l == nil {
+    l = Lookup(Detect(code))
+  }
+
+  var b strings.Builder
+
+  for _, t := range l.tokenize(code) {
+    if t.class == "" {
+      b.WriteString(template.HTMLEscapeString(t.text))
+      continue
+    }
+
+    b.WriteString(`<span class="`)
+    b.WriteString(t.class)
+    b.WriteString(`">`)
+    b.WriteString(template.HTMLEscapeString(t.text))
+    b.WriteString(`</span>`)
+  }
+
+  return template.HTML(b.String())
+}
+
+type token struct {
+  class string
+  text  string
+}
+
+// The tokenize method splits code into tokens. Runs of text which aren't
+// highlighted are returned as tokens with no class.
+func (l *Language) tokenize(code string) []token {
+  var (
+    tokens []token
+    plain  int
+  )
+
+  emit := func(start, end int, class string) {
+    if plain < start {
+      tokens = append(tokens, token{text: code[plain:start]})
+    }
+
+    tokens = append(tokens, token{class: class, text: code[start:end]})
+    plain = end
+  }
+
+  for i := 0; i < len(code); {
+    rest := code[i:]
+
+    if end := l.comment(code, i); end > i {
+      emit(i, end, ClassComment)
+      i = end
+      continue
+    }
+
+    if end := l.string(rest); end > 0 {
+      emit(i, i+end, ClassString)
+      i += end
+      continue
+    }
+
+    c := code[i]
+    wordStart := i == 0 || !isIdent(code[i-1])
+
+    if wordStart && isDigit(c) {
+      end := i + 1
+      for end < len(code) && (isIdent(code[end]) || code[end] == '.') {
+        end++
+      }
+
+      emit(i, end, ClassNumber)
+      i = end
+      continue
+    }
+
+    if wordStart && (isLetter(c) || strings.IndexByte(l.identStart, c) >= 0) {
+      end := i + 1
+      for end < len(code) && isIdent(code[end]) {
+        end++
+      }
+
+      word := code[i:end]
+      if l.caseInsensitive {
+        word = strings.ToLower(word)
+      }
+
+      switch {
+      case l.keywords[word]:
+        emit(i, end, ClassKeyword)
+      case l.builtins[word]:
+        emit(i, end, ClassBuiltin)
+      }
+
+      i = end
+      continue
+    }
+
+    i++
+  }
+
+  if plain < len(code) {
+    tokens = append(tokens, token{text: code[plain:]})
+  }
+
+  return tokens
+}
+
+// The comment method returns the end of the comment starting at code[i], or i
+// if there isn't one.
+func (l *Language) comment(code string, i int) int {
+  rest := code[i:]
+
+  for _, prefix := range l.lineComments {
+    if !strings.HasPrefix(rest, prefix) {
+      continue
+    }
+
+    if l.hashComment && i > 0 && !isSpace(code[i-1]) && code[i-1] != ';' {
+      continue
+    }
+
+    if n := strings.IndexByte(rest, '\n'); n >= 0 {
+      return i + n
+    }
+
+    return len(code)
+  }
+
+  if start, end := l.blockComment[0], l.blockComment[1]; start != "" && strings.HasPrefix(rest, start) {
+    if n := strings.Index(rest[len(start):], end); n >= 0 {
+      return i + len(start) + n + len(end)
+    }
+
+    return len(code)
+  }
+
+  return i
+}
+
+// The string method returns the length of the string literal at the start of
+// s, or 0 if there isn't one. Ordinary strings end at the end of the line, so
+// that a stray quote doesn't colour the rest of the snippet.
+func (l *Language) string(s string) int {
+  if s == "" {
+    return 0
+  }
+
+  q := s[0]
+
+  if l.tripleQuotes && (strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''")) {
+    if n := strings.Index(s[3:], s[:3]); n >= 0 {
+      return 3 + n + 3
+    }
+
+    return len(s)
+  }
+
+  if strings.IndexByte(l.rawQuotes, q) >= 0 {
+    if n := strings.IndexByte(s[1:], q); n >= 0 {
+      return n + 2
+    }
+
+    return len(s)
+  }
+
+  if strings.IndexByte(l.quotes, q) < 0 {
+    return 0
+  }
+
+  for i := 1; i < len(s); i++ {
+    switch s[i] {
+    case '\\':
+      i++
+    case q:
+      return i + 1
+    case '\n':
+      return i
+    }
+  }
+
+  return len(s)
+}
+
+func isLetter(c byte) bool {
+  return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+  return c >= '0' && c <= '9'
+}
+
+func isIdent(c byte) bool {
+  return isLetter(c) || isDigit(c)
+}
+
+func isSpace(c byte) bool {
+  return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,178 @@
+package highlight
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestHTML(t *testing.T) {
+    tests := []struct {
+        name     string
+        language string
+        code     string
+        want     string
+    }{
+        {
+            "Go",
+            "go",
+            "func main() {\n\tx := 42 // answer\n\tfmt.Println(\"hi\\\"\", nil)\n}",
+            `<span class="hl-keyword">func</span> main() {` + "\n\t" +
+                `x := <span class="hl-number">42</span> <span class="hl-comment">// answer</span>` + "\n\t" +
+                `fmt.Println(<span class="hl-string">&#34;hi\&#34;&#34;</span>, <span class="hl-builtin">nil</span>)` + "\n}",
+        },
+        {
+            "Go raw string",
+            "go",
+            "s := `a\n\"b\"`",
+            "s := <span class=\"hl-string\">`a\n&#34;b&#34;`</span>",
+        },
+        {
+            "Escapes HTML",
+            "javascript",
+            `if (a < b) { alert("<script>") }`,
+            `<span class="hl-keyword">if</span> (a &lt; b) { alert(<span class="hl-string">&#34;&lt;script&gt;&#34;</span>) }`,
+        },
+        {
+            "Unterminated string stops at the end of the line",
+            "python",
+            "x = 'oops\nreturn",
+            "x = <span class=\"hl-string\">&#39;oops</span>\n<span class=\"hl-keyword\">return</span>",
+        },
+        {
+            "Python triple quotes",
+            "python",
+            "\"\"\"doc\nstring\"\"\" # done",
+            "<span class=\"hl-string\">&#34;&#34;&#34;doc\nstring&#34;&#34;&#34;</span> <span class=\"hl-comment\"># done</span>",
+        },
+        {
+            "SQL is case-insensitive",
+            "sql",
+            "Select id FROM t -- all",
+            `<span class="hl-keyword">Select</span> id <span class="hl-keyword">FROM</span> t <span class="hl-comment">-- all</span>`,
+        },
+        {
+            "Shell # inside a word",
+            "shell",
+            "echo ${#x} # count",
+            `<span class="hl-builtin">echo</span> ${#x} <span class="hl-comment"># count</span>`,
+        },
+        {
+            "C preprocessor",
+            "c",
+            "#include <stdio.h>\nint x;",
+            "<span class=\"hl-keyword\">#include</span> &lt;stdio.h&gt;\n<span class=\"hl-builtin\">int</span> x;",
+        },
+        {
+            "Identifiers containing keywords",
+            "go",
+            "format(if2, x1)",
+            "format(if2, x1)",
+        },
+        {
+            "Plain text",
+            "text",
+            "if <b>",
+            "if &lt;b&gt;",
+        },
+        {
+            "Unicode",
+            "go",
+            "s := \"żółw\" // 🐢",
+            "s := <span class=\"hl-string\">&#34;żółw&#34;</span> <span class=\"hl-comment\">// 🐢</span>",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := string(HTML(tt.language, tt.code))
+
+            if got != tt.want {
+                t.Errorf("want\n%s\ngot\n%s", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestHTMLNeverLeaksMarkup(t *testing.T) {
+    code := "</code><script>alert(1)</script>\n\"</span>\n/* <img src=x onerror=alert(1)> */"
+
+    for _, l := range Languages() {
+        got := string(HTML(l.Name, code))
+
+        stripped := strings.NewReplacer(
+            `<span class="hl-comment">`, "",
+            `<span class="hl-string">`, "",
+            `<span class="hl-number">`, "",
+            `<span class="hl-keyword">`, "",
+            `<span class="hl-builtin">`, "",
+            `</span>`, "",
+        ).Replace(got)
+
+        if strings.ContainsAny(stripped, "<>") {
+            t.Errorf("%s: want all markup escaped; got %s", l.Name, got)
+        }
+    }
+}
+
+func TestDetect(t *testing.T) {
+    tests := []struct {
+        name string
+        code string
+        want string
+    }{
+        {"Go", "package main\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}", "go"},
+        {"Python", "def add(a, b):\n    return a + b\n\nif __name__ == '__main__':\n    print(add(1, 2))", "python"},
+        {"JavaScript", "const add = (a, b) => a + b;\nconsole.log(add(1, 2));", "javascript"},
+        {"C", "#include <stdio.h>\n\nint main(void) {\n    printf(\"hi\\n\");\n}", "c"},
+        {"SQL", "SELECT id, title FROM snippets\nWHERE expires > UTC_TIMESTAMP();", "sql"},
+        {"Shell", "cd /tmp\nfor f in *.log; do\n  echo $f\ndone", "shell"},
+        {"Shebang", "#!/usr/bin/env python3\nprint('hi')", "python"},
+        {"JSON", `{"title": "An old silent pond", "tags": [1, 2]}`, "json"},
+        {"Prose", "An old silent pond...\nA frog jumps into the pond,\nsplash! Silence again.", "text"},
+        {"Empty", "", "text"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := Detect(tt.code); got != tt.want {
+                t.Errorf("want %q; got %q", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestForFilename(t *testing.T) {
+    tests := []struct {
+        filename string
+        want     string
+    }{
+        {"main.go", "go"},
+        {"setup.PY", "python"},
+        {"include/list.h", "c"},
+        {"notes.txt", "text"},
+        {"go.mod", ""},
+        {"Makefile", ""},
+        {"", ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.filename, func(t *testing.T) {
+            if got := ForFilename(tt.filename); got != tt.want {
+                t.Errorf("want %q; got %q", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestExtension(t *testing.T) {
+    // Every language's extension should lead back to it.
+    for _, name := range Names() {
+        if got := ForFilename("snippet" + Extension(name)); got != name {
+            t.Errorf("%s: extension %q gives %q", name, Extension(name), got)
+        }
+    }
+
+    if got := Extension("cobol"); got != ".txt" {
+        t.Errorf("want .txt for an unknown language; got %q", got)
+    }
+}
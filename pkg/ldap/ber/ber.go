@@ -0,0 +1,270 @@
+// Package ber implements the small subset of the ASN.1 Basic Encoding Rules
+// needed to speak LDAP: definite lengths and tag numbers below 31.
+package ber
+
+import (
+  "errors"
+  "fmt"
+  "io"
+)
+
+// The classes of a BER tag.
+const (
+  ClassUniversal   byte = 0x00
+  ClassApplication byte = 0x40
+  ClassContext     byte = 0x80
+)
+
+// The universal tag numbers used by LDAP.
+const (
+  TagBoolean     byte = 1
+  TagInteger     byte = 2
+  TagOctetString byte = 4
+  TagNull        byte = 5
+  TagEnumerated  byte = 10
+  TagSequence    byte = 16
+  TagSet         byte = 17
+)
+
+// MaxLength is the largest element we are willing to read, to stop a
+// misbehaving peer from making us allocate without bound.
+const MaxLength = 1 << 20
+
+var ErrMalformed = errors.New("ber: malformed element")
+
+// A Packet is a single BER element. Primitive elements carry their content in
+// Value, constructed ones in Children.
+type Packet struct {
+  Class       byte
+  Constructed bool
+  Tag         byte
+  Value       []byte
+  Children    []*Packet
+}
+
+// NewPrimitive returns a primitive element with the given content.
+func NewPrimitive(class, tag byte, value []byte) *Packet {
+  return &Packet{Class: class, Tag: tag, Value: value}
+}
+
+// NewConstructed returns a constructed element containing children.
+func NewConstructed(class, tag byte, children ...*Packet) *Packet {
+  return &Packet{Class: class, Constructed: true, Tag: tag, Children: children}
+}
+
+// NewSequence returns a universal SEQUENCE containing children.
+func NewSequence(children ...*Packet) *Packet {
+  return NewConstructed(ClassUniversal, TagSequence, children...)
+}
+
+// NewSet returns a universal SET containing children.
+func NewSet(children ...*Packet) *Packet {
+  return NewConstructed(ClassUniversal, TagSet, children...)
+}
+
+// NewString returns a universal OCTET STRING.
+func NewString(s string) *Packet {
+  return NewPrimitive(ClassUniversal, TagOctetString, []byte(s))
+}
+
+// NewInteger returns a universal INTEGER.
+func NewInteger(n int64) *Packet {
+  return NewPrimitive(ClassUniversal, TagInteger, encodeInt(n))
+}
+
+// NewEnumerated returns a universal ENUMERATED.
+func NewEnumerated(n int64) *Packet {
+  return NewPrimitive(ClassUniversal, TagEnumerated, encodeInt(n))
+}
+
+// NewBoolean returns a universal BOOLEAN.
+func NewBoolean(b bool) *Packet {
+  if b {
+    return NewPrimitive(ClassUniversal, TagBoolean, []byte{0xff})
+  }
+
+  return NewPrimitive(ClassUniversal, TagBoolean, []byte{0x00})
+}
+
+// Is reports whether the packet has the given class and tag number.
+func (p *Packet) Is(class, tag byte) bool {
+  return p.Class == class && p.Tag == tag
+}
+
+// String returns the content of a primitive element as a string.
+func (p *Packet) String() string {
+  return string(p.Value)
+}
+
+// Int returns the content of an INTEGER or ENUMERATED element.
+func (p *Packet) Int() int64 {
+  var n int64
+
+  for i, b := range p.Value {
+    if i == 0 && b&0x80 != 0 {
+      n = -1
+    }
+
+    n = n<<8 | int64(b)
+  }
+
+  return n
+}
+
+// Bool returns the content of a BOOLEAN element.
+func (p *Packet) Bool() bool {
+  return len(p.Value) > 0 && p.Value[0] != 0
+}
+
+// Bytes returns the encoding of the packet.
+func (p *Packet) Bytes() []byte {
+  content := p.Value
+
+  if p.Constructed {
+    content = nil
+    for _, c := range p.Children {
+      content = append(content, c.Bytes()...)
+    }
+  }
+
+  identifier := p.Class | p.Tag
+  if p.Constructed {
+    identifier |= 0x20
+  }
+
+  b := append([]byte{identifier}, encodeLength(len(content))...)
+
+  return append(b, content...)
+}
+
+// Read reads a single element from r.
+func Read(r io.Reader) (*Packet, error) {
+  var header [2]byte
+
+  if _, err := io.ReadFull(r, header[:]); err != nil {
+    return nil, err
+  }
+
+  length := int(header[1])
+
+  // In the long form, the low bits give the number of length bytes which
+  // follow.
+  if length&0x80 != 0 {
+    n := length & 0x7f
+    if n == 0 || n > 4 {
+      return nil, ErrMalformed
+    }
+
+    lb := make([]byte, n)
+    if _, err := io.ReadFull(r, lb); err != nil {
+      return nil, err
+    }
+
+    length = 0
+    for _, b := range lb {
+      length = length<<8 | int(b)
+    }
+  }
+
+  if length > MaxLength {
+    return nil, fmt.Errorf("ber: element of %d bytes is too large", length)
+  }
+
+  content := make([]byte, length)
+  if _, err := io.ReadFull(r, content); err != nil {
+    return nil, err
+  }
+
+  return decode(header[0], content)
+}
+
+// Parse decodes a single element from the start of b, returning it and the
+// number of bytes it took up.
+func Parse(b []byte) (*Packet, int, error) {
+  if len(b) < 2 {
+    return nil, 0, ErrMalformed
+  }
+
+  length, offset := int(b[1]), 2
+
+  if length&0x80 != 0 {
+    n := length & 0x7f
+    if n == 0 || n > 4 || len(b) < 2+n {
+      return nil, 0, ErrMalformed
+    }
+
+    length = 0
+    for _, lb := range b[2 : 2+n] {
+      length = length<<8 | int(lb)
+    }
+
+    offset += n
+  }
+
+  if length < 0 || length > len(b)-offset {
+    return nil, 0, ErrMalformed
+  }
+
+  p, err := decode(b[0], b[offset:offset+length])
+  if err != nil {
+    return nil, 0, err
+  }
+
+  return p, offset + length, nil
+}
+
+func decode(identifier byte, content []byte) (*Packet, error) {
+  // High tag numbers are never used by LDAP.
+  if identifier&0x1f == 0x1f {
+    return nil, ErrMalformed
+  }
+
+  p := &Packet{
+    Class:       identifier & 0xc0,
+    Constructed: identifier&0x20 != 0,
+    Tag:         identifier & 0x1f,
+  }
+
+  if !p.Constructed {
+    p.Value = content
+    return p, nil
+  }
+
+  for len(content) > 0 {
+    child, n, err := Parse(content)
+    if err != nil {
+      return nil, err
+    }
+
+    p.Children = append(p.Children, child)
+    content = content[n:]
+  }
+
+  return p, nil
+}
+
+func encodeLength(n int) []byte {
+  if n < 0x80 {
+    return []byte{byte(n)}
+  }
+
+  var b []byte
+  for ; n > 0; n >>= 8 {
+    b = append([]byte{byte(n)}, b...)
+  }
+
+  return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func encodeInt(n int64) []byte {
+  b := []byte{byte(n)}
+
+  // Prepend bytes until the remaining value is just the sign extension of
+  // the byte we have so far.
+  for (n >= 0 && (n > 0x7f)) || (n < 0 && n < -0x80) {
+    n >>= 8
+    b = append([]byte{byte(n)}, b...)
+  }
+
+  return b
+}
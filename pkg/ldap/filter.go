@@ -0,0 +1,154 @@
+package ldap
+
+import (
+  "encoding/hex"
+  "fmt"
+  "strings"
+
+  "mateuszurbanski/snippetbox/pkg/ldap/ber"
+)
+
+// The context-specific tags of the search filter choices we support.
+const (
+  FilterAnd      byte = 0
+  FilterOr       byte = 1
+  FilterNot      byte = 2
+  FilterEquality byte = 3
+  FilterPresent  byte = 7
+)
+
+// EscapeFilter escapes a value for use in a search filter, so that user input
+// such as "*" or ")" can't change the meaning of the filter.
+func EscapeFilter(s string) string {
+  var b strings.Builder
+
+  for i := 0; i < len(s); i++ {
+    switch c := s[i]; c {
+    case '*', '(', ')', '\\', 0:
+      fmt.Fprintf(&b, "\\%02x", c)
+    default:
+      b.WriteByte(c)
+    }
+  }
+
+  return b.String()
+}
+
+// ParseFilter compiles a string search filter, such as
+// "(&(objectClass=person)(mail=alice@example.com))", to its BER encoding. Only
+// the and, or, not, equality and presence filters are supported.
+func ParseFilter(s string) (*ber.Packet, error) {
+  p, rest, err := parseFilter(strings.TrimSpace(s))
+  if err != nil {
+    return nil, err
+  }
+
+  if rest != "" {
+    return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+  }
+
+  return p, nil
+}
+
+func parseFilter(s string) (*ber.Packet, string, error) {
+  if !strings.HasPrefix(s, "(") {
+    return nil, "", fmt.Errorf("ldap: filter %q must start with '('", s)
+  }
+
+  s = s[1:]
+
+  switch {
+  case strings.HasPrefix(s, "&"), strings.HasPrefix(s, "|"):
+    tag := FilterAnd
+    if s[0] == '|' {
+      tag = FilterOr
+    }
+
+    p := ber.NewConstructed(ber.ClassContext, tag)
+    s = s[1:]
+
+    for strings.HasPrefix(s, "(") {
+      child, rest, err := parseFilter(s)
+      if err != nil {
+        return nil, "", err
+      }
+
+      p.Children = append(p.Children, child)
+      s = rest
+    }
+
+    if !strings.HasPrefix(s, ")") {
+      return nil, "", fmt.Errorf("ldap: unterminated filter")
+    }
+
+    return p, s[1:], nil
+
+  case strings.HasPrefix(s, "!"):
+    child, rest, err := parseFilter(s[1:])
+    if err != nil {
+      return nil, "", err
+    }
+
+    if !strings.HasPrefix(rest, ")") {
+      return nil, "", fmt.Errorf("ldap: unterminated filter")
+    }
+
+    return ber.NewConstructed(ber.ClassContext, FilterNot, child), rest[1:], nil
+  }
+
+  end := strings.IndexByte(s, ')')
+  if end < 0 {
+    return nil, "", fmt.Errorf("ldap: unterminated filter")
+  }
+
+  item, rest := s[:end], s[end+1:]
+
+  eq := strings.IndexByte(item, '=')
+  if eq <= 0 {
+    return nil, "", fmt.Errorf("ldap: invalid filter item %q", item)
+  }
+
+  attr, value := item[:eq], item[eq+1:]
+
+  if value == "*" {
+    return ber.NewPrimitive(ber.ClassContext, FilterPresent, []byte(attr)), rest, nil
+  }
+
+  if strings.ContainsAny(attr, "<>~:") || strings.Contains(value, "*") {
+    return nil, "", fmt.Errorf("ldap: unsupported filter item %q", item)
+  }
+
+  value, err := unescapeFilter(value)
+  if err != nil {
+    return nil, "", err
+  }
+
+  p := ber.NewConstructed(ber.ClassContext, FilterEquality, ber.NewString(attr), ber.NewString(value))
+
+  return p, rest, nil
+}
+
+func unescapeFilter(s string) (string, error) {
+  var b strings.Builder
+
+  for i := 0; i < len(s); i++ {
+    if s[i] != '\\' {
+      b.WriteByte(s[i])
+      continue
+    }
+
+    if i+3 > len(s) {
+      return "", fmt.Errorf("ldap: invalid escape in %q", s)
+    }
+
+    c, err := hex.DecodeString(s[i+1 : i+3])
+    if err != nil {
+      return "", fmt.Errorf("ldap: invalid escape in %q", s)
+    }
+
+    b.Write(c)
+    i += 2
+  }
+
+  return b.String(), nil
+}
@@ -0,0 +1,50 @@
+package ldap
+
+import (
+    "bytes"
+    "testing"
+
+    "mateuszurbanski/snippetbox/pkg/ldap/ber"
+)
+
+func TestEscapeFilter(t *testing.T) {
+    tests := []struct {
+        input string
+        want  string
+    }{
+        {"alice@example.com", "alice@example.com"},
+        {"*", `\2a`},
+        {"a)(uid=*", `a\29\28uid=\2a`},
+        {`back\slash`, `back\5cslash`},
+    }
+
+    for _, tt := range tests {
+        if got := EscapeFilter(tt.input); got != tt.want {
+            t.Errorf("EscapeFilter(%q): want %q; got %q", tt.input, tt.want, got)
+        }
+    }
+}
+
+func TestParseFilter(t *testing.T) {
+    want := ber.NewConstructed(ber.ClassContext, FilterAnd,
+        ber.NewPrimitive(ber.ClassContext, FilterPresent, []byte("objectClass")),
+        ber.NewConstructed(ber.ClassContext, FilterNot,
+            ber.NewConstructed(ber.ClassContext, FilterEquality, ber.NewString("mail"), ber.NewString("a*b")),
+        ),
+    )
+
+    got, err := ParseFilter(`(&(objectClass=*)(!(mail=a\2ab)))`)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !bytes.Equal(got.Bytes(), want.Bytes()) {
+        t.Errorf("want %x; got %x", want.Bytes(), got.Bytes())
+    }
+
+    for _, bad := range []string{"mail=alice", "(mail=alice", "(&(mail=a)", "(mail=a*)", "(mail=alice))", `(mail=\2)`} {
+        if _, err := ParseFilter(bad); err == nil {
+            t.Errorf("ParseFilter(%q): want error", bad)
+        }
+    }
+}
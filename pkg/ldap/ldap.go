@@ -0,0 +1,432 @@
+// Package ldap is a minimal LDAPv3 client which authenticates users against a
+// directory: it looks the user up with a search, then binds as them with the
+// password they gave.
+package ldap
+
+import (
+  "crypto/tls"
+  "errors"
+  "fmt"
+  "net"
+  "net/url"
+  "strings"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/ldap/ber"
+)
+
+// The LDAP operations we use, as application tags.
+const (
+  OpBindRequest      byte = 0
+  OpBindResponse     byte = 1
+  OpUnbindRequest    byte = 2
+  OpSearchRequest    byte = 3
+  OpSearchEntry      byte = 4
+  OpSearchDone       byte = 5
+  OpSearchReference  byte = 19
+  OpExtendedRequest  byte = 23
+  OpExtendedResponse byte = 24
+)
+
+// StartTLSOID is the name of the extended operation which upgrades a plain
+// connection to TLS.
+const StartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// The LDAP result codes we care about.
+const (
+  ResultSuccess                 = 0
+  ResultSizeLimitExceeded       = 4
+  ResultConfidentialityRequired = 13
+  ResultNoSuchObject            = 32
+  ResultInvalidCredentials      = 49
+)
+
+var (
+  ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+  ErrNoSuchUser         = errors.New("ldap: no such user")
+  ErrAmbiguousUser      = errors.New("ldap: more than one entry matches the user filter")
+)
+
+// An Error is a non-success result returned by the directory.
+type Error struct {
+  Code    int
+  Message string
+}
+
+func (e *Error) Error() string {
+  return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Config describes how to find and authenticate users in the directory.
+type Config struct {
+  // URL is the address of the directory, ldap://host:389 or
+  // ldaps://host:636. Plain ldap:// connections are upgraded with StartTLS
+  // before anything is sent, so that passwords never cross the network in
+  // the clear.
+  URL string
+
+  // Insecure skips the StartTLS upgrade of ldap:// connections, for
+  // directories on a trusted network which don't support it.
+  Insecure bool
+
+  // BindDN and BindPassword are the credentials of the service account used
+  // to search for users. If BindDN is empty, searches are anonymous.
+  BindDN       string
+  BindPassword string
+
+  // BaseDN is where searches for users start, and UserFilter is the filter
+  // used to find them. Its %s verb is replaced with the (escaped) username.
+  BaseDN     string
+  UserFilter string
+
+  // Attributes is the list of attributes to fetch for a user.
+  Attributes []string
+
+  Timeout time.Duration
+
+  // TLSConfig is used for ldaps:// and StartTLS connections. If it's nil,
+  // the server's certificate is checked against the system roots.
+  TLSConfig *tls.Config
+}
+
+// An Entry is a directory entry, with attribute names lower-cased.
+type Entry struct {
+  DN         string
+  Attributes map[string][]string
+}
+
+// Get returns the first value of an attribute, or "" if it has none.
+func (e *Entry) Get(attr string) string {
+  if v := e.Attributes[strings.ToLower(attr)]; len(v) > 0 {
+    return v[0]
+  }
+
+  return ""
+}
+
+// GetAll returns every value of an attribute.
+func (e *Entry) GetAll(attr string) []string {
+  return e.Attributes[strings.ToLower(attr)]
+}
+
+// Client authenticates users against a directory.
+type Client struct {
+  config Config
+}
+
+// New returns a client for the given configuration.
+func New(config Config) (*Client, error) {
+  u, err := url.Parse(config.URL)
+  if err != nil {
+    return nil, err
+  }
+
+  if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+    return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+  }
+
+  if config.UserFilter == "" {
+    config.UserFilter = "(mail=%s)"
+  }
+
+  if _, err := ParseFilter(fmt.Sprintf(config.UserFilter, "x")); err != nil {
+    return nil, err
+  }
+
+  if config.Timeout == 0 {
+    config.Timeout = 10 * time.Second
+  }
+
+  return &Client{config: config}, nil
+}
+
+// Authenticate looks up username and checks password by binding as the user.
+// It returns ErrNoSuchUser if the directory has no such user, and
+// ErrInvalidCredentials if the password is wrong.
+func (c *Client) Authenticate(username, password string) (*Entry, error) {
+  // An empty password would make this an "unauthenticated bind", which many
+  // directories accept without checking anything.
+  if password == "" {
+    return nil, ErrInvalidCredentials
+  }
+
+  conn, err := c.dial()
+  if err != nil {
+    return nil, err
+  }
+  defer conn.close()
+
+  if c.config.BindDN != "" {
+    if err := conn.bind(c.config.BindDN, c.config.BindPassword); err != nil {
+      return nil, fmt.Errorf("ldap: service account bind: %w", err)
+    }
+  }
+
+  filter := fmt.Sprintf(c.config.UserFilter, EscapeFilter(username))
+
+  entries, err := conn.search(c.config.BaseDN, filter, c.config.Attributes)
+  if err != nil {
+    return nil, err
+  }
+
+  switch len(entries) {
+  case 0:
+    return nil, ErrNoSuchUser
+  case 1:
+  default:
+    return nil, ErrAmbiguousUser
+  }
+
+  err = conn.bind(entries[0].DN, password)
+
+  var lerr *Error
+  if errors.As(err, &lerr) && lerr.Code == ResultInvalidCredentials {
+    return nil, ErrInvalidCredentials
+  } else if err != nil {
+    return nil, err
+  }
+
+  return entries[0], nil
+}
+
+type conn struct {
+  net.Conn
+  msgID int64
+}
+
+func (c *Client) dial() (*conn, error) {
+  u, err := url.Parse(c.config.URL)
+  if err != nil {
+    return nil, err
+  }
+
+  host := u.Host
+  dialer := &net.Dialer{Timeout: c.config.Timeout}
+
+  config := c.config.TLSConfig
+  if config == nil {
+    config = &tls.Config{ServerName: u.Hostname()}
+  }
+
+  var nc net.Conn
+
+  if u.Scheme == "ldaps" {
+    if u.Port() == "" {
+      host = net.JoinHostPort(u.Hostname(), "636")
+    }
+
+    nc, err = tls.DialWithDialer(dialer, "tcp", host, config)
+  } else {
+    if u.Port() == "" {
+      host = net.JoinHostPort(u.Hostname(), "389")
+    }
+
+    nc, err = dialer.Dial("tcp", host)
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  nc.SetDeadline(time.Now().Add(c.config.Timeout))
+
+  cn := &conn{Conn: nc}
+
+  if u.Scheme == "ldap" && !c.config.Insecure {
+    err = cn.startTLS(config)
+    if err != nil {
+      nc.Close()
+      return nil, fmt.Errorf("ldap: StartTLS: %w", err)
+    }
+  }
+
+  return cn, nil
+}
+
+// The startTLS method asks the server to switch the connection to TLS, and
+// then performs the handshake. Nothing else may be in flight on the
+// connection.
+func (c *conn) startTLS(config *tls.Config) error {
+  id, err := c.send(ber.NewConstructed(ber.ClassApplication, OpExtendedRequest,
+    ber.NewPrimitive(ber.ClassContext, 0, []byte(StartTLSOID)),
+  ))
+  if err != nil {
+    return err
+  }
+
+  op, err := c.receive(id)
+  if err != nil {
+    return err
+  }
+
+  if !op.Is(ber.ClassApplication, OpExtendedResponse) {
+    return ber.ErrMalformed
+  }
+
+  err = result(op)
+  if err != nil {
+    return err
+  }
+
+  tc := tls.Client(c.Conn, config)
+
+  err = tc.Handshake()
+  if err != nil {
+    return err
+  }
+
+  c.Conn = tc
+
+  return nil
+}
+
+func (c *conn) send(op *ber.Packet) (int64, error) {
+  c.msgID++
+
+  _, err := c.Write(ber.NewSequence(ber.NewInteger(c.msgID), op).Bytes())
+
+  return c.msgID, err
+}
+
+// The receive method reads the next message for the given ID and returns its
+// protocol operation.
+func (c *conn) receive(id int64) (*ber.Packet, error) {
+  for {
+    msg, err := ber.Read(c)
+    if err != nil {
+      return nil, err
+    }
+
+    if len(msg.Children) < 2 {
+      return nil, ber.ErrMalformed
+    }
+
+    if msg.Children[0].Int() == id {
+      return msg.Children[1], nil
+    }
+  }
+}
+
+func (c *conn) bind(dn, password string) error {
+  id, err := c.send(ber.NewConstructed(ber.ClassApplication, OpBindRequest,
+    ber.NewInteger(3),
+    ber.NewString(dn),
+    ber.NewPrimitive(ber.ClassContext, 0, []byte(password)),
+  ))
+  if err != nil {
+    return err
+  }
+
+  op, err := c.receive(id)
+  if err != nil {
+    return err
+  }
+
+  if !op.Is(ber.ClassApplication, OpBindResponse) {
+    return ber.ErrMalformed
+  }
+
+  return result(op)
+}
+
+func (c *conn) search(baseDN, filter string, attributes []string) ([]*Entry, error) {
+  f, err := ParseFilter(filter)
+  if err != nil {
+    return nil, err
+  }
+
+  attrs := ber.NewSequence()
+  for _, a := range attributes {
+    attrs.Children = append(attrs.Children, ber.NewString(a))
+  }
+
+  id, err := c.send(ber.NewConstructed(ber.ClassApplication, OpSearchRequest,
+    ber.NewString(baseDN),
+    ber.NewEnumerated(2), // wholeSubtree
+    ber.NewEnumerated(0), // neverDerefAliases
+    ber.NewInteger(2),    // we only ever want one entry; two means ambiguous
+    ber.NewInteger(0),
+    ber.NewBoolean(false),
+    f,
+    attrs,
+  ))
+  if err != nil {
+    return nil, err
+  }
+
+  var entries []*Entry
+
+  for {
+    op, err := c.receive(id)
+    if err != nil {
+      return nil, err
+    }
+
+    switch {
+    case op.Is(ber.ClassApplication, OpSearchEntry):
+      entry, err := parseEntry(op)
+      if err != nil {
+        return nil, err
+      }
+
+      entries = append(entries, entry)
+
+    case op.Is(ber.ClassApplication, OpSearchReference):
+      // We don't chase referrals.
+
+    case op.Is(ber.ClassApplication, OpSearchDone):
+      err := result(op)
+
+      // Hitting our size limit of two still tells us what we need to know.
+      var lerr *Error
+      if errors.As(err, &lerr) && lerr.Code == ResultSizeLimitExceeded && len(entries) > 0 {
+        err = nil
+      }
+
+      return entries, err
+
+    default:
+      return nil, ber.ErrMalformed
+    }
+  }
+}
+
+func (c *conn) close() {
+  c.send(ber.NewPrimitive(ber.ClassApplication, OpUnbindRequest, nil))
+  c.Close()
+}
+
+func parseEntry(op *ber.Packet) (*Entry, error) {
+  if len(op.Children) < 2 {
+    return nil, ber.ErrMalformed
+  }
+
+  entry := &Entry{DN: op.Children[0].String(), Attributes: map[string][]string{}}
+
+  for _, attr := range op.Children[1].Children {
+    if len(attr.Children) < 2 {
+      return nil, ber.ErrMalformed
+    }
+
+    name := strings.ToLower(attr.Children[0].String())
+
+    for _, v := range attr.Children[1].Children {
+      entry.Attributes[name] = append(entry.Attributes[name], v.String())
+    }
+  }
+
+  return entry, nil
+}
+
+func result(op *ber.Packet) error {
+  if len(op.Children) < 3 {
+    return ber.ErrMalformed
+  }
+
+  code := int(op.Children[0].Int())
+  if code == ResultSuccess {
+    return nil
+  }
+
+  return &Error{Code: code, Message: op.Children[2].String()}
+}
@@ -0,0 +1,330 @@
+// Package ldaptest provides an in-process stand-in LDAP directory for tests.
+// It understands StartTLS, simple binds, subtree searches with the filters
+// supported by package ldap, and unbinds. Like a well-configured directory,
+// it refuses binds with a password until the connection is using TLS.
+package ldaptest
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/tls"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "math/big"
+  "net"
+  "strings"
+  "sync"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/ldap"
+  "mateuszurbanski/snippetbox/pkg/ldap/ber"
+)
+
+// An Entry is a directory entry. Users who can bind have a Password.
+type Entry struct {
+  DN         string
+  Password   string
+  Attributes map[string][]string
+}
+
+// Server is a running stand-in directory.
+type Server struct {
+  // URL is the ldap:// URL of the server.
+  URL string
+
+  listener net.Listener
+  entries  []Entry
+  cert     tls.Certificate
+  wg       sync.WaitGroup
+
+  mu    sync.Mutex
+  conns map[net.Conn]bool
+}
+
+// NewServer starts a directory serving the given entries on a local port.
+// Callers should call Close when they are done with it.
+func NewServer(entries ...Entry) *Server {
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    panic(err)
+  }
+
+  cert, err := selfSignedCert()
+  if err != nil {
+    panic(err)
+  }
+
+  s := &Server{
+    URL:      "ldap://" + l.Addr().String(),
+    listener: l,
+    entries:  entries,
+    cert:     cert,
+    conns:    map[net.Conn]bool{},
+  }
+
+  s.wg.Add(1)
+  go s.serve()
+
+  return s
+}
+
+// TLSConfig returns a client TLS config which trusts the server's self-signed
+// certificate, for use with StartTLS.
+func (s *Server) TLSConfig() *tls.Config {
+  pool := x509.NewCertPool()
+  pool.AddCert(s.cert.Leaf)
+
+  return &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+}
+
+// Close stops the server, closing any open connections.
+func (s *Server) Close() {
+  s.listener.Close()
+
+  s.mu.Lock()
+  for c := range s.conns {
+    c.Close()
+  }
+  s.mu.Unlock()
+
+  s.wg.Wait()
+}
+
+func (s *Server) serve() {
+  defer s.wg.Done()
+
+  for {
+    c, err := s.listener.Accept()
+    if err != nil {
+      return
+    }
+
+    s.mu.Lock()
+    s.conns[c] = true
+    s.mu.Unlock()
+
+    s.wg.Add(1)
+    go func() {
+      defer s.wg.Done()
+
+      s.handle(c)
+
+      s.mu.Lock()
+      delete(s.conns, c)
+      s.mu.Unlock()
+
+      c.Close()
+    }()
+  }
+}
+
+func (s *Server) handle(c net.Conn) {
+  secure := false
+
+  for {
+    msg, err := ber.Read(c)
+    if err != nil || len(msg.Children) < 2 {
+      return
+    }
+
+    id, op := msg.Children[0].Int(), msg.Children[1]
+
+    reply := func(op *ber.Packet) {
+      c.Write(ber.NewSequence(ber.NewInteger(id), op).Bytes())
+    }
+
+    switch {
+    case op.Is(ber.ClassApplication, ldap.OpExtendedRequest) && len(op.Children) >= 1 && op.Children[0].String() == ldap.StartTLSOID && !secure:
+      reply(result(ldap.OpExtendedResponse, ldap.ResultSuccess))
+
+      tc := tls.Server(c, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+      if tc.Handshake() != nil {
+        return
+      }
+
+      // The caller closes the original connection, which the TLS
+      // connection wraps.
+      c, secure = tc, true
+
+    case op.Is(ber.ClassApplication, ldap.OpBindRequest) && len(op.Children) >= 3:
+      dn, password := op.Children[1].String(), op.Children[2].String()
+
+      if password != "" && !secure {
+        reply(result(ldap.OpBindResponse, ldap.ResultConfidentialityRequired))
+        continue
+      }
+
+      reply(result(ldap.OpBindResponse, s.bind(dn, password)))
+
+    case op.Is(ber.ClassApplication, ldap.OpSearchRequest) && len(op.Children) >= 8:
+      base, limit := op.Children[0].String(), int(op.Children[3].Int())
+
+      var n int
+      code := ldap.ResultSuccess
+
+      for _, e := range s.entries {
+        if !underBase(e.DN, base) || !match(op.Children[6], e) {
+          continue
+        }
+
+        if limit > 0 && n == limit {
+          code = ldap.ResultSizeLimitExceeded
+          break
+        }
+
+        reply(entry(e, op.Children[7].Children))
+        n++
+      }
+
+      reply(result(ldap.OpSearchDone, code))
+
+    default:
+      return
+    }
+  }
+}
+
+// The bind method checks a simple bind. Anonymous binds always succeed.
+func (s *Server) bind(dn, password string) int {
+  if dn == "" && password == "" {
+    return ldap.ResultSuccess
+  }
+
+  for _, e := range s.entries {
+    if strings.EqualFold(e.DN, dn) && e.Password != "" && e.Password == password {
+      return ldap.ResultSuccess
+    }
+  }
+
+  return ldap.ResultInvalidCredentials
+}
+
+func underBase(dn, base string) bool {
+  dn, base = strings.ToLower(dn), strings.ToLower(base)
+
+  return base == "" || dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+// The match function evaluates a BER-encoded search filter against an entry.
+// Attribute names and values are compared case-insensitively.
+func match(f *ber.Packet, e Entry) bool {
+  switch f.Tag {
+  case ldap.FilterAnd:
+    for _, c := range f.Children {
+      if !match(c, e) {
+        return false
+      }
+    }
+
+    return true
+
+  case ldap.FilterOr:
+    for _, c := range f.Children {
+      if match(c, e) {
+        return true
+      }
+    }
+
+    return false
+
+  case ldap.FilterNot:
+    return len(f.Children) == 1 && !match(f.Children[0], e)
+
+  case ldap.FilterEquality:
+    if len(f.Children) != 2 {
+      return false
+    }
+
+    for _, v := range values(e, f.Children[0].String()) {
+      if strings.EqualFold(v, f.Children[1].String()) {
+        return true
+      }
+    }
+
+    return false
+
+  case ldap.FilterPresent:
+    return len(values(e, f.String())) > 0
+  }
+
+  return false
+}
+
+func values(e Entry, attr string) []string {
+  for name, v := range e.Attributes {
+    if strings.EqualFold(name, attr) {
+      return v
+    }
+  }
+
+  return nil
+}
+
+func entry(e Entry, requested []*ber.Packet) *ber.Packet {
+  attrs := ber.NewSequence()
+
+  for name, vals := range e.Attributes {
+    if len(requested) > 0 && !wanted(name, requested) {
+      continue
+    }
+
+    set := ber.NewSet()
+    for _, v := range vals {
+      set.Children = append(set.Children, ber.NewString(v))
+    }
+
+    attrs.Children = append(attrs.Children, ber.NewSequence(ber.NewString(name), set))
+  }
+
+  return ber.NewConstructed(ber.ClassApplication, ldap.OpSearchEntry, ber.NewString(e.DN), attrs)
+}
+
+func wanted(name string, requested []*ber.Packet) bool {
+  for _, r := range requested {
+    if strings.EqualFold(r.String(), name) {
+      return true
+    }
+  }
+
+  return false
+}
+
+func result(op byte, code int) *ber.Packet {
+  return ber.NewConstructed(ber.ClassApplication, op,
+    ber.NewEnumerated(int64(code)),
+    ber.NewString(""),
+    ber.NewString(""),
+  )
+}
+
+// The selfSignedCert function creates a certificate for 127.0.0.1, for the
+// server to use for StartTLS.
+func selfSignedCert() (tls.Certificate, error) {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+
+  template := &x509.Certificate{
+    SerialNumber: big.NewInt(1),
+    Subject:      pkix.Name{CommonName: "ldaptest"},
+    IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+    NotBefore:    time.Now().Add(-time.Hour),
+    NotAfter:     time.Now().Add(24 * time.Hour),
+    KeyUsage:     x509.KeyUsageDigitalSignature,
+    ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+  }
+
+  der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+
+  leaf, err := x509.ParseCertificate(der)
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+
+  return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,77 @@
+package markdown
+
+import (
+  "container/list"
+  "crypto/sha256"
+  "html/template"
+  "sync"
+)
+
+// A Cache remembers the HTML rendered for recently shown Markdown, so that a
+// popular snippet is only parsed once rather than on every view. It holds at
+// most a fixed number of entries, and forgets the least recently used first.
+// Entries are keyed by a hash of the source, so an edited snippet simply
+// misses the cache. It is safe for concurrent use.
+type Cache struct {
+  mu      sync.Mutex
+  size    int
+  order   *list.List
+  entries map[[sha256.Size]byte]*list.Element
+}
+
+type cacheEntry struct {
+  key  [sha256.Size]byte
+  html template.HTML
+}
+
+// NewCache returns a cache which holds up to size rendered documents.
+func NewCache(size int) *Cache {
+  return &Cache{
+    size:    size,
+    order:   list.New(),
+    entries: map[[sha256.Size]byte]*list.Element{},
+  }
+}
+
+// Render returns the Markdown source as HTML, from the cache if it has been
+// rendered recently.
+func (c *Cache) Render(src string) template.HTML {
+  key := sha256.Sum256([]byte(src))
+
+  c.mu.Lock()
+  if e, ok := c.entries[key]; ok {
+    c.order.MoveToFront(e)
+    c.mu.Unlock()
+
+    return e.Value.(*cacheEntry).html
+  }
+  c.mu.Unlock()
+
+  // Render outside the lock, so that one large document doesn't hold up
+  // everybody else. Two requests may render the same source at once, which is
+  // harmless.
+  html := Render(src)
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if _, ok := c.entries[key]; !ok && c.size > 0 {
+    c.entries[key] = c.order.PushFront(&cacheEntry{key, html})
+
+    for c.order.Len() > c.size {
+      oldest := c.order.Back()
+      c.order.Remove(oldest)
+      delete(c.entries, oldest.Value.(*cacheEntry).key)
+    }
+  }
+
+  return html
+}
+
+// Len returns the number of documents in the cache.
+func (c *Cache) Len() int {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return c.order.Len()
+}
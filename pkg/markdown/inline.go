@@ -0,0 +1,459 @@
+package markdown
+
+import (
+  "html"
+  "html/template"
+  "regexp"
+  "strings"
+)
+
+// Limits on how far the inline parser looks for the end of a link or URL, and
+// how deeply emphasis and links can be nested, so that no input can make
+// rendering slow.
+const (
+  maxURL      = 2048
+  maxLinkText = 1024
+  maxDepth    = 16
+)
+
+var (
+  entity   = regexp.MustCompile(`^&(?:[A-Za-z][A-Za-z0-9]{1,31}|#[0-9]{1,7}|#[xX][0-9A-Fa-f]{1,6});`)
+  autolink = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*|[A-Za-z0-9.!#$%&'*+/=?^_{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*)>`)
+  bareURL  = regexp.MustCompile(`^https?://[^\s<>]+`)
+)
+
+// The inliner renders the inline content of a block: emphasis, code spans,
+// links and so on. Links can't be nested, so links inside the text of a link
+// are rendered as plain text.
+type inliner struct {
+  inLink bool
+  depth  int
+
+  // unclosed holds the delimiters (like "**" or "``") which were found to
+  // have no closing delimiter. Later openers needn't look again, which keeps
+  // text full of stray asterisks from taking quadratic time.
+  unclosed map[string]bool
+
+  // tags is the stack of raw HTML tags which have been opened but not closed
+  // yet. Any left open at the end are closed, so that a stray <b> can't
+  // spill out into the rest of the page.
+  tags []string
+}
+
+func renderInline(s string) string {
+  return (&inliner{}).render(s)
+}
+
+// The render method returns the HTML for the text s.
+func (in *inliner) render(s string) string {
+  var b strings.Builder
+
+  // Text is copied in runs, between the characters which might start
+  // something.
+  plain := 0
+  flush := func(i int) {
+    b.WriteString(template.HTMLEscapeString(s[plain:i]))
+  }
+
+  for i := 0; i < len(s); {
+    out, n := in.span(s, i)
+    if n == 0 {
+      i++
+      continue
+    }
+
+    flush(i)
+    b.WriteString(out)
+    i += n
+    plain = i
+  }
+
+  flush(len(s))
+
+  for len(in.tags) > 0 {
+    b.WriteString("</" + in.tags[len(in.tags)-1] + ">")
+    in.tags = in.tags[:len(in.tags)-1]
+  }
+
+  return b.String()
+}
+
+// The span method tries to parse an inline element at s[i]. It returns the
+// element's HTML and the number of bytes it used, or 0 if there isn't one.
+func (in *inliner) span(s string, i int) (string, int) {
+  rest := s[i:]
+
+  switch s[i] {
+  case '\\':
+    if len(rest) > 1 && rest[1] == '\n' {
+      return "<br>\n", 2
+    }
+
+    if len(rest) > 1 && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", rest[1]) >= 0 {
+      return template.HTMLEscapeString(rest[1:2]), 2
+    }
+
+  case '\n':
+    return "\n", 1
+
+  case ' ':
+    // Two or more spaces at the end of a line make a hard line break.
+    n := len(rest) - len(strings.TrimLeft(rest, " "))
+    if n < len(rest) && rest[n] == '\n' {
+      if n >= 2 {
+        return "<br>\n", n + 1
+      }
+
+      return "\n", n + 1
+    }
+
+    return rest[:n], n
+
+  case '`':
+    return in.codeSpan(rest)
+
+  case '&':
+    if m := entity.FindString(rest); m != "" {
+      if decoded := html.UnescapeString(m); decoded != m {
+        return template.HTMLEscapeString(decoded), len(m)
+      }
+    }
+
+  case '<':
+    if m := autolink.FindStringSubmatch(prefix(rest, maxURL)); m != nil && !in.inLink {
+      href := m[1]
+      if !strings.Contains(href, ":") {
+        href = "mailto:" + href
+      }
+
+      if href, ok := safeURL(href, false); ok {
+        return `<a href="` + href + `" rel="nofollow">` + template.HTMLEscapeString(m[1]) + `</a>`, len(m[0])
+      }
+    }
+
+    return in.rawHTML(rest)
+
+  case '!':
+    if strings.HasPrefix(rest, "![") {
+      return in.link(rest[1:], true)
+    }
+
+  case '[':
+    if !in.inLink {
+      return in.link(rest, false)
+    }
+
+  case '*', '_':
+    // An underscore inside a word, like snake_case, isn't emphasis.
+    if s[i] == '_' && i > 0 && isAlnum(s[i-1]) {
+      return "", 0
+    }
+
+    // A pair which doesn't open strong text is just text.
+    if strings.HasPrefix(rest, rest[:1]+rest[:1]) {
+      if out, n := in.emphasis(rest, rest[:2], "strong"); n > 0 {
+        return out, n
+      }
+
+      return rest[:2], 2
+    }
+
+    return in.emphasis(rest, rest[:1], "em")
+
+  case '~':
+    if strings.HasPrefix(rest, "~~") {
+      return in.emphasis(rest, "~~", "del")
+    }
+
+  case 'h':
+    if in.inLink || i > 0 && isAlnum(s[i-1]) {
+      return "", 0
+    }
+
+    if m := bareURL.FindString(prefix(rest, maxURL)); m != "" {
+      m = trimURL(m)
+      if href, ok := safeURL(m, false); ok {
+        return `<a href="` + href + `" rel="nofollow">` + template.HTMLEscapeString(m) + `</a>`, len(m)
+      }
+    }
+  }
+
+  return "", 0
+}
+
+// The codeSpan method parses a code span: text between two runs of backticks
+// of the same length. Its contents are shown as they are.
+func (in *inliner) codeSpan(s string) (string, int) {
+  ticks := len(s) - len(strings.TrimLeft(s, "`"))
+  fence := s[:ticks]
+
+  for i := ticks; i < len(s) && !in.unclosed[fence]; {
+    n := strings.Index(s[i:], fence)
+    if n < 0 {
+      break
+    }
+
+    start := i + n
+    end := start + ticks
+    if end < len(s) && s[end] == '`' || s[start-1] == '`' {
+      // A longer run of backticks doesn't close the span.
+      i = end
+      for i < len(s) && s[i] == '`' {
+        i++
+      }
+      continue
+    }
+
+    code := strings.ReplaceAll(s[ticks:start], "\n", " ")
+    if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+      code = code[1 : len(code)-1]
+    }
+
+    return "<code>" + template.HTMLEscapeString(code) + "</code>", end
+  }
+
+  // Without a closing run, the backticks are just text.
+  in.markUnclosed(fence)
+
+  return template.HTMLEscapeString(fence), ticks
+}
+
+// The emphasis method parses text between two delimiters (like *em*, **strong**
+// or ~~del~~) and wraps it in the tag. The opening delimiter can't be followed
+// by a space, nor the closing one preceded by one.
+func (in *inliner) emphasis(s, delim, tag string) (string, int) {
+  n := len(delim)
+  if len(s) <= n || isSpace(s[n]) || in.unclosed[delim] || in.depth >= maxDepth {
+    return "", 0
+  }
+
+  for i := n; i < len(s); i++ {
+    switch {
+    case s[i] == '\\':
+      i++
+
+    case s[i] == '`':
+      _, skip := in.codeSpan(s[i:])
+      i += skip - 1
+
+    case n == 1 && strings.HasPrefix(s[i:], delim+delim):
+      // A single * doesn't close at a **, which belongs to nested strong
+      // text.
+      i++
+
+    case strings.HasPrefix(s[i:], delim) && i > n && !isSpace(s[i-1]):
+
+      // An underscore which closes emphasis can't be inside a word.
+      if delim[0] == '_' && i+n < len(s) && isAlnum(s[i+n]) {
+        continue
+      }
+
+      inner := &inliner{inLink: in.inLink, depth: in.depth + 1}
+      return "<" + tag + ">" + inner.render(s[n:i]) + "</" + tag + ">", i + n
+    }
+  }
+
+  in.markUnclosed(delim)
+
+  return "", 0
+}
+
+func (in *inliner) markUnclosed(delim string) {
+  if in.unclosed == nil {
+    in.unclosed = map[string]bool{}
+  }
+
+  in.unclosed[delim] = true
+}
+
+// The link method parses a link, [text](url "title"), or an image if image is
+// true. A link to an unsafe URL is rendered as its text alone.
+func (in *inliner) link(s string, image bool) (string, int) {
+  if in.depth >= maxDepth {
+    return "", 0
+  }
+
+  // Find the ] which matches the opening [.
+  depth, end := 0, -1
+
+  for i := 0; i < len(s) && i < maxLinkText && end < 0; i++ {
+    switch s[i] {
+    case '\\':
+      i++
+    case '[':
+      depth++
+    case ']':
+      depth--
+      if depth == 0 {
+        end = i
+      }
+    }
+  }
+
+  if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+    return "", 0
+  }
+
+  dest, title, n := linkTarget(prefix(s[end+2:], maxURL))
+  if n == 0 {
+    return "", 0
+  }
+
+  text := s[1:end]
+  consumed := end + 2 + n
+  if image {
+    consumed++
+  }
+
+  href, ok := safeURL(dest, image)
+
+  if image {
+    if !ok {
+      return template.HTMLEscapeString(text), consumed
+    }
+
+    out := `<img src="` + href + `" alt="` + template.HTMLEscapeString(text) + `"`
+    if title != "" {
+      out += ` title="` + template.HTMLEscapeString(title) + `"`
+    }
+
+    return out + `>`, consumed
+  }
+
+  inner := &inliner{inLink: true, depth: in.depth + 1}
+  label := inner.render(text)
+
+  if !ok {
+    return label, consumed
+  }
+
+  out := `<a href="` + href + `"`
+  if title != "" {
+    out += ` title="` + template.HTMLEscapeString(title) + `"`
+  }
+
+  return out + ` rel="nofollow">` + label + `</a>`, consumed
+}
+
+// The linkTarget function parses the destination and optional title of a link,
+// up to and including the closing parenthesis. It returns 0 for the length if
+// they aren't well formed.
+func linkTarget(s string) (string, string, int) {
+  i := skipSpace(s, 0)
+
+  var dest string
+
+  if i < len(s) && s[i] == '<' {
+    end := strings.IndexAny(s[i:], ">\n")
+    if end < 0 || s[i+end] != '>' {
+      return "", "", 0
+    }
+
+    dest = s[i+1 : i+end]
+    i += end + 1
+  } else {
+    start, depth := i, 0
+
+    for ; i < len(s) && !isSpace(s[i]); i++ {
+      if s[i] == '\\' {
+        i++
+        continue
+      }
+
+      if s[i] == '(' {
+        depth++
+      } else if s[i] == ')' {
+        if depth == 0 {
+          break
+        }
+        depth--
+      }
+    }
+
+    if i > len(s) {
+      return "", "", 0
+    }
+
+    dest = s[start:i]
+  }
+
+  i = skipSpace(s, i)
+
+  var title string
+
+  if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+    end := strings.IndexByte(s[i+1:], s[i])
+    if end < 0 {
+      return "", "", 0
+    }
+
+    title = s[i+1 : i+1+end]
+    i = skipSpace(s, i+end+2)
+  }
+
+  if i >= len(s) || s[i] != ')' {
+    return "", "", 0
+  }
+
+  return unescape(dest), unescape(title), i + 1
+}
+
+// The trimURL function removes punctuation from the end of a bare URL, which
+// is more likely to end the sentence than to be part of the URL.
+func trimURL(u string) string {
+  for len(u) > 0 {
+    last := u[len(u)-1]
+
+    switch {
+    case strings.IndexByte(".,:;!?'\"*_~", last) >= 0:
+      u = u[:len(u)-1]
+    case last == ')' && strings.Count(u, ")") > strings.Count(u, "("):
+      u = u[:len(u)-1]
+    default:
+      return u
+    }
+  }
+
+  return u
+}
+
+// The unescape function removes backslash escapes and decodes entities in a
+// link destination or title.
+func unescape(s string) string {
+  var b strings.Builder
+
+  for i := 0; i < len(s); i++ {
+    if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", s[i+1]) >= 0 {
+      i++
+    }
+
+    b.WriteByte(s[i])
+  }
+
+  return html.UnescapeString(b.String())
+}
+
+// The prefix function returns at most the first n bytes of s.
+func prefix(s string, n int) string {
+  if len(s) > n {
+    return s[:n]
+  }
+
+  return s
+}
+
+func skipSpace(s string, i int) int {
+  for i < len(s) && isSpace(s[i]) {
+    i++
+  }
+
+  return i
+}
+
+func isSpace(c byte) bool {
+  return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isAlnum(c byte) bool {
+  return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
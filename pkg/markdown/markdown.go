@@ -0,0 +1,455 @@
+// Package markdown renders Markdown snippets to HTML on the server. It covers
+// the parts of CommonMark (and the GitHub extensions) people use in notes:
+// headings, paragraphs, emphasis, links, images, lists, block quotes, code
+// spans, fenced code blocks, tables and strikethrough.
+//
+// The output is safe to put straight into a page. Text is always escaped, link
+// and image URLs are limited to safe schemes, and the little raw HTML which is
+// allowed goes through an allowlist which drops every attribute (see
+// sanitize.go). Fenced code blocks are highlighted with the highlight package.
+package markdown
+
+import (
+  "html/template"
+  "regexp"
+  "strconv"
+  "strings"
+
+  "mateuszurbanski/snippetbox/pkg/highlight"
+)
+
+// Render returns the Markdown source as HTML.
+func Render(src string) template.HTML {
+  src = strings.ReplaceAll(src, "\r\n", "\n")
+  src = strings.ReplaceAll(src, "\r", "\n")
+  src = strings.ReplaceAll(src, "\x00", "�")
+
+  var b strings.Builder
+  renderBlocks(&b, strings.Split(src, "\n"), false)
+
+  return template.HTML(b.String())
+}
+
+var (
+  atxHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+  thematicBreak = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+  fenceOpen     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`]*?)[ \t]*$")
+  listMarker    = regexp.MustCompile(`^( {0,3})([-*+]|(\d{1,9})[.)])([ \t]+|$)`)
+  setextLine    = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+  tableDivider  = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// The renderBlocks function writes the HTML for a sequence of block-level
+// elements. Inside a tight list, paragraphs aren't wrapped in <p> tags.
+func renderBlocks(b *strings.Builder, lines []string, tight bool) {
+  for i := 0; i < len(lines); {
+    line := lines[i]
+
+    switch {
+    case isBlank(line):
+      i++
+
+    case fenceOpen.MatchString(line):
+      i = renderFence(b, lines, i)
+
+    case atxHeading.MatchString(line):
+      m := atxHeading.FindStringSubmatch(line)
+      level := strconv.Itoa(len(m[1]))
+      b.WriteString("<h" + level + ">" + renderInline(strings.TrimSpace(m[2])) + "</h" + level + ">\n")
+      i++
+
+    case thematicBreak.MatchString(line):
+      b.WriteString("<hr>\n")
+      i++
+
+    case isQuote(line):
+      i = renderQuote(b, lines, i)
+
+    case listMarker.MatchString(line):
+      i = renderList(b, lines, i)
+
+    case indentation(line) >= 4:
+      i = renderIndentedCode(b, lines, i)
+
+    case i+1 < len(lines) && strings.Contains(line, "|") && tableDivider.MatchString(lines[i+1]):
+      i = renderTable(b, lines, i)
+
+    default:
+      i = renderParagraph(b, lines, i, tight)
+    }
+  }
+}
+
+// The renderFence function writes a fenced code block starting at lines[i],
+// and returns the index of the line after it. The first word of the info
+// string names the language the code is highlighted as.
+func renderFence(b *strings.Builder, lines []string, i int) int {
+  m := fenceOpen.FindStringSubmatch(lines[i])
+  indent, fence := len(m[1]), m[2]
+
+  language := highlight.Text
+  if fields := strings.Fields(m[3]); len(fields) > 0 {
+    if name := highlight.Resolve(fields[0]); name != "" {
+      language = name
+    }
+  }
+
+  var code []string
+
+  for i++; i < len(lines); i++ {
+    trimmed := strings.TrimSpace(lines[i])
+    if indentation(lines[i]) < 4 && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+      i++
+      break
+    }
+
+    code = append(code, stripIndent(lines[i], indent))
+  }
+
+  b.WriteString(`<pre><code class="language-` + language + `">`)
+  if len(code) > 0 {
+    b.WriteString(string(highlight.HTML(language, strings.Join(code, "\n")+"\n")))
+  }
+  b.WriteString("</code></pre>\n")
+
+  return i
+}
+
+// The renderIndentedCode function writes a code block made of lines indented
+// by four or more spaces. It isn't highlighted, since it has no language.
+func renderIndentedCode(b *strings.Builder, lines []string, i int) int {
+  var code []string
+
+  for ; i < len(lines) && (isBlank(lines[i]) || indentation(lines[i]) >= 4); i++ {
+    code = append(code, stripIndent(lines[i], 4))
+  }
+
+  // Blank lines belong to the code block only if more code follows them.
+  end := len(code)
+  for end > 0 && isBlank(code[end-1]) {
+    end--
+  }
+  i -= len(code) - end
+
+  b.WriteString("<pre><code>" + template.HTMLEscapeString(strings.Join(code[:end], "\n")+"\n") + "</code></pre>\n")
+
+  return i
+}
+
+// The renderQuote function writes a block quote. Its contents are rendered as
+// blocks in their own right, so quotes can hold lists, code and other quotes.
+func renderQuote(b *strings.Builder, lines []string, i int) int {
+  var inner []string
+
+  for ; i < len(lines) && isQuote(lines[i]); i++ {
+    line := strings.TrimLeft(lines[i], " ")[1:]
+    if strings.HasPrefix(line, " ") {
+      line = line[1:]
+    }
+
+    inner = append(inner, line)
+  }
+
+  b.WriteString("<blockquote>\n")
+  renderBlocks(b, inner, false)
+  b.WriteString("</blockquote>\n")
+
+  return i
+}
+
+// The renderList function writes a bulleted or numbered list. An item carries
+// on for as long as its lines are indented past the item's marker (or, for
+// paragraph text, aren't indented at all). A list with blank lines between
+// its items is loose, and its paragraphs are wrapped in <p> tags.
+func renderList(b *strings.Builder, lines []string, i int) int {
+  first := listMarker.FindStringSubmatch(lines[i])
+  ordered := first[3] != ""
+  delimiter := first[2][len(first[2])-1:]
+
+  var (
+    items [][]string
+    loose bool
+  )
+
+  for i < len(lines) {
+    m := listMarker.FindStringSubmatch(lines[i])
+    if m == nil || (m[3] != "") != ordered || m[2][len(m[2])-1:] != delimiter {
+      break
+    }
+
+    width := len(m[0])
+    if isBlank(lines[i][width:]) || len(m[4]) > 4 {
+      width = len(m[1]) + len(m[2]) + 1
+    }
+
+    item := []string{strings.TrimLeft(lines[i][len(m[1])+len(m[2]):], " \t")}
+    i++
+
+    for i < len(lines) {
+      line := lines[i]
+
+      if isBlank(line) {
+        // A blank line carries on the item only if the next line is
+        // indented to its content.
+        j := i
+        for j < len(lines) && isBlank(lines[j]) {
+          j++
+        }
+
+        if j == len(lines) || indentation(lines[j]) < width {
+          break
+        }
+
+        loose = true
+        item = append(item, lines[i:j]...)
+        i = j
+        continue
+      }
+
+      if indentation(line) >= width {
+        item = append(item, stripIndent(line, width))
+        i++
+        continue
+      }
+
+      // Lazy continuation: unindented text carries on the item's last
+      // paragraph.
+      if isBlank(item[len(item)-1]) || startsBlock(line) {
+        break
+      }
+
+      item = append(item, line)
+      i++
+    }
+
+    items = append(items, item)
+
+    // A blank line between two items makes the list loose.
+    j := i
+    for j < len(lines) && isBlank(lines[j]) {
+      j++
+    }
+
+    if j < len(lines) && j > i && listMarker.MatchString(lines[j]) {
+      if m := listMarker.FindStringSubmatch(lines[j]); (m[3] != "") == ordered && m[2][len(m[2])-1:] == delimiter {
+        loose = true
+        i = j
+      }
+    }
+  }
+
+  tag := "ul"
+  if ordered {
+    tag = "ol"
+  }
+
+  b.WriteString("<" + tag)
+  if start, _ := strconv.Atoi(first[3]); ordered && start != 1 {
+    b.WriteString(` start="` + strconv.Itoa(start) + `"`)
+  }
+  b.WriteString(">\n")
+
+  for _, item := range items {
+    b.WriteString("<li>")
+    renderBlocks(b, item, !loose)
+    b.WriteString("</li>\n")
+  }
+
+  b.WriteString("</" + tag + ">\n")
+
+  return i
+}
+
+// The renderTable function writes a GitHub-style table: a header row, a row of
+// dashes (with colons to align the columns) and body rows, with the cells
+// separated by pipes.
+func renderTable(b *strings.Builder, lines []string, i int) int {
+  header := splitRow(lines[i])
+
+  var align []string
+  for _, cell := range splitRow(lines[i+1]) {
+    cell = strings.TrimSpace(cell)
+
+    switch {
+    case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+      align = append(align, "center")
+    case strings.HasSuffix(cell, ":"):
+      align = append(align, "right")
+    case strings.HasPrefix(cell, ":"):
+      align = append(align, "left")
+    default:
+      align = append(align, "")
+    }
+  }
+
+  // Cells get a class rather than a style attribute, so that the
+  // Content-Security-Policy doesn't have to allow inline styles.
+  row := func(cells []string, tag string) {
+    b.WriteString("<tr>")
+
+    for n := range align {
+      b.WriteString("<" + tag)
+      if align[n] != "" {
+        b.WriteString(` class="align-` + align[n] + `"`)
+      }
+      b.WriteString(">")
+
+      if n < len(cells) {
+        b.WriteString(renderInline(strings.TrimSpace(cells[n])))
+      }
+
+      b.WriteString("</" + tag + ">")
+    }
+
+    b.WriteString("</tr>\n")
+  }
+
+  b.WriteString("<table>\n<thead>\n")
+  row(header, "th")
+  b.WriteString("</thead>\n")
+
+  i += 2
+
+  if i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|") {
+    b.WriteString("<tbody>\n")
+
+    for ; i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|"); i++ {
+      row(splitRow(lines[i]), "td")
+    }
+
+    b.WriteString("</tbody>\n")
+  }
+
+  b.WriteString("</table>\n")
+
+  return i
+}
+
+// The splitRow function splits a table row into its cells, ignoring the pipes
+// at either end and any which are escaped with a backslash.
+func splitRow(line string) []string {
+  line = strings.TrimSpace(line)
+  line = strings.TrimPrefix(line, "|")
+  if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+    line = line[:len(line)-1]
+  }
+
+  var (
+    cells []string
+    start int
+  )
+
+  for i := 0; i < len(line); i++ {
+    switch line[i] {
+    case '\\':
+      i++
+    case '|':
+      cells = append(cells, line[start:i])
+      start = i + 1
+    }
+  }
+
+  return append(cells, line[start:])
+}
+
+// The renderParagraph function writes a paragraph, which runs until a blank
+// line or the start of another block. A paragraph underlined with = or - is a
+// heading instead.
+func renderParagraph(b *strings.Builder, lines []string, i int, tight bool) int {
+  var text []string
+
+  for ; i < len(lines) && !isBlank(lines[i]); i++ {
+    if len(text) > 0 {
+      if m := setextLine.FindStringSubmatch(lines[i]); m != nil {
+        level := "2"
+        if m[1][0] == '=' {
+          level = "1"
+        }
+
+        content := renderInline(strings.TrimSpace(strings.Join(text, "\n")))
+        b.WriteString("<h" + level + ">" + content + "</h" + level + ">\n")
+
+        return i + 1
+      }
+
+      if startsBlock(lines[i]) {
+        break
+      }
+    }
+
+    text = append(text, strings.TrimLeft(lines[i], " \t"))
+  }
+
+  content := renderInline(strings.TrimSpace(strings.Join(text, "\n")))
+
+  if tight {
+    b.WriteString(content)
+  } else {
+    b.WriteString("<p>" + content + "</p>\n")
+  }
+
+  return i
+}
+
+// The startsBlock function returns true if the line starts a block which
+// interrupts a paragraph.
+func startsBlock(line string) bool {
+  return fenceOpen.MatchString(line) ||
+    atxHeading.MatchString(line) ||
+    thematicBreak.MatchString(line) ||
+    isQuote(line) ||
+    listMarker.MatchString(line) && !isBlank(line[len(listMarker.FindString(line)):])
+}
+
+func isBlank(line string) bool {
+  return strings.TrimSpace(line) == ""
+}
+
+func isQuote(line string) bool {
+  return indentation(line) < 4 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+// The indentation function returns the width of the whitespace at the start of
+// the line, counting tabs to the next multiple of four columns.
+func indentation(line string) int {
+  width := 0
+
+  for _, c := range line {
+    switch c {
+    case ' ':
+      width++
+    case '\t':
+      width += 4 - width%4
+    default:
+      return width
+    }
+  }
+
+  return width
+}
+
+// The stripIndent function removes up to n columns of whitespace from the
+// start of the line.
+func stripIndent(line string, n int) string {
+  width := 0
+
+  for i, c := range line {
+    if width >= n {
+      return line[i:]
+    }
+
+    switch c {
+    case ' ':
+      width++
+    case '\t':
+      width += 4 - width%4
+      if width > n {
+        return strings.Repeat(" ", width-n) + line[i+1:]
+      }
+    default:
+      return line[i:]
+    }
+  }
+
+  return ""
+}
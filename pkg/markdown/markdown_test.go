@@ -0,0 +1,204 @@
+package markdown
+
+import (
+    "crypto/sha256"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestRender(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        want string
+    }{
+        {
+            "Headings",
+            "# Title #\n\nSetext\n===\n\nSub\n---",
+            "<h1>Title</h1>\n<h1>Setext</h1>\n<h2>Sub</h2>\n",
+        },
+        {
+            "Paragraph with inline formatting",
+            "Some *em*, **strong**, ~~gone~~ and `a <b>`.\nHard  \nbreak",
+            "<p>Some <em>em</em>, <strong>strong</strong>, <del>gone</del> and <code>a &lt;b&gt;</code>.\nHard<br>\nbreak</p>\n",
+        },
+        {
+            "Nested emphasis",
+            "*a **b** c* snake_case_name **open *x",
+            "<p><em>a <strong>b</strong> c</em> snake_case_name **open *x</p>\n",
+        },
+        {
+            "Tight list",
+            "- one\n- two\n  - nested\n- three",
+            "<ul>\n<li>one</li>\n<li>two<ul>\n<li>nested</li>\n</ul>\n</li>\n<li>three</li>\n</ul>\n",
+        },
+        {
+            "Loose ordered list",
+            "3. a\n\n4. b\n   more b",
+            "<ol start=\"3\">\n<li><p>a</p>\n</li>\n<li><p>b\nmore b</p>\n</li>\n</ol>\n",
+        },
+        {
+            "Block quote",
+            "> quote\n>\n> > nested",
+            "<blockquote>\n<p>quote</p>\n<blockquote>\n<p>nested</p>\n</blockquote>\n</blockquote>\n",
+        },
+        {
+            "Fenced code is highlighted",
+            "```golang\nfunc main() {}\n```",
+            "<pre><code class=\"language-go\"><span class=\"hl-keyword\">func</span> main() {}\n</code></pre>\n",
+        },
+        {
+            "Fenced code in an unknown language",
+            "~~~brainfuck\n<raw> & ok\n~~~",
+            "<pre><code class=\"language-text\">&lt;raw&gt; &amp; ok\n</code></pre>\n",
+        },
+        {
+            "Indented code",
+            "    x := <1>\n\n    y\n\nafter",
+            "<pre><code>x := &lt;1&gt;\n\ny\n</code></pre>\n<p>after</p>\n",
+        },
+        {
+            "Thematic break",
+            "a\n\n* * *",
+            "<p>a</p>\n<hr>\n",
+        },
+        {
+            "Table",
+            "| a | b |\n|:--|--:|\n| 1 | \\| |",
+            "<table>\n<thead>\n<tr><th class=\"align-left\">a</th><th class=\"align-right\">b</th></tr>\n</thead>\n<tbody>\n<tr><td class=\"align-left\">1</td><td class=\"align-right\">|</td></tr>\n</tbody>\n</table>\n",
+        },
+        {
+            "Links",
+            `[Go](https://go.dev "The Go site") [home](/) <https://go.dev> <me@example.com> see https://example.com/a_(b).`,
+            `<p><a href="https://go.dev" title="The Go site" rel="nofollow">Go</a> <a href="/" rel="nofollow">home</a> ` +
+                `<a href="https://go.dev" rel="nofollow">https://go.dev</a> <a href="mailto:me@example.com" rel="nofollow">me@example.com</a> ` +
+                `see <a href="https://example.com/a_(b)" rel="nofollow">https://example.com/a_(b)</a>.</p>` + "\n",
+        },
+        {
+            "Image",
+            `![A "pond"](/static/img/pond.png)`,
+            `<p><img src="/static/img/pond.png" alt="A &#34;pond&#34;"></p>` + "\n",
+        },
+        {
+            "Entities and escapes",
+            `1 < 2 & 3 &amp; &copy; \*not em\*`,
+            "<p>1 &lt; 2 &amp; 3 &amp; © *not em*</p>\n",
+        },
+        {
+            "Allowed HTML loses its attributes",
+            `<b class="x" onclick="steal()">bold <i>it</b> <kbd>Ctrl</kbd>`,
+            "<p><b>bold <i>it</i></b> <kbd>Ctrl</kbd></p>\n",
+        },
+        {
+            "Unclosed HTML is closed",
+            "<b>bold\n\nnext",
+            "<p><b>bold</b></p>\n<p>next</p>\n",
+        },
+        {
+            "Windows line endings",
+            "# A\r\n\r\nb\r\n",
+            "<h1>A</h1>\n<p>b</p>\n",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := string(Render(tt.src))
+
+            if got != tt.want {
+                t.Errorf("want\n%s\ngot\n%s", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestRenderSanitizes(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        want string
+    }{
+        {"Script", "<script>alert(1)</script>after", "<p>after</p>\n"},
+        {"Script in mixed case", "<ScRiPt>alert(1)</sCrIpT>after", "<p>after</p>\n"},
+        {"Unclosed script", "a <script>alert(1)", "<p>a </p>\n"},
+        {"Style", "<style>body{display:none}</style>", "<p></p>\n"},
+        {"Event handler", `<img src=x onerror="alert(1)">`, "<p></p>\n"},
+        {"Link tag", `<a href="javascript:alert(1)">x</a>`, "<p>x</p>\n"},
+        {"Iframe", `<iframe src="https://evil.example"></iframe>`, "<p></p>\n"},
+        {"Comment", "a<!-- <script>alert(1)</script> -->b", "<p>ab</p>\n"},
+        {"Stray closing tag", "</p></div>x", "<p>x</p>\n"},
+        {"JavaScript link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+        {"JavaScript link in mixed case", "[x](JaVaScRiPt:alert(1))", "<p>x</p>\n"},
+        {"JavaScript link with an entity", "[x](javascript&#58;alert(1))", "<p>x</p>\n"},
+        {"JavaScript link with a tab", "[x](java&Tab;script:alert(1))", "<p>x</p>\n"},
+        {"JavaScript link with an escape", `[x](javascript\:alert(1))`, "<p>x</p>\n"},
+        {"JavaScript autolink", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+        {"Data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>x</p>\n"},
+        {"Data image", "![x](data:image/svg+xml;base64,PHN2Zz4=)", "<p>x</p>\n"},
+        {"Mail image", "![x](mailto:me@example.com)", "<p>x</p>\n"},
+        {"Quotes in a URL", `[x](https://example.com/"onmouseover="alert(1))`, `<p><a href="https://example.com/%22onmouseover=%22alert%281%29" rel="nofollow">x</a></p>` + "\n"},
+        {"Quotes in a title", `[x](/ "a&quot; onclick=&quot;alert(1)")`, `<p><a href="/" title="a&#34; onclick=&#34;alert(1)" rel="nofollow">x</a></p>` + "\n"},
+        {"Code fence info string", "```\"><script>\nx\n```", "<pre><code class=\"language-text\">x\n</code></pre>\n"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := string(Render(tt.src))
+
+            if got != tt.want {
+                t.Errorf("want\n%s\ngot\n%s", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestRenderPathologicalInput(t *testing.T) {
+    inputs := []string{
+        strings.Repeat("*a ", 50000),
+        strings.Repeat("**a ", 50000),
+        strings.Repeat("_a ", 50000),
+        strings.Repeat("`a ", 50000),
+        strings.Repeat("[a ", 50000),
+        strings.Repeat("<!-- ", 50000),
+        strings.Repeat("<script></script>", 50000),
+        strings.Repeat("<a:", 50000),
+        strings.Repeat("> ", 50000),
+        strings.Repeat("- ", 50000),
+        strings.Repeat("*", 100000),
+        strings.Repeat("[a](", 50000),
+        strings.Repeat("1. ", 20000),
+    }
+
+    for _, src := range inputs {
+        start := time.Now()
+        Render(src)
+
+        if d := time.Since(start); d > 2*time.Second {
+            t.Errorf("rendering %q... took %s", src[:10], d)
+        }
+    }
+}
+
+func TestCache(t *testing.T) {
+    c := NewCache(2)
+
+    for _, src := range []string{"# a", "# b", "# a", "# c"} {
+        if got, want := c.Render(src), Render(src); got != want {
+            t.Errorf("want %q; got %q", want, got)
+        }
+    }
+
+    if c.Len() != 2 {
+        t.Errorf("want 2 entries; got %d", c.Len())
+    }
+
+    // "# b" was the least recently used, so it should have been evicted.
+    if _, ok := c.entries[sha256.Sum256([]byte("# b"))]; ok {
+        t.Error("want least recently used entry evicted")
+    }
+
+    if _, ok := c.entries[sha256.Sum256([]byte("# a"))]; !ok {
+        t.Error("want recently used entry kept")
+    }
+}
@@ -0,0 +1,138 @@
+package markdown
+
+import (
+  "html/template"
+  "net/url"
+  "regexp"
+  "strings"
+)
+
+// The raw HTML tags which are allowed through, all of them inline formatting.
+// They are always written without their attributes, which is where scripts
+// (onerror=...), styles and links (href=javascript:...) would hide.
+var allowedTags = map[string]bool{
+  "abbr": true, "b": true, "br": true, "cite": true, "code": true,
+  "del": true, "em": true, "i": true, "ins": true, "kbd": true,
+  "mark": true, "q": true, "s": true, "samp": true, "small": true,
+  "strong": true, "sub": true, "sup": true, "u": true, "var": true,
+}
+
+// The tags which are dropped along with everything inside them, since their
+// contents aren't meant to be read as text.
+var droppedTags = map[string]bool{
+  "iframe": true, "math": true, "noembed": true, "noframes": true,
+  "noscript": true, "object": true, "script": true, "style": true,
+  "svg": true, "template": true, "textarea": true, "title": true,
+  "xmp": true,
+}
+
+const maxTag = 1024
+
+var htmlTag = regexp.MustCompile(`^<(/?)([A-Za-z][A-Za-z0-9-]*)((?:\s+(?:[^<>"']|"[^"]*"|'[^']*')*)?)\s*/?>`)
+
+// The rawHTML method handles a < in the text. Comments and tags which aren't
+// allowed are removed, allowed tags lose their attributes, and anything else
+// is an ordinary < which is escaped.
+func (in *inliner) rawHTML(s string) (string, int) {
+  if strings.HasPrefix(s, "<!--") {
+    if !in.unclosed["<!--"] {
+      if end := strings.Index(s, "-->"); end >= 0 {
+        return "", end + 3
+      }
+    }
+
+    in.markUnclosed("<!--")
+
+    return "&lt;", 1
+  }
+
+  m := htmlTag.FindStringSubmatch(prefix(s, maxTag))
+  if m == nil {
+    return "&lt;", 1
+  }
+
+  closing, name := m[1] == "/", strings.ToLower(m[2])
+
+  switch {
+  case droppedTags[name] && !closing:
+    // Skip to the end of the element, or of the text if it isn't closed.
+    for i := len(m[0]); i < len(s); i++ {
+      end := strings.Index(s[i:], "</")
+      if end < 0 {
+        break
+      }
+
+      i += end
+      if !strings.EqualFold(prefix(s[i+2:], len(name)), name) {
+        continue
+      }
+
+      if gt := strings.IndexByte(s[i:], '>'); gt >= 0 {
+        return "", i + gt + 1
+      }
+
+      break
+    }
+
+    return "", len(s)
+
+  case !allowedTags[name]:
+    return "", len(m[0])
+
+  case name == "br":
+    return "<br>", len(m[0])
+
+  case !closing:
+    in.tags = append(in.tags, name)
+    return "<" + name + ">", len(m[0])
+  }
+
+  // A closing tag closes any tags opened inside its element too. One which
+  // doesn't match an open tag is dropped.
+  for i := len(in.tags) - 1; i >= 0; i-- {
+    if in.tags[i] != name {
+      continue
+    }
+
+    var b strings.Builder
+    for len(in.tags) > i {
+      b.WriteString("</" + in.tags[len(in.tags)-1] + ">")
+      in.tags = in.tags[:len(in.tags)-1]
+    }
+
+    return b.String(), len(m[0])
+  }
+
+  return "", len(m[0])
+}
+
+// The safeURL function checks a link or image URL, and returns it escaped for
+// use in an attribute. Only web links (and email links, for links) are
+// allowed, either absolute or relative; javascript:, data: and the like are
+// not.
+func safeURL(raw string, image bool) (string, bool) {
+  raw = strings.TrimSpace(raw)
+
+  for _, c := range raw {
+    if c < 0x20 || c == 0x7f || c == ' ' {
+      return "", false
+    }
+  }
+
+  u, err := url.Parse(raw)
+  if err != nil {
+    return "", false
+  }
+
+  switch strings.ToLower(u.Scheme) {
+  case "", "http", "https":
+  case "mailto":
+    if image {
+      return "", false
+    }
+  default:
+    return "", false
+  }
+
+  return template.HTMLEscapeString(u.String()), true
+}
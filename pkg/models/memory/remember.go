@@ -0,0 +1,116 @@
+package memory
+
+import (
+  "sync"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// Define a RememberTokenModel type which keeps "remember me" tokens in a map
+// keyed by series, guarded by a mutex.
+type RememberTokenModel struct {
+  mu     sync.Mutex
+  nextID int
+  tokens map[string]*models.RememberToken
+}
+
+// NewRememberTokenModel returns an empty in-memory remember token store.
+func NewRememberTokenModel() *RememberTokenModel {
+  return &RememberTokenModel{nextID: 1, tokens: map[string]*models.RememberToken{}}
+}
+
+func (m *RememberTokenModel) Insert(userID int, series, token string, expires time.Time) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  now := time.Now().UTC()
+
+  m.tokens[series] = &models.RememberToken{
+    ID:        m.nextID,
+    UserID:    userID,
+    Series:    series,
+    TokenHash: models.HashToken(token),
+    Created:   now,
+    LastUsed:  now,
+    Expires:   expires.UTC(),
+  }
+  m.nextID++
+
+  return nil
+}
+
+func (m *RememberTokenModel) Get(series string) (*models.RememberToken, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  t, ok := m.tokens[series]
+  if !ok {
+    return nil, models.ErrNoRecord
+  }
+
+  // Expired tokens are removed lazily when somebody tries to use them.
+  if time.Now().After(t.Expires) {
+    delete(m.tokens, series)
+    return nil, models.ErrNoRecord
+  }
+
+  copy := *t
+  return &copy, nil
+}
+
+func (m *RememberTokenModel) Rotate(series, oldToken, newToken string) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  t, ok := m.tokens[series]
+  if !ok || !t.Matches(oldToken) || time.Now().After(t.Expires) {
+    return models.ErrNoRecord
+  }
+
+  t.PreviousTokenHash = t.TokenHash
+  t.TokenHash = models.HashToken(newToken)
+  t.LastUsed = time.Now().UTC()
+  t.Rotated = t.LastUsed
+
+  return nil
+}
+
+func (m *RememberTokenModel) Delete(series string) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  delete(m.tokens, series)
+
+  return nil
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(userID int) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  for series, t := range m.tokens {
+    if t.UserID == userID {
+      delete(m.tokens, series)
+    }
+  }
+
+  return nil
+}
+
+// The DeleteExpired method removes every expired token, including those which
+// nobody tries to use again.
+func (m *RememberTokenModel) DeleteExpired() error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  now := time.Now()
+
+  for series, t := range m.tokens {
+    if now.After(t.Expires) {
+      delete(m.tokens, series)
+    }
+  }
+
+  return nil
+}
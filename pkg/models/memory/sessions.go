@@ -0,0 +1,190 @@
+// Package memory provides in-memory implementations of the model interfaces,
+// for single-instance deployments that don't want to keep state in MySQL.
+// Everything stored here is lost when the process exits.
+package memory
+
+import (
+  "sort"
+  "sync"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+type session struct {
+  models.Session
+  tokenHash string
+}
+
+// Define a SessionModel type which keeps login sessions in a map, guarded by
+// a mutex. The sessions are also indexed by the hash of their token, which is
+// how every request looks them up.
+type SessionModel struct {
+  mu       sync.Mutex
+  nextID   int
+  sessions map[int]*session
+  byToken  map[string]*session
+}
+
+// NewSessionModel returns an empty in-memory session store. Expired sessions
+// are only removed when they're used, so call DeleteExpired every so often to
+// stop the store from growing without bound.
+func NewSessionModel() *SessionModel {
+  return &SessionModel{nextID: 1, sessions: map[int]*session{}, byToken: map[string]*session{}}
+}
+
+// The delete method removes a session from both maps. The caller must hold
+// the mutex.
+func (m *SessionModel) delete(s *session) {
+  delete(m.sessions, s.ID)
+  delete(m.byToken, s.tokenHash)
+}
+
+// The Insert method stores a new session for a user. Like the MySQL store, it
+// only keeps the hash of the token.
+func (m *SessionModel) Insert(userID int, token, ipAddress, userAgent string, expires time.Time) (int, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  now := time.Now().UTC()
+
+  s := &session{
+    Session: models.Session{
+      ID:        m.nextID,
+      UserID:    userID,
+      IPAddress: ipAddress,
+      UserAgent: userAgent,
+      Created:   now,
+      LastSeen:  now,
+      Expires:   expires.UTC(),
+    },
+    tokenHash: models.HashToken(token),
+  }
+
+  m.sessions[s.ID] = s
+  m.byToken[s.tokenHash] = s
+  m.nextID++
+
+  return s.ID, nil
+}
+
+// The GetByToken method returns the unexpired session matching a token. If
+// there isn't one we return the ErrNoRecord error.
+func (m *SessionModel) GetByToken(token string) (*models.Session, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  s, ok := m.byToken[models.HashToken(token)]
+  if !ok {
+    return nil, models.ErrNoRecord
+  }
+
+  // An expired session is removed as soon as somebody tries to use it,
+  // rather than waiting for the next cleanup.
+  if time.Now().After(s.Expires) {
+    m.delete(s)
+    return nil, models.ErrNoRecord
+  }
+
+  found := s.Session
+  return &found, nil
+}
+
+// The Touch method records that a session has just been used, and from which
+// IP address.
+func (m *SessionModel) Touch(id int, ipAddress string) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  s, ok := m.sessions[id]
+  if !ok {
+    return models.ErrNoRecord
+  }
+
+  s.LastSeen = time.Now().UTC()
+  s.IPAddress = ipAddress
+
+  return nil
+}
+
+// The ListForUser method returns all of a user's unexpired sessions, most
+// recently used first.
+func (m *SessionModel) ListForUser(userID int) ([]*models.Session, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  sessions := []*models.Session{}
+
+  for _, s := range m.sessions {
+    if s.UserID == userID && time.Now().Before(s.Expires) {
+      found := s.Session
+      sessions = append(sessions, &found)
+    }
+  }
+
+  sort.Slice(sessions, func(i, j int) bool {
+    return sessions[i].LastSeen.After(sessions[j].LastSeen)
+  })
+
+  return sessions, nil
+}
+
+// The Delete method revokes one of a user's sessions. A session belonging to
+// somebody else is treated as missing.
+func (m *SessionModel) Delete(id, userID int) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  s, ok := m.sessions[id]
+  if !ok || s.UserID != userID {
+    return models.ErrNoRecord
+  }
+
+  m.delete(s)
+
+  return nil
+}
+
+// The DeleteByToken method removes the session matching a token, which is what
+// happens when a user logs out.
+func (m *SessionModel) DeleteByToken(token string) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  if s, ok := m.byToken[models.HashToken(token)]; ok {
+    m.delete(s)
+  }
+
+  return nil
+}
+
+// The DeleteAllForUser method revokes every session belonging to a user
+// except the one with the ID exceptID. Pass 0 to revoke them all.
+func (m *SessionModel) DeleteAllForUser(userID, exceptID int) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  for id, s := range m.sessions {
+    if s.UserID == userID && id != exceptID {
+      m.delete(s)
+    }
+  }
+
+  return nil
+}
+
+// The DeleteExpired method removes every expired session.
+func (m *SessionModel) DeleteExpired() error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  now := time.Now()
+
+  for _, s := range m.sessions {
+    if now.After(s.Expires) {
+      m.delete(s)
+    }
+  }
+
+  return nil
+}
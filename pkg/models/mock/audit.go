@@ -0,0 +1,75 @@
+package mock
+
+import (
+    "sync"
+    "time"
+
+    "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// Unlike the other mocks, AuditModel keeps the events it is given, so that
+// tests can check what was recorded.
+type AuditModel struct {
+    mu     sync.Mutex
+    lastID int
+    Events []*models.AuditEvent
+}
+
+func (m *AuditModel) Insert(e *models.AuditEvent) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.lastID++
+
+    copy := *e
+    copy.ID = m.lastID
+    copy.Created = time.Now().UTC()
+
+    m.Events = append(m.Events, &copy)
+
+    return nil
+}
+
+func (m *AuditModel) DeleteBefore(before time.Time) (int, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    kept := []*models.AuditEvent{}
+
+    for _, e := range m.Events {
+        if !e.Created.Before(before) {
+            kept = append(kept, e)
+        }
+    }
+
+    n := len(m.Events) - len(kept)
+    m.Events = kept
+
+    return n, nil
+}
+
+func (m *AuditModel) List(f models.AuditFilter, offset, limit int) ([]*models.AuditEvent, int, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    events := []*models.AuditEvent{}
+
+    for i := len(m.Events) - 1; i >= 0; i-- {
+        if f.Matches(m.Events[i]) {
+            events = append(events, m.Events[i])
+        }
+    }
+
+    total := len(events)
+
+    if offset > len(events) {
+        offset = len(events)
+    }
+
+    events = events[offset:]
+    if len(events) > limit {
+        events = events[:limit]
+    }
+
+    return events, total, nil
+}
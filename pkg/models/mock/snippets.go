@@ -1,34 +1,333 @@
 package mock
 
 import (
+    "strings"
+    "sync"
     "time"
 
     "mateuszurbanski/snippetbox/pkg/models"
 )
 
 var mockSnippet = &models.Snippet{
-    ID:      1,
-    Title:   "An old silent pond",
-    Content: "An old silent pond...",
-    Created: time.Now(),
-    Expires: time.Now(),
+    ID:         1,
+    Slug:       "pondXq7kLm2vRt9w",
+    UserID:     2,
+    Title:      "An old silent pond",
+    Content:    "An old silent pond...",
+    Created:    time.Now(),
+    Updated:    time.Now(),
+    Expires:    time.Now(),
+    Visibility: models.VisibilityPublic,
+    Language:   "text",
+    Format:     models.FormatPlain,
 }
 
-type SnippetModel struct{}
+var mockPrivateSnippet = &models.Snippet{
+    ID:         3,
+    Slug:       "noteB4nW8cYe1sZa",
+    UserID:     1,
+    Title:      "A private note",
+    Content:    "For my eyes only...",
+    Created:    time.Now(),
+    Updated:    time.Now(),
+    Expires:    time.Now(),
+    Visibility: models.VisibilityPrivate,
+    Language:   "text",
+    Format:     models.FormatPlain,
+}
+
+var mockUnlistedSnippet = &models.Snippet{
+    ID:         4,
+    Slug:       "haikuJ6dF0gHp3uT",
+    UserID:     3,
+    Title:      "An unlisted haiku",
+    Content:    "Over the wintry forest...",
+    Created:    time.Now(),
+    Updated:    time.Now(),
+    Expires:    time.Now(),
+    Visibility: models.VisibilityUnlisted,
+    Language:   "text",
+    Format:     models.FormatPlain,
+}
+
+var mockMarkdownSnippet = &models.Snippet{
+    ID:         5,
+    Slug:       "notesQ5rT7yU9iOp",
+    UserID:     3,
+    Title:      "Release notes",
+    Content:    "# Release notes\n\n- Faster *search*\n- <script>alert(1)</script>Safer links\n\n```go\nfunc main() {}\n```\n",
+    Created:    time.Now(),
+    Updated:    time.Now(),
+    Expires:    time.Now(),
+    Visibility: models.VisibilityUnlisted,
+    Format:     models.FormatMarkdown,
+}
+
+var mockMultiFileSnippet = &models.Snippet{
+    ID:         6,
+    Slug:       "gistM8nB2vC4xZ6q",
+    UserID:     3,
+    Title:      "Hello, modules",
+    Content:    "package main\n\nfunc main() {}\n",
+    Created:    time.Now(),
+    Updated:    time.Now(),
+    Expires:    time.Now(),
+    Visibility: models.VisibilityPublic,
+    Language:   "go",
+    Format:     models.FormatPlain,
+    Files: []*models.SnippetFile{
+        {Filename: "main.go", Language: "go", Content: "package main\n\nfunc main() {}\n"},
+        {Filename: "go.mod", Language: "text", Content: "module hello\n\ngo 1.16\n"},
+        {Filename: "README.md", Language: "", Content: "# Hello\n\nRun it with *go run*.\n"},
+    },
+}
+
+var mockBurnSnippet = &models.Snippet{
+    ID:               7,
+    Slug:             "burnR2tY4uI6oP8a",
+    UserID:           3,
+    Title:            "A secret",
+    Content:          "This message will self-destruct...",
+    Created:          time.Now(),
+    Updated:          time.Now(),
+    Visibility:       models.VisibilityUnlisted,
+    Language:         "text",
+    Format:           models.FormatPlain,
+    BurnAfterReading: true,
+}
+
+var mockBurnMultiFileSnippet = &models.Snippet{
+    ID:               8,
+    Slug:             "burnF3gH5jK7lZ9x",
+    UserID:           3,
+    Title:            "Secret config",
+    Content:          "user=admin\n",
+    Created:          time.Now(),
+    Updated:          time.Now(),
+    Visibility:       models.VisibilityUnlisted,
+    Language:         "text",
+    Format:           models.FormatPlain,
+    BurnAfterReading: true,
+    Files: []*models.SnippetFile{
+        {Filename: "user.txt", Language: "text", Content: "user=admin\n"},
+        {Filename: "password.txt", Language: "text", Content: "password=hunter2\n"},
+    },
+}
+
+// The history of mockSnippet: it was created as "An old pond" and renamed.
+var mockSnippetRevisions = []*models.SnippetRevision{
+    {
+        ID:         2,
+        SnippetID:  1,
+        Number:     2,
+        AuthorID:   2,
+        AuthorName: "Mallory",
+        Title:      "An old silent pond",
+        Content:    "An old silent pond...",
+        Language:   "text",
+        Format:     models.FormatPlain,
+        Created:    time.Now(),
+    },
+    {
+        ID:         1,
+        SnippetID:  1,
+        Number:     1,
+        AuthorID:   2,
+        AuthorName: "Mallory",
+        Title:      "An old pond",
+        Content:    "An old pond...",
+        Language:   "text",
+        Format:     models.FormatPlain,
+        Created:    time.Now().Add(-time.Hour),
+    },
+}
+
+// SnippetModel remembers which snippets have been burnt, so that a burn after
+// reading snippet can only be read once in each test.
+type SnippetModel struct {
+    burnt sync.Map
+}
 
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+func (m *SnippetModel) Insert(userID int, slug, title string, expires time.Time, visibility, format string, burnAfterReading bool, files []*models.SnippetFile) (int, error) {
     return 2, nil
 }
 
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+    if _, ok := m.burnt.Load(id); ok {
+        return nil, models.ErrNoRecord
+    }
+
     switch id {
     case 1:
         return mockSnippet, nil
+    case 3:
+        return mockPrivateSnippet, nil
+    case 4:
+        return mockUnlistedSnippet, nil
+    case 5:
+        return mockMarkdownSnippet, nil
+    case 6:
+        return mockMultiFileSnippet, nil
+    case 7:
+        return mockBurnSnippet, nil
+    case 8:
+        return mockBurnMultiFileSnippet, nil
     default:
         return nil, models.ErrNoRecord
     }
 }
 
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+    for _, s := range []*models.Snippet{mockSnippet, mockPrivateSnippet, mockUnlistedSnippet, mockMarkdownSnippet, mockMultiFileSnippet, mockBurnSnippet, mockBurnMultiFileSnippet} {
+        if s.Slug == slug {
+            return m.Get(s.ID)
+        }
+    }
+
+    return nil, models.ErrNoRecord
+}
+
+func (m *SnippetModel) Burn(id int) error {
+    s, err := m.Get(id)
+    if err != nil || !s.BurnAfterReading {
+        return models.ErrNoRecord
+    }
+
+    // Only the first caller stores the ID; everybody else finds it there.
+    if _, burnt := m.burnt.LoadOrStore(id, true); burnt {
+        return models.ErrNoRecord
+    }
+
+    return nil
+}
+
 func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
     return []*models.Snippet{mockSnippet}, nil
 }
+
+func (m *SnippetModel) Delete(id int) error {
+    switch id {
+    case 1, 3, 4, 5, 6, 7, 8:
+        return nil
+    default:
+        return models.ErrNoRecord
+    }
+}
+
+func (m *SnippetModel) List(query string, offset, limit int) ([]*models.Snippet, int, error) {
+    if !strings.Contains(mockSnippet.Title, query) && !strings.Contains(mockSnippet.Content, query) {
+        return []*models.Snippet{}, 0, nil
+    }
+
+    if offset > 0 || limit < 1 {
+        return []*models.Snippet{}, 1, nil
+    }
+
+    return []*models.Snippet{mockSnippet}, 1, nil
+}
+
+func (m *SnippetModel) Search(query string, userID, offset, limit int) ([]*models.Snippet, int, error) {
+    snippets := []*models.Snippet{}
+
+    for _, s := range []*models.Snippet{mockBurnSnippet, mockMultiFileSnippet, mockMarkdownSnippet, mockUnlistedSnippet, mockPrivateSnippet, mockSnippet} {
+        if (s.Visibility != models.VisibilityPublic || s.BurnAfterReading) && s.UserID != userID {
+            continue
+        }
+
+        if strings.Contains(s.Title, query) || strings.Contains(s.Content, query) {
+            snippets = append(snippets, s)
+        }
+    }
+
+    total := len(snippets)
+
+    if offset >= total {
+        return []*models.Snippet{}, total, nil
+    }
+
+    if offset+limit < total {
+        snippets = snippets[:offset+limit]
+    }
+
+    return snippets[offset:], total, nil
+}
+
+func (m *SnippetModel) Extend(id, days int, latest time.Time) error {
+    s, err := m.Get(id)
+    if err != nil {
+        return err
+    }
+
+    if s.NeverExpires() {
+        return models.ErrNeverExpires
+    }
+
+    return nil
+}
+
+func (m *SnippetModel) Count() (int, int, error) {
+    return 1, 1, nil
+}
+
+func (m *SnippetModel) ListForUser(userID int) ([]*models.Snippet, error) {
+    snippets := []*models.Snippet{}
+
+    for _, s := range []*models.Snippet{mockBurnSnippet, mockMultiFileSnippet, mockUnlistedSnippet, mockMarkdownSnippet, mockPrivateSnippet, mockSnippet} {
+        if s.UserID == userID {
+            snippets = append(snippets, s)
+        }
+    }
+
+    return snippets, nil
+}
+
+func (m *SnippetModel) Update(id, authorID int, title, format string, files []*models.SnippetFile) (int, error) {
+    revisions, err := m.Revisions(id)
+    if err != nil {
+        return 0, err
+    }
+
+    return revisions[0].Number + 1, nil
+}
+
+func (m *SnippetModel) Revisions(id int) ([]*models.SnippetRevision, error) {
+    s, err := m.Get(id)
+    if err != nil {
+        return nil, err
+    }
+
+    if id == 1 {
+        return mockSnippetRevisions, nil
+    }
+
+    // Other snippets have only been created, never edited.
+    return []*models.SnippetRevision{
+        {
+            ID:        id * 100,
+            SnippetID: id,
+            Number:    1,
+            AuthorID:  s.UserID,
+            Title:     s.Title,
+            Content:   s.Content,
+            Language:  s.Language,
+            Format:    s.Format,
+            Files:     s.Files,
+            Created:   s.Created,
+        },
+    }, nil
+}
+
+func (m *SnippetModel) Revision(id, number int) (*models.SnippetRevision, error) {
+    revisions, err := m.Revisions(id)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, r := range revisions {
+        if r.Number == number {
+            return r, nil
+        }
+    }
+
+    return nil, models.ErrNoRecord
+}
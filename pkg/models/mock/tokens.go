@@ -0,0 +1,52 @@
+package mock
+
+import (
+    "time"
+
+    "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// MockAPIToken is the value of Alice's API token, for tests to send.
+const MockAPIToken = "sbx_aliceQ3wE5rT7yU9iO1pA3sD5fG7hJ9kL1zX3cV5b"
+
+var mockAPIToken = &models.APIToken{
+    ID:        1,
+    UserID:    1,
+    Name:      "Laptop",
+    TokenHash: models.HashToken(MockAPIToken),
+    Created:   time.Now(),
+}
+
+type APITokenModel struct{}
+
+func (m *APITokenModel) Insert(userID int, name, token string) (int, error) {
+    return 2, nil
+}
+
+func (m *APITokenModel) GetByToken(token string) (*models.APIToken, error) {
+    if models.HashToken(token) == mockAPIToken.TokenHash {
+        return mockAPIToken, nil
+    }
+
+    return nil, models.ErrNoRecord
+}
+
+func (m *APITokenModel) Touch(id int) error {
+    return nil
+}
+
+func (m *APITokenModel) ListForUser(userID int) ([]*models.APIToken, error) {
+    if userID == mockAPIToken.UserID {
+        return []*models.APIToken{mockAPIToken}, nil
+    }
+
+    return []*models.APIToken{}, nil
+}
+
+func (m *APITokenModel) Delete(id, userID int) error {
+    if id == mockAPIToken.ID && userID == mockAPIToken.UserID {
+        return nil
+    }
+
+    return models.ErrNoRecord
+}
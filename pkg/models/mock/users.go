@@ -1,6 +1,8 @@
 package mock
 
 import (
+    "strings"
+    "sync"
     "time"
 
     "mateuszurbanski/snippetbox/pkg/models"
@@ -12,33 +14,269 @@ var mockUser = &models.User{
     Email:   "alice@example.com",
     Created: time.Now(),
     Active:  true,
+    Role:    models.RoleUser,
 }
 
-type UserModel struct{}
+var mockModerator = &models.User{
+    ID:      2,
+    Name:    "Mallory",
+    Email:   "mallory@example.com",
+    Created: time.Now(),
+    Active:  true,
+    Role:    models.RoleModerator,
+}
+
+var mockAdmin = &models.User{
+    ID:      3,
+    Name:    "Ada",
+    Email:   "ada@example.com",
+    Created: time.Now(),
+    Active:  true,
+    Role:    models.RoleAdmin,
+}
+
+// Users inserted through the mock are remembered, along with any linked
+// identities, so that they can be looked up again.
+type UserModel struct {
+    mu         sync.Mutex
+    inserted   []*models.User
+    identities map[string]int
+    deletions  map[int]*models.User
+    deleted    map[int]bool
+}
 
 func (m *UserModel) Insert(name, email, password string) error {
     switch email {
-    case "dupe@example.com":
+    case "dupe@example.com", "alice@example.com", "mallory@example.com", "ada@example.com":
         return models.ErrDuplicateEmail
-    default:
-        return nil
     }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.inserted = append(m.inserted, &models.User{
+        ID:      4 + len(m.inserted),
+        Name:    name,
+        Email:   email,
+        Created: time.Now(),
+        Active:  true,
+        Role:    models.RoleUser,
+    })
+
+    return nil
 }
 
 func (m *UserModel) Authenticate(email, password string) (int, error) {
+    if password != "pa$$word" {
+        return 0, models.ErrInvalidCredentials
+    }
+
     switch email {
     case "alice@example.com":
         return 1, nil
+    case "mallory@example.com":
+        return 2, nil
+    case "ada@example.com":
+        return 3, nil
     default:
         return 0, models.ErrInvalidCredentials
     }
 }
 
 func (m *UserModel) Get(id int) (*models.User, error) {
+    m.mu.Lock()
+    deleted, scheduled := m.deleted[id], m.deletions[id]
+    m.mu.Unlock()
+
+    if deleted {
+        return nil, models.ErrNoRecord
+    }
+
+    // Scheduled deletions are kept on a copy of the user, so that the shared
+    // fixtures stay the same for every test.
+    if scheduled != nil {
+        return scheduled, nil
+    }
+
     switch id {
     case 1:
         return mockUser, nil
-    default:
+    case 2:
+        return mockModerator, nil
+    case 3:
+        return mockAdmin, nil
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for _, u := range m.inserted {
+        if u.ID == id {
+            return u, nil
+        }
+    }
+
+    return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+    for _, u := range []*models.User{mockUser, mockModerator, mockAdmin} {
+        if u.Email == email {
+            return u, nil
+        }
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for _, u := range m.inserted {
+        if u.Email == email {
+            return u, nil
+        }
+    }
+
+    return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) GetByIdentity(issuer, subject string) (*models.User, error) {
+    m.mu.Lock()
+    id, ok := m.identities[issuer+" "+subject]
+    m.mu.Unlock()
+
+    if !ok {
         return nil, models.ErrNoRecord
     }
+
+    return m.Get(id)
+}
+
+func (m *UserModel) LinkIdentity(id int, issuer, subject string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if m.identities == nil {
+        m.identities = map[string]int{}
+    }
+
+    m.identities[issuer+" "+subject] = id
+
+    return nil
+}
+
+func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string) error {
+    if id != 1 {
+        return models.ErrNoRecord
+    }
+
+    if currentPassword != "pa$$word" {
+        return models.ErrInvalidCredentials
+    }
+
+    return nil
+}
+
+func (m *UserModel) List(query string, offset, limit int) ([]*models.User, int, error) {
+    users := []*models.User{}
+
+    for _, u := range []*models.User{mockUser, mockModerator, mockAdmin} {
+        if strings.Contains(u.Name, query) || strings.Contains(u.Email, query) {
+            users = append(users, u)
+        }
+    }
+
+    total := len(users)
+
+    if offset > len(users) {
+        offset = len(users)
+    }
+
+    users = users[offset:]
+    if len(users) > limit {
+        users = users[:limit]
+    }
+
+    return users, total, nil
+}
+
+func (m *UserModel) SetActive(id int, active bool) error {
+    _, err := m.Get(id)
+    return err
+}
+
+func (m *UserModel) SetRole(id int, role string) error {
+    u, err := m.Get(id)
+    if err != nil {
+        return err
+    }
+
+    // Only users inserted through the mock are changed, so that the shared
+    // fixtures stay the same for every test.
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for _, i := range m.inserted {
+        if i == u {
+            u.Role = role
+        }
+    }
+
+    return nil
+}
+
+func (m *UserModel) Count() (int, int, error) {
+    return 3, 3, nil
+}
+
+func (m *UserModel) ScheduleDeletion(id int, after time.Time, keepSnippets bool) error {
+    u, err := m.Get(id)
+    if err != nil {
+        return err
+    }
+
+    copy := *u
+    copy.DeleteAfter = after
+    copy.KeepSnippets = keepSnippets
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if m.deletions == nil {
+        m.deletions = map[int]*models.User{}
+    }
+
+    m.deletions[id] = &copy
+
+    return nil
+}
+
+func (m *UserModel) CancelDeletion(id int) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    delete(m.deletions, id)
+
+    return nil
+}
+
+func (m *UserModel) DeleteScheduled(now time.Time) ([]int, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    deleted := []int{}
+
+    for id, u := range m.deletions {
+        if u.DeleteAfter.After(now) {
+            continue
+        }
+
+        if m.deleted == nil {
+            m.deleted = map[int]bool{}
+        }
+
+        m.deleted[id] = true
+        delete(m.deletions, id)
+        deleted = append(deleted, id)
+    }
+
+    return deleted, nil
 }
@@ -1,7 +1,11 @@
 package models
 
 import (
+  "crypto/sha256"
+  "crypto/subtle"
+  "encoding/hex"
   "errors"
+  "strings"
   "time"
 )
 
@@ -9,14 +13,176 @@ var (
   ErrNoRecord           = errors.New("models: no matching record found")
   ErrInvalidCredentials = errors.New("models: invalid credenials")
   ErrDuplicateEmail     = errors.New("models: duplicate email")
+  ErrNeverExpires       = errors.New("models: snippet never expires")
 )
 
+// The roles a user can have. Each role has all the permissions of the roles
+// before it: moderators can do anything users can, and admins anything
+// moderators can.
+const (
+  RoleUser      = "user"
+  RoleModerator = "moderator"
+  RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+  RoleUser:      1,
+  RoleModerator: 2,
+  RoleAdmin:     3,
+}
+
+// ValidRole returns true if role is one of the known roles.
+func ValidRole(role string) bool {
+  _, ok := roleRanks[role]
+  return ok
+}
+
+// OutranksRole returns true if role a has more permissions than role b.
+func OutranksRole(a, b string) bool {
+  return roleRanks[a] > roleRanks[b]
+}
+
+// The visibilities a snippet can have. Public snippets are listed on the home
+// page, unlisted ones can be seen by anybody who has the link, and private ones
+// only by their owner.
+const (
+  VisibilityPublic   = "public"
+  VisibilityUnlisted = "unlisted"
+  VisibilityPrivate  = "private"
+)
+
+// The formats a snippet's content can be written in. Plain snippets are shown
+// as they are (with their syntax highlighted), and Markdown ones are rendered
+// to HTML.
+const (
+  FormatPlain    = "plain"
+  FormatMarkdown = "markdown"
+)
+
+// A Snippet's Expires is the zero time if it never expires. A snippet which
+// is BurnAfterReading is deleted the first time somebody other than its owner
+// reads it.
 type Snippet struct {
-  ID      int
-  Title   string
-  Content string
-  Created time.Time
-  Expires time.Time
+  ID               int
+  Slug             string
+  UserID           int
+  Title            string
+  Content          string
+  Created          time.Time
+  Updated          time.Time
+  Expires          time.Time
+  Visibility       string
+  Language         string
+  Format           string
+  BurnAfterReading bool
+  Files            []*SnippetFile
+}
+
+// A SnippetFile is one of the files of a snippet. Snippets with one file
+// don't need a filename; when a snippet has several, each file has a name
+// which is unique within the snippet. The snippet's Content and Language are
+// those of its first file.
+type SnippetFile struct {
+  Filename string
+  Language string
+  Content  string
+}
+
+// The largest number of files a snippet can have.
+const MaxSnippetFiles = 10
+
+// IsMarkdown returns true if the file should be rendered as Markdown in a
+// snippet written in the given format. Named files are Markdown if their name
+// ends in .md or .markdown; an unnamed file follows the snippet's format.
+func (f *SnippetFile) IsMarkdown(format string) bool {
+  if f.Filename == "" {
+    return format == FormatMarkdown
+  }
+
+  name := strings.ToLower(f.Filename)
+
+  return strings.HasSuffix(name, ".md") || strings.HasSuffix(name, ".markdown")
+}
+
+// AllFiles returns the snippet's files. Snippets loaded in lists don't carry
+// their files, and neither do single-file snippets from older backends, so
+// then it returns one unnamed file made from the snippet's content.
+func (s *Snippet) AllFiles() []*SnippetFile {
+  if len(s.Files) > 0 {
+    return s.Files
+  }
+
+  return []*SnippetFile{{Language: s.Language, Content: s.Content}}
+}
+
+// SameFiles returns true if a and b are the same files, in the same order.
+func SameFiles(a, b []*SnippetFile) bool {
+  if len(a) != len(b) {
+    return false
+  }
+
+  for i := range a {
+    if *a[i] != *b[i] {
+      return false
+    }
+  }
+
+  return true
+}
+
+// VisibleTo returns true if the user may see the snippet. Anonymous visitors
+// are represented by a nil user.
+func (s *Snippet) VisibleTo(u *User) bool {
+  if s.Visibility != VisibilityPrivate {
+    return true
+  }
+
+  return u != nil && s.UserID != 0 && s.UserID == u.ID
+}
+
+// NeverExpires returns true if the snippet is kept until it's deleted.
+func (s *Snippet) NeverExpires() bool {
+  return s.Expires.IsZero()
+}
+
+// BurnsFor returns true if the user reading the snippet would delete it: it's
+// to be burnt after reading, and they aren't its owner. Anonymous visitors are
+// represented by a nil user.
+func (s *Snippet) BurnsFor(u *User) bool {
+  return s.BurnAfterReading && (u == nil || s.UserID == 0 || s.UserID != u.ID)
+}
+
+// URL returns the path of the snippet's page.
+func (s *Snippet) URL() string {
+  return "/s/" + s.Slug
+}
+
+// A SnippetRevision is a version of a snippet. One is stored when the snippet
+// is created and on every edit, and they are never changed afterwards.
+// Numbers count up from 1 for each snippet. AuthorID is 0 (and AuthorName
+// empty) if the author's account has been deleted.
+type SnippetRevision struct {
+  ID         int
+  SnippetID  int
+  Number     int
+  AuthorID   int
+  AuthorName string
+  Title      string
+  Content    string
+  Language   string
+  Format     string
+  Files      []*SnippetFile
+  Created    time.Time
+}
+
+// AllFiles returns the revision's files, or one unnamed file made from its
+// content if it doesn't carry them.
+func (r *SnippetRevision) AllFiles() []*SnippetFile {
+  if len(r.Files) > 0 {
+    return r.Files
+  }
+
+  return []*SnippetFile{{Language: r.Language, Content: r.Content}}
 }
 
 type User struct {
@@ -26,4 +192,183 @@ type User struct {
   HashedPassword []byte
   Created        time.Time
   Active         bool
+  Role           string
+  DeleteAfter    time.Time
+  KeepSnippets   bool
+}
+
+// DeletionScheduled returns true if the user has asked for their account to be
+// deleted, and it is waiting out its grace period.
+func (u *User) DeletionScheduled() bool {
+  return !u.DeleteAfter.IsZero()
+}
+
+// HasRole returns true if the user has the given role, or one that outranks
+// it.
+func (u *User) HasRole(role string) bool {
+  return roleRanks[u.Role] >= roleRanks[role] && roleRanks[role] > 0
+}
+
+// CanEditSnippet returns true if the user may edit the snippet, or restore an
+// older revision of it. Only the owner can.
+func (u *User) CanEditSnippet(s *Snippet) bool {
+  return s.UserID != 0 && s.UserID == u.ID
+}
+
+// CanDeleteSnippet returns true if the user may delete the snippet: their own
+// snippets, or anybody's if they are a moderator.
+func (u *User) CanDeleteSnippet(s *Snippet) bool {
+  return (s.UserID != 0 && s.UserID == u.ID) || u.HasRole(RoleModerator)
+}
+
+// A Session is a server-side login session. The Token is never stored in the
+// clear; backends keep its SHA-256 hash so a leaked table can't be replayed.
+type Session struct {
+  ID        int
+  UserID    int
+  IPAddress string
+  UserAgent string
+  Created   time.Time
+  LastSeen  time.Time
+  Expires   time.Time
+}
+
+// A RememberToken keeps a user logged in across browser restarts. The cookie
+// holds a series identifier, which stays the same for the life of the token,
+// and a secret token which is replaced every time it is used. Only the hash of
+// the secret is stored. If a series turns up with an old secret, the cookie
+// must have been copied, so the user's logins are revoked. The secret it
+// replaced, and when, are kept so that requests which were already on their
+// way with it can be told apart from a copied cookie.
+type RememberToken struct {
+  ID                int
+  UserID            int
+  Series            string
+  TokenHash         string
+  PreviousTokenHash string
+  Created           time.Time
+  LastUsed          time.Time
+  Rotated           time.Time
+  Expires           time.Time
+}
+
+// Matches reports whether token is the current secret for the series.
+func (t *RememberToken) Matches(token string) bool {
+  return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(t.TokenHash)) == 1
+}
+
+// MatchesPrevious reports whether token is the secret the series had before
+// it was last rotated, and the rotation happened no earlier than since.
+func (t *RememberToken) MatchesPrevious(token string, since time.Time) bool {
+  return t.PreviousTokenHash != "" && !t.Rotated.Before(since) &&
+    subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(t.PreviousTokenHash)) == 1
+}
+
+// An APIToken lets scripts and command-line tools act as a user, by sending it
+// in an "Authorization: Bearer" header. Like session tokens, only its hash is
+// stored. LastUsed is zero until the token is first used.
+type APIToken struct {
+  ID        int
+  UserID    int
+  Name      string
+  TokenHash string
+  Created   time.Time
+  LastUsed  time.Time
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of a session token, which is
+// what the session backends store and look tokens up by.
+func HashToken(token string) string {
+  sum := sha256.Sum256([]byte(token))
+
+  return hex.EncodeToString(sum[:])
+}
+
+// The actions recorded in the audit log.
+const (
+  AuditSignup             = "user.signup"
+  AuditLogin              = "user.login"
+  AuditLoginFailed        = "user.login_failed"
+  AuditLogout             = "user.logout"
+  AuditPasswordChange     = "user.password_change"
+  AuditSessionRevoke      = "user.session_revoke"
+  AuditLogoutEverywhere   = "user.logout_everywhere"
+  AuditIdentityLink       = "user.identity_link"
+  AuditRememberTokenReuse = "user.remember_token_reuse"
+  AuditAccountExport      = "user.export"
+  AuditAPITokenCreate     = "user.api_token_create"
+  AuditAPITokenRevoke     = "user.api_token_revoke"
+  AuditDeletionSchedule   = "user.deletion_schedule"
+  AuditDeletionCancel     = "user.deletion_cancel"
+  AuditAccountDelete      = "user.delete"
+  AuditSnippetCreate      = "snippet.create"
+  AuditSnippetEdit        = "snippet.edit"
+  AuditSnippetDelete      = "snippet.delete"
+  AuditSnippetBurn        = "snippet.burn"
+  AuditAdminActivate      = "admin.user_activate"
+  AuditAdminDeactivate    = "admin.user_deactivate"
+  AuditAdminSnippetDelete = "admin.snippet_delete"
+  AuditAdminSnippetExtend = "admin.snippet_extend"
+)
+
+// AuditActions lists every audit action, for filtering the log.
+var AuditActions = []string{
+  AuditSignup,
+  AuditLogin,
+  AuditLoginFailed,
+  AuditLogout,
+  AuditPasswordChange,
+  AuditSessionRevoke,
+  AuditLogoutEverywhere,
+  AuditIdentityLink,
+  AuditRememberTokenReuse,
+  AuditAccountExport,
+  AuditAPITokenCreate,
+  AuditAPITokenRevoke,
+  AuditDeletionSchedule,
+  AuditDeletionCancel,
+  AuditAccountDelete,
+  AuditSnippetCreate,
+  AuditSnippetEdit,
+  AuditSnippetDelete,
+  AuditSnippetBurn,
+  AuditAdminActivate,
+  AuditAdminDeactivate,
+  AuditAdminSnippetDelete,
+  AuditAdminSnippetExtend,
+}
+
+// An AuditEvent is an entry in the append-only audit log. ActorID is the user
+// who performed the action, or 0 if they weren't logged in. Subject identifies
+// what the action was performed on, like "snippet:12" or "user:3".
+type AuditEvent struct {
+  ID        int
+  Created   time.Time
+  Action    string
+  ActorID   int
+  Subject   string
+  IPAddress string
+  UserAgent string
+  RequestID string
+}
+
+// An AuditFilter narrows down the audit events returned by a query. Zero
+// values match everything. BeforeID only matches events older than the one
+// with that ID, which lets exports page through the log a batch at a time
+// without the batches shifting as new events are written.
+type AuditFilter struct {
+  Action   string
+  ActorID  int
+  Since    time.Time
+  Until    time.Time
+  BeforeID int
+}
+
+// Matches returns true if the event passes the filter.
+func (f AuditFilter) Matches(e *AuditEvent) bool {
+  return (f.Action == "" || e.Action == f.Action) &&
+    (f.ActorID == 0 || e.ActorID == f.ActorID) &&
+    (f.Since.IsZero() || !e.Created.Before(f.Since)) &&
+    (f.Until.IsZero() || e.Created.Before(f.Until)) &&
+    (f.BeforeID == 0 || e.ID < f.BeforeID)
 }
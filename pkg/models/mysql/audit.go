@@ -0,0 +1,120 @@
+package mysql
+
+import (
+  "database/sql"
+  "strings"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// Define an AuditModel type which writes the audit log to the audit_events
+// table. There are deliberately no methods to change events, and the only way
+// to remove them is once they are past the retention period; the table's
+// triggers reject any other attempt to do so.
+type AuditModel struct {
+  DB *sql.DB
+}
+
+// We'll use the Insert method to append an event to the audit log.
+func (m *AuditModel) Insert(e *models.AuditEvent) error {
+  stmt := `INSERT INTO audit_events (created, action, actor_id, subject, ip_address, user_agent, request_id)
+  VALUES(UTC_TIMESTAMP(), ?, ?, ?, ?, ?, ?)`
+
+  _, err := m.DB.Exec(stmt, e.Action, e.ActorID, e.Subject, e.IPAddress, e.UserAgent, e.RequestID)
+
+  return err
+}
+
+// The List method returns a page of the events matching the filter, newest
+// first, along with the total number of matching events.
+func (m *AuditModel) List(f models.AuditFilter, offset, limit int) ([]*models.AuditEvent, int, error) {
+  where, args := auditWhere(f)
+
+  var total int
+
+  err := m.DB.QueryRow(`SELECT COUNT(*) FROM audit_events`+where, args...).Scan(&total)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  stmt := `SELECT id, created, action, actor_id, subject, ip_address, user_agent, request_id
+  FROM audit_events` + where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+
+  rows, err := m.DB.Query(stmt, append(args, limit, offset)...)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  defer rows.Close()
+
+  events := []*models.AuditEvent{}
+
+  for rows.Next() {
+    e := &models.AuditEvent{}
+
+    err = rows.Scan(&e.ID, &e.Created, &e.Action, &e.ActorID, &e.Subject, &e.IPAddress, &e.UserAgent, &e.RequestID)
+    if err != nil {
+      return nil, 0, err
+    }
+
+    events = append(events, e)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, 0, err
+  }
+
+  return events, total, nil
+}
+
+// The DeleteBefore method removes the events created before the given time,
+// and returns how many were removed.
+func (m *AuditModel) DeleteBefore(before time.Time) (int, error) {
+  result, err := m.DB.Exec(`DELETE FROM audit_events WHERE created < ?`, before.UTC())
+  if err != nil {
+    return 0, err
+  }
+
+  n, err := result.RowsAffected()
+
+  return int(n), err
+}
+
+// The auditWhere function builds the WHERE clause and its arguments for an
+// audit filter.
+func auditWhere(f models.AuditFilter) (string, []interface{}) {
+  var conditions []string
+  var args []interface{}
+
+  if f.Action != "" {
+    conditions = append(conditions, "action = ?")
+    args = append(args, f.Action)
+  }
+
+  if f.ActorID != 0 {
+    conditions = append(conditions, "actor_id = ?")
+    args = append(args, f.ActorID)
+  }
+
+  if !f.Since.IsZero() {
+    conditions = append(conditions, "created >= ?")
+    args = append(args, f.Since.UTC())
+  }
+
+  if !f.Until.IsZero() {
+    conditions = append(conditions, "created < ?")
+    args = append(args, f.Until.UTC())
+  }
+
+  if f.BeforeID != 0 {
+    conditions = append(conditions, "id < ?")
+    args = append(args, f.BeforeID)
+  }
+
+  if len(conditions) == 0 {
+    return "", nil
+  }
+
+  return " WHERE " + strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,14 @@
+package mysql
+
+import (
+  "strings"
+)
+
+// The likePattern function turns a search query into a LIKE pattern matching
+// any value which contains it. The LIKE wildcards in the query are escaped, so
+// that searching for "100%" finds exactly that.
+func likePattern(query string) string {
+  r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+  return "%" + r.Replace(query) + "%"
+}
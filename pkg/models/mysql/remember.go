@@ -0,0 +1,100 @@
+package mysql
+
+import (
+  "database/sql"
+  "errors"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// Define a RememberTokenModel type which stores "remember me" tokens in the
+// remember_tokens table.
+type RememberTokenModel struct {
+  DB *sql.DB
+}
+
+// We'll use the Insert method to start a new series for a user. Only the hash
+// of the token is written to the database.
+func (m *RememberTokenModel) Insert(userID int, series, token string, expires time.Time) error {
+  stmt := `INSERT INTO remember_tokens (user_id, series, token_hash, created, last_used, expires)
+  VALUES(?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?)`
+
+  _, err := m.DB.Exec(stmt, userID, series, models.HashToken(token), expires.UTC())
+
+  return err
+}
+
+// The Get method returns the unexpired token for a series. If there isn't one
+// we return the ErrNoRecord error.
+func (m *RememberTokenModel) Get(series string) (*models.RememberToken, error) {
+  stmt := `SELECT id, user_id, series, token_hash, previous_token_hash, created, last_used, rotated, expires
+  FROM remember_tokens WHERE series = ? AND expires > UTC_TIMESTAMP()`
+
+  t := &models.RememberToken{}
+
+  var rotated sql.NullTime
+
+  err := m.DB.QueryRow(stmt, series).Scan(&t.ID, &t.UserID, &t.Series, &t.TokenHash, &t.PreviousTokenHash, &t.Created, &t.LastUsed, &rotated, &t.Expires)
+  if err != nil {
+    if errors.Is(err, sql.ErrNoRows) {
+      return nil, models.ErrNoRecord
+    } else {
+      return nil, err
+    }
+  }
+
+  t.Rotated = rotated.Time
+
+  return t, nil
+}
+
+// The Rotate method replaces the token of a series, keeping the hash of the
+// old one. The old token has to match, so that if two requests race to use the
+// same token only one of them wins; the other gets ErrNoRecord.
+func (m *RememberTokenModel) Rotate(series, oldToken, newToken string) error {
+  // MySQL applies the assignments in order, so previous_token_hash gets the
+  // hash from before the update.
+  stmt := `UPDATE remember_tokens SET previous_token_hash = token_hash, token_hash = ?,
+  last_used = UTC_TIMESTAMP(), rotated = UTC_TIMESTAMP()
+  WHERE series = ? AND token_hash = ? AND expires > UTC_TIMESTAMP()`
+
+  result, err := m.DB.Exec(stmt, models.HashToken(newToken), series, models.HashToken(oldToken))
+  if err != nil {
+    return err
+  }
+
+  n, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+
+  if n == 0 {
+    return models.ErrNoRecord
+  }
+
+  return nil
+}
+
+// The Delete method removes a series, which is what happens when a user logs
+// out.
+func (m *RememberTokenModel) Delete(series string) error {
+  _, err := m.DB.Exec(`DELETE FROM remember_tokens WHERE series = ?`, series)
+
+  return err
+}
+
+// The DeleteAllForUser method removes every series belonging to a user.
+func (m *RememberTokenModel) DeleteAllForUser(userID int) error {
+  _, err := m.DB.Exec(`DELETE FROM remember_tokens WHERE user_id = ?`, userID)
+
+  return err
+}
+
+// The DeleteExpired method removes every expired series. Nothing else removes
+// the ones which are never used again.
+func (m *RememberTokenModel) DeleteExpired() error {
+  _, err := m.DB.Exec(`DELETE FROM remember_tokens WHERE expires < UTC_TIMESTAMP()`)
+
+  return err
+}
@@ -0,0 +1,142 @@
+package mysql
+
+import (
+  "database/sql"
+  "errors"
+  "time"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// Define a SessionModel type which stores login sessions in the sessions
+// table.
+type SessionModel struct {
+  DB *sql.DB
+}
+
+// We'll use the Insert method to store a new session for a user. Only the hash
+// of the token is written to the database.
+func (m *SessionModel) Insert(userID int, token, ipAddress, userAgent string, expires time.Time) (int, error) {
+  stmt := `INSERT INTO sessions (user_id, token_hash, ip_address, user_agent, created, last_seen, expires)
+  VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?)`
+
+  result, err := m.DB.Exec(stmt, userID, models.HashToken(token), ipAddress, userAgent, expires.UTC())
+  if err != nil {
+    return 0, err
+  }
+
+  id, err := result.LastInsertId()
+  if err != nil {
+    return 0, err
+  }
+
+  return int(id), nil
+}
+
+// The GetByToken method returns the unexpired session matching a token. If
+// there isn't one we return the ErrNoRecord error.
+func (m *SessionModel) GetByToken(token string) (*models.Session, error) {
+  stmt := `SELECT id, user_id, ip_address, user_agent, created, last_seen, expires FROM sessions
+  WHERE token_hash = ? AND expires > UTC_TIMESTAMP()`
+
+  s := &models.Session{}
+
+  err := m.DB.QueryRow(stmt, models.HashToken(token)).Scan(&s.ID, &s.UserID, &s.IPAddress, &s.UserAgent, &s.Created, &s.LastSeen, &s.Expires)
+  if err != nil {
+    if errors.Is(err, sql.ErrNoRows) {
+      return nil, models.ErrNoRecord
+    } else {
+      return nil, err
+    }
+  }
+
+  return s, nil
+}
+
+// The Touch method records that a session has just been used, and from which
+// IP address.
+func (m *SessionModel) Touch(id int, ipAddress string) error {
+  stmt := `UPDATE sessions SET last_seen = UTC_TIMESTAMP(), ip_address = ? WHERE id = ?`
+
+  _, err := m.DB.Exec(stmt, ipAddress, id)
+
+  return err
+}
+
+// The ListForUser method returns all of a user's unexpired sessions, most
+// recently used first.
+func (m *SessionModel) ListForUser(userID int) ([]*models.Session, error) {
+  stmt := `SELECT id, user_id, ip_address, user_agent, created, last_seen, expires FROM sessions
+  WHERE user_id = ? AND expires > UTC_TIMESTAMP() ORDER BY last_seen DESC`
+
+  rows, err := m.DB.Query(stmt, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  defer rows.Close()
+
+  sessions := []*models.Session{}
+
+  for rows.Next() {
+    s := &models.Session{}
+
+    err = rows.Scan(&s.ID, &s.UserID, &s.IPAddress, &s.UserAgent, &s.Created, &s.LastSeen, &s.Expires)
+    if err != nil {
+      return nil, err
+    }
+
+    sessions = append(sessions, s)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, err
+  }
+
+  return sessions, nil
+}
+
+// The Delete method revokes one of a user's sessions. Scoping the statement
+// by user stops anyone from revoking sessions that aren't their own.
+func (m *SessionModel) Delete(id, userID int) error {
+  result, err := m.DB.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, id, userID)
+  if err != nil {
+    return err
+  }
+
+  n, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+
+  if n == 0 {
+    return models.ErrNoRecord
+  }
+
+  return nil
+}
+
+// The DeleteByToken method removes the session matching a token, which is what
+// happens when a user logs out.
+func (m *SessionModel) DeleteByToken(token string) error {
+  _, err := m.DB.Exec(`DELETE FROM sessions WHERE token_hash = ?`, models.HashToken(token))
+
+  return err
+}
+
+// The DeleteAllForUser method revokes every session belonging to a user
+// except the one with the ID exceptID. Pass 0 to revoke them all.
+func (m *SessionModel) DeleteAllForUser(userID, exceptID int) error {
+  _, err := m.DB.Exec(`DELETE FROM sessions WHERE user_id = ? AND id <> ?`, userID, exceptID)
+
+  return err
+}
+
+// The DeleteExpired method removes every expired session. Nothing else removes
+// the ones which are never used again, along with their IP address and user
+// agent.
+func (m *SessionModel) DeleteExpired() error {
+  _, err := m.DB.Exec(`DELETE FROM sessions WHERE expires < UTC_TIMESTAMP()`)
+
+  return err
+}
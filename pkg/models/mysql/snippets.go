@@ -3,6 +3,8 @@ package mysql
 import (
   "database/sql"
   "errors"
+  "time"
+
   "mateuszurbanski/snippetbox/pkg/models"
 )
 
@@ -11,27 +13,101 @@ type SnippetModel struct {
   DB *sql.DB
 }
 
-// This will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+// The columns selected for a snippet, in the order scanSnippet reads them.
+const snippetColumns = `id, slug, COALESCE(user_id, 0), title, content, created, updated, expires, visibility, language, format, burn_after_reading`
+
+// The condition for snippets which haven't expired. Snippets which never
+// expire have a NULL expiry.
+const snippetLive = `(expires IS NULL OR expires > UTC_TIMESTAMP())`
+
+// The scanSnippet function reads a snippet selected with snippetColumns from a
+// row.
+func scanSnippet(row interface{ Scan(...interface{}) error }) (*models.Snippet, error) {
+  s := &models.Snippet{}
+
+  var expires sql.NullTime
+
+  err := row.Scan(&s.ID, &s.Slug, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Updated, &expires, &s.Visibility, &s.Language, &s.Format, &s.BurnAfterReading)
+  if err != nil {
+    return nil, err
+  }
+
+  s.Expires = expires.Time
+
+  return s, nil
+}
+
+// This will insert a new snippet, with its files, into the database, along
+// with its first revision. A zero expires means it never expires.
+func (m *SnippetModel) Insert(userID int, slug, title string, expires time.Time, visibility, format string, burnAfterReading bool, files []*models.SnippetFile) (int, error) {
   // Write the SQL statement we want to execute. I've split it over two lines
   // for readability (which is why it's surrounded with backquotes instead
   // of normal double quotes).
-  stmt := `INSERT INTO snippets (title, content, created, expires)
-  VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+  stmt := `INSERT INTO snippets (slug, user_id, title, content, created, updated, expires, visibility, language, format, burn_after_reading)
+  VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?, ?, ?, ?, ?)`
+
+  // The snippet, its files and its first revision are inserted in a
+  // transaction, so that there's never a snippet without a history.
+  tx, err := m.DB.Begin()
+  if err != nil {
+    return 0, err
+  }
+
+  // A snippet which never expires is stored with a NULL expiry.
+  var expiry sql.NullTime
+  if !expires.IsZero() {
+    expiry = sql.NullTime{Time: expires.UTC(), Valid: true}
+  }
 
-  // Use the Exec() method on the embedded connection pool to execute the
-  // statement. The first parameter is the SQL statement, followed by the
-  // title, content and expiry values for the placeholder parameters. This
-  // method returns a sql.Result object, which contains some basic
-  // information about what happened when the statement was executed.
-  result, err := m.DB.Exec(stmt, title, content, expires)
+  // Use the Exec() method on the transaction to execute the statement. The
+  // first parameter is the SQL statement, followed by the slug, owner, title,
+  // content, expiry, visibility, language, format and burn after reading
+  // values for the placeholder parameters. The snippet's content and language
+  // are those of its first file. This method returns a sql.Result object,
+  // which contains some basic information about what happened when the
+  // statement was executed.
+  result, err := tx.Exec(stmt, slug, userID, title, files[0].Content, expiry, visibility, files[0].Language, format, burnAfterReading)
   if err != nil {
+    tx.Rollback()
     return 0, err
   }
 
   // Use the LastInsertId() method on the result object to get the ID of our
   // newly inserted record in the snippets table.
   id, err := result.LastInsertId()
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  err = insertFiles(tx, "snippet_files", "snippet_id", id, files)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  stmt = `INSERT INTO snippet_revisions (snippet_id, number, user_id, title, content, language, format, created)
+  SELECT id, 1, user_id, title, content, language, format, created FROM snippets WHERE id = ?`
+
+  result, err = tx.Exec(stmt, id)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  revisionID, err := result.LastInsertId()
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  err = insertFiles(tx, "snippet_revision_files", "revision_id", revisionID, files)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  err = tx.Commit()
   if err != nil {
     return 0, err
   }
@@ -41,12 +117,243 @@ func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
   return int(id), nil
 }
 
+// This will change a snippet's title, format and files, and store the result
+// as its next revision, written by authorID. It returns the number of the new
+// revision.
+func (m *SnippetModel) Update(id, authorID int, title, format string, files []*models.SnippetFile) (int, error) {
+  tx, err := m.DB.Begin()
+  if err != nil {
+    return 0, err
+  }
+
+  // Lock the snippet's row, so that two edits at once can't both take the
+  // same revision number.
+  var current int
+
+  stmt := `SELECT id FROM snippets WHERE id = ? AND ` + snippetLive + ` FOR UPDATE`
+  err = tx.QueryRow(stmt, id).Scan(&current)
+  if err != nil {
+    tx.Rollback()
+
+    if errors.Is(err, sql.ErrNoRows) {
+      return 0, models.ErrNoRecord
+    }
+
+    return 0, err
+  }
+
+  var number int
+
+  stmt = `SELECT COALESCE(MAX(number), 0) + 1 FROM snippet_revisions WHERE snippet_id = ?`
+  err = tx.QueryRow(stmt, id).Scan(&number)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  stmt = `UPDATE snippets SET title = ?, content = ?, language = ?, format = ?, updated = UTC_TIMESTAMP() WHERE id = ?`
+  _, err = tx.Exec(stmt, title, files[0].Content, files[0].Language, format, id)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  // The files are replaced as a whole, since they may have been renamed,
+  // reordered, added or removed.
+  _, err = tx.Exec(`DELETE FROM snippet_files WHERE snippet_id = ?`, id)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  err = insertFiles(tx, "snippet_files", "snippet_id", int64(id), files)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  stmt = `INSERT INTO snippet_revisions (snippet_id, number, user_id, title, content, language, format, created)
+  VALUES(?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+  result, err := tx.Exec(stmt, id, number, authorID, title, files[0].Content, files[0].Language, format)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  revisionID, err := result.LastInsertId()
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  err = insertFiles(tx, "snippet_revision_files", "revision_id", revisionID, files)
+  if err != nil {
+    tx.Rollback()
+    return 0, err
+  }
+
+  err = tx.Commit()
+  if err != nil {
+    return 0, err
+  }
+
+  return number, nil
+}
+
+// The insertFiles function stores the files of a snippet or revision, in
+// order, in the given table. column names the table's reference to the
+// snippet or revision.
+func insertFiles(tx *sql.Tx, table, column string, id int64, files []*models.SnippetFile) error {
+  stmt := `INSERT INTO ` + table + ` (` + column + `, position, filename, language, content)
+  VALUES(?, ?, ?, ?, ?)`
+
+  for i, f := range files {
+    _, err := tx.Exec(stmt, id, i, f.Filename, f.Language, f.Content)
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// The loadFiles method runs a query selecting the id of a snippet or
+// revision, followed by the filename, language and content of its files in
+// order, and returns the files of each snippet or revision by its id.
+func (m *SnippetModel) loadFiles(query string, args ...interface{}) (map[int][]*models.SnippetFile, error) {
+  rows, err := m.DB.Query(query, args...)
+  if err != nil {
+    return nil, err
+  }
+
+  defer rows.Close()
+
+  files := map[int][]*models.SnippetFile{}
+
+  for rows.Next() {
+    var id int
+    f := &models.SnippetFile{}
+
+    err := rows.Scan(&id, &f.Filename, &f.Language, &f.Content)
+    if err != nil {
+      return nil, err
+    }
+
+    files[id] = append(files[id], f)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, err
+  }
+
+  return files, nil
+}
+
+// The withFiles method loads the files of a single snippet.
+func (m *SnippetModel) withFiles(s *models.Snippet) (*models.Snippet, error) {
+  files, err := m.loadFiles(`SELECT snippet_id, filename, language, content FROM snippet_files
+  WHERE snippet_id = ? ORDER BY position`, s.ID)
+  if err != nil {
+    return nil, err
+  }
+
+  s.Files = files[s.ID]
+
+  return s, nil
+}
+
+// The columns selected for a revision, in the order scanRevision reads them.
+const revisionColumns = `r.id, r.snippet_id, r.number, COALESCE(r.user_id, 0), COALESCE(u.name, ''),
+  r.title, r.content, r.language, r.format, r.created`
+
+// The scanRevision function reads a revision selected with revisionColumns
+// from a row.
+func scanRevision(row interface{ Scan(...interface{}) error }) (*models.SnippetRevision, error) {
+  r := &models.SnippetRevision{}
+
+  err := row.Scan(&r.ID, &r.SnippetID, &r.Number, &r.AuthorID, &r.AuthorName, &r.Title, &r.Content, &r.Language, &r.Format, &r.Created)
+  if err != nil {
+    return nil, err
+  }
+
+  return r, nil
+}
+
+// This will return all the revisions of a snippet, newest first.
+func (m *SnippetModel) Revisions(id int) ([]*models.SnippetRevision, error) {
+  stmt := `SELECT ` + revisionColumns + ` FROM snippet_revisions r
+  LEFT JOIN users u ON u.id = r.user_id
+  WHERE r.snippet_id = ? ORDER BY r.number DESC`
+
+  rows, err := m.DB.Query(stmt, id)
+  if err != nil {
+    return nil, err
+  }
+
+  defer rows.Close()
+
+  revisions := []*models.SnippetRevision{}
+
+  for rows.Next() {
+    r, err := scanRevision(rows)
+    if err != nil {
+      return nil, err
+    }
+
+    revisions = append(revisions, r)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, err
+  }
+
+  files, err := m.loadFiles(`SELECT f.revision_id, f.filename, f.language, f.content FROM snippet_revision_files f
+  JOIN snippet_revisions r ON r.id = f.revision_id
+  WHERE r.snippet_id = ? ORDER BY f.position`, id)
+  if err != nil {
+    return nil, err
+  }
+
+  for _, r := range revisions {
+    r.Files = files[r.ID]
+  }
+
+  return revisions, nil
+}
+
+// This will return a specific revision of a snippet.
+func (m *SnippetModel) Revision(id, number int) (*models.SnippetRevision, error) {
+  stmt := `SELECT ` + revisionColumns + ` FROM snippet_revisions r
+  LEFT JOIN users u ON u.id = r.user_id
+  WHERE r.snippet_id = ? AND r.number = ?`
+
+  r, err := scanRevision(m.DB.QueryRow(stmt, id, number))
+  if err != nil {
+    if errors.Is(err, sql.ErrNoRows) {
+      return nil, models.ErrNoRecord
+    } else {
+      return nil, err
+    }
+  }
+
+  files, err := m.loadFiles(`SELECT revision_id, filename, language, content FROM snippet_revision_files
+  WHERE revision_id = ? ORDER BY position`, r.ID)
+  if err != nil {
+    return nil, err
+  }
+
+  r.Files = files[r.ID]
+
+  return r, nil
+}
+
 // This will return a specific snippet based on its id.
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
   // Write the SQL statement we want to execute. Again, I've split it over two
   // lines for readability.
-  stmt := `SELECT id, title, content, created, expires FROM snippets
-  WHERE expires > UTC_TIMESTAMP() AND id = ?`
+  stmt := `SELECT ` + snippetColumns + ` FROM snippets
+  WHERE ` + snippetLive + ` AND id = ?`
 
   // Use the QueryRow() method on the connection pool to execute our
   // SQL statement, passing in the untrusted id variable as the value for the
@@ -54,15 +361,9 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
   // holds the result from the database.
   row := m.DB.QueryRow(stmt, id)
 
-  // Initialize a pointer to a new zeroed Snippet struct.
-  s := &models.Snippet{}
-
-  // Use row.Scan() to copy the values from each field in sql.Row to the
-  // corresponding field in the Snippet struct. Notice that the arguments
-  // to row.Scan are *pointers* to the place you want to copy the data into,
-  // and the number of arguments must be exactly the same as the number of
-  // columns returned by your statement.
-  err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+  // Use scanSnippet() to copy the values from each field in sql.Row to a new
+  // Snippet struct.
+  s, err := scanSnippet(row)
 
   if err != nil {
     // If the query returns no rows, then row.Scan() will return a
@@ -76,15 +377,34 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
     }
   }
 
-  // If everything went ok then return the Snippet object.
-  return s, nil
+  // If everything went ok then return the Snippet object, along with its
+  // files.
+  return m.withFiles(s)
 }
 
-// This will return the 10 most recently created snippets.
+// This will return a specific snippet based on its public slug.
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+  stmt := `SELECT ` + snippetColumns + ` FROM snippets
+  WHERE ` + snippetLive + ` AND slug = ?`
+
+  s, err := scanSnippet(m.DB.QueryRow(stmt, slug))
+  if err != nil {
+    if errors.Is(err, sql.ErrNoRows) {
+      return nil, models.ErrNoRecord
+    } else {
+      return nil, err
+    }
+  }
+
+  return m.withFiles(s)
+}
+
+// This will return the 10 most recently created public snippets. Snippets to
+// be burnt after reading are left out, so that passers-by don't burn them.
 func(m *SnippetModel) Latest() ([]*models.Snippet, error) {
   // Write the SQL statement we want to execute.
-  stmt := `SELECT id, title, content, created, expires FROM snippets
-  WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
+  stmt := `SELECT ` + snippetColumns + ` FROM snippets
+  WHERE ` + snippetLive + ` AND visibility = 'public' AND NOT burn_after_reading ORDER BY created DESC LIMIT 10`
 
   // Use the Query() method on the connection pool to execute our
   // SQL statement. This returns a sql.Rows resultset containing the result of
@@ -112,15 +432,9 @@ func(m *SnippetModel) Latest() ([]*models.Snippet, error) {
   // database connection.
 
   for rows.Next() {
-    // Create a pointer to a new zeroed Snippet struct.
-    s := &models.Snippet{}
-
-    // Use rows.Scan() to copy the values from each field in the row to the
-    // new Snippet object that we created. Again, the arguments to row.Scan()
-    // must be pointers to the place you want to copy the data into, and the
-    // number of arguments must be exactly the same as the number of
-    // columns returned by your statement.
-    err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+    // Use scanSnippet() to copy the values from each field in the row to a
+    // new Snippet object.
+    s, err := scanSnippet(rows)
 
     if err != nil {
       return nil, err
@@ -141,3 +455,210 @@ func(m *SnippetModel) Latest() ([]*models.Snippet, error) {
   // If everything went OK then return the Snippets slice.
   return snippets, nil
 }
+
+// This will delete a specific snippet based on its id.
+func (m *SnippetModel) Delete(id int) error {
+  result, err := m.DB.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+  if err != nil {
+    return err
+  }
+
+  n, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+
+  // If nothing was deleted, the snippet didn't exist.
+  if n == 0 {
+    return models.ErrNoRecord
+  }
+
+  return nil
+}
+
+// This will delete a snippet which is to be burnt after reading, as somebody
+// reads it. It's a single DELETE, so when several people read the snippet at
+// once, only one of them deletes it; the others get ErrNoRecord and must not
+// be shown the snippet.
+func (m *SnippetModel) Burn(id int) error {
+  stmt := `DELETE FROM snippets WHERE id = ? AND burn_after_reading AND ` + snippetLive
+
+  result, err := m.DB.Exec(stmt, id)
+  if err != nil {
+    return err
+  }
+
+  n, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+
+  if n == 0 {
+    return models.ErrNoRecord
+  }
+
+  return nil
+}
+
+// This will return a page of snippets whose title, or the name or content of
+// one of whose files, contains query,
+// newest first, along with the total number of matching snippets. Unlike
+// Latest(), expired snippets are included.
+func (m *SnippetModel) List(query string, offset, limit int) ([]*models.Snippet, int, error) {
+  pattern := likePattern(query)
+
+  return m.page(snippetSearch, []interface{}{pattern, pattern, pattern}, offset, limit)
+}
+
+// This will return a page of the snippets which a user can find by searching,
+// like List(), but only those which haven't expired and are public, or their
+// own. Unlisted snippets are only for people who have been given the link,
+// and so are snippets to be burnt after reading.
+func (m *SnippetModel) Search(query string, userID, offset, limit int) ([]*models.Snippet, int, error) {
+  pattern := likePattern(query)
+
+  where := snippetLive + ` AND ((visibility = ? AND NOT burn_after_reading) OR user_id = ?) AND (` + snippetSearch + `)`
+
+  return m.page(where, []interface{}{models.VisibilityPublic, userID, pattern, pattern, pattern}, offset, limit)
+}
+
+// The condition used by List() and Search() to match snippets against a LIKE
+// pattern, which is given three times.
+const snippetSearch = `title LIKE ? OR id IN (SELECT snippet_id FROM snippet_files WHERE filename LIKE ? OR content LIKE ?)`
+
+// The page helper returns a page of the snippets matching the where clause,
+// newest first, and the total number of them.
+func (m *SnippetModel) page(where string, args []interface{}, offset, limit int) ([]*models.Snippet, int, error) {
+  var total int
+
+  stmt := `SELECT COUNT(*) FROM snippets WHERE ` + where
+  err := m.DB.QueryRow(stmt, args...).Scan(&total)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  stmt = `SELECT ` + snippetColumns + ` FROM snippets
+  WHERE ` + where + ` ORDER BY created DESC LIMIT ? OFFSET ?`
+
+  rows, err := m.DB.Query(stmt, append(args, limit, offset)...)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  defer rows.Close()
+
+  snippets := []*models.Snippet{}
+
+  for rows.Next() {
+    s, err := scanSnippet(rows)
+    if err != nil {
+      return nil, 0, err
+    }
+
+    snippets = append(snippets, s)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, 0, err
+  }
+
+  return snippets, total, nil
+}
+
+// This will return all of a user's snippets, with their files, newest first,
+// including expired ones.
+func (m *SnippetModel) ListForUser(userID int) ([]*models.Snippet, error) {
+  stmt := `SELECT ` + snippetColumns + ` FROM snippets
+  WHERE user_id = ? ORDER BY created DESC`
+
+  rows, err := m.DB.Query(stmt, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  defer rows.Close()
+
+  snippets := []*models.Snippet{}
+
+  for rows.Next() {
+    s, err := scanSnippet(rows)
+    if err != nil {
+      return nil, err
+    }
+
+    snippets = append(snippets, s)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, err
+  }
+
+  files, err := m.loadFiles(`SELECT f.snippet_id, f.filename, f.language, f.content FROM snippet_files f
+  JOIN snippets s ON s.id = f.snippet_id
+  WHERE s.user_id = ? ORDER BY f.position`, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  for _, s := range snippets {
+    s.Files = files[s.ID]
+  }
+
+  return snippets, nil
+}
+
+// This will push back the expiry of a specific snippet by the given number of
+// days, but no later than latest. Expired snippets are extended from now, so
+// they come back to life. Snippets which never expire are left as they are,
+// and we return the ErrNeverExpires error.
+func (m *SnippetModel) Extend(id, days int, latest time.Time) error {
+  stmt := `UPDATE snippets
+  SET expires = LEAST(DATE_ADD(GREATEST(expires, UTC_TIMESTAMP()), INTERVAL ? DAY), ?) WHERE id = ? AND expires IS NOT NULL`
+
+  result, err := m.DB.Exec(stmt, days, latest.UTC(), id)
+  if err != nil {
+    return err
+  }
+
+  n, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+
+  if n > 0 {
+    return nil
+  }
+
+  // Nothing was changed, because the snippet doesn't exist, because it
+  // never expires, or because it already expires at the latest time.
+  var neverExpires bool
+
+  err = m.DB.QueryRow(`SELECT expires IS NULL FROM snippets WHERE id = ?`, id).Scan(&neverExpires)
+  if err != nil {
+    if errors.Is(err, sql.ErrNoRows) {
+      return models.ErrNoRecord
+    }
+
+    return err
+  }
+
+  if neverExpires {
+    return models.ErrNeverExpires
+  }
+
+  return nil
+}
+
+// This will return the total number of snippets, and how many of them
+// haven't expired yet.
+func (m *SnippetModel) Count() (int, int, error) {
+  var total, live int
+
+  stmt := `SELECT COUNT(*), COALESCE(SUM(` + snippetLive + `), 0) FROM snippets`
+  err := m.DB.QueryRow(stmt).Scan(&total, &live)
+  if err != nil {
+    return 0, 0, err
+  }
+
+  return total, live, nil
+}
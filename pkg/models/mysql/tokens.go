@@ -0,0 +1,127 @@
+package mysql
+
+import (
+  "database/sql"
+  "errors"
+
+  "mateuszurbanski/snippetbox/pkg/models"
+)
+
+// Define an APITokenModel type which stores personal API tokens in the
+// api_tokens table.
+type APITokenModel struct {
+  DB *sql.DB
+}
+
+// We'll use the Insert method to add a new token for a user. Only the hash of
+// the token is written to the database, so it can't be shown again.
+func (m *APITokenModel) Insert(userID int, name, token string) (int, error) {
+  stmt := `INSERT INTO api_tokens (user_id, name, token_hash, created)
+  VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+  result, err := m.DB.Exec(stmt, userID, name, models.HashToken(token))
+  if err != nil {
+    return 0, err
+  }
+
+  id, err := result.LastInsertId()
+  if err != nil {
+    return 0, err
+  }
+
+  return int(id), nil
+}
+
+// The columns selected for a token, in the order scanAPIToken reads them.
+const apiTokenColumns = `id, user_id, name, token_hash, created, last_used`
+
+// The scanAPIToken function reads a token selected with apiTokenColumns from a
+// row.
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*models.APIToken, error) {
+  t := &models.APIToken{}
+
+  var lastUsed sql.NullTime
+
+  err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.Created, &lastUsed)
+  if err != nil {
+    return nil, err
+  }
+
+  t.LastUsed = lastUsed.Time
+
+  return t, nil
+}
+
+// The GetByToken method returns the token with the given value. If there isn't
+// one we return the ErrNoRecord error.
+func (m *APITokenModel) GetByToken(token string) (*models.APIToken, error) {
+  stmt := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE token_hash = ?`
+
+  t, err := scanAPIToken(m.DB.QueryRow(stmt, models.HashToken(token)))
+  if err != nil {
+    if errors.Is(err, sql.ErrNoRows) {
+      return nil, models.ErrNoRecord
+    } else {
+      return nil, err
+    }
+  }
+
+  return t, nil
+}
+
+// The Touch method records that a token has just been used.
+func (m *APITokenModel) Touch(id int) error {
+  _, err := m.DB.Exec(`UPDATE api_tokens SET last_used = UTC_TIMESTAMP() WHERE id = ?`, id)
+
+  return err
+}
+
+// The ListForUser method returns a user's tokens, newest first.
+func (m *APITokenModel) ListForUser(userID int) ([]*models.APIToken, error) {
+  stmt := `SELECT ` + apiTokenColumns + ` FROM api_tokens
+  WHERE user_id = ? ORDER BY created DESC, id DESC`
+
+  rows, err := m.DB.Query(stmt, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  defer rows.Close()
+
+  tokens := []*models.APIToken{}
+
+  for rows.Next() {
+    t, err := scanAPIToken(rows)
+    if err != nil {
+      return nil, err
+    }
+
+    tokens = append(tokens, t)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, err
+  }
+
+  return tokens, nil
+}
+
+// The Delete method revokes a token. The user ID is part of the query so that
+// users can only revoke their own tokens; anything else is ErrNoRecord.
+func (m *APITokenModel) Delete(id, userID int) error {
+  result, err := m.DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+  if err != nil {
+    return err
+  }
+
+  n, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+
+  if n == 0 {
+    return models.ErrNoRecord
+  }
+
+  return nil
+}
@@ -4,21 +4,34 @@ import (
   "database/sql"
   "errors"
   "strings"
+  "time"
 
   "mateuszurbanski/snippetbox/pkg/models"
+  "mateuszurbanski/snippetbox/pkg/passwords"
 
   "github.com/go-sql-driver/mysql"
-  "golang.org/x/crypto/bcrypt"
 )
 
+// The Hasher field holds the current password hashing policy. New passwords
+// are hashed with it, and existing hashes which fall short of it are upgraded
+// when their owner next logs in. If it is nil, passwords.DefaultHasher is used.
 type UserModel struct {
-  DB *sql.DB
+  DB     *sql.DB
+  Hasher passwords.Hasher
+}
+
+func (m *UserModel) hasher() passwords.Hasher {
+  if m.Hasher == nil {
+    return passwords.DefaultHasher
+  }
+
+  return m.Hasher
 }
 
 // We'll use the Insert method to add a new record to the users table.
 func(m *UserModel) Insert(name, email, password string) error {
-  // Create a bcrypt hash of the plain-text password.
-  hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+  // Hash the plain-text password using the current policy.
+  hashedPassword, err := m.hasher().Hash(password)
 
   if err != nil {
     return err
@@ -30,7 +43,7 @@ func(m *UserModel) Insert(name, email, password string) error {
   // Use the Exec() method to insert the user details and hashed password
   // into the users table.
 
-  _, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+  _, err = m.DB.Exec(stmt, name, email, hashedPassword)
 
   if err != nil {
     // If this returns an error, we use the errors.As() function to check
@@ -61,7 +74,7 @@ func(m *UserModel) Authenticate(email, password string) (int, error) {
   // matching email exists, or the user is not active, we return the
   // ErrInvalidCredentials error.
   var id int
-  var hashedPassword []byte
+  var hashedPassword string
 
   stmt := "SELECT id, hashed_password FROM users WHERE email =? AND active = TRUE"
   row  := m.DB.QueryRow(stmt, email)
@@ -77,12 +90,29 @@ func(m *UserModel) Authenticate(email, password string) (int, error) {
 
   // Check whether the hashed password and plain-text password provided match.
   // If they don't, we return the ErrInvalidCredentials error.
-  err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+  ok, err := passwords.Verify(password, hashedPassword)
 
   if err != nil {
-    if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-      return 0, models.ErrInvalidCredentials
-    } else {
+    return 0, err
+  }
+
+  if !ok {
+    return 0, models.ErrInvalidCredentials
+  }
+
+  // The password is correct, so this is our one chance to upgrade the stored
+  // hash if it was created with an older algorithm or weaker parameters than
+  // the current policy. The WHERE clause makes sure we never overwrite a
+  // password which was changed in the meantime.
+  if m.hasher().NeedsRehash(hashedPassword) {
+    newHashedPassword, err := m.hasher().Hash(password)
+    if err != nil {
+      return 0, err
+    }
+
+    stmt = "UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?"
+    _, err = m.DB.Exec(stmt, newHashedPassword, id, hashedPassword)
+    if err != nil {
       return 0, err
     }
   }
@@ -91,20 +121,299 @@ func(m *UserModel) Authenticate(email, password string) (int, error) {
   return id, nil
 }
 
+// The columns selected for a user, in the order scanUser expects them.
+const userColumns = `id, name, email, created, active, role, delete_after, keep_snippets`
+
+// The scanUser function reads a user selected with userColumns from a row.
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+  u := &models.User{}
+
+  var deleteAfter sql.NullTime
+
+  err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active, &u.Role, &deleteAfter, &u.KeepSnippets)
+  if err != nil {
+    if errors.Is(err, sql.ErrNoRows) {
+      return nil, models.ErrNoRecord
+    } else {
+      return nil, err
+    }
+  }
+
+  u.DeleteAfter = deleteAfter.Time
+
+  return u, nil
+}
+
 // We'll use the Get method to fetch details for a specific user based
 // on their user ID.
 func (m *UserModel) Get(id int) (*models.User, error) {
-  u := &models.User{}
+  stmt := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+
+  return scanUser(m.DB.QueryRow(stmt, id))
+}
 
-  stmt := `SELECT id, name, email, created, active FROM users WHERE id = ?`
-  err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active)
+// We'll use the ChangePassword method to change a user's password. The current
+// password has to be supplied and match, otherwise ErrInvalidCredentials is
+// returned.
+func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string) error {
+  var currentHashedPassword string
+
+  stmt := "SELECT hashed_password FROM users WHERE id = ?"
+  err := m.DB.QueryRow(stmt, id).Scan(&currentHashedPassword)
   if err != nil {
-      if errors.Is(err, sql.ErrNoRows) {
-          return nil, models.ErrNoRecord
-      } else {
-          return nil, err
-      }
+    if errors.Is(err, sql.ErrNoRows) {
+      return models.ErrNoRecord
+    } else {
+      return err
+    }
   }
 
-  return u, nil
+  ok, err := passwords.Verify(currentPassword, currentHashedPassword)
+  if err != nil {
+    return err
+  }
+
+  if !ok {
+    return models.ErrInvalidCredentials
+  }
+
+  newHashedPassword, err := m.hasher().Hash(newPassword)
+  if err != nil {
+    return err
+  }
+
+  stmt = "UPDATE users SET hashed_password = ? WHERE id = ?"
+  _, err = m.DB.Exec(stmt, newHashedPassword, id)
+
+  return err
+}
+
+// The List method returns a page of users whose name or email contains query,
+// ordered by ID, along with the total number of matching users.
+func (m *UserModel) List(query string, offset, limit int) ([]*models.User, int, error) {
+  pattern := likePattern(query)
+
+  var total int
+
+  stmt := `SELECT COUNT(*) FROM users WHERE name LIKE ? OR email LIKE ?`
+  err := m.DB.QueryRow(stmt, pattern, pattern).Scan(&total)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  stmt = `SELECT ` + userColumns + ` FROM users
+  WHERE name LIKE ? OR email LIKE ? ORDER BY id LIMIT ? OFFSET ?`
+
+  rows, err := m.DB.Query(stmt, pattern, pattern, limit, offset)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  defer rows.Close()
+
+  users := []*models.User{}
+
+  for rows.Next() {
+    u, err := scanUser(rows)
+    if err != nil {
+      return nil, 0, err
+    }
+
+    users = append(users, u)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, 0, err
+  }
+
+  return users, total, nil
+}
+
+// The SetActive method activates or deactivates a user. Deactivated users
+// can't log in, and are logged out of any existing sessions.
+func (m *UserModel) SetActive(id int, active bool) error {
+  result, err := m.DB.Exec(`UPDATE users SET active = ? WHERE id = ?`, active, id)
+  if err != nil {
+    return err
+  }
+
+  n, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+
+  // MySQL reports zero affected rows when the value doesn't change, so we
+  // have to check separately whether the user exists.
+  if n == 0 {
+    _, err = m.Get(id)
+    return err
+  }
+
+  return nil
+}
+
+// The SetRole method changes a user's role.
+func (m *UserModel) SetRole(id int, role string) error {
+  result, err := m.DB.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+  if err != nil {
+    return err
+  }
+
+  n, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+
+  if n == 0 {
+    _, err = m.Get(id)
+    return err
+  }
+
+  return nil
+}
+
+// The Count method returns the total number of users, and how many of them
+// are active.
+func (m *UserModel) Count() (int, int, error) {
+  var total, active int
+
+  stmt := `SELECT COUNT(*), COALESCE(SUM(active), 0) FROM users`
+  err := m.DB.QueryRow(stmt).Scan(&total, &active)
+  if err != nil {
+    return 0, 0, err
+  }
+
+  return total, active, nil
+}
+
+// The GetByEmail method fetches a user by their email address.
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+  stmt := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
+
+  return scanUser(m.DB.QueryRow(stmt, email))
+}
+
+// The GetByIdentity method fetches the user linked to an external identity,
+// given the issuer of the identity provider and the user's subject there.
+func (m *UserModel) GetByIdentity(issuer, subject string) (*models.User, error) {
+  stmt := `SELECT ` + userColumns + ` FROM users
+  WHERE id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)`
+
+  return scanUser(m.DB.QueryRow(stmt, issuer, subject))
+}
+
+// The LinkIdentity method links an external identity to a user, so that they
+// can log in with it from now on.
+func (m *UserModel) LinkIdentity(id int, issuer, subject string) error {
+  stmt := `INSERT INTO user_identities (issuer, subject, user_id, created)
+  VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+  _, err := m.DB.Exec(stmt, issuer, subject, id)
+
+  return err
+}
+
+// The ScheduleDeletion method marks a user's account to be deleted once the
+// given time has passed. If keepSnippets is true their snippets are kept,
+// without an owner, rather than deleted with the account.
+func (m *UserModel) ScheduleDeletion(id int, after time.Time, keepSnippets bool) error {
+  stmt := `UPDATE users SET delete_after = ?, keep_snippets = ? WHERE id = ?`
+
+  _, err := m.DB.Exec(stmt, after.UTC(), keepSnippets, id)
+
+  return err
+}
+
+// The CancelDeletion method unmarks an account which was scheduled for
+// deletion.
+func (m *UserModel) CancelDeletion(id int) error {
+  stmt := `UPDATE users SET delete_after = NULL, keep_snippets = FALSE WHERE id = ?`
+
+  _, err := m.DB.Exec(stmt, id)
+
+  return err
+}
+
+// The DeleteScheduled method deletes every account whose grace period ended
+// before now, and returns their IDs. Each account is deleted in a transaction
+// along with its snippets, or after anonymizing them by removing their owner.
+// Sessions, remember me tokens and linked identities go with the account
+// through their foreign keys.
+func (m *UserModel) DeleteScheduled(now time.Time) ([]int, error) {
+  rows, err := m.DB.Query(`SELECT id, keep_snippets FROM users WHERE delete_after <= ?`, now.UTC())
+  if err != nil {
+    return nil, err
+  }
+
+  type pending struct {
+    id           int
+    keepSnippets bool
+  }
+
+  var due []pending
+
+  for rows.Next() {
+    var p pending
+
+    if err := rows.Scan(&p.id, &p.keepSnippets); err != nil {
+      rows.Close()
+      return nil, err
+    }
+
+    due = append(due, p)
+  }
+
+  rows.Close()
+
+  if err = rows.Err(); err != nil {
+    return nil, err
+  }
+
+  deleted := []int{}
+
+  for _, p := range due {
+    err = m.delete(p.id, p.keepSnippets)
+    if err != nil {
+      return deleted, err
+    }
+
+    deleted = append(deleted, p.id)
+  }
+
+  return deleted, nil
+}
+
+func (m *UserModel) delete(id int, keepSnippets bool) error {
+  tx, err := m.DB.Begin()
+  if err != nil {
+    return err
+  }
+
+  // Private snippets are deleted even if the user chose to keep their
+  // snippets. Without an owner nobody could ever read them again, and they
+  // would never be removed.
+  _, err = tx.Exec(`DELETE FROM snippets WHERE user_id = ? AND visibility = ?`, id, models.VisibilityPrivate)
+  if err != nil {
+    tx.Rollback()
+    return err
+  }
+
+  stmt := `DELETE FROM snippets WHERE user_id = ?`
+  if keepSnippets {
+    stmt = `UPDATE snippets SET user_id = NULL WHERE user_id = ?`
+  }
+
+  _, err = tx.Exec(stmt, id)
+  if err != nil {
+    tx.Rollback()
+    return err
+  }
+
+  _, err = tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+  if err != nil {
+    tx.Rollback()
+    return err
+  }
+
+  return tx.Commit()
 }
@@ -0,0 +1,350 @@
+// Package oidc implements the parts of OpenID Connect we need to let users log
+// in with an external identity provider: discovery, the authorization code
+// flow with PKCE, and verification of RS256-signed ID tokens.
+package oidc
+
+import (
+  "crypto"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "math/big"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+var (
+  ErrInvalidToken = errors.New("oidc: invalid ID token")
+  ErrUnknownKey   = errors.New("oidc: ID token signed with an unknown key")
+)
+
+// Config holds the settings for an identity provider and our client
+// registration with it.
+type Config struct {
+  Issuer       string
+  ClientID     string
+  ClientSecret string
+  RedirectURL  string
+
+  // HTTPClient is used to talk to the provider. If it is nil, a client with a
+  // ten second timeout is used.
+  HTTPClient *http.Client
+}
+
+// Provider is an OpenID Connect provider whose endpoints have been found
+// through discovery.
+type Provider struct {
+  config                Config
+  authorizationEndpoint string
+  tokenEndpoint         string
+  jwksURI               string
+
+  mu   sync.Mutex
+  keys map[string]*rsa.PublicKey
+}
+
+// Claims are the ID token claims we use.
+type Claims struct {
+  Issuer        string   `json:"iss"`
+  Subject       string   `json:"sub"`
+  Audience      audience `json:"aud"`
+  Expiry        int64    `json:"exp"`
+  IssuedAt      int64    `json:"iat"`
+  Nonce         string   `json:"nonce"`
+  Email         string   `json:"email"`
+  EmailVerified bool     `json:"email_verified"`
+  Name          string   `json:"name"`
+}
+
+// The audience claim may be a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+  var s string
+  if err := json.Unmarshal(b, &s); err == nil {
+    *a = audience{s}
+    return nil
+  }
+
+  var ss []string
+  if err := json.Unmarshal(b, &ss); err != nil {
+    return err
+  }
+
+  *a = ss
+
+  return nil
+}
+
+// NewProvider fetches the provider's discovery document from
+// <issuer>/.well-known/openid-configuration.
+func NewProvider(config Config) (*Provider, error) {
+  if config.HTTPClient == nil {
+    config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+  }
+
+  var discovery struct {
+    Issuer                string `json:"issuer"`
+    AuthorizationEndpoint string `json:"authorization_endpoint"`
+    TokenEndpoint         string `json:"token_endpoint"`
+    JWKSURI               string `json:"jwks_uri"`
+  }
+
+  err := getJSON(config.HTTPClient, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+  if err != nil {
+    return nil, err
+  }
+
+  // The issuer in the document must be exactly the one we were configured
+  // with, otherwise tokens from it would fail verification anyway.
+  if discovery.Issuer != config.Issuer {
+    return nil, fmt.Errorf("oidc: issuer mismatch: configured %q, provider says %q", config.Issuer, discovery.Issuer)
+  }
+
+  return &Provider{
+    config:                config,
+    authorizationEndpoint: discovery.AuthorizationEndpoint,
+    tokenEndpoint:         discovery.TokenEndpoint,
+    jwksURI:               discovery.JWKSURI,
+    keys:                  map[string]*rsa.PublicKey{},
+  }, nil
+}
+
+// AuthCodeURL returns the URL to send the user to so they can log in with the
+// provider. The state and nonce values protect against CSRF and replay, and
+// the PKCE verifier ties the authorization code to this login attempt.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+  q := url.Values{
+    "response_type":         {"code"},
+    "client_id":             {p.config.ClientID},
+    "redirect_uri":          {p.config.RedirectURL},
+    "scope":                 {"openid email profile"},
+    "state":                 {state},
+    "nonce":                 {nonce},
+    "code_challenge":        {Challenge(verifier)},
+    "code_challenge_method": {"S256"},
+  }
+
+  sep := "?"
+  if strings.Contains(p.authorizationEndpoint, "?") {
+    sep = "&"
+  }
+
+  return p.authorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange swaps an authorization code for tokens at the token endpoint, and
+// returns the verified claims of the ID token.
+func (p *Provider) Exchange(code, verifier, nonce string) (*Claims, error) {
+  form := url.Values{
+    "grant_type":    {"authorization_code"},
+    "code":          {code},
+    "redirect_uri":  {p.config.RedirectURL},
+    "code_verifier": {verifier},
+  }
+
+  req, err := http.NewRequest(http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+  if err != nil {
+    return nil, err
+  }
+
+  req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  req.Header.Set("Accept", "application/json")
+  req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+  rs, err := p.config.HTTPClient.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer rs.Body.Close()
+
+  var token struct {
+    IDToken          string `json:"id_token"`
+    Error            string `json:"error"`
+    ErrorDescription string `json:"error_description"`
+  }
+
+  err = json.NewDecoder(rs.Body).Decode(&token)
+  if err != nil {
+    return nil, fmt.Errorf("oidc: decoding token response: %w", err)
+  }
+
+  if rs.StatusCode != http.StatusOK || token.Error != "" {
+    return nil, fmt.Errorf("oidc: token endpoint returned %s: %s %s", rs.Status, token.Error, token.ErrorDescription)
+  }
+
+  return p.Verify(token.IDToken, nonce)
+}
+
+// Verify checks the signature and claims of a raw ID token and returns its
+// claims.
+func (p *Provider) Verify(rawIDToken, nonce string) (*Claims, error) {
+  parts := strings.Split(rawIDToken, ".")
+  if len(parts) != 3 {
+    return nil, ErrInvalidToken
+  }
+
+  var header struct {
+    Alg string `json:"alg"`
+    Kid string `json:"kid"`
+  }
+
+  if err := decodeSegment(parts[0], &header); err != nil {
+    return nil, ErrInvalidToken
+  }
+
+  // We only accept RS256. In particular this rejects "none".
+  if header.Alg != "RS256" {
+    return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+  }
+
+  key, err := p.key(header.Kid)
+  if err != nil {
+    return nil, err
+  }
+
+  signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+  if err != nil {
+    return nil, ErrInvalidToken
+  }
+
+  digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+  if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+    return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+  }
+
+  var claims Claims
+  if err := decodeSegment(parts[1], &claims); err != nil {
+    return nil, ErrInvalidToken
+  }
+
+  switch {
+  case claims.Issuer != p.config.Issuer:
+    return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+  case !contains(claims.Audience, p.config.ClientID):
+    return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+  case time.Now().After(time.Unix(claims.Expiry, 0).Add(time.Minute)):
+    return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+  case claims.Nonce != nonce:
+    return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidToken)
+  }
+
+  return &claims, nil
+}
+
+// The key method returns the provider's signing key with the given ID,
+// fetching the key set again if we haven't seen it before (the provider may
+// have rotated its keys).
+func (p *Provider) key(kid string) (*rsa.PublicKey, error) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  if key, ok := p.keys[kid]; ok {
+    return key, nil
+  }
+
+  var jwks struct {
+    Keys []struct {
+      Kty string `json:"kty"`
+      Kid string `json:"kid"`
+      N   string `json:"n"`
+      E   string `json:"e"`
+    } `json:"keys"`
+  }
+
+  if err := getJSON(p.config.HTTPClient, p.jwksURI, &jwks); err != nil {
+    return nil, err
+  }
+
+  keys := map[string]*rsa.PublicKey{}
+
+  for _, k := range jwks.Keys {
+    if k.Kty != "RSA" {
+      continue
+    }
+
+    n, err := base64.RawURLEncoding.DecodeString(k.N)
+    if err != nil {
+      continue
+    }
+
+    e, err := base64.RawURLEncoding.DecodeString(k.E)
+    if err != nil {
+      continue
+    }
+
+    keys[k.Kid] = &rsa.PublicKey{
+      N: new(big.Int).SetBytes(n),
+      E: int(new(big.Int).SetBytes(e).Int64()),
+    }
+  }
+
+  p.keys = keys
+
+  key, ok := p.keys[kid]
+  if !ok {
+    return nil, ErrUnknownKey
+  }
+
+  return key, nil
+}
+
+// RandomString returns a random URL-safe string, for use as a state, nonce or
+// PKCE verifier.
+func RandomString() (string, error) {
+  b := make([]byte, 32)
+
+  if _, err := rand.Read(b); err != nil {
+    return "", err
+  }
+
+  return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge for a verifier.
+func Challenge(verifier string) string {
+  sum := sha256.Sum256([]byte(verifier))
+
+  return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+  rs, err := client.Get(url)
+  if err != nil {
+    return err
+  }
+  defer rs.Body.Close()
+
+  if rs.StatusCode != http.StatusOK {
+    return fmt.Errorf("oidc: GET %s returned %s", url, rs.Status)
+  }
+
+  return json.NewDecoder(rs.Body).Decode(v)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+  b, err := base64.RawURLEncoding.DecodeString(segment)
+  if err != nil {
+    return err
+  }
+
+  return json.Unmarshal(b, v)
+}
+
+func contains(ss []string, s string) bool {
+  for _, v := range ss {
+    if v == s {
+      return true
+    }
+  }
+
+  return false
+}
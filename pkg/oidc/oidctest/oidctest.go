@@ -0,0 +1,240 @@
+// Package oidctest provides a stand-in OpenID Connect provider for tests. It
+// implements discovery, a JWKS endpoint, an authorization endpoint which logs
+// in a preconfigured user without any interaction, and a token endpoint which
+// checks the PKCE verifier before issuing an RS256-signed ID token.
+package oidctest
+
+import (
+  "crypto"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "math/big"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "sync"
+  "time"
+)
+
+const keyID = "test-key"
+
+// User describes the person who is "logged in" at the provider.
+type User struct {
+  Subject       string
+  Email         string
+  EmailVerified bool
+  Name          string
+}
+
+// Provider is a running stand-in provider. Set User before starting a login
+// to control who the provider says the user is.
+type Provider struct {
+  *httptest.Server
+  ClientID     string
+  ClientSecret string
+
+  mu    sync.Mutex
+  user  User
+  key   *rsa.PrivateKey
+  codes map[string]grant
+}
+
+type grant struct {
+  clientID    string
+  redirectURI string
+  challenge   string
+  nonce       string
+  user        User
+}
+
+// NewProvider starts a provider which accepts the given client credentials.
+// Callers should call Close when they are done with it.
+func NewProvider(clientID, clientSecret string) *Provider {
+  key, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    panic(err)
+  }
+
+  p := &Provider{
+    ClientID:     clientID,
+    ClientSecret: clientSecret,
+    key:          key,
+    codes:        map[string]grant{},
+  }
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+  mux.HandleFunc("/authorize", p.authorize)
+  mux.HandleFunc("/token", p.token)
+  mux.HandleFunc("/jwks", p.jwks)
+
+  p.Server = httptest.NewServer(mux)
+
+  return p
+}
+
+// SetUser sets the user who will be logged in by the next authorization
+// request.
+func (p *Provider) SetUser(u User) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  p.user = u
+}
+
+// Issuer returns the issuer identifier of the provider.
+func (p *Provider) Issuer() string {
+  return p.URL
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "issuer":                                p.URL,
+    "authorization_endpoint":                p.URL + "/authorize",
+    "token_endpoint":                        p.URL + "/token",
+    "jwks_uri":                              p.URL + "/jwks",
+    "response_types_supported":              []string{"code"},
+    "subject_types_supported":               []string{"public"},
+    "id_token_signing_alg_values_supported": []string{"RS256"},
+    "code_challenge_methods_supported":      []string{"S256"},
+  })
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+  pub := p.key.PublicKey
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "keys": []map[string]string{{
+      "kty": "RSA",
+      "alg": "RS256",
+      "use": "sig",
+      "kid": keyID,
+      "n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+      "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+    }},
+  })
+}
+
+// The authorize handler logs the current user in straight away and redirects
+// back to the client with an authorization code.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+  q := r.URL.Query()
+
+  if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" {
+    http.Error(w, "invalid_request", http.StatusBadRequest)
+    return
+  }
+
+  if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+    http.Error(w, "PKCE is required", http.StatusBadRequest)
+    return
+  }
+
+  redirect, err := url.Parse(q.Get("redirect_uri"))
+  if err != nil || redirect.String() == "" {
+    http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+    return
+  }
+
+  code := randomString()
+
+  p.mu.Lock()
+  p.codes[code] = grant{
+    clientID:    p.ClientID,
+    redirectURI: redirect.String(),
+    challenge:   q.Get("code_challenge"),
+    nonce:       q.Get("nonce"),
+    user:        p.user,
+  }
+  p.mu.Unlock()
+
+  rq := redirect.Query()
+  rq.Set("code", code)
+  rq.Set("state", q.Get("state"))
+  redirect.RawQuery = rq.Encode()
+
+  http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+  id, secret, ok := r.BasicAuth()
+  if ok {
+    id, _ = url.QueryUnescape(id)
+    secret, _ = url.QueryUnescape(secret)
+  }
+
+  if !ok || id != p.ClientID || secret != p.ClientSecret {
+    writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+    return
+  }
+
+  r.ParseForm()
+  code := r.PostForm.Get("code")
+
+  // Codes can only be used once.
+  p.mu.Lock()
+  g, ok := p.codes[code]
+  delete(p.codes, code)
+  p.mu.Unlock()
+
+  verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+  if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+    r.PostForm.Get("redirect_uri") != g.redirectURI ||
+    base64.RawURLEncoding.EncodeToString(verifier[:]) != g.challenge {
+    writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+    return
+  }
+
+  now := time.Now()
+
+  idToken := p.sign(map[string]interface{}{
+    "iss":            p.URL,
+    "sub":            g.user.Subject,
+    "aud":            g.clientID,
+    "iat":            now.Unix(),
+    "exp":            now.Add(5 * time.Minute).Unix(),
+    "nonce":          g.nonce,
+    "email":          g.user.Email,
+    "email_verified": g.user.EmailVerified,
+    "name":           g.user.Name,
+  })
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "access_token": randomString(),
+    "token_type":   "Bearer",
+    "expires_in":   300,
+    "id_token":     idToken,
+  })
+}
+
+func (p *Provider) sign(claims map[string]interface{}) string {
+  header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+  payload, _ := json.Marshal(claims)
+
+  signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+  digest := sha256.Sum256([]byte(signed))
+
+  signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+  if err != nil {
+    panic(err)
+  }
+
+  return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+  b := make([]byte, 16)
+  rand.Read(b)
+
+  return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,215 @@
+// Package passwords hashes and verifies user passwords. Hashes are stored in
+// a self-describing format (PHC strings for argon2id, modular crypt format for
+// bcrypt), so the algorithm and parameters used for every stored hash are
+// known and weaker hashes can be upgraded when the user next logs in.
+package passwords
+
+import (
+  "crypto/rand"
+  "crypto/subtle"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "strings"
+
+  "golang.org/x/crypto/argon2"
+  "golang.org/x/crypto/bcrypt"
+)
+
+var (
+  ErrUnknownAlgorithm = errors.New("passwords: unknown hash algorithm")
+  ErrMalformedHash    = errors.New("passwords: malformed hash")
+)
+
+// A Hasher hashes passwords according to a policy, and can tell whether an
+// existing hash falls short of that policy and should be replaced.
+type Hasher interface {
+  Hash(password string) (string, error)
+  NeedsRehash(encoded string) bool
+}
+
+// DefaultHasher is the policy used when none has been configured. The
+// parameters follow the OWASP recommendations for argon2id.
+var DefaultHasher Hasher = &Argon2id{
+  Memory:      64 * 1024,
+  Iterations:  3,
+  Parallelism: 2,
+  SaltLength:  16,
+  KeyLength:   32,
+}
+
+// New returns a Hasher for the named algorithm: "argon2id" or "bcrypt".
+func New(algorithm string) (Hasher, error) {
+  switch algorithm {
+  case "argon2id":
+    h := *DefaultHasher.(*Argon2id)
+    return &h, nil
+  case "bcrypt":
+    return &Bcrypt{Cost: 12}, nil
+  default:
+    return nil, ErrUnknownAlgorithm
+  }
+}
+
+// Verify reports whether password matches the encoded hash, whichever of the
+// supported algorithms it was created with.
+func Verify(password, encoded string) (bool, error) {
+  switch {
+  case strings.HasPrefix(encoded, "$argon2id$"):
+    p, salt, key, err := decodeArgon2id(encoded)
+    if err != nil {
+      return false, err
+    }
+
+    other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+
+    return subtle.ConstantTimeCompare(key, other) == 1, nil
+  case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+    err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+    if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+      return false, nil
+    } else if err != nil {
+      return false, err
+    }
+
+    return true, nil
+  default:
+    return false, ErrUnknownAlgorithm
+  }
+}
+
+// Argon2id hashes passwords with argon2id. Memory is in KiB.
+type Argon2id struct {
+  Memory      uint32
+  Iterations  uint32
+  Parallelism uint8
+  SaltLength  uint32
+  KeyLength   uint32
+}
+
+// Validate returns an error if argon2id can't be run with the parameters in h.
+// It needs at least one iteration and one thread, and would panic without them.
+func (h *Argon2id) Validate() error {
+  switch {
+  case h.Iterations < 1:
+    return errors.New("passwords: argon2id needs at least 1 iteration")
+  case h.Parallelism < 1:
+    return errors.New("passwords: argon2id needs a parallelism of at least 1")
+  case h.KeyLength < 1:
+    return errors.New("passwords: argon2id needs a key length of at least 1")
+  }
+
+  return nil
+}
+
+// Hash returns the PHC string for password, for example
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func (h *Argon2id) Hash(password string) (string, error) {
+  salt := make([]byte, h.SaltLength)
+
+  _, err := rand.Read(salt)
+  if err != nil {
+    return "", err
+  }
+
+  key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+  return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+    argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+    base64.RawStdEncoding.EncodeToString(salt),
+    base64.RawStdEncoding.EncodeToString(key),
+  ), nil
+}
+
+// NeedsRehash returns true if encoded isn't an argon2id hash, or any of its
+// parameters are weaker than the ones configured in h.
+func (h *Argon2id) NeedsRehash(encoded string) bool {
+  p, salt, key, err := decodeArgon2id(encoded)
+  if err != nil {
+    return true
+  }
+
+  return p.Memory < h.Memory ||
+    p.Iterations < h.Iterations ||
+    p.Parallelism < h.Parallelism ||
+    uint32(len(salt)) < h.SaltLength ||
+    uint32(len(key)) < h.KeyLength
+}
+
+// The decodeArgon2id function parses a PHC string created by Argon2id.Hash and
+// returns its parameters, salt and derived key.
+func decodeArgon2id(encoded string) (*Argon2id, []byte, []byte, error) {
+  parts := strings.Split(encoded, "$")
+  if len(parts) != 6 || parts[1] != "argon2id" {
+    return nil, nil, nil, ErrMalformedHash
+  }
+
+  var version int
+
+  _, err := fmt.Sscanf(parts[2], "v=%d", &version)
+  if err != nil || version != argon2.Version {
+    return nil, nil, nil, ErrMalformedHash
+  }
+
+  p := &Argon2id{}
+
+  _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+  if err != nil {
+    return nil, nil, nil, ErrMalformedHash
+  }
+
+  salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+  if err != nil {
+    return nil, nil, nil, ErrMalformedHash
+  }
+
+  key, err := base64.RawStdEncoding.DecodeString(parts[5])
+  if err != nil || len(key) == 0 {
+    return nil, nil, nil, ErrMalformedHash
+  }
+
+  p.SaltLength = uint32(len(salt))
+  p.KeyLength = uint32(len(key))
+
+  // A hash with parameters argon2id can't run with can never match, and
+  // trying would panic.
+  if p.Validate() != nil {
+    return nil, nil, nil, ErrMalformedHash
+  }
+
+  return p, salt, key, nil
+}
+
+// Bcrypt hashes passwords with bcrypt at the given cost.
+type Bcrypt struct {
+  Cost int
+}
+
+// Validate returns an error if bcrypt doesn't support the cost in h.
+func (h *Bcrypt) Validate() error {
+  if h.Cost < bcrypt.MinCost || h.Cost > bcrypt.MaxCost {
+    return fmt.Errorf("passwords: bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+  }
+
+  return nil
+}
+
+func (h *Bcrypt) Hash(password string) (string, error) {
+  hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+  if err != nil {
+    return "", err
+  }
+
+  return string(hash), nil
+}
+
+// NeedsRehash returns true if encoded isn't a bcrypt hash, or its cost is
+// lower than the one configured in h.
+func (h *Bcrypt) NeedsRehash(encoded string) bool {
+  cost, err := bcrypt.Cost([]byte(encoded))
+  if err != nil {
+    return true
+  }
+
+  return cost < h.Cost
+}
@@ -0,0 +1,97 @@
+package passwords
+
+import (
+    "testing"
+)
+
+func TestVerify(t *testing.T) {
+    // Use cheap parameters so the test runs quickly.
+    weak := &Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+    strong := &Argon2id{Memory: 2048, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+    argonHash, err := weak.Hash("validPa$$word")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    bcryptHash, err := (&Bcrypt{Cost: 4}).Hash("validPa$$word")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name            string
+        password        string
+        encoded         string
+        want            bool
+        wantNeedsRehash bool
+    }{
+        {"Argon2id match", "validPa$$word", argonHash, true, true},
+        {"Argon2id mismatch", "wrongPa$$word", argonHash, false, true},
+        {"Bcrypt match", "validPa$$word", bcryptHash, true, true},
+        {"Bcrypt mismatch", "wrongPa$$word", bcryptHash, false, true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ok, err := Verify(tt.password, tt.encoded)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if ok != tt.want {
+                t.Errorf("want %t; got %t", tt.want, ok)
+            }
+
+            if got := strong.NeedsRehash(tt.encoded); got != tt.wantNeedsRehash {
+                t.Errorf("want NeedsRehash %t; got %t", tt.wantNeedsRehash, got)
+            }
+        })
+    }
+
+    // A hash which already meets the policy is left alone.
+    if weak.NeedsRehash(argonHash) {
+        t.Error("want hash created with the current policy to not need a rehash")
+    }
+
+    if _, err := Verify("validPa$$word", "plaintext"); err != ErrUnknownAlgorithm {
+        t.Errorf("want %v; got %v", ErrUnknownAlgorithm, err)
+    }
+}
+
+func TestValidate(t *testing.T) {
+    tests := []struct {
+        name    string
+        hasher  interface{ Validate() error }
+        wantErr bool
+    }{
+        {"Argon2id defaults", DefaultHasher.(*Argon2id), false},
+        {"Argon2id without iterations", &Argon2id{Memory: 1024, Parallelism: 1, KeyLength: 32}, true},
+        {"Argon2id without parallelism", &Argon2id{Memory: 1024, Iterations: 1, KeyLength: 32}, true},
+        {"Argon2id without a key", &Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1}, true},
+        {"Bcrypt", &Bcrypt{Cost: 12}, false},
+        {"Bcrypt cost too low", &Bcrypt{Cost: 3}, true},
+        {"Bcrypt cost too high", &Bcrypt{Cost: 32}, true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := tt.hasher.Validate()
+
+            if (err != nil) != tt.wantErr {
+                t.Errorf("want error %t; got %v", tt.wantErr, err)
+            }
+        })
+    }
+
+    // A stored hash with parameters argon2id can't run with is rejected,
+    // rather than panicking.
+    for _, encoded := range []string{
+        "$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5",
+        "$argon2id$v=19$m=1024,t=1,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5",
+    } {
+        if _, err := Verify("validPa$$word", encoded); err != ErrMalformedHash {
+            t.Errorf("want %v; got %v", ErrMalformedHash, err)
+        }
+    }
+}
@@ -5,4 +5,42 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+// Snippet forms start with one file. Adding a file copies the last one with
+// its fields cleared; without JavaScript the buttons stay hidden and the form
+// still works for the files it has.
+var files = document.getElementById("files");
+var addFile = document.getElementById("add-file");
+if (files && addFile) {
+	var updateButtons = function() {
+		var blocks = files.querySelectorAll("fieldset.file");
+		addFile.hidden = blocks.length >= 10;
+		for (var i = 0; i < blocks.length; i++) {
+			blocks[i].querySelector(".remove-file").hidden = blocks.length == 1;
+		}
+	};
+
+	addFile.addEventListener("click", function() {
+		var blocks = files.querySelectorAll("fieldset.file");
+		var block = blocks[blocks.length - 1].cloneNode(true);
+		var errors = block.querySelectorAll(".error");
+		for (var i = 0; i < errors.length; i++) {
+			errors[i].remove();
+		}
+		block.querySelector("input[name='filename']").value = "";
+		block.querySelector("textarea[name='content']").value = "";
+		block.querySelector("select[name='language']").value = "";
+		files.appendChild(block);
+		updateButtons();
+		block.querySelector("input[name='filename']").focus();
+	});
+
+	files.addEventListener("click", function(e) {
+		if (e.target.classList.contains("remove-file")) {
+			e.target.closest("fieldset.file").remove();
+			updateButtons();
+		}
+	});
+
+	updateButtons();
+}